@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// defaultChannel is joined by everyone on connect and is never removed
+const defaultChannel = "general"
+
+// channel names are 1-32 lowercase letters, digits, - and _
+var validChannel = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// A client's messages wait in a queue of sendQueue for its writer, which gives
+// each one writeTimeout. A client that falls that far behind is disconnected.
+const (
+	sendQueue    = 64
+	writeTimeout = 10 * time.Second
+)
+
+// client is one connection. name never changes, channels is owned by the server lock.
+// send is the queue of messages for writeLoop, so a slow client never holds up the server.
+type client struct {
+	ws       *websocket.Conn
+	name     string
+	channels map[string]bool
+	send     chan Message
+}
+
+// channel is a named room of the chat, messages sent to it go to its members only
+type channel struct {
+	name    string
+	members map[*client]bool
+}
+
+// channelInfo is how a channel is listed to clients
+type channelInfo struct {
+	Name    string `json:"name"`
+	Members int    `json:"members"`
+}
+
+// chatServer keeps the channels and who is in them. Channels are created by
+// the first member to join and removed when the last one leaves, apart from
+// defaultChannel.
+type chatServer struct {
+	mu        sync.Mutex
+	channels  map[string]*channel
+	userCount int
+}
+
+func newChatServer() *chatServer {
+	s := &chatServer{channels: make(map[string]*channel)}
+	s.channels[defaultChannel] = &channel{name: defaultChannel, members: make(map[*client]bool)}
+	return s
+}
+
+// register names a new connection
+func (s *chatServer) register(ws *websocket.Conn) *client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.userCount++
+	return &client{ws: ws, name: fmt.Sprintf("user-%d", s.userCount), channels: make(map[string]bool), send: make(chan Message, sendQueue)}
+}
+
+// join adds c to the channel, creating it if needed, and tells its members.
+// It returns the members' names, or an error if the name isn't allowed.
+func (s *chatServer) join(c *client, name string) ([]string, error) {
+	if !validChannel.MatchString(name) {
+		return nil, fmt.Errorf("channel names are 1-32 lowercase letters, digits, - and _, not %q", name)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch := s.channels[name]
+	if ch == nil {
+		ch = &channel{name: name, members: make(map[*client]bool)}
+		s.channels[name] = ch
+	}
+	if !ch.members[c] {
+		ch.members[c] = true
+		c.channels[name] = true
+		s.sendToChannel(ch, Message{Type: "system", Channel: name, Text: fmt.Sprintf("%s has joined #%s.", c.name, name)})
+	}
+	return ch.memberNames(), nil
+}
+
+// leave removes c from the channel and tells the members left
+func (s *chatServer) leave(c *client, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch := s.channels[name]
+	if ch == nil || !ch.members[c] {
+		return fmt.Errorf("not in #%s", name)
+	}
+	s.remove(c, ch)
+	return nil
+}
+
+// disconnect takes c out of every channel it is in
+func (s *chatServer) disconnect(c *client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name := range c.channels {
+		s.remove(c, s.channels[name])
+	}
+}
+
+// remove takes c out of ch, call with the lock held
+func (s *chatServer) remove(c *client, ch *channel) {
+	delete(ch.members, c)
+	delete(c.channels, ch.name)
+	if len(ch.members) == 0 && ch.name != defaultChannel {
+		delete(s.channels, ch.name)
+		return
+	}
+	s.sendToChannel(ch, Message{Type: "system", Channel: ch.name, Text: fmt.Sprintf("%s has left #%s.", c.name, ch.name)})
+}
+
+// list is every channel by name with its member count
+func (s *chatServer) list() []channelInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	infos := make([]channelInfo, 0, len(s.channels))
+	for _, ch := range s.channels {
+		infos = append(infos, channelInfo{Name: ch.name, Members: len(ch.members)})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// chat sends a chat line from c to the members of a channel c is in
+func (s *chatServer) chat(c *client, name, text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !c.channels[name] {
+		return fmt.Errorf("join #%s before chatting in it", name)
+	}
+	s.sendToChannel(s.channels[name], Message{Type: "chat", Channel: name, Sender: c.name, Text: text})
+	return nil
+}
+
+// memberNames lists the members sorted by name
+func (ch *channel) memberNames() []string {
+	names := make([]string, 0, len(ch.members))
+	for m := range ch.members {
+		names = append(names, m.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sendToChannel queues msg for every member of ch, call with the lock held so
+// members see a channel's messages in one order. Queuing never blocks.
+func (s *chatServer) sendToChannel(ch *channel, msg Message) {
+	fmt.Printf("Broadcasting to #%s: %+v\n", ch.name, msg)
+	for member := range ch.members {
+		sendMessage(member, msg)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// dial connects to the test server and reads its welcome
+func dial(t *testing.T, url string) (*websocket.Conn, string) {
+	t.Helper()
+	ws, err := websocket.Dial(url, "", "http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	welcome := receive(t, ws, "welcome")
+	receive(t, ws, "joined")
+	return ws, welcome.UserName
+}
+
+// receive reads messages until one of type msgType, failing after a few seconds
+func receive(t *testing.T, ws *websocket.Conn, msgType string) Message {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var msg Message
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			t.Fatalf("waiting for %s: %v", msgType, err)
+		}
+		if msg.Type == msgType {
+			return msg
+		}
+	}
+}
+
+func send(t *testing.T, ws *websocket.Conn, msg Message) {
+	t.Helper()
+	if err := websocket.JSON.Send(ws, msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChannels(t *testing.T) {
+	srv := httptest.NewServer(websocket.Handler(newChatServer().handleConnections))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	a, aName := dial(t, url)
+	b, _ := dial(t, url)
+
+	send(t, a, Message{Type: "join", Channel: "gophers"})
+	if joined := receive(t, a, "joined"); joined.Channel != "gophers" || !reflect.DeepEqual(joined.Members, []string{aName}) {
+		t.Fatalf("joining gophers: %+v", joined)
+	}
+
+	// chat only reaches the channel's members, and the sender is the server's name for them
+	send(t, a, Message{Type: "chat", Channel: "gophers", Sender: "admin", Text: "just us"})
+	if chat := receive(t, a, "chat"); chat.Channel != "gophers" || chat.Sender != aName || chat.Text != "just us" {
+		t.Fatalf("gophers chat arrived as %+v", chat)
+	}
+	send(t, a, Message{Type: "chat", Text: "hello everyone"})
+	if chat := receive(t, b, "chat"); chat.Channel != defaultChannel || chat.Text != "hello everyone" {
+		t.Fatalf("b got %+v, want only the general chat", chat)
+	}
+
+	// b can't chat in a channel it isn't in, or join one with a bad name
+	send(t, b, Message{Type: "chat", Channel: "gophers", Text: "let me in"})
+	if e := receive(t, b, "error"); e.Channel != "gophers" {
+		t.Fatalf("chatting outside the channel: %+v", e)
+	}
+	send(t, b, Message{Type: "join", Channel: "No Spaces!"})
+	receive(t, b, "error")
+
+	send(t, b, Message{Type: "list"})
+	want := []channelInfo{{Name: defaultChannel, Members: 2}, {Name: "gophers", Members: 1}}
+	if list := receive(t, b, "channels"); !reflect.DeepEqual(list.Channels, want) {
+		t.Fatalf("channels %+v, want %+v", list.Channels, want)
+	}
+
+	// the last member leaving removes the channel, general stays
+	send(t, a, Message{Type: "leave", Channel: "gophers"})
+	receive(t, a, "left")
+	send(t, a, Message{Type: "leave", Channel: defaultChannel})
+	receive(t, a, "left")
+	send(t, a, Message{Type: "leave", Channel: "gophers"})
+	receive(t, a, "error")
+	send(t, b, Message{Type: "chat", Text: "anyone?"})
+	receive(t, b, "chat")
+	send(t, b, Message{Type: "list"})
+	want = []channelInfo{{Name: defaultChannel, Members: 1}}
+	if list := receive(t, b, "channels"); !reflect.DeepEqual(list.Channels, want) {
+		t.Fatalf("channels %+v after leaving, want %+v", list.Channels, want)
+	}
+}
+
+func TestSlowClient(t *testing.T) {
+	conns := make(chan *websocket.Conn)
+	done := make(chan struct{})
+	defer close(done)
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		conns <- ws
+		<-done
+	}))
+	defer srv.Close()
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), "", "http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// nothing writes this client's queue out, as if it had stopped reading
+	s := newChatServer()
+	c := s.register(<-conns)
+	if _, err := s.join(c, "gophers"); err != nil {
+		t.Fatal(err)
+	}
+	sent := make(chan struct{})
+	go func() {
+		for range sendQueue + 1 {
+			s.chat(c, "gophers", "are you there?")
+		}
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("chat blocked on a client that doesn't keep up")
+	}
+
+	// it is cut off instead, its reads fail at once
+	var msg Message
+	if err := websocket.JSON.Receive(c.ws, &msg); err == nil {
+		t.Fatal("the client that fell behind is still connected")
+	}
+}
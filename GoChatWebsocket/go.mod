@@ -4,4 +4,4 @@ go 1.23.3
 
 require github.com/gorilla/websocket v1.5.3
 
-require golang.org/x/net v0.32.0 // indirect
+require golang.org/x/net v0.32.0
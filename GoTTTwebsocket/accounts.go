@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// account is who a login token belongs to, as the accounts service (UsersGo) sends it
+type account struct {
+	ID        int    `json:"id"`
+	FirstName string `json:"firstname"`
+	LastName  string `json:"lastname"`
+}
+
+// name is what the account plays as when hello doesn't ask for a name
+func (a *account) name() string {
+	return strings.TrimSpace(a.FirstName + " " + a.LastName)
+}
+
+// identity is the key the account's puzzle stats are kept under
+func (a *account) identity() string {
+	return fmt.Sprintf("account-%d", a.ID)
+}
+
+// how long the accounts service has to check a token
+const accountsTimeout = 5 * time.Second
+
+// errBadToken is the accounts service refusing a login token
+var errBadToken = errors.New("Invalid or expired login token.")
+
+// accountService checks login tokens against a UsersGo compatible endpoint:
+// GET url with "Authorization: Bearer <token>" answers 200 and the account as
+// JSON ({"id": 7, "firstname": "Ada", ...}), or 401, 403 or 404 for a bad token.
+type accountService struct {
+	url  string
+	http *http.Client
+}
+
+func newAccountService(url string) *accountService {
+	return &accountService{url: url, http: &http.Client{Timeout: accountsTimeout}}
+}
+
+// authenticate returns the account token belongs to, errBadToken if none does
+func (a *accountService) authenticate(ctx context.Context, token string) (_ *account, err error) {
+	ctx, span := startStorageSpan(ctx, "accounts.authenticate")
+	defer endSpan(span, &err)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	injectHeaders(ctx, req)
+	resp, err := a.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("accounts service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+		return nil, errBadToken
+	default:
+		return nil, fmt.Errorf("accounts service: %s", resp.Status)
+	}
+	var acc account
+	if err := json.NewDecoder(resp.Body).Decode(&acc); err != nil {
+		return nil, fmt.Errorf("accounts service: %w", err)
+	}
+	if acc.ID == 0 {
+		return nil, errors.New("accounts service: no account id in the answer")
+	}
+	return &acc, nil
+}
+
+// accountToken reads the login token from the websocket or event stream request,
+// "Authorization: Bearer <token>" or ?access_token= for browsers, which can't set headers there
+func accountToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+	return r.URL.Query().Get("access_token")
+}
+
+// signIn checks the login token a connection was opened with. Without one
+// it plays as a guest and the account is nil.
+func (s *Server) signIn(r *http.Request) (*account, error) {
+	token := accountToken(r)
+	if token == "" {
+		return nil, nil
+	}
+	if s.accounts == nil {
+		return nil, errors.New("This server has no accounts, connect without a login token to play as a guest.")
+	}
+	acc, err := s.accounts.authenticate(r.Context(), token)
+	if err != nil && !errors.Is(err, errBadToken) {
+		fmt.Println("Accounts Error:", err)
+		return nil, errors.New("Couldn't check your login token, try again later.")
+	}
+	return acc, err
+}
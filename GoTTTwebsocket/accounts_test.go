@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"goChatSocket/client"
+	"goChatSocket/protocol"
+)
+
+// stubAccounts stands in for UsersGo, answering for the accounts it is given by token
+func stubAccounts(t *testing.T, accounts map[string]account) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		acc, ok := accounts[token]
+		if !ok {
+			WriteJSON(w, http.StatusUnauthorized, ApiError{Error: "unauthorized"})
+			return
+		}
+		WriteJSON(w, http.StatusOK, acc)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/account/me"
+}
+
+func TestAccounts(t *testing.T) {
+	server := newServer()
+	server.accounts = newAccountService(stubAccounts(t, map[string]account{
+		"ada": {ID: 1, FirstName: "Ada", LastName: "Lovelace"},
+		"bob": {ID: 2, FirstName: "Bob"},
+	}))
+	srv := httptest.NewServer(server.routes())
+	t.Cleanup(srv.Close)
+	addr := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+
+	_, err := client.DialAs(roomURL(t, addr, "rated"), client.Identity{Token: "mallory"})
+	var perr *protocol.Error
+	if !errors.As(err, &perr) || perr.Code != protocol.CodeUnauthorized {
+		t.Fatalf("dial with a bad token: %v", err)
+	}
+
+	x := dialAs(t, roomURL(t, addr, "rated"), client.Identity{Token: "ada"})
+	if acc := x.Account(); acc == nil || acc.ID != 1 || acc.Name != "Ada Lovelace" || acc.Rating != initialRating {
+		t.Fatalf("welcome account %+v", acc)
+	}
+	if assigned := waitFor[client.Assigned](t, x, nil); assigned.UserName != "Ada Lovelace" {
+		t.Fatalf("assigned %+v", assigned)
+	}
+	o := dialAs(t, roomURL(t, addr, "rated"), client.Identity{Token: "bob"})
+
+	// the game between accounts counts, so nobody gets to analyze it
+	waitFor(t, o, func(e client.TurnChanged) bool { return e.Symbol == "X" })
+	o.Analyze(make([]string, 9), "X", 0)
+	if rejected := waitFor[client.Rejected](t, o, nil); rejected.Code != protocol.CodeAnalysisDisabled {
+		t.Fatalf("analysis during a game between accounts got %+v", rejected)
+	}
+	if status, _ := postAnalyze(t, srv.URL, protocol.Analyze{Board: make([]string, 9), Turn: "X"}); status != http.StatusForbidden {
+		t.Fatalf("its position over HTTP: status %d", status)
+	}
+
+	// X wins the top row, the ratings move by half of K between equals
+	play(t, x, o, 0, 3, 1, 4, 2)
+	waitFor(t, o, func(e client.SystemNotice) bool { return e.Text == "Ratings: Ada Lovelace 1216 (+16), Bob 1184 (-16)." })
+
+	var ladder []rating
+	if status := adminCall(t, srv.URL, "", "GET", "/ratings", nil, &ladder); status != http.StatusOK || len(ladder) != 2 ||
+		ladder[0].Account != 1 || ladder[0].Rating != 1216 || ladder[1].Rating != 1184 {
+		t.Fatalf("ladder: status %d, %+v", status, ladder)
+	}
+
+	// the game is in Ada's history with the accounts and ratings it was played at
+	var games []archivedGame
+	adminCall(t, srv.URL, "", "GET", "/games?account=1", nil, &games)
+	if len(games) != 1 || games[0].Tags["XAccount"] != "1" || games[0].Tags["ORating"] != "1200" {
+		data, _ := json.Marshal(games)
+		t.Fatalf("history %s", data)
+	}
+
+	// guests can play, their games aren't rated
+	guest := dial(t, roomURL(t, addr, "casual"))
+	if guest.Account() != nil {
+		t.Fatalf("guest signed in as %+v", guest.Account())
+	}
+	ada := dialAs(t, roomURL(t, addr, "casual"), client.Identity{Token: "ada"})
+	play(t, guest, ada, 0, 3, 1, 4, 2)
+	waitFor[client.GameOver](t, ada, nil)
+	var ratingNow rating
+	adminCall(t, srv.URL, "", "GET", "/ratings/1", nil, &ratingNow)
+	if ratingNow.Games != 1 || ratingNow.Rating != 1216 {
+		t.Fatalf("rating after a guest game %+v", ratingNow)
+	}
+	adminCall(t, srv.URL, "", "GET", "/games?account=2", nil, &games)
+	if len(games) != 1 {
+		t.Fatalf("Bob's history has %d games", len(games))
+	}
+}
+
+func TestNoAccountsService(t *testing.T) {
+	_, err := client.DialAs(roomURL(t, startServer(t, nil), "main"), client.Identity{Token: "ada"})
+	var perr *protocol.Error
+	if !errors.As(err, &perr) || perr.Code != protocol.CodeUnauthorized {
+		t.Fatalf("dial with a token and no accounts service: %v", err)
+	}
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"goChatSocket/game"
+	"goChatSocket/protocol"
+)
+
+// adminAPI is the operator console under /admin: look at rooms and
+// connections, kick and ban, end or reset games, make announcements and change
+// room settings. Every request needs the header "Authorization: Bearer <token>",
+// with no token set the API is off. Everything that changes something goes in
+// the audit log.
+type adminAPI struct {
+	hub     *Hub
+	clients *clientRegistry
+	token   string
+	bans    *banList
+	audit   *auditLog
+}
+
+func newAdminAPI(hub *Hub, clients *clientRegistry) *adminAPI {
+	return &adminAPI{
+		hub:     hub,
+		clients: clients,
+		bans:    newBanList(),
+		audit:   newAuditLog(),
+	}
+}
+
+// errNoGame rejects ending or resetting a room that isn't playing
+var errNoGame = statusError{http.StatusConflict, errors.New("no game in progress")}
+
+// authorized runs f only for requests carrying the admin token
+func (a *adminAPI) authorized(f apiFunc) apiFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if a.token == "" {
+			return notFound("the admin API is off, start the server with TTT_ADMIN_TOKEN set")
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			return statusError{http.StatusUnauthorized, errors.New("missing or wrong admin token")}
+		}
+		return f(w, r)
+	}
+}
+
+// action wraps a handler that changes something. f fills in the audit entry's
+// target and details, the entry is logged whether it worked or not and is
+// sent back as the response.
+func (a *adminAPI) action(name string, f func(w http.ResponseWriter, r *http.Request, entry *auditEntry) error) apiFunc {
+	return a.authorized(func(w http.ResponseWriter, r *http.Request) error {
+		entry := &auditEntry{Time: time.Now().UTC(), Admin: hostOf(r.RemoteAddr), Action: name}
+		err := f(w, r, entry)
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		a.audit.record(*entry)
+		if err != nil {
+			return err
+		}
+		return WriteJSON(w, http.StatusOK, entry)
+	})
+}
+
+// readOptionalJSON is readJSON for requests where the body may be left out
+func readOptionalJSON(r *http.Request, v any) error {
+	if r.ContentLength == 0 {
+		return nil
+	}
+	return readJSON(r, v)
+}
+
+// room looks up the room named in the path
+func (a *adminAPI) room(r *http.Request) (*Room, error) {
+	room := a.hub.get(r.PathValue("id"))
+	if room == nil {
+		return nil, notFound("room %s not found", r.PathValue("id"))
+	}
+	return room, nil
+}
+
+// kick disconnects a client, telling them why
+func kick(c *Client, code, reason string) {
+	c.disconnect(&protocol.Error{Code: code, Message: reason})
+}
+
+// views
+
+// roomSettings are the options an admin can change on a live room.
+// SpectatorDelay is in seconds.
+type roomSettings struct {
+	AutoRematch    bool `json:"autoRematch"`
+	SpectatorDelay int  `json:"spectatorDelay"`
+}
+
+// adminRoomView is a room as the admin API shows it
+type adminRoomView struct {
+	ID          string           `json:"id"`
+	Started     bool             `json:"started"`
+	Turn        string           `json:"turn,omitempty"`
+	Board       []string         `json:"board"`
+	Seq         uint64           `json:"seq"`
+	Settings    roomSettings     `json:"settings"`
+	Connections []connectionView `json:"connections"`
+}
+
+// connectionView is one connection as the admin API shows it
+type connectionView struct {
+	ID        string    `json:"id"`
+	IP        string    `json:"ip"`
+	Room      string    `json:"room"`
+	Name      string    `json:"name"`
+	Symbol    string    `json:"symbol,omitempty"`
+	Transport string    `json:"transport"`
+	Codec     string    `json:"codec"`
+	Connected time.Time `json:"connected"`
+}
+
+// adminView describes the room and everyone in it
+func (r *Room) adminView() adminRoomView {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	view := adminRoomView{
+		ID:          r.ID,
+		Started:     r.started,
+		Board:       append([]string(nil), r.game.Board...),
+		Seq:         r.seq,
+		Settings:    r.settings(),
+		Connections: make([]connectionView, 0, len(r.clients)),
+	}
+	if r.started {
+		view.Turn = r.game.Turn
+	}
+	for c := range r.clients {
+		view.Connections = append(view.Connections, connectionView{
+			ID:        c.id,
+			IP:        c.ip,
+			Room:      r.ID,
+			Name:      c.name,
+			Symbol:    c.symbol,
+			Transport: c.conn.name(),
+			Codec:     c.codec.Name(),
+			Connected: c.connected,
+		})
+	}
+	sort.Slice(view.Connections, func(i, j int) bool {
+		return view.Connections[i].Connected.Before(view.Connections[j].Connected)
+	})
+	return view
+}
+
+// settings reads the admin settings, call with the lock held
+func (r *Room) settings() roomSettings {
+	return roomSettings{
+		AutoRematch:    r.opts.autoRematch,
+		SpectatorDelay: int(r.opts.spectatorDelay / time.Second),
+	}
+}
+
+// changeSettings applies the settings that are set and returns the result
+func (r *Room) changeSettings(req roomSettingsRequest) roomSettings {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if req.SpectatorDelay != nil {
+		r.setSpectatorDelay(time.Duration(*req.SpectatorDelay) * time.Second)
+	}
+	if req.AutoRematch != nil {
+		r.opts.autoRematch = *req.AutoRematch
+		if r.opts.autoRematch {
+			r.maybeStart()
+		}
+	}
+	return r.settings()
+}
+
+// end stops the game in progress as a draw, reported like any other game over
+func (r *Room) end(reason string) error {
+	r.mu.Lock()
+	if !r.started {
+		r.mu.Unlock()
+		return errNoGame
+	}
+	r.broadcast(&protocol.GameOver{Text: reason})
+	onGameOver := r.finishGame("", "adjudication")
+	r.mu.Unlock()
+
+	if onGameOver != nil {
+		onGameOver("")
+	}
+	return nil
+}
+
+// reset throws the game in progress away and starts a new one with the same
+// players, nothing is reported
+func (r *Room) reset(reason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.started {
+		return errNoGame
+	}
+	r.broadcast(&protocol.GameOver{Text: reason})
+	r.settleWager("", "reset")
+	r.started = false
+	r.game = game.New(r.opts.gameRules())
+	r.mergeChat()
+	r.maybeStart()
+	return nil
+}
+
+// announce posts a server announcement in the room
+func (r *Room) announce(text string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.broadcastSystem("ANNOUNCEMENT: " + text)
+}
+
+// bans
+
+// ban refuses new connections from an address
+type ban struct {
+	IP     string    `json:"ip"`
+	Reason string    `json:"reason,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// message tells a banned client why
+func (b ban) message() string {
+	if b.Reason == "" {
+		return "You have been banned."
+	}
+	return "You have been banned: " + b.Reason
+}
+
+type banList struct {
+	mu   sync.Mutex
+	bans map[string]ban
+}
+
+func newBanList() *banList {
+	return &banList{bans: make(map[string]ban)}
+}
+
+func (b *banList) add(ip, reason string) ban {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entry := ban{IP: ip, Reason: reason, Time: time.Now().UTC()}
+	b.bans[ip] = entry
+	return entry
+}
+
+// remove lifts a ban, reporting whether there was one
+func (b *banList) remove(ip string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.bans[ip]
+	delete(b.bans, ip)
+	return ok
+}
+
+func (b *banList) banned(ip string) (ban, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entry, ok := b.bans[ip]
+	return entry, ok
+}
+
+// list returns the bans, oldest first
+func (b *banList) list() []ban {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	list := make([]ban, 0, len(b.bans))
+	for _, entry := range b.bans {
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Time.Before(list[j].Time) })
+	return list
+}
+
+// banIP bans an address and kicks everyone connected from it, returning their ids
+func (a *adminAPI) banIP(ip, reason string) []string {
+	message := a.bans.add(ip, reason).message()
+	kicked := []string{}
+	for _, c := range a.clients.fromIP(ip) {
+		kick(c, protocol.CodeBanned, message)
+		kicked = append(kicked, c.id)
+	}
+	return kicked
+}
+
+// REST handlers
+
+type reasonRequest struct {
+	Reason string `json:"reason"`
+}
+
+type banRequest struct {
+	IP     string `json:"ip"`
+	Reason string `json:"reason"`
+}
+
+// roomSettingsRequest only changes the settings it includes, SpectatorDelay is in seconds
+type roomSettingsRequest struct {
+	AutoRematch    *bool `json:"autoRematch"`
+	SpectatorDelay *int  `json:"spectatorDelay"`
+}
+
+type announceRequest struct {
+	Text string `json:"text"`
+}
+
+func (a *adminAPI) handleRooms(w http.ResponseWriter, r *http.Request) error {
+	rooms := a.hub.list()
+	views := make([]adminRoomView, 0, len(rooms))
+	for _, room := range rooms {
+		views = append(views, room.adminView())
+	}
+	return WriteJSON(w, http.StatusOK, views)
+}
+
+func (a *adminAPI) handleRoom(w http.ResponseWriter, r *http.Request) error {
+	room, err := a.room(r)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, room.adminView())
+}
+
+func (a *adminAPI) handleConnections(w http.ResponseWriter, r *http.Request) error {
+	connections := []connectionView{}
+	for _, room := range a.hub.list() {
+		connections = append(connections, room.adminView().Connections...)
+	}
+	return WriteJSON(w, http.StatusOK, connections)
+}
+
+func (a *adminAPI) handleKick(w http.ResponseWriter, r *http.Request, entry *auditEntry) error {
+	entry.Target = r.PathValue("id")
+	req := new(reasonRequest)
+	if err := readOptionalJSON(r, req); err != nil {
+		return err
+	}
+	c := a.clients.get(entry.Target)
+	if c == nil {
+		return notFound("connection %s not found", entry.Target)
+	}
+	message := "You were removed by an admin."
+	if req.Reason != "" {
+		message = "You were removed by an admin: " + req.Reason
+	}
+	entry.Details = map[string]string{"ip": c.ip, "reason": req.Reason}
+	kick(c, protocol.CodeKicked, message)
+	return nil
+}
+
+func (a *adminAPI) handleBanConnection(w http.ResponseWriter, r *http.Request, entry *auditEntry) error {
+	entry.Target = r.PathValue("id")
+	req := new(reasonRequest)
+	if err := readOptionalJSON(r, req); err != nil {
+		return err
+	}
+	c := a.clients.get(entry.Target)
+	if c == nil {
+		return notFound("connection %s not found", entry.Target)
+	}
+	entry.Details = map[string]any{"ip": c.ip, "reason": req.Reason, "kicked": a.banIP(c.ip, req.Reason)}
+	return nil
+}
+
+func (a *adminAPI) handleBans(w http.ResponseWriter, r *http.Request) error {
+	return WriteJSON(w, http.StatusOK, a.bans.list())
+}
+
+func (a *adminAPI) handleBan(w http.ResponseWriter, r *http.Request, entry *auditEntry) error {
+	req := new(banRequest)
+	if err := readJSON(r, req); err != nil {
+		return err
+	}
+	entry.Target = req.IP
+	if net.ParseIP(req.IP) == nil {
+		return fmt.Errorf("%q is not an IP address", req.IP)
+	}
+	entry.Details = map[string]any{"reason": req.Reason, "kicked": a.banIP(req.IP, req.Reason)}
+	return nil
+}
+
+func (a *adminAPI) handleUnban(w http.ResponseWriter, r *http.Request, entry *auditEntry) error {
+	entry.Target = r.PathValue("ip")
+	if !a.bans.remove(entry.Target) {
+		return notFound("%s is not banned", entry.Target)
+	}
+	return nil
+}
+
+func (a *adminAPI) handleEndRoom(w http.ResponseWriter, r *http.Request, entry *auditEntry) error {
+	entry.Target = r.PathValue("id")
+	req := new(reasonRequest)
+	if err := readOptionalJSON(r, req); err != nil {
+		return err
+	}
+	room, err := a.room(r)
+	if err != nil {
+		return err
+	}
+	message := "The game was ended by an admin."
+	if req.Reason != "" {
+		message = "The game was ended by an admin: " + req.Reason
+	}
+	entry.Details = map[string]string{"reason": req.Reason}
+	return room.end(message)
+}
+
+func (a *adminAPI) handleResetRoom(w http.ResponseWriter, r *http.Request, entry *auditEntry) error {
+	entry.Target = r.PathValue("id")
+	req := new(reasonRequest)
+	if err := readOptionalJSON(r, req); err != nil {
+		return err
+	}
+	room, err := a.room(r)
+	if err != nil {
+		return err
+	}
+	message := "The game was reset by an admin."
+	if req.Reason != "" {
+		message = "The game was reset by an admin: " + req.Reason
+	}
+	entry.Details = map[string]string{"reason": req.Reason}
+	return room.reset(message)
+}
+
+func (a *adminAPI) handleRoomSettings(w http.ResponseWriter, r *http.Request, entry *auditEntry) error {
+	entry.Target = r.PathValue("id")
+	req := new(roomSettingsRequest)
+	if err := readJSON(r, req); err != nil {
+		return err
+	}
+	if d := req.SpectatorDelay; d != nil && (*d < 0 || time.Duration(*d)*time.Second > maxSpectatorDelay) {
+		return fmt.Errorf("spectatorDelay must be between 0 and %d seconds", int(maxSpectatorDelay.Seconds()))
+	}
+	room, err := a.room(r)
+	if err != nil {
+		return err
+	}
+	entry.Details = room.changeSettings(*req)
+	return nil
+}
+
+func (a *adminAPI) handleAnnounce(w http.ResponseWriter, r *http.Request, entry *auditEntry) error {
+	req := new(announceRequest)
+	if err := readJSON(r, req); err != nil {
+		return err
+	}
+	if req.Text == "" || len(req.Text) > 500 {
+		return errors.New("text must be 1 to 500 characters")
+	}
+	rooms := a.hub.list()
+	for _, room := range rooms {
+		room.announce(req.Text)
+	}
+	entry.Details = map[string]any{"text": req.Text, "rooms": len(rooms)}
+	return nil
+}
+
+func (a *adminAPI) handleAudit(w http.ResponseWriter, r *http.Request) error {
+	return WriteJSON(w, http.StatusOK, a.audit.list())
+}
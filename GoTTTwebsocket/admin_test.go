@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"goChatSocket/client"
+	"goChatSocket/protocol"
+)
+
+// adminCall sends one admin request and decodes the JSON answer into out
+func adminCall(t *testing.T, base, token, method, path string, body, out any) int {
+	t.Helper()
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	req, err := http.NewRequest(method, base+path, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestAdmin(t *testing.T) {
+	server := newServer()
+	server.enableAdmin("secret", nil)
+	srv := httptest.NewServer(server.routes())
+	defer srv.Close()
+	url := roomURL(t, "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", "moderated")
+
+	if status := adminCall(t, srv.URL, "", "GET", "/admin/rooms", nil, nil); status != http.StatusUnauthorized {
+		t.Fatalf("no token: status %d", status)
+	}
+	if status := adminCall(t, srv.URL, "wrong", "GET", "/admin/rooms", nil, nil); status != http.StatusUnauthorized {
+		t.Fatalf("wrong token: status %d", status)
+	}
+
+	x, o := dial(t, url), dial(t, url)
+	waitFor(t, o, func(e client.TurnChanged) bool { return e.Symbol == "X" })
+
+	var rooms []adminRoomView
+	adminCall(t, srv.URL, "secret", "GET", "/admin/rooms", nil, &rooms)
+	if len(rooms) != 1 || !rooms[0].Started || len(rooms[0].Connections) != 2 {
+		t.Fatalf("rooms %+v", rooms)
+	}
+	xID := rooms[0].Connections[0].ID
+
+	// an announcement reaches everyone
+	adminCall(t, srv.URL, "secret", "POST", "/admin/announcements", announceRequest{Text: "restart at noon"}, nil)
+	waitFor(t, o, func(e client.SystemNotice) bool { return e.Text == "ANNOUNCEMENT: restart at noon" })
+
+	// kicking X tells them why and forfeits their game
+	if status := adminCall(t, srv.URL, "secret", "POST", "/admin/connections/"+xID+"/kick", reasonRequest{Reason: "spam"}, nil); status != http.StatusOK {
+		t.Fatalf("kick: status %d", status)
+	}
+	rejected := waitFor[client.Rejected](t, x, nil)
+	if rejected.Code != protocol.CodeKicked {
+		t.Fatalf("kicked client got %+v", rejected)
+	}
+	waitFor(t, o, func(e client.GameOver) bool { return e.Winner == "O" })
+
+	// after a ban the address can't come back
+	adminCall(t, srv.URL, "secret", "POST", "/admin/bans", banRequest{IP: "127.0.0.1", Reason: "cheating"}, nil)
+	waitFor(t, o, func(e client.Rejected) bool { return e.Code == protocol.CodeBanned })
+	if _, err := client.Dial(url); err == nil || !strings.Contains(err.Error(), "cheating") {
+		t.Fatalf("banned dial got %v", err)
+	}
+	adminCall(t, srv.URL, "secret", "DELETE", "/admin/bans/127.0.0.1", nil, nil)
+	dial(t, url)
+
+	// the ban kicked O too, the new connection waits alone: no game to reset but settings can change
+	if status := adminCall(t, srv.URL, "secret", "POST", "/admin/rooms/moderated/reset", nil, nil); status != http.StatusConflict {
+		t.Fatalf("reset without a game: status %d", status)
+	}
+	var changed auditEntry
+	delay := 5
+	adminCall(t, srv.URL, "secret", "PATCH", "/admin/rooms/moderated/settings", roomSettingsRequest{SpectatorDelay: &delay}, &changed)
+	if settings, _ := changed.Details.(map[string]any); settings["spectatorDelay"] != 5.0 || settings["autoRematch"] != true {
+		t.Fatalf("settings changed to %+v", changed.Details)
+	}
+
+	var audit []auditEntry
+	adminCall(t, srv.URL, "secret", "GET", "/admin/audit", nil, &audit)
+	var actions []string
+	for _, entry := range audit {
+		actions = append(actions, entry.Action)
+	}
+	if got := strings.Join(actions, ","); got != "announce,kick,ban,unban,resetRoom,roomSettings" {
+		t.Fatalf("audit log has %s", got)
+	}
+}
@@ -0,0 +1,157 @@
+// Package ai picks moves without searching the game tree, for boards too big
+// for the solver in the game package such as 4x4x4 Qubic.
+package ai
+
+import "goChatSocket/game"
+
+// Heuristic picks a move for the side to move, in order of preference:
+//   - a move that wins
+//   - a move that stops the opponent winning next
+//   - a fork: a move leaving two lines one short of a win
+//   - against an opponent's fork, a threat that forces them elsewhere, or
+//     else taking the fork square
+//   - the move with the best score over the lines through it, open lines
+//     count more the fuller they are and our own count double
+//
+// Ties go to the lowest position. The game must not be over.
+func Heuristic(g *game.Game) int {
+	p := newPosition(g)
+	me, them := g.Turn, game.Other(g.Turn)
+	moves := g.LegalMoves()
+
+	for _, symbol := range []string{me, them} {
+		for _, pos := range moves {
+			if p.completes(pos, symbol) {
+				return pos
+			}
+		}
+	}
+	for _, pos := range moves {
+		if p.threats(pos, me) >= 2 {
+			return pos
+		}
+	}
+
+	var forks []int
+	for _, pos := range moves {
+		if p.threats(pos, them) >= 2 {
+			forks = append(forks, pos)
+		}
+	}
+	if len(forks) > 0 {
+		if pos, ok := p.forcingMove(moves, me, them); ok {
+			return pos
+		}
+		return forks[0]
+	}
+
+	best, bestScore := moves[0], -1
+	for _, pos := range moves {
+		if score := p.score(pos, me); score > bestScore {
+			best, bestScore = pos, score
+		}
+	}
+	return best
+}
+
+// position is a board with the lines crossing each cell
+type position struct {
+	board   []string
+	line    int
+	through [][][]int
+}
+
+func newPosition(g *game.Game) *position {
+	p := &position{
+		board:   append([]string(nil), g.Board...),
+		line:    g.Rules.Line,
+		through: make([][][]int, len(g.Board)),
+	}
+	for _, line := range g.Rules.Lines() {
+		for _, pos := range line {
+			p.through[pos] = append(p.through[pos], line)
+		}
+	}
+	return p
+}
+
+// count returns how many cells of line symbol holds and how many the other side does
+func (p *position) count(line []int, symbol string) (mine, theirs int) {
+	for _, pos := range line {
+		switch p.board[pos] {
+		case "":
+		case symbol:
+			mine++
+		default:
+			theirs++
+		}
+	}
+	return mine, theirs
+}
+
+// completes reports whether symbol at pos finishes a line
+func (p *position) completes(pos int, symbol string) bool {
+	for _, line := range p.through[pos] {
+		if mine, theirs := p.count(line, symbol); mine == p.line-1 && theirs == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// threats counts the lines symbol at pos leaves one short of a win
+func (p *position) threats(pos int, symbol string) int {
+	threats := 0
+	for _, line := range p.through[pos] {
+		if mine, theirs := p.count(line, symbol); mine == p.line-2 && theirs == 0 {
+			threats++
+		}
+	}
+	return threats
+}
+
+// forcingMove finds a move making a threat whose answer doesn't give the
+// opponent a fork, so they have to defend instead of forking
+func (p *position) forcingMove(moves []int, me, them string) (int, bool) {
+	for _, pos := range moves {
+		p.board[pos] = me
+		answer := -1
+		for _, line := range p.through[pos] {
+			if mine, theirs := p.count(line, me); mine == p.line-1 && theirs == 0 {
+				answer = p.emptyIn(line)
+				break
+			}
+		}
+		safe := answer >= 0 && p.threats(answer, them) < 2
+		p.board[pos] = ""
+		if safe {
+			return pos, true
+		}
+	}
+	return 0, false
+}
+
+// emptyIn returns the first empty cell of line
+func (p *position) emptyIn(line []int) int {
+	for _, pos := range line {
+		if p.board[pos] == "" {
+			return pos
+		}
+	}
+	return -1
+}
+
+// score values pos for symbol by the open lines through it
+func (p *position) score(pos int, symbol string) int {
+	score := 0
+	for _, line := range p.through[pos] {
+		mine, theirs := p.count(line, symbol)
+		switch {
+		case theirs == 0:
+			score += 2 << (2 * mine)
+		case mine == 0:
+			score += 1 << (2 * theirs)
+		}
+	}
+	return score
+}
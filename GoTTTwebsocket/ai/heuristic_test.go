@@ -0,0 +1,80 @@
+package ai
+
+import (
+	"math/rand"
+	"testing"
+
+	"goChatSocket/game"
+)
+
+func TestHeuristicWinsAndBlocks(t *testing.T) {
+	tests := map[string]struct {
+		board []string
+		turn  string
+		want  int
+	}{
+		"win":                {[]string{"X", "X", "", "O", "O", "", "", "", ""}, "X", 2},
+		"block":              {[]string{"X", "X", "", "", "O", "", "", "", ""}, "O", 2},
+		"win before a block": {[]string{"X", "X", "", "O", "O", "", "X", "", ""}, "O", 5},
+	}
+	for name, tt := range tests {
+		g, err := game.FromPosition(game.Classic, tt.board, tt.turn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := Heuristic(g); got != tt.want {
+			t.Errorf("%s: played %d, want %d", name, got, tt.want)
+		}
+	}
+}
+
+// the heuristic never loses 3x3, whatever the opponent tries
+func TestHeuristicNeverLosesClassic(t *testing.T) {
+	for _, side := range []string{game.X, game.O} {
+		var try func(g *game.Game, moves []int)
+		try = func(g *game.Game, moves []int) {
+			if g.Over() {
+				if winner := g.Winner(); winner != "" && winner != side {
+					t.Fatalf("lost as %s after %v", side, moves)
+				}
+				return
+			}
+			if g.Turn == side {
+				pos := Heuristic(g)
+				next := g.Clone()
+				if err := next.Play(pos, side); err != nil {
+					t.Fatal(err)
+				}
+				try(next, append(moves, pos))
+				return
+			}
+			for _, pos := range g.LegalMoves() {
+				next := g.Clone()
+				next.Play(pos, next.Turn)
+				try(next, append(append([]int(nil), moves...), pos))
+			}
+		}
+		try(game.New(game.Classic), nil)
+	}
+}
+
+func TestHeuristicBeatsRandomQubic(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		g := game.New(game.Qubic)
+		side := []string{game.X, game.O}[i%2]
+		for !g.Over() {
+			pos := Heuristic(g)
+			if g.Turn != side {
+				moves := g.LegalMoves()
+				pos = moves[rng.Intn(len(moves))]
+			}
+			if err := g.Play(pos, g.Turn); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if g.Winner() != side {
+			t.Fatalf("game %d as %s: winner %q after %v", i, side, g.Winner(), g.Moves)
+		}
+	}
+}
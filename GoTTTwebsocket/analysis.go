@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+
+	"goChatSocket/game"
+	"goChatSocket/protocol"
+)
+
+// the biggest board side the solver is asked about
+const maxAnalysisSize = 6
+
+// maxAnalysisEmpty caps the empty cells of an analyzed position, the solver
+// needs about a second for 12 on the bigger boards and several for 13
+const maxAnalysisEmpty = 12
+
+// maxAnalyses is how many analyses may run at once, the rest are turned away
+const maxAnalyses = 2
+
+var (
+	errRatedAnalysis = errors.New("Analysis is disabled during rated games.")
+	errAnalysisBusy  = errors.New("Too many analyses are running, try again in a moment.")
+)
+
+// analyze solves the position in req for POST /analyze and the analyze message
+func analyze(req *protocol.Analyze) (*protocol.Analysis, error) {
+	size := int(math.Sqrt(float64(len(req.Board))))
+	if size*size != len(req.Board) || size > maxAnalysisSize {
+		return nil, fmt.Errorf("board must be square with up to %d cells per side", maxAnalysisSize)
+	}
+	// refuse what the solver would only give up on after searching for seconds
+	empty := 0
+	for _, cell := range req.Board {
+		if cell == "" {
+			empty++
+		}
+	}
+	if empty > maxAnalysisEmpty {
+		return nil, fmt.Errorf("position has %d empty cells, the solver takes up to %d", empty, maxAnalysisEmpty)
+	}
+	rules := game.Rules{Size: size, Line: req.Line}
+	if rules.Line == 0 {
+		rules.Line = size
+	}
+
+	g, err := game.FromPosition(rules, req.Board, req.Turn)
+	if err != nil {
+		return nil, err
+	}
+	a, err := game.Analyze(g)
+	if err != nil {
+		return nil, err
+	}
+
+	analysis := &protocol.Analysis{Turn: a.Turn, Outcome: a.Outcome, Plies: a.Plies, Best: a.Best}
+	for _, m := range a.Moves {
+		analysis.Moves = append(analysis.Moves, protocol.MoveValue{Position: m.Position, Outcome: m.Outcome, Plies: m.Plies})
+	}
+	return analysis, nil
+}
+
+// handleAnalyze answers POST /analyze, the body is a protocol.Analyze
+func (s *Server) handleAnalyze(w http.ResponseWriter, r *http.Request) error {
+	var req protocol.Analyze
+	if err := readJSON(r, &req); err != nil {
+		return err
+	}
+	if err := req.Validate(); err != nil {
+		return err
+	}
+	// nobody gets to look up the position of a rated game in progress
+	if s.hub.ratedPosition(req.Board) {
+		return statusError{http.StatusForbidden, errRatedAnalysis}
+	}
+	if !s.startAnalysis() {
+		return statusError{http.StatusServiceUnavailable, errAnalysisBusy}
+	}
+	defer s.endAnalysis()
+	analysis, err := analyze(&req)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, analysis)
+}
+
+// analyzeFor answers an analyze message from c, who is in room
+func (s *Server) analyzeFor(c *Client, room *Room, req *protocol.Analyze) error {
+	if !room.analysisAllowed() || s.hub.ratedPosition(req.Board) {
+		return &protocol.Error{Code: protocol.CodeAnalysisDisabled, Message: errRatedAnalysis.Error()}
+	}
+	if !s.startAnalysis() {
+		return &protocol.Error{Code: protocol.CodeRateLimited, Message: errAnalysisBusy.Error()}
+	}
+	defer s.endAnalysis()
+	analysis, err := analyze(req)
+	if err != nil {
+		return &protocol.Error{Code: protocol.CodeInvalidPayload, Message: err.Error()}
+	}
+	c.reply(analysis)
+	return nil
+}
+
+// startAnalysis takes one of the maxAnalyses slots, false if they are all in use
+func (s *Server) startAnalysis() bool {
+	select {
+	case s.analyses <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// endAnalysis gives back the slot of a finished analysis
+func (s *Server) endAnalysis() {
+	<-s.analyses
+}
+
+// analysisAllowed reports whether the room's clients may use the solver,
+// not while a rated game is being played
+func (r *Room) analysisAllowed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !r.playingRated()
+}
+
+// playingRated reports whether the room is playing a game that counts: a rated
+// room's, one played for stakes, or one between accounts that rateGame will rate.
+// Call with the lock held.
+func (r *Room) playingRated() bool {
+	return r.started && (r.opts.rated || r.opts.stake > 0 || r.ratesAccounts())
+}
+
+// ratedPosition reports whether board is on the table in a rated game in progress
+func (h *Hub) ratedPosition(board []string) bool {
+	for _, r := range h.list() {
+		r.mu.Lock()
+		live := r.playingRated() && slices.Equal(r.game.Board, board)
+		r.mu.Unlock()
+		if live {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"goChatSocket/client"
+	"goChatSocket/protocol"
+)
+
+// postAnalyze sends a position to POST /analyze
+func postAnalyze(t *testing.T, base string, req protocol.Analyze) (int, protocol.Analysis) {
+	t.Helper()
+	body, _ := json.Marshal(req)
+	resp, err := http.Post(base+"/analyze", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var analysis protocol.Analysis
+	if resp.StatusCode == http.StatusOK {
+		json.NewDecoder(resp.Body).Decode(&analysis)
+	}
+	return resp.StatusCode, analysis
+}
+
+func TestAnalyze(t *testing.T) {
+	server := newServer()
+	srv := httptest.NewServer(server.routes())
+	defer srv.Close()
+	addr := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+
+	// X to move wins at once on the top row
+	board := []string{"X", "X", "", "O", "O", "", "", "", ""}
+	status, analysis := postAnalyze(t, srv.URL, protocol.Analyze{Board: board, Turn: "X"})
+	if status != http.StatusOK || analysis.Best != 2 || analysis.Outcome != "win" || analysis.Plies != 1 {
+		t.Fatalf("status %d analysis %+v", status, analysis)
+	}
+	if status, _ := postAnalyze(t, srv.URL, protocol.Analyze{Board: board, Turn: "O"}); status != http.StatusBadRequest {
+		t.Fatalf("wrong turn: status %d", status)
+	}
+
+	// spectators can ask over the websocket
+	x := dial(t, roomURL(t, addr, "casual"))
+	x.Analyze(board, "X", 0)
+	if got := waitFor[client.Analyzed](t, x, nil); got.Best != 2 || len(got.Moves) != 5 {
+		t.Fatalf("analysis %+v", got)
+	}
+
+	// but nobody can during a rated game, not even through another room or HTTP
+	server.hub.createRoom("rated", roomOptions{rated: true})
+	rx, ro := dial(t, roomURL(t, addr, "rated")), dial(t, roomURL(t, addr, "rated"))
+	waitFor(t, ro, func(e client.TurnChanged) bool { return e.Symbol == "X" })
+	rx.Analyze(make([]string, 9), "X", 0)
+	if rejected := waitFor[client.Rejected](t, rx, nil); rejected.Code != protocol.CodeAnalysisDisabled {
+		t.Fatalf("rated analysis got %+v", rejected)
+	}
+	x.Analyze(make([]string, 9), "X", 0)
+	if rejected := waitFor[client.Rejected](t, x, nil); rejected.Code != protocol.CodeAnalysisDisabled {
+		t.Fatalf("live rated position got %+v", rejected)
+	}
+	if status, _ := postAnalyze(t, srv.URL, protocol.Analyze{Board: make([]string, 9), Turn: "X"}); status != http.StatusForbidden {
+		t.Fatalf("live rated position over HTTP: status %d", status)
+	}
+
+	// an empty 4x4 board is refused before searching
+	if status, _ := postAnalyze(t, srv.URL, protocol.Analyze{Board: make([]string, 16), Turn: "X"}); status != http.StatusBadRequest {
+		t.Fatalf("empty 4x4: status %d", status)
+	}
+
+	// and nothing more runs while every slot is taken
+	for range maxAnalyses {
+		server.startAnalysis()
+	}
+	if status, _ := postAnalyze(t, srv.URL, protocol.Analyze{Board: board, Turn: "X"}); status != http.StatusServiceUnavailable {
+		t.Fatalf("busy analysis over HTTP: status %d", status)
+	}
+	x.Analyze(board, "X", 0)
+	if rejected := waitFor[client.Rejected](t, x, nil); rejected.Code != protocol.CodeRateLimited {
+		t.Fatalf("busy analysis got %+v", rejected)
+	}
+	server.endAnalysis()
+	if status, _ := postAnalyze(t, srv.URL, protocol.Analyze{Board: board, Turn: "X"}); status != http.StatusOK {
+		t.Fatalf("analysis with a free slot: status %d", status)
+	}
+}
+
+func TestPlayingRated(t *testing.T) {
+	ada, bob := &account{ID: 1}, &account{ID: 2}
+	tests := []struct {
+		name    string
+		opts    roomOptions
+		players map[string]gamePlayer
+		rated   bool
+	}{
+		{"casual", roomOptions{}, nil, false},
+		{"rated room", roomOptions{rated: true}, nil, true},
+		{"for stakes", roomOptions{stake: 30}, nil, true},
+		{"between accounts", roomOptions{}, map[string]gamePlayer{"X": {Account: ada}, "O": {Account: bob}}, true},
+		{"account against a guest", roomOptions{}, map[string]gamePlayer{"X": {Account: ada}, "O": {}}, false},
+		{"account against itself", roomOptions{}, map[string]gamePlayer{"X": {Account: ada}, "O": {Account: ada}}, false},
+	}
+	for _, tt := range tests {
+		r := &Room{opts: tt.opts, gamePlayers: tt.players}
+		if r.playingRated() {
+			t.Errorf("%s: rated before the game started", tt.name)
+		}
+		r.started = true
+		if got := r.playingRated(); got != tt.rated {
+			t.Errorf("%s: rated %v, want %v", tt.name, got, tt.rated)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ApiError is the JSON body sent back when a handler fails
+type ApiError struct {
+	Error string `json:"error"`
+}
+
+// statusError lets a handler pick the HTTP status of its error, anything else is a 400
+type statusError struct {
+	status int
+	err    error
+}
+
+func (e statusError) Error() string { return e.err.Error() }
+func (e statusError) Unwrap() error { return e.err }
+
+func notFound(format string, args ...any) error {
+	return statusError{http.StatusNotFound, fmt.Errorf(format, args...)}
+}
+
+// helpers
+func WriteJSON(w http.ResponseWriter, status int, v any) error {
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
+
+// readJSON decodes a request body into v
+func readJSON(r *http.Request, v any) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return fmt.Errorf("invalid JSON body: %w", err)
+	}
+	return nil
+}
+
+type apiFunc func(http.ResponseWriter, *http.Request) error
+
+func makeHTTPHandleFunc(f apiFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := f(w, r); err != nil {
+			status := http.StatusBadRequest
+			var se statusError
+			if errors.As(err, &se) {
+				status = se.status
+			}
+			WriteJSON(w, status, ApiError{Error: err.Error()})
+		}
+	}
+}
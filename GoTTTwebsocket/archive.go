@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"goChatSocket/game"
+	"goChatSocket/notation"
+)
+
+// how many finished games the archive keeps, and how many imported ones besides
+const (
+	maxArchivedGames = 1000
+	maxImportedGames = 1000
+)
+
+// archivedGame is a finished or imported game with its id in the archive
+type archivedGame struct {
+	ID string `json:"id"`
+	*notation.Record
+	n int // the number in ID, to list both kinds of game in order
+}
+
+// gameArchive keeps the last finished games for export, see notation for the format.
+// Imported games are kept apart so importing can't push out games played here.
+type gameArchive struct {
+	mu       sync.Mutex
+	nextID   int
+	games    []archivedGame
+	imported []archivedGame
+}
+
+func newGameArchive() *gameArchive {
+	return &gameArchive{}
+}
+
+// add stores a game played here and returns its id
+func (a *gameArchive) add(rec *notation.Record) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.store(&a.games, rec, maxArchivedGames)
+}
+
+// addImported stores an imported game and returns its id
+func (a *gameArchive) addImported(rec *notation.Record) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.store(&a.imported, rec, maxImportedGames)
+}
+
+// store appends rec to games, dropping the oldest beyond max, call with the lock held
+func (a *gameArchive) store(games *[]archivedGame, rec *notation.Record, max int) string {
+	a.nextID++
+	id := fmt.Sprintf("g%d", a.nextID)
+	*games = append(*games, archivedGame{ID: id, Record: rec, n: a.nextID})
+	if len(*games) > max {
+		*games = (*games)[len(*games)-max:]
+	}
+	return id
+}
+
+// get returns the game with id, or false
+func (a *gameArchive) get(id string) (archivedGame, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, g := range slices.Concat(a.games, a.imported) {
+		if g.ID == id {
+			return g, true
+		}
+	}
+	return archivedGame{}, false
+}
+
+// list returns every archived game, oldest first
+func (a *gameArchive) list() []archivedGame {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	games := slices.Concat(a.games, a.imported)
+	slices.SortFunc(games, func(g, h archivedGame) int { return g.n - h.n })
+	return games
+}
+
+// newRecord starts the record of the game that just began, call with the lock held.
+// Signed in players are tagged with their account and rating, e.g. [XAccount "7"] [XRating "1216"].
+func (r *Room) newRecord() *notation.Record {
+	rec := &notation.Record{
+		Event: r.ID,
+		Date:  time.Now().Format("2006.01.02"),
+		Rules: r.game.Rules,
+		X:     r.playerName(game.X),
+		O:     r.playerName(game.O),
+	}
+	for _, symbol := range []string{game.X, game.O} {
+		if p := r.players[symbol]; p != nil && p.account != nil {
+			if rec.Tags == nil {
+				rec.Tags = make(map[string]string)
+			}
+			rec.Tags[symbol+"Account"] = strconv.Itoa(p.account.ID)
+			rec.Tags[symbol+"Rating"] = strconv.Itoa(r.hub.ratings.get(p.account.ID).Rating)
+		}
+	}
+	return rec
+}
+
+// playerName is the name of whoever plays symbol, or of the player whose seat
+// is kept for them after a takeover
+func (r *Room) playerName(symbol string) string {
+	if p := r.players[symbol]; p != nil {
+		return p.name
+	}
+	return r.returning[symbol].Name
+}
+
+// currentRecord is the game in progress so far, call with the lock held
+func (r *Room) currentRecord() *notation.Record {
+	rec := r.record
+	if rec == nil {
+		rec = r.newRecord()
+	}
+	copied := *rec
+	copied.Moves = append([]int{}, r.game.Moves...)
+	if r.game.Rules.PickSymbol() {
+		copied.Symbols = append([]string(nil), r.game.Symbols...)
+	}
+	copied.Result = notation.Unfinished
+	return &copied
+}
+
+// archiveGame stores the game that just ended, call with the lock held.
+// termination says why it ended early, empty when the board decided it.
+func (r *Room) archiveGame(winner, termination string) {
+	rec := r.currentRecord()
+	rec.Result = notation.ResultFor(winner)
+	rec.Termination = termination
+	_, span := startStorageSpan(r.traceCtx(), "archive.add", attrRoom.String(r.ID))
+	r.hub.archive.add(rec)
+	span.End()
+}
+
+// exportGame returns the game in progress, or nil when none is. delayed is
+// true when the room holds its spectators back, then the game stays unexported.
+func (r *Room) exportGame() (rec *notation.Record, delayed bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.started {
+		return nil, false
+	}
+	if r.opts.spectatorDelay > 0 {
+		return nil, true
+	}
+	return r.currentRecord(), false
+}
+
+// writeNotation sends games as TTN text
+func writeNotation(w http.ResponseWriter, records ...*notation.Record) error {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	for i, rec := range records {
+		if i > 0 {
+			io.WriteString(w, "\n")
+		}
+		io.WriteString(w, notation.Format(rec))
+	}
+	return nil
+}
+
+// wantsNotation reports whether a request asked for TTN rather than JSON
+func wantsNotation(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "ttn" || strings.HasPrefix(r.Header.Get("Accept"), "text/plain")
+}
+
+// handleGames lists the archived games, ?format=ttn exports them all as one text.
+// ?account=<id> only lists the games that account played.
+func (s *Server) handleGames(w http.ResponseWriter, r *http.Request) error {
+	games := s.hub.archive.list()
+	if id := r.URL.Query().Get("account"); id != "" {
+		games = slices.DeleteFunc(games, func(g archivedGame) bool {
+			return g.Tags["XAccount"] != id && g.Tags["OAccount"] != id
+		})
+	}
+	if wantsNotation(r) {
+		records := make([]*notation.Record, len(games))
+		for i, g := range games {
+			records[i] = g.Record
+		}
+		return writeNotation(w, records...)
+	}
+	return WriteJSON(w, http.StatusOK, games)
+}
+
+// handleGame exports one archived game
+func (s *Server) handleGame(w http.ResponseWriter, r *http.Request) error {
+	g, ok := s.hub.archive.get(r.PathValue("id"))
+	if !ok {
+		return notFound("game %s not found", r.PathValue("id"))
+	}
+	if wantsNotation(r) {
+		return writeNotation(w, g.Record)
+	}
+	return WriteJSON(w, http.StatusOK, g)
+}
+
+// handleRoomGame exports the game being played in a room
+func (s *Server) handleRoomGame(w http.ResponseWriter, r *http.Request) error {
+	room := s.hub.get(r.PathValue("id"))
+	if room == nil {
+		return notFound("room %s not found", r.PathValue("id"))
+	}
+	rec, delayed := room.exportGame()
+	if delayed {
+		// anyone could read the moves here before the delayed spectators see them
+		return statusError{http.StatusForbidden, fmt.Errorf("room %s delays its spectators, its game is archived when it ends", room.ID)}
+	}
+	if rec == nil {
+		return notFound("no game in progress in room %s", room.ID)
+	}
+	if wantsNotation(r) {
+		return writeNotation(w, rec)
+	}
+	return WriteJSON(w, http.StatusOK, rec)
+}
+
+// the biggest notation body POST /games reads
+const maxImportSize = 1 << 20
+
+// handleImport archives the games in a TTN body after replaying each one
+// through the engine, nothing is stored unless they all check out
+func (s *Server) handleImport(w http.ResponseWriter, r *http.Request) error {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+	if err != nil {
+		return err
+	}
+	records, err := notation.ParseAll(string(body))
+	if err != nil {
+		return err
+	}
+	for i, rec := range records {
+		if _, err := rec.Replay(); err != nil {
+			return fmt.Errorf("game %d: %w", i+1, err)
+		}
+	}
+
+	imported := make([]archivedGame, len(records))
+	for i, rec := range records {
+		// accounts and ratings only come from games played here, or anyone could write a history
+		for _, symbol := range []string{game.X, game.O} {
+			delete(rec.Tags, symbol+"Account")
+			delete(rec.Tags, symbol+"Rating")
+		}
+		imported[i] = archivedGame{ID: s.hub.archive.addImported(rec), Record: rec}
+	}
+	return WriteJSON(w, http.StatusCreated, imported)
+}
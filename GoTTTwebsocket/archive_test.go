@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"goChatSocket/client"
+	"goChatSocket/game"
+	"goChatSocket/notation"
+)
+
+// get fetches a path and returns the status and body
+func get(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestGameExportImport(t *testing.T) {
+	srv := httptest.NewServer(newServer().routes())
+	defer srv.Close()
+	url := roomURL(t, "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", "archived")
+
+	x, o := dial(t, url), dial(t, url)
+	play(t, x, o, 4, 0, 6, 2, 1, 8, 7)
+	waitFor[client.GameOver](t, o, nil)
+
+	status, text := get(t, srv.URL+"/games/g1?format=ttn")
+	if status != http.StatusOK || !strings.Contains(text, "1. b2 a1 2. a3 c1 3. b1 c3 4. b3 1-0") || !strings.Contains(text, `[Event "archived"]`) {
+		t.Fatalf("status %d export:\n%s", status, text)
+	}
+
+	// the rematch is exported as it goes
+	waitFor(t, x, func(e client.TurnChanged) bool { return e.Symbol == "X" })
+	x.Move(4)
+	waitFor(t, o, func(e client.Moved) bool { return e.Position == 4 })
+	var current notation.Record
+	status, body := get(t, srv.URL+"/rooms/archived/game")
+	json.Unmarshal([]byte(body), &current)
+	if status != http.StatusOK || current.Result != notation.Unfinished || len(current.Moves) != 1 {
+		t.Fatalf("status %d game in progress %s", status, body)
+	}
+
+	// importing replays every game before anything is stored
+	resp, err := http.Post(srv.URL+"/games", "text/plain", strings.NewReader(text+"\n1. a1 a1 *\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("illegal import: status %d", resp.StatusCode)
+	}
+	resp, err = http.Post(srv.URL+"/games", "text/plain", strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var imported []archivedGame
+	json.NewDecoder(resp.Body).Decode(&imported)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || len(imported) != 1 || imported[0].ID != "g2" {
+		t.Fatalf("import: status %d %+v", resp.StatusCode, imported)
+	}
+}
+
+func TestRoomGameDelayed(t *testing.T) {
+	srv := httptest.NewServer(newServer().routes())
+	defer srv.Close()
+	url := roomURL(t, "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", "delayed") + "&spectatorDelay=1m"
+
+	// the moves stay out of reach until the delayed spectators have them
+	x, o := dial(t, url), dial(t, url)
+	play(t, x, o, 4)
+	waitFor(t, o, func(e client.Moved) bool { return e.Position == 4 })
+	if status, body := get(t, srv.URL+"/rooms/delayed/game"); status != http.StatusForbidden {
+		t.Fatalf("delayed game in progress: status %d %s", status, body)
+	}
+	if status, _ := get(t, srv.URL+"/rooms/delayed/game?format=ttn"); status != http.StatusForbidden {
+		t.Fatalf("delayed game in progress as TTN: status %d", status)
+	}
+}
+
+func TestImportIsKeptApart(t *testing.T) {
+	server := newServer()
+	srv := httptest.NewServer(server.routes())
+	defer srv.Close()
+	played := server.hub.archive.add(&notation.Record{Rules: game.Classic, Tags: map[string]string{"XAccount": "1"}})
+
+	// an import can't claim games for an account
+	forged := "[X \"Ada\"]\n[O \"Bob\"]\n[XAccount \"1\"]\n[XRating \"3000\"]\n\n1. a1 b1 2. a2 b2 3. a3 1-0\n"
+	resp, err := http.Post(srv.URL+"/games", "text/plain", strings.NewReader(forged))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	var games []archivedGame
+	adminCall(t, srv.URL, "", "GET", "/games?account=1", nil, &games)
+	if resp.StatusCode != http.StatusCreated || len(games) != 1 || games[0].ID != played {
+		t.Fatalf("import: status %d, account history %+v", resp.StatusCode, games)
+	}
+
+	// nor push out the games played here
+	for range maxImportedGames {
+		server.hub.archive.addImported(&notation.Record{Rules: game.Classic})
+	}
+	if _, ok := server.hub.archive.get(played); !ok {
+		t.Fatal("imports pushed out a game played here")
+	}
+	all := server.hub.archive.list()
+	if len(all) != maxImportedGames+1 || all[0].ID != played {
+		t.Fatalf("archive lists %d games starting with %s", len(all), all[0].ID)
+	}
+}
+
+func TestImportRejectsHugeBoards(t *testing.T) {
+	srv := httptest.NewServer(newServer().routes())
+	defer srv.Close()
+	for _, rules := range []string{"60x60x60/4", "27x27/3", "1000x1000/3"} {
+		text := "[Rules \"" + rules + "\"]\n\n1. a1 *\n"
+		resp, err := http.Post(srv.URL+"/games", "text/plain", strings.NewReader(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("%s: status %d", rules, resp.StatusCode)
+		}
+	}
+}
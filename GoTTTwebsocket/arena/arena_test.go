@@ -0,0 +1,168 @@
+package arena
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"goChatSocket/game"
+)
+
+// The test binary doubles as a fake engine: run as "<binary> fake-engine <behaviour>"
+// it speaks TTTP instead of running the tests.
+func TestMain(m *testing.M) {
+	if len(os.Args) == 3 && os.Args[1] == "fake-engine" {
+		fakeEngine(os.Args[2])
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// fakeEngine plays the first empty cell, unless behaviour says otherwise:
+//
+//	nostart   exits before the handshake
+//	crash     exits when asked for a move
+//	slow      never answers a move
+//	illegal   answers every move with a cell off the board
+func fakeEngine(behaviour string) {
+	if behaviour == "nostart" {
+		return
+	}
+	var board string
+	lines := bufio.NewScanner(os.Stdin)
+	for lines.Scan() {
+		fields := strings.Fields(lines.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "tttp":
+			fmt.Println("id name fake-" + behaviour)
+			fmt.Println("tttpok")
+		case "isready":
+			fmt.Println("readyok")
+		case "position":
+			board = fields[1]
+		case "go":
+			switch behaviour {
+			case "crash":
+				os.Stdout.Close() // the pipe closes now, not once the (race enabled) binary has exited
+				return
+			case "slow":
+				time.Sleep(time.Minute)
+			case "illegal":
+				fmt.Println("info picking a cell nobody else has")
+				fmt.Println("bestmove 99")
+			default:
+				fmt.Println("bestmove", strings.IndexByte(board, '.'))
+			}
+		case "quit":
+			return
+		}
+	}
+}
+
+func fake(behaviour string) Entrant {
+	return Entrant{Name: behaviour, Command: []string{os.Args[0], "fake-engine", behaviour}}
+}
+
+var testConfig = Config{
+	Rules:        game.Classic,
+	MoveTime:     100 * time.Millisecond,
+	StartTimeout: 2 * time.Second,
+	GamesPerPair: 2,
+}
+
+func TestPlayGame(t *testing.T) {
+	tests := []struct {
+		x, o   string
+		winner string
+		reason string
+		moves  int
+	}{
+		// first empty cell each: X takes 0 2 4 6, the 2-4-6 diagonal
+		{"first", "first", "X", ReasonLine, 7},
+		{"first", "crash", "X", ReasonCrash, 1},
+		{"crash", "first", "O", ReasonCrash, 0},
+		{"first", "slow", "X", ReasonTimeout, 1},
+		{"illegal", "first", "O", ReasonIllegal, 0},
+		{"first", "nostart", "X", ReasonCrash, 0},
+		{"nostart", "nostart", "", ReasonCrash, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.x+"-"+tt.o, func(t *testing.T) {
+			r := PlayGame(fake(tt.x), fake(tt.o), testConfig)
+			if r.Winner != tt.winner || r.Reason != tt.reason || len(r.Moves) != tt.moves {
+				t.Fatalf("got %+v, want winner %q by %s after %d moves", r, tt.winner, tt.reason, tt.moves)
+			}
+			if tt.reason != ReasonLine && r.Detail == "" {
+				t.Fatal("forfeit without a detail")
+			}
+		})
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	if _, err := RoundRobin([]Entrant{fake("first")}, testConfig, nil); err == nil {
+		t.Fatal("a round robin of one accepted")
+	}
+
+	// first beats both, illegal beats nostart, which never plays
+	var games int
+	table, err := RoundRobin([]Entrant{fake("first"), fake("illegal"), fake("nostart")}, testConfig, func(Result) { games++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if games != 6 || len(table.Results) != 6 {
+		t.Fatalf("progress saw %d games, table has %d, want 6", games, len(table.Results))
+	}
+	for _, check := range []struct {
+		name      string
+		got, want any
+	}{
+		{"standings", table.Standings(), []int{0, 1, 2}},
+		{"totals", []float64{table.Total(0), table.Total(1), table.Total(2)}, []float64{4, 2, 0}},
+		{"score", table.Score, [][]float64{{0, 2, 2}, {0, 0, 2}, {0, 0, 0}}},
+		{"games", table.Games, [][]int{{0, 2, 2}, {2, 0, 2}, {2, 2, 0}}},
+		{"wins", table.Wins, []int{4, 2, 0}},
+		{"losses", table.Losses, []int{0, 2, 4}},
+		{"errors", table.Errors, []int{0, 2, 4}},
+	} {
+		if !reflect.DeepEqual(check.got, check.want) {
+			t.Errorf("%s: %v, want %v", check.name, check.got, check.want)
+		}
+	}
+	if out := table.String(); !strings.Contains(out, "first") || !strings.Contains(out, "2/2") {
+		t.Errorf("crosstable:\n%s", out)
+	}
+}
+
+func TestCrosstableRecord(t *testing.T) {
+	tests := []struct {
+		result Result
+		score  [][]float64
+		wins   []int
+		draws  []int
+		losses []int
+		errors []int
+	}{
+		{Result{Winner: "X", Reason: ReasonLine}, [][]float64{{0, 1}, {0, 0}}, []int{1, 0}, []int{0, 0}, []int{0, 1}, []int{0, 0}},
+		{Result{Winner: "O", Reason: ReasonTimeout}, [][]float64{{0, 0}, {1, 0}}, []int{0, 1}, []int{0, 0}, []int{1, 0}, []int{1, 0}},
+		{Result{Reason: ReasonDraw}, [][]float64{{0, 0.5}, {0.5, 0}}, []int{0, 0}, []int{1, 1}, []int{0, 0}, []int{0, 0}},
+		// neither could start, neither scores
+		{Result{Reason: ReasonCrash}, [][]float64{{0, 0}, {0, 0}}, []int{0, 0}, []int{0, 0}, []int{1, 1}, []int{1, 1}},
+	}
+	for _, tt := range tests {
+		table := newCrosstable([]Entrant{{Name: "a"}, {Name: "b"}})
+		table.record(0, 1, tt.result)
+		got := []any{table.Score, table.Wins, table.Draws, table.Losses, table.Errors}
+		want := []any{tt.score, tt.wins, tt.draws, tt.losses, tt.errors}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%+v: score, wins, draws, losses, errors %v, want %v", tt.result, got, want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package arena
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Crosstable holds the results of a round robin.
+// Score[i][j] is what entrant i scored against j: 1 per win, 0.5 per draw.
+type Crosstable struct {
+	Names   []string    `json:"names"`
+	Score   [][]float64 `json:"score"`
+	Games   [][]int     `json:"games"`
+	Wins    []int       `json:"wins"`
+	Draws   []int       `json:"draws"`
+	Losses  []int       `json:"losses"`
+	Errors  []int       `json:"errors"` // games lost (or double forfeited) by crash, timeout or illegal move
+	Results []Result    `json:"results"`
+}
+
+func newCrosstable(entrants []Entrant) *Crosstable {
+	n := len(entrants)
+	t := &Crosstable{
+		Names:  make([]string, n),
+		Score:  make([][]float64, n),
+		Games:  make([][]int, n),
+		Wins:   make([]int, n),
+		Draws:  make([]int, n),
+		Losses: make([]int, n),
+		Errors: make([]int, n),
+	}
+	for i, e := range entrants {
+		t.Names[i] = e.Name
+		t.Score[i] = make([]float64, n)
+		t.Games[i] = make([]int, n)
+	}
+	return t
+}
+
+// record adds one game between entrant x (playing X) and o (playing O)
+func (t *Crosstable) record(x, o int, r Result) {
+	t.Results = append(t.Results, r)
+	t.Games[x][o]++
+	t.Games[o][x]++
+
+	switch {
+	case r.Winner == "" && r.Reason != ReasonDraw:
+		// double forfeit, neither engine could play so neither scores
+		t.Losses[x]++
+		t.Losses[o]++
+		t.Errors[x]++
+		t.Errors[o]++
+		return
+	case r.Winner == "":
+		t.Score[x][o] += 0.5
+		t.Score[o][x] += 0.5
+		t.Draws[x]++
+		t.Draws[o]++
+		return
+	case r.Winner == "X":
+	default:
+		x, o = o, x // O won, swap so x is the winner below
+	}
+	t.Score[x][o]++
+	t.Wins[x]++
+	t.Losses[o]++
+	if r.Reason != ReasonLine {
+		t.Errors[o]++
+	}
+}
+
+// Total is entrant i's score across all opponents.
+func (t *Crosstable) Total(i int) float64 {
+	var total float64
+	for _, s := range t.Score[i] {
+		total += s
+	}
+	return total
+}
+
+// Standings returns entrant indexes ordered by total score, best first.
+func (t *Crosstable) Standings() []int {
+	order := make([]int, len(t.Names))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return t.Total(order[a]) > t.Total(order[b])
+	})
+	return order
+}
+
+// String renders the crosstable, one row per entrant in standings order.
+func (t *Crosstable) String() string {
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	order := t.Standings()
+
+	fmt.Fprint(tw, "#\tengine")
+	for rank := range order {
+		fmt.Fprintf(tw, "\t%d", rank+1)
+	}
+	fmt.Fprintln(tw, "\tscore\tW-D-L\terrors")
+
+	for rank, i := range order {
+		fmt.Fprintf(tw, "%d\t%s", rank+1, t.Names[i])
+		for _, j := range order {
+			if i == j {
+				fmt.Fprint(tw, "\t-")
+			} else {
+				fmt.Fprintf(tw, "\t%g/%d", t.Score[i][j], t.Games[i][j])
+			}
+		}
+		fmt.Fprintf(tw, "\t%g\t%d-%d-%d\t%d\n", t.Total(i), t.Wins[i], t.Draws[i], t.Losses[i], t.Errors[i])
+	}
+	tw.Flush()
+	return b.String()
+}
@@ -0,0 +1,234 @@
+// Package arena runs bots ("engines") written in any language against each
+// other. Engines are subprocesses that speak TTTP, a line based protocol in the
+// spirit of UCI, over stdin/stdout:
+//
+//	arena  -> engine   tttp                      start of session
+//	engine -> arena    id name <name>            optional
+//	engine -> arena    tttpok                    handshake done
+//	arena  -> engine   newgame
+//	arena  -> engine   rules size <n> line <k>   n x n board, k in a row wins,
+//	                   [depth <d>]               with d layers on a 3D board
+//	arena  -> engine   isready
+//	engine -> arena    readyok
+//	arena  -> engine   position <cells> <turn>   cells row by row (layer by layer), '.' 'X' or 'O'
+//	arena  -> engine   go movetime <ms>
+//	engine -> arena    bestmove <index>          0 based cell index, row by row
+//	arena  -> engine   quit
+//
+// Engines may print "info ..." lines at any time, they are ignored.
+// An engine that crashes, times out or plays an illegal move loses the game.
+package arena
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"goChatSocket/game"
+)
+
+// Errors returned while talking to an engine.
+var (
+	ErrTimeout  = errors.New("engine timed out")
+	ErrCrashed  = errors.New("engine exited")
+	ErrBadReply = errors.New("engine sent an invalid reply")
+)
+
+// grace is extra time allowed on top of the move budget for process scheduling
+const grace = 50 * time.Millisecond
+
+// Engine is a running engine subprocess.
+type Engine struct {
+	Name string
+
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	lines chan string // stdout, closed when the process exits
+}
+
+// Start launches command and performs the tttp handshake within timeout.
+func Start(command []string, timeout time.Duration) (*Engine, error) {
+	if len(command) == 0 {
+		return nil, errors.New("empty engine command")
+	}
+
+	cmd := exec.Command(command[0], command[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	e := &Engine{
+		Name:  filepath.Base(command[0]),
+		cmd:   cmd,
+		stdin: stdin,
+		lines: make(chan string, 16),
+	}
+
+	// pump stdout into a channel so reads can time out
+	go func() {
+		defer close(e.lines)
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			e.lines <- strings.TrimSpace(scanner.Text())
+		}
+	}()
+
+	if err := e.send("tttp"); err != nil {
+		e.Kill()
+		return nil, err
+	}
+	_, err = e.expect("tttpok", timeout, func(line string) {
+		if name, ok := strings.CutPrefix(line, "id name "); ok {
+			e.Name = name
+		}
+	})
+	if err != nil {
+		e.Kill()
+		return nil, fmt.Errorf("handshake: %w", err)
+	}
+	return e, nil
+}
+
+// NewGame tells the engine the rules and waits until it is ready.
+func (e *Engine) NewGame(rules game.Rules, timeout time.Duration) error {
+	for _, line := range []string{
+		"newgame",
+		rulesLine(rules),
+		"isready",
+	} {
+		if err := e.send(line); err != nil {
+			return err
+		}
+	}
+	_, err := e.expect("readyok", timeout, nil)
+	return err
+}
+
+// rulesLine is the rules command for rules, depth is only sent for 3D boards
+func rulesLine(rules game.Rules) string {
+	line := fmt.Sprintf("rules size %d line %d", rules.Size, rules.Line)
+	if rules.Depth > 0 {
+		line += fmt.Sprintf(" depth %d", rules.Depth)
+	}
+	return line
+}
+
+// BestMove sends the position and asks for a move within budget.
+// The move is not validated here, that is the caller's job.
+func (e *Engine) BestMove(g *game.Game, budget time.Duration) (int, error) {
+	if err := e.send("position " + EncodeBoard(g.Board) + " " + g.Turn); err != nil {
+		return 0, err
+	}
+	if err := e.send(fmt.Sprintf("go movetime %d", budget.Milliseconds())); err != nil {
+		return 0, err
+	}
+
+	reply, err := e.expect("bestmove", budget+grace, nil)
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(reply)
+	if len(fields) != 2 {
+		return 0, fmt.Errorf("%w: %q", ErrBadReply, reply)
+	}
+	position, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrBadReply, reply)
+	}
+	return position, nil
+}
+
+// Close asks the engine to quit, killing it if it doesn't exit in time.
+func (e *Engine) Close() {
+	e.send("quit")
+	e.stdin.Close()
+
+	done := make(chan struct{})
+	go func() {
+		e.cmd.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		e.cmd.Process.Kill()
+		<-done
+	}
+}
+
+// Kill stops the engine right away, used after a timeout or crash.
+func (e *Engine) Kill() {
+	e.cmd.Process.Kill()
+	e.cmd.Wait()
+}
+
+func (e *Engine) send(line string) error {
+	if _, err := io.WriteString(e.stdin, line+"\n"); err != nil {
+		return fmt.Errorf("%w: %v", ErrCrashed, err)
+	}
+	return nil
+}
+
+// expect reads lines until one starting with keyword arrives, handing any
+// other line to other (if set). It fails if the engine exits or time runs out.
+func (e *Engine) expect(keyword string, timeout time.Duration, other func(string)) (string, error) {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case line, ok := <-e.lines:
+			if !ok {
+				return "", ErrCrashed
+			}
+			if line == keyword || strings.HasPrefix(line, keyword+" ") {
+				return line, nil
+			}
+			if other != nil {
+				other(line)
+			}
+		case <-deadline:
+			return "", ErrTimeout
+		}
+	}
+}
+
+// EncodeBoard writes a board the way TTTP expects it, e.g. "X.O......".
+func EncodeBoard(board []string) string {
+	var b strings.Builder
+	for _, cell := range board {
+		if cell == "" {
+			b.WriteByte('.')
+		} else {
+			b.WriteString(cell)
+		}
+	}
+	return b.String()
+}
+
+// DecodeBoard is the reverse of EncodeBoard.
+func DecodeBoard(cells string) ([]string, error) {
+	board := make([]string, len(cells))
+	for i, c := range cells {
+		switch c {
+		case '.':
+		case 'X', 'O':
+			board[i] = string(c)
+		default:
+			return nil, fmt.Errorf("invalid cell %q in board %q", c, cells)
+		}
+	}
+	return board, nil
+}
@@ -0,0 +1,162 @@
+package arena
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"goChatSocket/game"
+)
+
+// Entrant is one engine taking part in a tournament.
+type Entrant struct {
+	Name    string
+	Command []string
+}
+
+// Config controls how games are played.
+// MoveTime: budget for each move.
+// StartTimeout: how long an engine gets for the handshake and isready.
+// GamesPerPair: games each pair plays, colours alternate between games.
+type Config struct {
+	Rules        game.Rules
+	MoveTime     time.Duration
+	StartTimeout time.Duration
+	GamesPerPair int
+}
+
+// How a game ended.
+const (
+	ReasonLine    = "line"
+	ReasonDraw    = "draw"
+	ReasonTimeout = "timeout"
+	ReasonCrash   = "crash"
+	ReasonIllegal = "illegal move"
+)
+
+// Result of one game. Winner is "X", "O" or empty for a draw.
+type Result struct {
+	X      string `json:"x"`
+	O      string `json:"o"`
+	Winner string `json:"winner"`
+	Reason string `json:"reason"`
+	Detail string `json:"detail,omitempty"`
+	Moves  []int  `json:"moves"`
+}
+
+// PlayGame starts fresh engines for x and o and plays one game between them.
+// A side that fails to start, crashes, times out or plays an illegal move loses.
+func PlayGame(x, o Entrant, cfg Config) Result {
+	result := Result{X: x.Name, O: o.Name}
+	g := game.New(cfg.Rules)
+
+	// start both sides first so that when both are broken neither gets the win
+	engines := map[string]*Engine{}
+	failures := map[string]error{}
+	for _, side := range []struct {
+		symbol  string
+		entrant Entrant
+	}{{game.X, x}, {game.O, o}} {
+		e, err := Start(side.entrant.Command, cfg.StartTimeout)
+		if err == nil {
+			if err = e.NewGame(cfg.Rules, cfg.StartTimeout); err != nil {
+				e.Kill()
+			}
+		}
+		if err != nil {
+			failures[side.symbol] = err
+			continue
+		}
+		engines[side.symbol] = e
+	}
+	if len(failures) > 0 {
+		for _, e := range engines {
+			e.Kill()
+		}
+		if len(failures) == 2 {
+			result := forfeit(result, game.X, failures[game.X])
+			result.Winner = ""
+			result.Detail += fmt.Sprintf(", O: %v", failures[game.O])
+			return result
+		}
+		for symbol, err := range failures {
+			return forfeit(result, symbol, err)
+		}
+	}
+	defer func() {
+		for _, e := range engines {
+			e.Close()
+		}
+	}()
+
+	for !g.Over() {
+		mover := g.Turn
+		position, err := engines[mover].BestMove(g, cfg.MoveTime)
+		if err != nil {
+			engines[mover].Kill()
+			delete(engines, mover)
+			result.Moves = g.Moves
+			return forfeit(result, mover, err)
+		}
+		if err := g.Play(position, mover); err != nil {
+			result.Moves = g.Moves
+			return forfeit(result, mover, fmt.Errorf("%w: %d (%v)", errIllegal, position, err))
+		}
+	}
+
+	result.Moves = g.Moves
+	result.Winner = g.Winner()
+	result.Reason = ReasonLine
+	if result.Winner == "" {
+		result.Reason = ReasonDraw
+	}
+	return result
+}
+
+var errIllegal = errors.New("illegal move")
+
+// forfeit records a loss for loser with the reason derived from err.
+// when both sides fail to start the caller clears Winner, a double forfeit.
+func forfeit(result Result, loser string, err error) Result {
+	result.Winner = game.Other(loser)
+	result.Detail = fmt.Sprintf("%s: %v", loser, err)
+	switch {
+	case errors.Is(err, ErrTimeout):
+		result.Reason = ReasonTimeout
+	case errors.Is(err, errIllegal):
+		result.Reason = ReasonIllegal
+	default:
+		result.Reason = ReasonCrash
+	}
+	return result
+}
+
+// RoundRobin plays every entrant against every other and builds the crosstable.
+// progress, if set, is called after each game.
+func RoundRobin(entrants []Entrant, cfg Config, progress func(Result)) (*Crosstable, error) {
+	if len(entrants) < 2 {
+		return nil, errors.New("need at least two engines")
+	}
+	if err := cfg.Rules.Validate(); err != nil {
+		return nil, err
+	}
+
+	table := newCrosstable(entrants)
+	for i := 0; i < len(entrants); i++ {
+		for j := i + 1; j < len(entrants); j++ {
+			for n := 0; n < cfg.GamesPerPair; n++ {
+				// alternate who plays X
+				x, o := i, j
+				if n%2 == 1 {
+					x, o = j, i
+				}
+				result := PlayGame(entrants[x], entrants[o], cfg)
+				table.record(x, o, result)
+				if progress != nil {
+					progress(result)
+				}
+			}
+		}
+	}
+	return table, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// how many audit entries are kept in memory for GET /admin/audit
+const auditLogSize = 1000
+
+// auditEntry records one admin action.
+// Admin: the address the request came from.
+// Target: the room, connection or IP acted on.
+// Details: whatever else the action needs to be understood later.
+// Error: why the action failed, empty when it worked.
+type auditEntry struct {
+	Time    time.Time `json:"time"`
+	Admin   string    `json:"admin"`
+	Action  string    `json:"action"`
+	Target  string    `json:"target,omitempty"`
+	Details any       `json:"details,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// auditLog keeps the latest admin actions and, if out is set, appends every
+// one to it as a line of JSON
+type auditLog struct {
+	mu      sync.Mutex
+	entries []auditEntry
+	out     io.Writer
+}
+
+func newAuditLog() *auditLog {
+	return &auditLog{}
+}
+
+func (l *auditLog) record(entry auditEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.entries) == auditLogSize {
+		l.entries = l.entries[1:]
+	}
+	l.entries = append(l.entries, entry)
+
+	fmt.Printf("Admin %s on %q from %s\n", entry.Action, entry.Target, entry.Admin)
+	if l.out != nil {
+		if err := json.NewEncoder(l.out).Encode(entry); err != nil {
+			fmt.Println("Audit log error:", err)
+		}
+	}
+}
+
+// list returns the kept entries, oldest first
+func (l *auditLog) list() []auditEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]auditEntry{}, l.entries...)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Broker carries room traffic between server instances (nodes) so they can
+// share rooms, see cluster.go. Messages are opaque bytes.
+// Publish: send msg to every subscriber of topic, on any node, in order.
+// Subscribe: call handler with each message published on topic until
+// unsubscribe is called. Handlers run one message at a time, never inside Publish.
+// Claim: make node the holder of the lease on key for ttl, unless another node
+// holds an unexpired lease, and return the holder. Claiming again renews.
+// Owner: the node holding an unexpired lease on key, "" if nobody does.
+// Release: give up node's lease on key, if it holds it.
+type Broker interface {
+	Publish(ctx context.Context, topic string, msg []byte) error
+	Subscribe(ctx context.Context, topic string, handler func(msg []byte)) (unsubscribe func(), err error)
+	Claim(ctx context.Context, key, node string, ttl time.Duration) (owner string, err error)
+	Owner(ctx context.Context, key string) (string, error)
+	Release(ctx context.Context, key, node string) error
+}
+
+// subscription feeds one handler from a queue on its own goroutine, so a
+// publisher never waits for handlers and messages stay in order
+type subscription struct {
+	handler func([]byte)
+
+	mu     sync.Mutex
+	queue  [][]byte
+	closed bool
+	wake   chan struct{}
+}
+
+func newSubscription(handler func([]byte)) *subscription {
+	s := &subscription{handler: handler, wake: make(chan struct{}, 1)}
+	go s.run()
+	return s
+}
+
+// deliver queues a message for the handler
+func (s *subscription) deliver(msg []byte) {
+	s.mu.Lock()
+	if !s.closed {
+		s.queue = append(s.queue, msg)
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// close drops whatever is still queued and stops the goroutine
+func (s *subscription) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.queue = nil
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscription) run() {
+	for range s.wake {
+		for {
+			s.mu.Lock()
+			if s.closed {
+				s.mu.Unlock()
+				return
+			}
+			if len(s.queue) == 0 {
+				s.mu.Unlock()
+				break
+			}
+			msg := s.queue[0]
+			s.queue = s.queue[1:]
+			s.mu.Unlock()
+
+			s.handler(msg)
+		}
+	}
+}
+
+// memoryBroker is a Broker for nodes running in the same process, e.g. tests
+type memoryBroker struct {
+	mu     sync.Mutex
+	subs   map[string]map[*subscription]bool // topic -> subscribers
+	leases map[string]lease
+}
+
+// lease is who holds a key and until when
+type lease struct {
+	node    string
+	expires time.Time
+}
+
+func newMemoryBroker() *memoryBroker {
+	return &memoryBroker{
+		subs:   make(map[string]map[*subscription]bool),
+		leases: make(map[string]lease),
+	}
+}
+
+func (b *memoryBroker) Publish(ctx context.Context, topic string, msg []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subs[topic] {
+		s.deliver(msg)
+	}
+	return nil
+}
+
+func (b *memoryBroker) Subscribe(ctx context.Context, topic string, handler func([]byte)) (func(), error) {
+	s := newSubscription(handler)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[topic] == nil {
+		b.subs[topic] = make(map[*subscription]bool)
+	}
+	b.subs[topic][s] = true
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs[topic], s)
+			if len(b.subs[topic]) == 0 {
+				delete(b.subs, topic)
+			}
+			b.mu.Unlock()
+			s.close()
+		})
+	}, nil
+}
+
+func (b *memoryBroker) Claim(ctx context.Context, key, node string, ttl time.Duration) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if l, ok := b.leases[key]; ok && l.node != node && l.expires.After(now) {
+		return l.node, nil
+	}
+	b.leases[key] = lease{node: node, expires: now.Add(ttl)}
+	return node, nil
+}
+
+func (b *memoryBroker) Owner(ctx context.Context, key string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if l, ok := b.leases[key]; ok && l.expires.After(time.Now()) {
+		return l.node, nil
+	}
+	return "", nil
+}
+
+func (b *memoryBroker) Release(ctx context.Context, key, node string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.leases[key].node == node {
+		delete(b.leases, key)
+	}
+	return nil
+}
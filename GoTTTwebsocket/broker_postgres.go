@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Postgres limits a NOTIFY payload to just under 8000 bytes
+const maxNotifyPayload = 7999
+
+// postgresBroker is a Broker backed by Postgres: messages go through
+// LISTEN/NOTIFY and leases live in the ttt_leases table, so every node
+// pointed at the same database shares rooms.
+type postgresBroker struct {
+	db       *sql.DB
+	listener *pq.Listener
+
+	// listenMu keeps LISTEN and UNLISTEN in step with subs, mu guards subs
+	// alone so notifications keep flowing while the listener is busy
+	listenMu sync.Mutex
+	mu       sync.Mutex
+	subs     map[string]map[*subscription]bool // channel -> subscribers
+}
+
+// newPostgresBroker connects to the database at connStr and creates the lease table
+func newPostgresBroker(connStr string) (*postgresBroker, error) {
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`create table if not exists ttt_leases (
+		key text primary key,
+		node text not null,
+		expires timestamptz not null
+	)`)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &postgresBroker{db: db, subs: make(map[string]map[*subscription]bool)}
+	b.listener = pq.NewListener(connStr, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			fmt.Println("Broker Listener Error:", err)
+		}
+	})
+	go b.listen()
+	return b, nil
+}
+
+// channelName turns a topic into a valid Postgres identifier, topics can be
+// longer than the 63 byte limit or hold characters LISTEN won't take
+func channelName(topic string) string {
+	sum := sha256.Sum256([]byte(topic))
+	return "ttt_" + hex.EncodeToString(sum[:])[:32]
+}
+
+// listen hands every notification to the subscribers of its channel
+func (b *postgresBroker) listen() {
+	for n := range b.listener.Notify {
+		if n == nil {
+			continue // the connection was lost and is back, anything sent meanwhile is gone
+		}
+		msg, err := base64.StdEncoding.DecodeString(n.Extra)
+		if err != nil {
+			continue
+		}
+		b.mu.Lock()
+		for s := range b.subs[n.Channel] {
+			s.deliver(msg)
+		}
+		b.mu.Unlock()
+	}
+}
+
+func (b *postgresBroker) Publish(ctx context.Context, topic string, msg []byte) (err error) {
+	ctx, span := startStorageSpan(ctx, "postgres.notify", postgresAttr, attrTopic.String(topic), attrBytes.Int(len(msg)))
+	defer endSpan(span, &err)
+	payload := base64.StdEncoding.EncodeToString(msg)
+	if len(payload) > maxNotifyPayload {
+		return fmt.Errorf("message of %d bytes is too big for NOTIFY", len(msg))
+	}
+	_, err = b.db.ExecContext(ctx, "select pg_notify($1, $2)", channelName(topic), payload)
+	return err
+}
+
+func (b *postgresBroker) Subscribe(ctx context.Context, topic string, handler func([]byte)) (func(), error) {
+	channel := channelName(topic)
+	s := newSubscription(handler)
+
+	b.listenMu.Lock()
+	defer b.listenMu.Unlock()
+	b.mu.Lock()
+	listening := b.subs[channel] != nil
+	b.mu.Unlock()
+	if !listening {
+		if err := b.listener.Listen(channel); err != nil && !errors.Is(err, pq.ErrChannelAlreadyOpen) {
+			s.close()
+			return nil, err
+		}
+	}
+
+	b.mu.Lock()
+	if b.subs[channel] == nil {
+		b.subs[channel] = make(map[*subscription]bool)
+	}
+	b.subs[channel][s] = true
+	b.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.close()
+			b.listenMu.Lock()
+			defer b.listenMu.Unlock()
+			b.mu.Lock()
+			delete(b.subs[channel], s)
+			last := len(b.subs[channel]) == 0
+			if last {
+				delete(b.subs, channel)
+			}
+			b.mu.Unlock()
+			if last {
+				b.listener.Unlisten(channel)
+			}
+		})
+	}, nil
+}
+
+func (b *postgresBroker) Claim(ctx context.Context, key, node string, ttl time.Duration) (owner string, err error) {
+	ctx, span := startStorageSpan(ctx, "postgres.claim", postgresAttr, attribute.String("ttt.lease", key))
+	defer endSpan(span, &err)
+	// take the lease if it is free, ours or expired, otherwise report who has it
+	err = b.db.QueryRowContext(ctx, `insert into ttt_leases (key, node, expires)
+		values ($1, $2, now() + $3 * interval '1 millisecond')
+		on conflict (key) do update set node = excluded.node, expires = excluded.expires
+		where ttt_leases.node = excluded.node or ttt_leases.expires < now()
+		returning node`, key, node, ttl.Milliseconds()).Scan(&owner)
+	if errors.Is(err, sql.ErrNoRows) {
+		return b.Owner(ctx, key)
+	}
+	return owner, err
+}
+
+func (b *postgresBroker) Owner(ctx context.Context, key string) (owner string, err error) {
+	ctx, span := startStorageSpan(ctx, "postgres.owner", postgresAttr, attribute.String("ttt.lease", key))
+	defer endSpan(span, &err)
+	err = b.db.QueryRowContext(ctx, "select node from ttt_leases where key = $1 and expires > now()", key).Scan(&owner)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return owner, err
+}
+
+func (b *postgresBroker) Release(ctx context.Context, key, node string) (err error) {
+	ctx, span := startStorageSpan(ctx, "postgres.release", postgresAttr, attribute.String("ttt.lease", key))
+	defer endSpan(span, &err)
+	_, err = b.db.ExecContext(ctx, "delete from ttt_leases where key = $1 and node = $2", key, node)
+	return err
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"goChatSocket/protocol"
+	"goChatSocket/puzzle"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// how many outgoing messages a client can fall behind before we drop it
+const sendBuffer = 256
+
+// Client is one connection, over a websocket or the SSE fallback (see transport.go).
+// name, symbol and token are owned by the room the client is in, only touch them under the room lock.
+// name: player-1, spectator-3 or the tournament player's name
+// symbol: "X" or "O" when seated, empty for spectators
+// token: seat token from the ?token= query, used to claim reserved (tournament) seats
+// wantName: the name asked for in hello, given when nobody in the room has it
+// profile: the id of the saved profile logged in with, empty if none
+// account: the account signed in with on the websocket handshake, nil for guests
+type Client struct {
+	// id is given by the server's client registry, ip and connected never change
+	id        string
+	ip        string
+	connected time.Time
+
+	conn   transport
+	room   *Room
+	name   string
+	symbol string
+	token  string
+
+	// set from hello before the client joins a room, never changed after
+	wantName string
+	profile  string
+	account  *account
+
+	// reactions and quick chats sent lately, owned by the room lock
+	limit rateLimit
+
+	// the puzzle being solved, only touched by the goroutine handling the client's messages
+	puzzle *puzzle.Attempt
+
+	// the trace of the connection's handshake, set before it joins a room.
+	// Joining and leaving are traced in it, its messages link to it.
+	ctx context.Context
+
+	// protocol version agreed in the hello handshake, and the codec picked by the websocket subprotocol
+	version int
+	codec   protocol.Codec
+
+	// outgoing messages are queued and written by writeLoop so a slow client
+	// can't hold up everyone else in the room
+	mu     sync.Mutex
+	send   chan frame
+	closed bool
+
+	// closed once writeLoop has written its last message
+	done chan struct{}
+}
+
+func newClient(conn transport, codec protocol.Codec, token string, version int) *Client {
+	c := &Client{
+		ip:        hostOf(conn.remoteAddr()),
+		connected: time.Now(),
+		conn:      conn,
+		token:     token,
+		version:   version,
+		codec:     codec,
+		ctx:       context.Background(),
+		send:      make(chan frame, sendBuffer),
+		done:      make(chan struct{}),
+	}
+	go c.writeLoop()
+	return c
+}
+
+// user is who the client counts as for puzzle stats: their account, their
+// profile, or for guests the connection itself.
+func (c *Client) user() string {
+	if c.account != nil {
+		return c.account.identity()
+	}
+	if c.profile != "" {
+		return c.profile
+	}
+	return c.id
+}
+
+// reply queues a single payload for this client, e.g. &protocol.System{...}
+func (c *Client) reply(payload any) {
+	c.queue(protocol.MustNew(payload))
+}
+
+// queue hands a message to the writer without blocking
+func (c *Client) queue(msg protocol.Envelope) {
+	c.push(newEncoder(msg))
+}
+
+// push queues a message that may be shared with other clients, see encoder
+func (c *Client) push(enc *encoder) {
+	f, err := enc.frameFor(c)
+	if err != nil {
+		fmt.Println("Encode Error:", err)
+		return
+	}
+	c.pushFrame(f)
+}
+
+// pushFrame queues a message that is already encoded for this client
+func (c *Client) pushFrame(f frame) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	if f.ctx != nil {
+		f.queued = time.Now()
+	}
+	select {
+	case c.send <- f:
+	default:
+		// too far behind, disconnect rather than stall the room
+		fmt.Println("Dropping slow client:", c.conn.remoteAddr())
+		c.closed = true
+		close(c.send)
+		c.conn.close()
+	}
+}
+
+// close stops the writer, safe to call more than once
+func (c *Client) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.send)
+	}
+}
+
+// disconnect sends a last message, e.g. why an admin kicked them, and hangs up once it is written
+func (c *Client) disconnect(payload any) {
+	c.reply(payload)
+	c.close()
+	go func() {
+		<-c.done
+		c.conn.close()
+	}()
+}
+
+func (c *Client) writeLoop() {
+	defer close(c.done)
+	for f := range c.send {
+		if err := c.write(f); err != nil {
+			// the read loop notices the closed connection and cleans up
+			c.conn.close()
+		}
+	}
+}
+
+// write sends one frame. A broadcast's frames are traced as its children,
+// from when they were queued until they are written.
+func (c *Client) write(f frame) (err error) {
+	if f.ctx == nil {
+		return c.conn.send(f)
+	}
+	_, span := otel.Tracer(tracerName).Start(f.ctx, "send", trace.WithTimestamp(f.queued), trace.WithAttributes(
+		attrRoom.String(f.room), attrConn.String(c.id), attrSeq.Int64(int64(f.seq)),
+		attrBytes.Int(len(f.data)), attribute.String("ttt.transport", c.conn.name()),
+	))
+	defer endSpan(span, &err)
+	return c.conn.send(f)
+}
+
+// hostOf strips the port from a remote address, leaving the IP bans apply to
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// frame is one encoded outgoing message.
+// ctx is the trace of the broadcast it belongs to, nil for replies, with
+// the room it was broadcast to and when it was queued for the send span.
+type frame struct {
+	seq    uint64 // room event number, the SSE stream uses it as the event id
+	data   []byte
+	binary bool
+
+	ctx    context.Context
+	room   string
+	queued time.Time
+}
+
+// encoder encodes one envelope at most once per codec and protocol version,
+// so a broadcast costs one encode per codec in use instead of one per client.
+// It is not safe for concurrent use, broadcasts use it under the room lock.
+// ctx and room are set for broadcasts and passed on to every frame.
+type encoder struct {
+	env    protocol.Envelope
+	frames map[encoding]frame
+
+	ctx  context.Context
+	room string
+}
+
+type encoding struct {
+	codec   string
+	version int
+}
+
+func newEncoder(env protocol.Envelope) *encoder {
+	return &encoder{env: env, frames: make(map[encoding]frame, 1)}
+}
+
+// frameFor returns the message as c wants it on the wire
+func (e *encoder) frameFor(c *Client) (frame, error) {
+	key := encoding{c.codec.Name(), c.version}
+	if f, ok := e.frames[key]; ok {
+		return f, nil
+	}
+	env := e.env
+	env.V = c.version
+	data, err := c.codec.Encode(env)
+	if err != nil {
+		return frame{}, err
+	}
+	f := frame{seq: env.Seq, data: data, binary: c.codec.Binary(), ctx: e.ctx, room: e.room}
+	e.frames[key] = f
+	return f, nil
+}
@@ -0,0 +1,393 @@
+// Package client is a headless Go client for the Tic-Tac-Toe websocket server.
+// It connects to /ws, says hello to agree on a protocol version, turns incoming
+// messages into typed events and sends moves and chat lines on behalf of the user.
+// When a proxy blocks the websocket upgrade it falls back to server-sent events.
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"goChatSocket/protocol"
+)
+
+// Client is a single connection to the game server.
+type Client struct {
+	conn     conn
+	events   chan Event
+	version  int
+	room     string
+	identity Identity
+	profile  *protocol.Profile
+	account  *protocol.Account
+
+	// guards seq, the sequence number of the last message we sent
+	sendMu sync.Mutex
+	seq    uint64
+
+	// guards the fields below, they are written by the read loop
+	mu       sync.Mutex
+	userName string
+	symbol   string
+	err      error
+
+	// roomSeq is the last room event we passed on, resyncing is set while
+	// we wait for missed events after a gap
+	roomSeq   uint64
+	resyncing bool
+}
+
+// Dial connects to a server websocket url such as ws://localhost:8080/ws?room=lunch
+// and starts reading events in the background. It offers every codec, the
+// binary one first. If the websocket can't be opened it tries the server's
+// event stream fallback (/sse) on the same host.
+func Dial(rawURL string) (*Client, error) {
+	return DialCodec(rawURL, protocol.Codecs...)
+}
+
+// DialCodec is Dial offering only the given codecs, in order of preference,
+// e.g. DialCodec(url, protocol.JSON). JSON is always accepted as a last resort.
+func DialCodec(rawURL string, codecs ...protocol.Codec) (*Client, error) {
+	return dial(rawURL, Identity{}, codecs)
+}
+
+// Identity is who we say we are when connecting.
+// Name: the display name we'd like, the server picks one when it is empty or taken.
+// Profile: the login token of a saved profile (POST /profiles).
+// Token: an account login token from the accounts service, empty to play as a guest.
+type Identity struct {
+	Name    string
+	Profile string
+	Token   string
+}
+
+// DialAs is Dial asking for a name, logging in with a saved profile or signing in to an account.
+func DialAs(rawURL string, id Identity) (*Client, error) {
+	return dial(rawURL, id, protocol.Codecs)
+}
+
+// dial opens the websocket, or the event stream when that fails, and says hello
+func dial(rawURL string, id Identity, codecs []protocol.Codec) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server url %q: %w", rawURL, err)
+	}
+
+	var cn conn
+	cn, err = dialWebsocket(u, codecs, id.Token)
+	if err != nil {
+		sse, sseErr := dialSSE(u, id.Token)
+		if sseErr != nil {
+			return nil, fmt.Errorf("websocket: %v, sse fallback: %w", err, sseErr)
+		}
+		cn = sse
+	}
+	return start(cn, id)
+}
+
+// DialSSE is Dial without trying the websocket first. It takes the same
+// ws:// url, the event stream address is derived from it.
+func DialSSE(rawURL string) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server url %q: %w", rawURL, err)
+	}
+	cn, err := dialSSE(u, "")
+	if err != nil {
+		return nil, err
+	}
+	return start(cn, Identity{})
+}
+
+// start says hello over a fresh connection and starts the read loop
+func start(cn conn, id Identity) (*Client, error) {
+	c := &Client{
+		conn:     cn,
+		events:   make(chan Event, 64),
+		identity: id,
+	}
+	if err := c.hello(); err != nil {
+		cn.close()
+		return nil, err
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+// how long we wait for the server to answer hello
+const helloTimeout = 10 * time.Second
+
+// hello offers our protocol versions and waits for the server's welcome
+func (c *Client) hello() error {
+	hello := &protocol.Hello{Versions: protocol.SupportedVersions, Client: "goChatSocket/client", Name: c.identity.Name, Profile: c.identity.Profile}
+	if err := c.send(hello); err != nil {
+		return err
+	}
+
+	data, err := c.conn.receive(helloTimeout)
+	if err != nil {
+		return fmt.Errorf("waiting for welcome: %w", err)
+	}
+	_, payload, err := c.conn.codec().Decode(data, protocol.FromServer)
+	if err != nil {
+		return fmt.Errorf("waiting for welcome: %w", err)
+	}
+	switch p := payload.(type) {
+	case *protocol.Welcome:
+		c.version, c.room, c.profile, c.account = p.Version, p.Room, p.Profile, p.Account
+		return nil
+	case *protocol.Error:
+		return p
+	}
+	return errors.New("waiting for welcome: unexpected message")
+}
+
+// RoomURL adds the room and seat token to a server address, empty values are left out.
+func RoomURL(addr, room, token string) (string, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid server url %q: %w", addr, err)
+	}
+	q := u.Query()
+	if room != "" {
+		q.Set("room", room)
+	}
+	if token != "" {
+		q.Set("token", token)
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Events returns the channel of events pushed by the server.
+// It is closed when the connection ends, check Err() for the reason.
+func (c *Client) Events() <-chan Event {
+	return c.events
+}
+
+// Version returns the protocol version agreed with the server.
+func (c *Client) Version() int {
+	return c.version
+}
+
+// Room returns the id of the room we joined.
+func (c *Client) Room() string {
+	return c.room
+}
+
+// Profile returns the saved profile we logged in with as it was when we
+// connected, nil for guests.
+func (c *Client) Profile() *protocol.Profile {
+	return c.profile
+}
+
+// Account returns the account we signed in to as it was when we connected,
+// nil for guests.
+func (c *Client) Account() *protocol.Account {
+	return c.account
+}
+
+// Transport returns how we are connected, TransportWebsocket or TransportSSE.
+func (c *Client) Transport() string {
+	return c.conn.transport()
+}
+
+// Codec returns the name of the codec agreed with the server, e.g. "msgpack".
+func (c *Client) Codec() string {
+	return c.conn.codec().Name()
+}
+
+// Err returns the error that ended the connection, if any.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// UserName returns the name the server assigned us, empty until assigned.
+func (c *Client) UserName() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.userName
+}
+
+// Symbol returns our player symbol ("X" or "O"), empty for spectators.
+func (c *Client) Symbol() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.symbol
+}
+
+// Move asks the server to place our symbol at position (0-8 on a 3x3 board).
+// If the server refuses it a Rejected event arrives on Events().
+func (c *Client) Move(position int) error {
+	return c.send(protocol.At(position))
+}
+
+// Place is Move placing symbol, for variants where players pick X or O
+// each move (Snapshot.Rules.PickSymbol).
+func (c *Client) Place(position int, symbol string) error {
+	move := protocol.At(position)
+	move.Symbol = symbol
+	return c.send(move)
+}
+
+// MoveCell is Move for a 3D board, layer 0 is the top one.
+func (c *Client) MoveCell(layer, row, col int) error {
+	return c.send(protocol.AtCell(layer, row, col))
+}
+
+// SetName asks to be called name in the room from now on. A Renamed event
+// confirms it, or a Rejected one says why not (invalidName, nameTaken).
+func (c *Client) SetName(name string) error {
+	return c.send(&protocol.SetName{Name: name})
+}
+
+// Chat sends a chat line to everyone in the room.
+func (c *Client) Chat(text string) error {
+	return c.send(&protocol.Chat{Text: text})
+}
+
+// React sends an emoji from protocol.Emojis, on move number move or on the
+// room when move is 0. Reactions are rate limited, see protocol.CodeRateLimited.
+func (c *Client) React(emoji string, move int) error {
+	return c.send(&protocol.Reaction{Emoji: emoji, Move: move})
+}
+
+// QuickChat sends one of the protocol.QuickChats phrases by its ID.
+func (c *Client) QuickChat(phrase string) error {
+	return c.send(&protocol.QuickChat{Phrase: phrase})
+}
+
+// WatchTournament subscribes to a tournament's bracket updates.
+func (c *Client) WatchTournament(id string) error {
+	return c.send(&protocol.WatchTournament{ID: id})
+}
+
+// Analyze asks the server's solver about a position, the answer arrives as an
+// Analyzed event. line is how many in a row win, 0 for the board's side.
+func (c *Client) Analyze(board []string, turn string, line int) error {
+	return c.send(&protocol.Analyze{Board: board, Turn: turn, Line: line})
+}
+
+// RequestPuzzle asks for a puzzle where we win in n moves on a size x size
+// board, 0 for any. It arrives as a PuzzleReceived event.
+func (c *Client) RequestPuzzle(n, size int) error {
+	return c.send(&protocol.PuzzleRequest{N: n, Size: size})
+}
+
+// PuzzleMove plays position in the puzzle with id, the verdict arrives as a PuzzleChecked event.
+func (c *Client) PuzzleMove(id string, position int) error {
+	return c.send(&protocol.PuzzleMove{ID: id, Position: &position})
+}
+
+// Close ends the connection, Events() is closed shortly after.
+func (c *Client) Close() error {
+	return c.conn.close()
+}
+
+// send wraps a payload in an envelope with our next sequence number
+func (c *Client) send(payload any) error {
+	msg, err := protocol.New(payload)
+	if err != nil {
+		return err
+	}
+
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	if c.version != 0 {
+		msg.V = c.version
+	}
+	c.seq++
+	msg.Seq = c.seq
+	data, err := c.conn.codec().Encode(msg)
+	if err != nil {
+		return err
+	}
+	return c.conn.send(data)
+}
+
+// LastSeq returns the sequence number of the last message we sent,
+// handy for matching a Rejected event to the message it refers to.
+func (c *Client) LastSeq() uint64 {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	return c.seq
+}
+
+// readLoop decodes server messages until the connection drops
+func (c *Client) readLoop() {
+	defer close(c.events)
+
+	for {
+		data, err := c.conn.receive(0)
+		if err != nil {
+			c.mu.Lock()
+			c.err = err
+			c.mu.Unlock()
+			return
+		}
+
+		env, ev := decode(c.conn.codec(), data)
+		if !c.inOrder(env, ev) {
+			continue
+		}
+
+		switch e := ev.(type) {
+		case Assigned:
+			c.mu.Lock()
+			c.userName, c.symbol = e.UserName, e.Symbol
+			c.mu.Unlock()
+		case Spectating:
+			c.mu.Lock()
+			c.userName, c.symbol = e.UserName, ""
+			c.mu.Unlock()
+		case Renamed:
+			c.mu.Lock()
+			c.userName = e.Name
+			c.mu.Unlock()
+		}
+		c.events <- ev
+	}
+}
+
+// inOrder checks room events against the last seq we saw. Duplicates are
+// dropped, and on a gap we ask the server to resync from the first missing
+// event and drop everything until the replay catches up.
+func (c *Client) inOrder(env protocol.Envelope, ev Event) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := ev.(Snapshot); ok {
+		c.roomSeq, c.resyncing = env.Seq, false
+		return true
+	}
+	if env.Seq == 0 {
+		// not a room event, e.g. an error or our seat assignment
+		return true
+	}
+	if env.Seq <= c.roomSeq {
+		return false
+	}
+	if env.Seq > c.roomSeq+1 {
+		if !c.resyncing {
+			c.resyncing = true
+			go c.send(&protocol.Resync{FromSeq: c.roomSeq + 1})
+		}
+		return false
+	}
+	c.roomSeq, c.resyncing = env.Seq, false
+	return true
+}
+
+// Resync asks the server to send every room event after the last one we saw again,
+// or a snapshot if it no longer has them. Useful after a reconnect.
+func (c *Client) Resync() error {
+	c.mu.Lock()
+	from := c.roomSeq + 1
+	c.mu.Unlock()
+	return c.send(&protocol.Resync{FromSeq: from})
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"net/url"
+	"time"
+
+	"goChatSocket/protocol"
+
+	"golang.org/x/net/websocket"
+)
+
+// Transport names, see Client.Transport.
+const (
+	TransportWebsocket = "websocket"
+	TransportSSE       = "sse"
+)
+
+// conn carries raw messages to and from the server, over a websocket or the
+// server-sent events fallback.
+// receive waits at most timeout for the next message, 0 waits forever.
+// codec is how messages are encoded on this connection.
+type conn interface {
+	send(data []byte) error
+	receive(timeout time.Duration) ([]byte, error)
+	close() error
+	transport() string
+	codec() protocol.Codec
+}
+
+// wsConn is the normal websocket connection
+type wsConn struct {
+	ws    *websocket.Conn
+	coder protocol.Codec
+}
+
+// dialWebsocket offers codecs as subprotocols, the server picks one.
+// JSON is always offered last: a server that echoes no subprotocol speaks it,
+// and with two or more offers x/net leaves Protocol as is so we can tell.
+// A non-empty token signs in to an account.
+func dialWebsocket(u *url.URL, codecs []protocol.Codec, token string) (*wsConn, error) {
+	// x/net/websocket requires an origin, derive one from the server address
+	origin := "http://" + u.Host
+	if u.Scheme == "wss" {
+		origin = "https://" + u.Host
+	}
+
+	config, err := websocket.NewConfig(u.String(), origin)
+	if err != nil {
+		return nil, err
+	}
+	for _, codec := range codecs {
+		if codec != protocol.JSON {
+			config.Protocol = append(config.Protocol, protocol.Subprotocol(codec))
+		}
+	}
+	config.Protocol = append(config.Protocol, protocol.Subprotocol(protocol.JSON))
+	if token != "" {
+		config.Header.Set("Authorization", "Bearer "+token)
+	}
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// after the handshake Protocol holds the server's pick, if any
+	c := &wsConn{ws: ws, coder: protocol.JSON}
+	if p := ws.Config().Protocol; len(p) == 1 {
+		if codec, ok := protocol.CodecFor(p[0]); ok {
+			c.coder = codec
+		}
+	}
+	return c, nil
+}
+
+func (c *wsConn) send(data []byte) error {
+	if c.coder.Binary() {
+		return websocket.Message.Send(c.ws, data)
+	}
+	// send as a string so it goes out as a text frame, like websocket.JSON does
+	return websocket.Message.Send(c.ws, string(data))
+}
+
+func (c *wsConn) receive(timeout time.Duration) ([]byte, error) {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	c.ws.SetReadDeadline(deadline)
+
+	var data []byte
+	err := websocket.Message.Receive(c.ws, &data)
+	return data, err
+}
+
+func (c *wsConn) close() error {
+	return c.ws.Close()
+}
+
+func (c *wsConn) transport() string {
+	return TransportWebsocket
+}
+
+func (c *wsConn) codec() protocol.Codec {
+	return c.coder
+}
@@ -0,0 +1,215 @@
+package client
+
+import (
+	"goChatSocket/protocol"
+)
+
+// Event is anything the server pushes to a connected client.
+// Use a type switch on the value received from Client.Events() to handle it.
+type Event interface {
+	event()
+}
+
+// Assigned: we were given a seat in the game (assignPlayer).
+type Assigned struct {
+	UserName string
+	Symbol   string
+}
+
+// Spectating: the lobby was full so we are watching (lobbyFull).
+type Spectating struct {
+	UserName string
+	Text     string
+}
+
+// Moved: a symbol was placed on the board (move). Cell is set on 3D boards,
+// Player is who moved when the rules let players pick their symbol.
+type Moved struct {
+	Position int
+	Cell     *protocol.Cell
+	Symbol   string
+	Player   string
+}
+
+// Renamed: the server accepted our new name (setName).
+type Renamed struct {
+	Name string
+}
+
+// TurnChanged: it is now Symbol's turn (updateTurn).
+type TurnChanged struct {
+	Symbol string
+}
+
+// Snapshot: the whole room state, sent when we join and when a resync gap
+// was too big to replay (snapshot). Turn is empty while waiting for players.
+// Board has one entry per cell of Rules' board. Stake is what each player
+// puts up per game in a room played for stakes.
+type Snapshot struct {
+	Board   []string
+	Rules   protocol.Rules
+	Turn    string
+	Players map[string]string // names by symbol
+	Stake   int64
+}
+
+// ChatReceived: a chat line from another user (chat).
+// Channel is protocol.ChannelSpectators for the spectator chat, empty for the room chat.
+type ChatReceived struct {
+	Sender  string
+	Text    string
+	Channel string
+}
+
+// Reacted: someone reacted with an emoji (reaction). Move is the move they
+// reacted to, 0 for the room, and Counts how many reacted to it with each emoji.
+type Reacted struct {
+	Sender string
+	Emoji  string
+	Move   int
+	Counts map[string]int
+}
+
+// SystemNotice: a GAMEMASTER announcement (system).
+type SystemNotice struct {
+	Text string
+}
+
+// GameOver: the game ended, Winner is empty on a draw (gameOver).
+type GameOver struct {
+	Winner string
+	Text   string
+}
+
+// Analyzed: the solver's answer to Client.Analyze (analysis). Outcome and
+// Plies value the position for Turn, Best is the strongest move.
+type Analyzed struct {
+	Turn    string
+	Outcome string
+	Plies   int
+	Best    int
+	Moves   []protocol.MoveValue
+}
+
+// PuzzleReceived: a puzzle to solve, Turn wins in N moves (puzzle).
+// Solved is how many puzzles we have solved so far.
+type PuzzleReceived struct {
+	ID     string
+	Board  []string
+	Turn   string
+	Size   int
+	Line   int
+	N      int
+	Solved int
+}
+
+// PuzzleChecked: the server's verdict on a puzzle move (puzzleResult).
+// Reply is the opponent's defence when the puzzle goes on, -1 otherwise.
+type PuzzleChecked struct {
+	ID          string
+	Position    int
+	Correct     bool
+	Solved      bool
+	Reply       int
+	Board       []string
+	Left        int
+	SolvedCount int
+	Text        string
+}
+
+// Rejected: the server refused one of our messages (error).
+// RefSeq is the sequence number of the message that was rejected.
+type Rejected struct {
+	Code    string
+	Message string
+	RefSeq  uint64
+	RefType string
+}
+
+func (e Rejected) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// Unknown wraps any message this package does not understand yet.
+type Unknown struct {
+	Envelope protocol.Envelope
+	Err      error
+}
+
+func (Assigned) event()       {}
+func (Spectating) event()     {}
+func (Moved) event()          {}
+func (Renamed) event()        {}
+func (TurnChanged) event()    {}
+func (Snapshot) event()       {}
+func (ChatReceived) event()   {}
+func (Reacted) event()        {}
+func (SystemNotice) event()   {}
+func (GameOver) event()       {}
+func (Analyzed) event()       {}
+func (PuzzleReceived) event() {}
+func (PuzzleChecked) event()  {}
+func (Rejected) event()       {}
+func (Unknown) event()        {}
+
+// Decode turns a JSON server message into its typed event.
+func Decode(data []byte) Event {
+	_, ev := decode(protocol.JSON, data)
+	return ev
+}
+
+// decode also returns the envelope, the read loop needs its Seq
+func decode(codec protocol.Codec, data []byte) (protocol.Envelope, Event) {
+	env, payload, err := codec.Decode(data, protocol.FromServer)
+	if err != nil {
+		return env, Unknown{Envelope: env, Err: err}
+	}
+	return env, fromPayload(env, payload)
+}
+
+func fromPayload(env protocol.Envelope, payload any) Event {
+	switch p := payload.(type) {
+	case *protocol.AssignPlayer:
+		return Assigned{UserName: p.UserName, Symbol: p.Symbol}
+	case *protocol.LobbyFull:
+		return Spectating{UserName: p.UserName, Text: p.Text}
+	case *protocol.Move:
+		return Moved{Position: *p.Position, Cell: p.Cell, Symbol: p.Symbol, Player: p.Player}
+	case *protocol.SetName:
+		return Renamed{Name: p.Name}
+	case *protocol.UpdateTurn:
+		return TurnChanged{Symbol: p.Symbol}
+	case *protocol.Snapshot:
+		// servers from before other boards only played 3x3
+		rules := protocol.Rules{Size: 3, Line: 3}
+		if p.Rules != nil {
+			rules = *p.Rules
+		}
+		if len(p.Board) != rules.Size*rules.Size*max(rules.Depth, 1) {
+			return Unknown{Envelope: env}
+		}
+		return Snapshot{Board: p.Board, Rules: rules, Turn: p.Turn, Players: p.Players, Stake: p.Stake}
+	case *protocol.Chat:
+		return ChatReceived{Sender: p.Sender, Text: p.Text, Channel: p.Channel}
+	case *protocol.Reaction:
+		return Reacted{Sender: p.Sender, Emoji: p.Emoji, Move: p.Move, Counts: p.Counts}
+	case *protocol.System:
+		return SystemNotice{Text: p.Text}
+	case *protocol.GameOver:
+		return GameOver{Winner: p.Winner, Text: p.Text}
+	case *protocol.Analysis:
+		return Analyzed{Turn: p.Turn, Outcome: p.Outcome, Plies: p.Plies, Best: p.Best, Moves: p.Moves}
+	case *protocol.Puzzle:
+		return PuzzleReceived{ID: p.ID, Board: p.Board, Turn: p.Turn, Size: p.Size, Line: p.Line, N: p.N, Solved: p.Solved}
+	case *protocol.PuzzleResult:
+		reply := -1
+		if p.Reply != nil {
+			reply = *p.Reply
+		}
+		return PuzzleChecked{ID: p.ID, Position: p.Position, Correct: p.Correct, Solved: p.Solved, Reply: reply,
+			Board: p.Board, Left: p.Left, SolvedCount: p.SolvedCount, Text: p.Text}
+	case *protocol.Error:
+		return Rejected{Code: p.Code, Message: p.Message, RefSeq: p.RefSeq, RefType: p.RefType}
+	}
+	return Unknown{Envelope: env}
+}
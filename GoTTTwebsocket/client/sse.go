@@ -0,0 +1,192 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"goChatSocket/protocol"
+)
+
+// sseConn talks to the server's fallback transport: server messages arrive
+// as server-sent events on GET /sse, ours are POSTed to /sse/{session}.
+type sseConn struct {
+	http   *http.Client
+	post   string
+	body   io.ReadCloser
+	cancel context.CancelFunc
+
+	// events are parsed by a background reader so receive can time out
+	events chan []byte
+	err    error // why events was closed
+}
+
+// how long we wait for the stream to open and send its session id
+const sseOpenTimeout = 10 * time.Second
+
+// sseURL turns a websocket address like ws://host/ws?room=a into the
+// matching event stream address http://host/sse?room=a
+func sseURL(u *url.URL) *url.URL {
+	s := *u
+	switch s.Scheme {
+	case "wss":
+		s.Scheme = "https"
+	case "ws":
+		s.Scheme = "http"
+	}
+	s.Path = strings.TrimSuffix(s.Path, "/ws") + "/sse"
+	return &s
+}
+
+// dialSSE opens the event stream, a non-empty token signs in to an account
+func dialSSE(u *url.URL, token string) (*sseConn, error) {
+	streamURL := sseURL(u)
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, streamURL.String(), nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	// give up if the stream doesn't open in time, cancelling also ends a stalled read
+	timer := time.AfterFunc(sseOpenTimeout, cancel)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("event stream: %s", resp.Status)
+	}
+
+	c := &sseConn{
+		http:   http.DefaultClient,
+		body:   resp.Body,
+		cancel: cancel,
+		events: make(chan []byte, 64),
+	}
+
+	// the first event carries the session id our messages are posted to
+	reader := bufio.NewReader(resp.Body)
+	name, data, err := readEvent(reader)
+	if err == nil && name != "session" {
+		err = fmt.Errorf("expected a session event, got %q", name)
+	}
+	if !timer.Stop() && err == nil {
+		err = errors.New("timed out opening the event stream")
+	}
+	if err != nil {
+		c.close()
+		return nil, err
+	}
+	post := *streamURL
+	post.Path += "/" + data
+	post.RawQuery = ""
+	c.post = post.String()
+
+	go c.readLoop(reader)
+	return c, nil
+}
+
+// readLoop passes on every message event until the stream ends
+func (c *sseConn) readLoop(reader *bufio.Reader) {
+	defer close(c.events)
+	for {
+		name, data, err := readEvent(reader)
+		if err != nil {
+			c.err = err
+			return
+		}
+		if name == "" || name == "message" {
+			c.events <- []byte(data)
+		}
+	}
+}
+
+// readEvent reads one server-sent event, skipping comments like the heartbeat
+func readEvent(r *bufio.Reader) (name, data string, err error) {
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case line == "":
+			// a blank line ends the event, or is just padding between them
+			if len(lines) > 0 || name != "" {
+				return name, strings.Join(lines, "\n"), nil
+			}
+		case strings.HasPrefix(line, ":"):
+			// comment
+		default:
+			field, value, _ := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+			switch field {
+			case "event":
+				name = value
+			case "data":
+				lines = append(lines, value)
+			}
+		}
+	}
+}
+
+func (c *sseConn) send(data []byte) error {
+	resp, err := c.http.Post(c.post, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("post message: %s", resp.Status)
+	}
+	return nil
+}
+
+func (c *sseConn) receive(timeout time.Duration) ([]byte, error) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case data, ok := <-c.events:
+		if !ok {
+			return nil, c.err
+		}
+		return data, nil
+	case <-expired:
+		return nil, errors.New("timed out waiting for a message")
+	}
+}
+
+func (c *sseConn) close() error {
+	c.cancel()
+	return c.body.Close()
+}
+
+func (c *sseConn) transport() string {
+	return TransportSSE
+}
+
+// the event stream is text, always JSON
+func (c *sseConn) codec() protocol.Codec {
+	return protocol.JSON
+}
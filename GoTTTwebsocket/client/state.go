@@ -0,0 +1,100 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+
+	"goChatSocket/protocol"
+)
+
+// State is a local mirror of the game, rebuilt from the events we receive.
+// It is not safe for concurrent use, feed it from the goroutine reading Events().
+type State struct {
+	UserName string
+	Symbol   string // empty when spectating
+	Board    []string
+	Rules    protocol.Rules
+	Turn     string // symbol whose turn it is, empty before the game starts
+	Over     bool
+	Result   string // text of the last gameOver message
+}
+
+// Apply updates the state with one event from the server.
+func (s *State) Apply(ev Event) {
+	switch e := ev.(type) {
+	case Assigned:
+		s.UserName, s.Symbol = e.UserName, e.Symbol
+	case Spectating:
+		s.UserName, s.Symbol = e.UserName, ""
+	case Renamed:
+		s.UserName = e.Name
+	case Snapshot:
+		s.Board, s.Rules, s.Turn = append([]string(nil), e.Board...), e.Rules, e.Turn
+		s.Over, s.Result = false, ""
+	case Moved:
+		if e.Position >= 0 && e.Position < len(s.Board) {
+			s.Board[e.Position] = e.Symbol
+		}
+	case TurnChanged:
+		// a turn after a finished game means a new game started
+		if s.Over {
+			s.Board = make([]string, len(s.Board))
+			s.Over, s.Result = false, ""
+		}
+		s.Turn = e.Symbol
+	case GameOver:
+		s.Over, s.Result = true, e.Text
+		s.Turn = ""
+	}
+}
+
+// MyTurn reports whether we hold a seat and it is our move.
+func (s *State) MyTurn() bool {
+	return s.Symbol != "" && !s.Over && s.Turn == s.Symbol
+}
+
+// LegalMoves lists the empty cells.
+func (s *State) LegalMoves() []int {
+	var moves []int
+	for i, cell := range s.Board {
+		if cell == "" {
+			moves = append(moves, i)
+		}
+	}
+	return moves
+}
+
+// String draws the board in ASCII, empty cells show their key (position + 1,
+// 1-9 on a 3x3 board). A 3D board is drawn layer by layer from the top.
+func (s *State) String() string {
+	size := s.Rules.Size
+	if size == 0 || len(s.Board) == 0 {
+		return ""
+	}
+	width := len(fmt.Sprint(len(s.Board)))
+	divider := strings.Repeat("-", width+2)
+	var b strings.Builder
+	for layer := 0; layer < len(s.Board)/(size*size); layer++ {
+		if s.Rules.Depth > 0 {
+			fmt.Fprintf(&b, "layer %d\n", layer+1)
+		}
+		for row := 0; row < size; row++ {
+			if row > 0 {
+				b.WriteString(strings.TrimSuffix(strings.Repeat(divider+"+", size), "+") + "\n")
+			}
+			for col := 0; col < size; col++ {
+				pos := (layer*size+row)*size + col
+				cell := s.Board[pos]
+				if cell == "" {
+					cell = fmt.Sprint(pos + 1)
+				}
+				if col > 0 {
+					b.WriteString("|")
+				}
+				fmt.Fprintf(&b, " %*s ", width, cell)
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
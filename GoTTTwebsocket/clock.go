@@ -0,0 +1,26 @@
+package main
+
+import "time"
+
+// clock tells rooms the time and runs their timers (spectator delays, seats
+// kept after a takeover). The hub's clock is the real one, the simulation
+// tests swap in a virtual one they advance themselves (see sim_test.go).
+type clock interface {
+	now() time.Time
+	afterFunc(d time.Duration, f func()) timer
+}
+
+// timer is a callback scheduled on a clock, *time.Timer for the real one
+type timer interface {
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) now() time.Time {
+	return time.Now()
+}
+
+func (realClock) afterFunc(d time.Duration, f func()) timer {
+	return time.AfterFunc(d, f)
+}
@@ -0,0 +1,685 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"goChatSocket/game"
+	"goChatSocket/protocol"
+)
+
+// cluster lets several server instances (nodes) share rooms through a Broker.
+//
+// Every room is owned by one node, the one holding the room's lease, which
+// runs it exactly like a single server would. Other nodes relay their
+// connections to the owner: what a client sends is published on the room's
+// topic, the owner keeps a stand-in Client (a proxy) for it in the room and
+// the proxy's frames are published back on the client's node topic.
+//
+// After every event the owner publishes the room state on the room topic. When
+// the owner stops renewing its lease another node with connections in the room
+// claims it, restores the game from the last state and keeps the seats free
+// for the players for a while. Players that don't come back forfeit.
+type cluster struct {
+	node   string
+	broker Broker
+	server *Server
+	ttl    time.Duration
+
+	mu     sync.Mutex
+	rooms  map[string]*clusterRoom
+	closed atomic.Bool
+
+	// the latest state of each owned room waiting to be published, see replicate
+	stateMu sync.Mutex
+	states  map[string]roomState
+	dirty   chan struct{}
+
+	stop        chan struct{}
+	unsubscribe func()
+}
+
+// clusterRoom is this node's part in one room.
+// room: the room while this node owns it, nil while it is relayed.
+// owner: the node owning it, as last seen.
+// local: this node's connections in the room, by id.
+// proxies: while this node owns the room, the connections of other nodes, by id.
+// state: the last state the owner published, to take over from.
+type clusterRoom struct {
+	id          string
+	room        *Room
+	owner       string
+	local       map[string]*Client
+	proxies     map[string]*Client
+	state       *roomState
+	unsubscribe func()
+}
+
+// clusterMessage is everything nodes send each other.
+// On room topics: join, data and leave from relaying nodes, state from the owner.
+// On node topics: frame and close for one of the node's connections, frames
+// name the room they were broadcast to.
+// Trace carries the sender's trace context, the receiver continues it (see tracing.go).
+type clusterMessage struct {
+	Kind   string            `json:"kind"`
+	Node   string            `json:"node,omitempty"`
+	Conn   string            `json:"conn,omitempty"`
+	Room   string            `json:"room,omitempty"`
+	Data   []byte            `json:"data,omitempty"`
+	Binary bool              `json:"binary,omitempty"`
+	Seq    uint64            `json:"seq,omitempty"`
+	Join   *joinInfo         `json:"join,omitempty"`
+	State  *roomState        `json:"state,omitempty"`
+	Trace  map[string]string `json:"trace,omitempty"`
+}
+
+// joinInfo is what the owner needs to make a proxy for a relayed connection
+type joinInfo struct {
+	Token     string    `json:"token,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	Profile   string    `json:"profile,omitempty"`
+	Account   *account  `json:"account,omitempty"`
+	Codec     string    `json:"codec"`
+	Version   int       `json:"version"`
+	IP        string    `json:"ip"`
+	Transport string    `json:"transport"`
+	Connected time.Time `json:"connected"`
+}
+
+// roomState is what another node needs to carry on a room
+type roomState struct {
+	Seq            uint64               `json:"seq"`
+	Started        bool                 `json:"started"`
+	Moves          []int                `json:"moves,omitempty"`
+	Symbols        []string             `json:"symbols,omitempty"`
+	Seats          map[string]seatState `json:"seats,omitempty"`
+	PlayerCount    int                  `json:"playerCount"`
+	SpectatorCount int                  `json:"spectatorCount"`
+	AutoRematch    bool                 `json:"autoRematch"`
+	SpectatorDelay time.Duration        `json:"spectatorDelay"`
+	Rules          game.Rules           `json:"rules"`
+	Stake          int64                `json:"stake,omitempty"`
+	Wager          *wager               `json:"wager,omitempty"`
+}
+
+// seatState is who sat in a seat: their connection id, name, and profile and account
+type seatState struct {
+	Conn string `json:"conn"`
+	Name string `json:"name"`
+	gamePlayer
+}
+
+// how long a broker call may take
+const brokerTimeout = 5 * time.Second
+
+func roomTopic(id string) string   { return "room/" + id }
+func nodeTopic(node string) string { return "node/" + node }
+func roomLease(id string) string   { return "room/" + id }
+func nodeLease(node string) string { return "node/" + node }
+
+// joinCluster makes this server one node of a cluster sharing rooms through
+// broker. ttl is how long leases last, a node that stops renewing them loses
+// its rooms after that. Call it before serving any connection.
+func (s *Server) joinCluster(node string, broker Broker, ttl time.Duration) error {
+	cl := &cluster{
+		node:   node,
+		broker: broker,
+		server: s,
+		ttl:    ttl,
+		rooms:  make(map[string]*clusterRoom),
+		states: make(map[string]roomState),
+		dirty:  make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), brokerTimeout)
+	defer cancel()
+	if owner, err := broker.Claim(ctx, nodeLease(node), node, ttl); err != nil {
+		return err
+	} else if owner != node {
+		return fmt.Errorf("node name %s is already in use", node)
+	}
+	unsubscribe, err := broker.Subscribe(ctx, nodeTopic(node), cl.handleNodeMessage)
+	if err != nil {
+		return err
+	}
+	cl.unsubscribe = unsubscribe
+
+	// connection ids must be unique across the cluster
+	s.clients.prefix = node + "."
+	s.cluster = cl
+	go cl.heartbeat()
+	go cl.publishStates()
+	return nil
+}
+
+// close leaves the cluster without handing anything over, as if the node had
+// crashed: its rooms move once their leases run out
+func (cl *cluster) close() {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if !cl.closed.CompareAndSwap(false, true) {
+		return
+	}
+	close(cl.stop)
+	cl.unsubscribe()
+	for _, cr := range cl.rooms {
+		cr.unsubscribe()
+	}
+}
+
+// publish sends a message as part of ctx's trace, a failure is logged: a lost
+// message is no worse than a dropped connection.
+// It takes no locks, proxies publish from under the room lock.
+func (cl *cluster) publish(ctx context.Context, topic string, msg clusterMessage) {
+	if cl.closed.Load() {
+		return
+	}
+	msg.Node = cl.node
+	msg.Trace = injectTrace(ctx)
+	data, err := json.Marshal(msg)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), brokerTimeout)
+		err = cl.broker.Publish(ctx, topic, data)
+		cancel()
+	}
+	if err != nil {
+		fmt.Printf("Cluster publish to %s failed: %v\n", topic, err)
+	}
+}
+
+// track returns this node's part in a room, subscribing to the room topic the first time
+func (cl *cluster) track(id string) (*clusterRoom, error) {
+	if cr, ok := cl.rooms[id]; ok {
+		return cr, nil
+	}
+	cr := &clusterRoom{id: id, local: make(map[string]*Client), proxies: make(map[string]*Client)}
+	ctx, cancel := context.WithTimeout(context.Background(), brokerTimeout)
+	defer cancel()
+	unsubscribe, err := cl.broker.Subscribe(ctx, roomTopic(id), func(data []byte) {
+		cl.handleRoomMessage(cr, data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	cr.unsubscribe = unsubscribe
+	cl.rooms[id] = cr
+	return cr, nil
+}
+
+// tidy releases a room that emptied and forgets rooms this node has no part in anymore, call with cl.mu held
+func (cl *cluster) tidy(cr *clusterRoom) {
+	if cr.room != nil && cl.server.hub.get(cr.id) != cr.room {
+		cr.room = nil
+		cr.proxies = make(map[string]*Client)
+		ctx, cancel := context.WithTimeout(context.Background(), brokerTimeout)
+		cl.broker.Release(ctx, roomLease(cr.id), cl.node)
+		cancel()
+	}
+	if cr.room == nil && len(cr.local) == 0 {
+		cr.unsubscribe()
+		delete(cl.rooms, cr.id)
+	}
+}
+
+// checkOwner claims or renews the room's lease. Winning it for a room that
+// was relayed means the owner is gone and this node takes over. When the
+// room moved to another node our connections join it there. Call with cl.mu held.
+func (cl *cluster) checkOwner(cr *clusterRoom, opts roomOptions) error {
+	ctx, cancel := context.WithTimeout(context.Background(), brokerTimeout)
+	owner, err := cl.broker.Claim(ctx, roomLease(cr.id), cl.node, cl.ttl)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case owner == cl.node && cr.room == nil:
+		cl.takeOver(cr, opts)
+	case owner != cl.node && cr.room != nil:
+		// our lease ran out while we still had the room, someone else has the game now
+		fmt.Printf("Lost room %s to node %s\n", cr.id, owner)
+	case owner != cl.node && owner != cr.owner:
+		for _, c := range cr.local {
+			cl.publishJoin(cr, c)
+		}
+	}
+	cr.owner = owner
+	return nil
+}
+
+// takeOver starts running a room here, from the last state its owner
+// published if there is one, and seats this node's connections again.
+// Call with cl.mu held.
+func (cl *cluster) takeOver(cr *clusterRoom, opts roomOptions) {
+	state := cr.state
+	if state != nil {
+		opts.autoRematch, opts.spectatorDelay = state.AutoRematch, state.SpectatorDelay
+		opts.rules, opts.stake = state.Rules, state.Stake
+		fmt.Printf("Taking over room %s at event #%d\n", cr.id, state.Seq)
+	}
+	opts.replicate = cl.replicator(cr.id)
+	cr.room = cl.server.hub.restore(cr.id, opts, state)
+	cr.state = nil
+	for _, c := range cr.local {
+		cr.room.join(c)
+	}
+
+	// players on a node that went down with the owner have a while to come back
+	if state != nil && state.Started {
+		room := cr.room
+		cl.server.hub.clock.afterFunc(cl.ttl, room.expireReturning)
+	}
+}
+
+// publishJoin asks the owner to add one of our connections to the room
+func (cl *cluster) publishJoin(cr *clusterRoom, c *Client) {
+	cl.publish(c.ctx, roomTopic(cr.id), clusterMessage{
+		Kind: "join",
+		Conn: c.id,
+		Join: &joinInfo{
+			Token:     c.token,
+			Name:      c.wantName,
+			Profile:   c.profile,
+			Account:   c.account,
+			Codec:     c.codec.Name(),
+			Version:   c.version,
+			IP:        c.ip,
+			Transport: c.conn.name(),
+			Connected: c.connected,
+		},
+	})
+}
+
+// enter puts a connection in its room, returning the room when this node owns it.
+// A new room is claimed by the node its first connection arrives on.
+func (cl *cluster) enter(c *Client, id string, opts roomOptions) *Room {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cr, err := cl.track(id)
+	if err == nil && cr.room == nil {
+		err = cl.checkOwner(cr, opts)
+	}
+	if err != nil {
+		// without the broker this node can only run the room on its own
+		fmt.Println("Cluster Error:", err)
+	}
+	if cr == nil {
+		return cl.server.hub.join(id, c, opts)
+	}
+
+	cr.local[c.id] = c
+	if cr.room == nil {
+		cl.publishJoin(cr, c)
+		return nil
+	}
+	opts.replicate = cl.replicator(id)
+	cr.room = cl.server.hub.join(id, c, opts)
+	return cr.room
+}
+
+// forward hands a message to the room's owner, ctx is its trace. It returns
+// the room instead when this node owns it, for the caller to dispatch.
+func (cl *cluster) forward(ctx context.Context, c *Client, id string, data []byte) *Room {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cr := cl.rooms[id]
+	if cr == nil {
+		return cl.server.hub.get(id)
+	}
+	if cr.room != nil {
+		return cr.room
+	}
+	cl.publish(ctx, roomTopic(id), clusterMessage{Kind: "data", Conn: c.id, Data: data})
+	return nil
+}
+
+// leave takes a connection out of its room, wherever the room runs
+func (cl *cluster) leave(c *Client, id string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cr := cl.rooms[id]
+	if cr == nil {
+		if room := cl.server.hub.get(id); room != nil {
+			room.leave(c)
+		}
+		return
+	}
+	delete(cr.local, c.id)
+	if cr.room != nil {
+		cr.room.leave(c)
+	} else {
+		cl.publish(c.ctx, roomTopic(id), clusterMessage{Kind: "leave", Conn: c.id})
+	}
+	cl.tidy(cr)
+}
+
+// handleRoomMessage runs on the owner for join, data and leave, and on the
+// other nodes for state. Messages for rooms we don't own are ignored, their
+// senders join again once they see the new owner.
+func (cl *cluster) handleRoomMessage(cr *clusterRoom, data []byte) {
+	var msg clusterMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		fmt.Println("Cluster message error:", err)
+		return
+	}
+	if msg.Node == cl.node {
+		return
+	}
+
+	cl.mu.Lock()
+	if cl.closed.Load() {
+		cl.mu.Unlock()
+		return
+	}
+	if msg.Kind == "state" {
+		if cr.room == nil {
+			cr.state = msg.State
+		}
+		cl.mu.Unlock()
+		return
+	}
+	room := cr.room
+	if room == nil {
+		cl.mu.Unlock()
+		return
+	}
+
+	switch msg.Kind {
+	case "join":
+		if _, ok := cr.proxies[msg.Conn]; ok || msg.Join == nil {
+			break
+		}
+		codec, ok := codecNamed(msg.Join.Codec)
+		if !ok {
+			break
+		}
+		proxy := newClient(&remoteTransport{
+			cluster: cl,
+			node:    msg.Node,
+			conn:    msg.Conn,
+			addr:    msg.Join.IP,
+			kind:    msg.Join.Transport,
+			closed:  make(chan struct{}),
+		}, codec, msg.Join.Token, msg.Join.Version)
+		proxy.id, proxy.connected = msg.Conn, msg.Join.Connected
+		proxy.wantName, proxy.profile, proxy.account = msg.Join.Name, msg.Join.Profile, msg.Join.Account
+		proxy.ctx = extractTrace(msg.Trace)
+		cl.server.clients.add(proxy)
+		cr.proxies[msg.Conn] = proxy
+		cr.room = cl.server.hub.join(cr.id, proxy, roomOptions{autoRematch: true, replicate: cl.replicator(cr.id)})
+
+	case "data":
+		proxy := cr.proxies[msg.Conn]
+		cl.mu.Unlock()
+		if proxy != nil {
+			// the message's trace goes on from the node it was sent to
+			ctx, span := startSpan(extractTrace(msg.Trace), "message", clientAttrs(proxy, cr.id)...)
+			cl.server.dispatch(ctx, proxy, room, msg.Data)
+			span.End()
+		}
+		return
+
+	case "leave":
+		proxy := cr.proxies[msg.Conn]
+		if proxy == nil {
+			break
+		}
+		cl.dropProxy(cr, proxy)
+		cl.tidy(cr)
+	}
+	cl.mu.Unlock()
+}
+
+// dropProxy takes a relayed connection out of an owned room, call with cl.mu held
+func (cl *cluster) dropProxy(cr *clusterRoom, proxy *Client) {
+	delete(cr.proxies, proxy.id)
+	cl.server.tournaments.unwatch(proxy)
+	cr.room.leave(proxy)
+	cl.server.clients.remove(proxy)
+	proxy.close()
+}
+
+// handleNodeMessage delivers what an owner sends to one of our connections
+func (cl *cluster) handleNodeMessage(data []byte) {
+	var msg clusterMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		fmt.Println("Cluster message error:", err)
+		return
+	}
+	c := cl.server.clients.get(msg.Conn)
+	if c == nil {
+		return
+	}
+	switch msg.Kind {
+	case "frame":
+		f := frame{seq: msg.Seq, data: msg.Data, binary: msg.Binary}
+		if msg.Trace != nil {
+			f.ctx, f.room = extractTrace(msg.Trace), msg.Room
+		}
+		c.pushFrame(f)
+	case "close":
+		c.conn.close()
+	}
+}
+
+// heartbeat keeps this node's leases alive, notices owners that went away
+// and drops proxies of nodes that did
+func (cl *cluster) heartbeat() {
+	ticker := time.NewTicker(cl.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cl.stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), brokerTimeout)
+		if _, err := cl.broker.Claim(ctx, nodeLease(cl.node), cl.node, cl.ttl); err != nil {
+			fmt.Println("Cluster Error:", err)
+		}
+		cancel()
+
+		cl.mu.Lock()
+		alive := make(map[string]bool)
+		for _, cr := range cl.rooms {
+			if err := cl.checkOwner(cr, roomOptions{autoRematch: true}); err != nil {
+				fmt.Println("Cluster Error:", err)
+			}
+			for _, proxy := range cr.proxies {
+				node := proxy.conn.(*remoteTransport).node
+				if _, ok := alive[node]; !ok {
+					alive[node] = cl.nodeAlive(node)
+				}
+				if !alive[node] {
+					cl.dropProxy(cr, proxy)
+				}
+			}
+			cl.tidy(cr)
+		}
+		cl.mu.Unlock()
+	}
+}
+
+// nodeAlive reports whether a node is still renewing its lease
+func (cl *cluster) nodeAlive(node string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), brokerTimeout)
+	defer cancel()
+	owner, err := cl.broker.Owner(ctx, nodeLease(node))
+	return err != nil || owner == node // when in doubt keep the connections
+}
+
+// replicator returns the replicate option for an owned room: it keeps the
+// latest state and leaves publishing to publishStates, so the room lock is
+// never held over a broker call
+func (cl *cluster) replicator(id string) func(roomState) {
+	return func(state roomState) {
+		cl.stateMu.Lock()
+		cl.states[id] = state
+		cl.stateMu.Unlock()
+		select {
+		case cl.dirty <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// publishStates publishes the latest state of every room that changed
+func (cl *cluster) publishStates() {
+	for {
+		select {
+		case <-cl.stop:
+			return
+		case <-cl.dirty:
+		}
+		cl.stateMu.Lock()
+		states := cl.states
+		cl.states = make(map[string]roomState)
+		cl.stateMu.Unlock()
+
+		for id, state := range states {
+			cl.publish(context.Background(), roomTopic(id), clusterMessage{Kind: "state", State: &state})
+		}
+	}
+}
+
+func codecNamed(name string) (protocol.Codec, bool) {
+	for _, codec := range protocol.Codecs {
+		if codec.Name() == name {
+			return codec, true
+		}
+	}
+	return nil, false
+}
+
+// remoteTransport stands in for a connection on another node: frames for it
+// are published to that node, which writes them to the real connection
+type remoteTransport struct {
+	cluster *cluster
+	node    string
+	conn    string
+	addr    string
+	kind    string
+
+	once   sync.Once
+	closed chan struct{}
+}
+
+// receive is never used, what the client sends arrives through the room topic
+func (t *remoteTransport) receive(timeout time.Duration) ([]byte, error) {
+	<-t.closed
+	return nil, errSessionClosed
+}
+
+func (t *remoteTransport) send(f frame) error {
+	ctx := f.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	t.cluster.publish(ctx, nodeTopic(t.node), clusterMessage{Kind: "frame", Conn: t.conn, Room: f.room, Data: f.data, Binary: f.binary, Seq: f.seq})
+	return nil
+}
+
+// close hangs up the real connection
+func (t *remoteTransport) close() error {
+	t.once.Do(func() {
+		close(t.closed)
+		t.cluster.publish(context.Background(), nodeTopic(t.node), clusterMessage{Kind: "close", Conn: t.conn})
+	})
+	return nil
+}
+
+func (t *remoteTransport) remoteAddr() string {
+	return t.addr
+}
+
+func (t *remoteTransport) name() string {
+	return t.kind
+}
+
+// room side
+
+// state is what another node needs to carry on the room, call with the lock held
+func (r *Room) state() roomState {
+	state := roomState{
+		Seq:            r.seq,
+		Started:        r.started,
+		Moves:          append([]int(nil), r.game.Moves...),
+		Symbols:        append([]string(nil), r.game.Symbols...),
+		Seats:          make(map[string]seatState),
+		PlayerCount:    r.playerCount,
+		SpectatorCount: r.spectatorCount,
+		AutoRematch:    r.opts.autoRematch,
+		SpectatorDelay: r.opts.spectatorDelay,
+		Rules:          r.opts.gameRules(),
+		Stake:          r.opts.stake,
+		Wager:          r.wager,
+	}
+	for symbol, p := range r.players {
+		state.Seats[symbol] = seatState{Conn: p.id, Name: p.name, gamePlayer: r.gamePlayers[symbol]}
+	}
+	return state
+}
+
+// restore picks up a room from the state its previous owner published:
+// the game is replayed and the seats are kept for the same connections
+func (r *Room) restore(state roomState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.seq = state.Seq
+	r.started = state.Started
+	r.playerCount, r.spectatorCount = state.PlayerCount, state.SpectatorCount
+	r.game = game.New(r.opts.gameRules())
+	for i, pos := range state.Moves {
+		// the player's own symbol, unless the state says they picked another
+		symbol := r.game.Turn
+		if i < len(state.Symbols) {
+			symbol = state.Symbols[i]
+		}
+		r.game.Place(pos, r.game.Turn, symbol)
+	}
+	r.feed = spectatorFeed{sent: r.seq, board: append([]string(nil), r.game.Board...)}
+	if r.started {
+		r.feed.turn = r.game.Turn
+	}
+	r.returning = state.Seats
+	if state.Wager != nil && r.hub.escrow != nil {
+		r.hub.escrow.adopt(*state.Wager)
+		r.wager = state.Wager
+	}
+	if r.started {
+		r.gamePlayers = make(map[string]gamePlayer)
+		for symbol, seat := range state.Seats {
+			r.gamePlayers[symbol] = seat.gamePlayer
+		}
+	}
+}
+
+// expireReturning stops keeping seats for players lost in a takeover, a game
+// still waiting for one of them is forfeited
+func (r *Room) expireReturning() {
+	r.mu.Lock()
+	missing := r.returning
+	r.returning = nil
+	var onGameOver func(string)
+	var winner string
+	for _, symbol := range []string{game.X, game.O} {
+		if seat, ok := missing[symbol]; ok && r.started && r.players[symbol] == nil {
+			winner, onGameOver = r.forfeit(symbol, seat.Name)
+			break
+		}
+	}
+	r.mu.Unlock()
+
+	if onGameOver != nil {
+		onGameOver(winner)
+	}
+	r.hub.removeIfEmpty(r)
+}
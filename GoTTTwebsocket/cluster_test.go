@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"goChatSocket/client"
+)
+
+// startNode runs one server of a cluster and returns it with its websocket address
+func startNode(t *testing.T, broker Broker, node string, ttl time.Duration) (*Server, string) {
+	t.Helper()
+	server := newServer()
+	if err := server.joinCluster(node, broker, ttl); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.cluster.close)
+	srv := httptest.NewServer(server.routes())
+	t.Cleanup(srv.Close)
+	return server, "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+}
+
+// play makes the moves in order, X first, waiting for each turn
+func play(t *testing.T, x, o *client.Client, moves ...int) {
+	t.Helper()
+	for i, pos := range moves {
+		mover, symbol := x, "X"
+		if i%2 == 1 {
+			mover, symbol = o, "O"
+		}
+		waitFor(t, mover, func(e client.TurnChanged) bool { return e.Symbol == symbol })
+		if err := mover.Move(pos); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestClusterSharedRoom(t *testing.T) {
+	broker := newMemoryBroker()
+	_, a := startNode(t, broker, "a", time.Second)
+	_, b := startNode(t, broker, "b", time.Second)
+
+	// X opens the room on node a, O and a spectator reach it through node b
+	x := dial(t, roomURL(t, a, "shared"))
+	waitFor(t, x, func(e client.Assigned) bool { return e.Symbol == "X" })
+	o := dial(t, roomURL(t, b, "shared"))
+	waitFor(t, o, func(e client.Assigned) bool { return e.Symbol == "O" })
+	spectator := dial(t, roomURL(t, b, "shared"))
+	waitFor[client.Spectating](t, spectator, nil)
+
+	// X takes the top row while O plays the middle row
+	play(t, x, o, 0, 3, 1, 4, 2)
+	for _, c := range []*client.Client{x, o, spectator} {
+		if over := waitFor[client.GameOver](t, c, nil); over.Winner != "X" {
+			t.Fatalf("winner %q, want X", over.Winner)
+		}
+	}
+
+	if err := o.Chat("hello from b"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, x, func(e client.ChatReceived) bool { return e.Text == "hello from b" })
+
+	// moves from the relaying node are checked by the owner like any other
+	waitFor(t, o, func(e client.TurnChanged) bool { return e.Symbol == "X" })
+	o.Move(8)
+	if rejected := waitFor[client.Rejected](t, o, nil); rejected.Code != "illegalMove" {
+		t.Fatalf("out of turn move got %+v", rejected)
+	}
+}
+
+func TestClusterFailover(t *testing.T) {
+	const ttl = 300 * time.Millisecond
+	broker := newMemoryBroker()
+	nodeA, a := startNode(t, broker, "a", ttl)
+	_, b := startNode(t, broker, "b", ttl)
+
+	x := dial(t, roomURL(t, a, "failover"))
+	waitFor(t, x, func(e client.Assigned) bool { return e.Symbol == "X" })
+	o := dial(t, roomURL(t, b, "failover"))
+	waitFor(t, o, func(e client.Assigned) bool { return e.Symbol == "O" })
+	spectator := dial(t, roomURL(t, b, "failover"))
+	waitFor[client.Spectating](t, spectator, nil)
+
+	play(t, x, o, 0, 4)
+	waitFor(t, spectator, func(e client.Moved) bool { return e.Position == 4 })
+
+	// node a goes down with the room and X's connection
+	nodeA.cluster.close()
+	x.Close()
+
+	// b takes over from the last state a published and O keeps their seat
+	for _, c := range []*client.Client{o, spectator} {
+		snapshot := waitFor[client.Snapshot](t, c, nil)
+		if snapshot.Board[0] != "X" || snapshot.Board[4] != "O" || snapshot.Turn != "X" {
+			t.Fatalf("restored board %v turn %q", snapshot.Board, snapshot.Turn)
+		}
+	}
+	waitFor(t, o, func(e client.Assigned) bool { return e.Symbol == "O" })
+
+	// X never comes back and forfeits
+	if over := waitFor[client.GameOver](t, spectator, nil); over.Winner != "O" {
+		t.Fatalf("winner %q, want O", over.Winner)
+	}
+}
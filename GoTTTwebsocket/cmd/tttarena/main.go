@@ -0,0 +1,83 @@
+// tttarena - run a round robin between TTTP engines and print the crosstable.
+//
+// Each argument is an engine command line, quote it if it has arguments:
+//
+//	go build -o bin/tttengine ./cmd/tttengine
+//	go run ./cmd/tttarena "bin/tttengine -strategy random" "bin/tttengine -strategy greedy" "python3 mybot.py"
+//	go run ./cmd/tttarena -size 4 -line 4 -depth 4 "bin/tttengine -strategy greedy" "bin/tttengine -strategy heuristic"
+//
+// See the arena package for the protocol engines have to speak.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"goChatSocket/arena"
+	"goChatSocket/game"
+)
+
+func main() {
+	var cfg arena.Config
+	flag.IntVar(&cfg.Rules.Size, "size", game.Classic.Size, "board size (cells per side)")
+	flag.IntVar(&cfg.Rules.Line, "line", game.Classic.Line, "symbols in a row needed to win")
+	flag.IntVar(&cfg.Rules.Depth, "depth", 0, "layers of a 3D board, 0 for a flat one (-size 4 -line 4 -depth 4 is Qubic)")
+	flag.DurationVar(&cfg.MoveTime, "movetime", time.Second, "time budget per move")
+	flag.DurationVar(&cfg.StartTimeout, "start-timeout", 5*time.Second, "time allowed for the handshake and isready")
+	flag.IntVar(&cfg.GamesPerPair, "games", 2, "games per pair of engines, colours alternate")
+	out := flag.String("json", "", "also write the crosstable and every game to this JSON file")
+	flag.Parse()
+
+	entrants := entrantsFromArgs(flag.Args())
+	table, err := arena.RoundRobin(entrants, cfg, func(r arena.Result) {
+		winner := "draw"
+		switch r.Winner {
+		case game.X:
+			winner = r.X + " wins"
+		case game.O:
+			winner = r.O + " wins"
+		}
+		fmt.Printf("%s (X) vs %s (O): %s by %s %s\n", r.X, r.O, winner, r.Reason, r.Detail)
+	})
+	if err != nil {
+		fmt.Println("Arena Error:", err)
+		os.Exit(2)
+	}
+
+	fmt.Println()
+	fmt.Print(table)
+
+	if *out != "" {
+		data, err := json.MarshalIndent(table, "", "  ")
+		if err == nil {
+			err = os.WriteFile(*out, append(data, '\n'), 0o644)
+		}
+		if err != nil {
+			fmt.Println("Error saving crosstable:", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// entrantsFromArgs turns each command line into an entrant with a unique name
+func entrantsFromArgs(args []string) []arena.Entrant {
+	seen := map[string]int{}
+	var entrants []arena.Entrant
+	for _, arg := range args {
+		command := strings.Fields(arg)
+		if len(command) == 0 {
+			continue
+		}
+		name := strings.Join(command, " ")
+		seen[name]++
+		if seen[name] > 1 {
+			name = fmt.Sprintf("%s #%d", name, seen[name])
+		}
+		entrants = append(entrants, arena.Entrant{Name: name, Command: command})
+	}
+	return entrants
+}
@@ -0,0 +1,253 @@
+// tttcli - play Tic-Tac-Toe against the websocket server from a terminal.
+//
+//	go run ./cmd/tttcli                 // play with the keyboard
+//	go run ./cmd/tttcli --bot           // let the bot play
+//	go run ./cmd/tttcli --addr ws://host:8080/ws
+//	go run ./cmd/tttcli --room t1-m3 --token <seat token>   // play a tournament match
+//	go run ./cmd/tttcli --room cube --addr 'ws://localhost:8080/ws?mode=qubic'   // a new 4x4x4 room
+//	go run ./cmd/tttcli --name Ada --profile <profile token>   // play under a name, keeping stats
+//	go run ./cmd/tttcli --login <account token>   // sign in to a rated account
+//
+// Type 1-9 to place your symbol (up to 64 on a Qubic board), 5=O to pick the
+// symbol in the wild and Order and Chaos variants, /name <name> to change your
+// name, /react <emoji> [move] to react to the room or a move (1-6 pick from
+// the emoji list), /quick <phrase> for a quick chat line, anything else is sent
+// as chat, /quit to leave.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"goChatSocket/ai"
+	"goChatSocket/client"
+	"goChatSocket/game"
+	"goChatSocket/protocol"
+)
+
+func main() {
+	addr := flag.String("addr", "ws://localhost:8080/ws", "websocket address of the game server")
+	bot := flag.Bool("bot", false, "play automatically instead of reading moves from the keyboard")
+	delay := flag.Duration("delay", 500*time.Millisecond, "how long the bot waits before moving")
+	room := flag.String("room", "", "room to join, the server's main room if empty")
+	token := flag.String("token", "", "seat token for a reserved (tournament) seat")
+	name := flag.String("name", "", "display name to ask for, the server picks one if empty or taken")
+	profile := flag.String("profile", "", "login token of a saved profile (POST /profiles)")
+	login := flag.String("login", "", "account login token from the accounts service, empty to play as a guest")
+	flag.Parse()
+
+	var c *client.Client
+	url, err := client.RoomURL(*addr, *room, *token)
+	if err == nil {
+		c, err = client.DialAs(url, client.Identity{Name: *name, Profile: *profile, Token: *login})
+	}
+	if err != nil {
+		fmt.Println("Connection Error:", err)
+		os.Exit(1)
+	}
+	defer c.Close()
+	if c.Transport() != client.TransportWebsocket {
+		fmt.Println("Websocket unavailable, connected over", c.Transport())
+	}
+
+	// read keyboard lines in the background so we can select on them with events
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	var state client.State
+	var botMove <-chan time.Time
+
+	for {
+		select {
+		case ev, ok := <-c.Events():
+			if !ok {
+				fmt.Println("Connection closed:", c.Err())
+				return
+			}
+			state.Apply(ev)
+			render(ev, &state)
+
+			// schedule a bot move whenever it becomes our turn
+			if *bot && state.MyTurn() && botMove == nil {
+				botMove = time.After(*delay)
+			}
+
+		case <-botMove:
+			botMove = nil
+			if !state.MyTurn() {
+				continue
+			}
+			if err := c.Move(pickMove(&state)); err != nil {
+				fmt.Println("Send Error:", err)
+				return
+			}
+
+		case line, ok := <-lines:
+			if !ok {
+				return
+			}
+			if !handleInput(c, &state, strings.TrimSpace(line)) {
+				return
+			}
+		}
+	}
+}
+
+// handleInput sends a move or chat line, returns false when the user wants to quit
+func handleInput(c *client.Client, state *client.State, line string) bool {
+	if line == "" {
+		return true
+	}
+	if line == "/quit" {
+		return false
+	}
+	if name, ok := strings.CutPrefix(line, "/name "); ok {
+		if err := c.SetName(strings.TrimSpace(name)); err != nil {
+			fmt.Println("Send Error:", err)
+			return false
+		}
+		return true
+	}
+	if args, ok := strings.CutPrefix(line, "/react "); ok {
+		return react(c, strings.Fields(args))
+	}
+	if phrase, ok := strings.CutPrefix(line, "/quick "); ok {
+		if _, ok := protocol.QuickChatText(strings.TrimSpace(phrase)); !ok {
+			fmt.Println("Quick chats:")
+			for _, p := range protocol.QuickChats {
+				fmt.Printf("  %-8s %s\n", p.ID, p.Text)
+			}
+			return true
+		}
+		if err := c.QuickChat(strings.TrimSpace(phrase)); err != nil {
+			fmt.Println("Send Error:", err)
+			return false
+		}
+		return true
+	}
+
+	// keys 1-9 map to board positions 0-8, on bigger boards they go on up,
+	// 5=O places an O where the variant lets players pick
+	keyText, symbol, _ := strings.Cut(line, "=")
+	if key, err := strconv.Atoi(keyText); err == nil {
+		if !state.MyTurn() {
+			fmt.Println("It's not your turn!")
+			return true
+		}
+		if key < 1 || key > len(state.Board) || state.Board[key-1] != "" {
+			fmt.Printf("Pick an empty cell between 1 and %d.\n", len(state.Board))
+			return true
+		}
+		if err := c.Place(key-1, strings.ToUpper(symbol)); err != nil {
+			fmt.Println("Send Error:", err)
+			return false
+		}
+		return true
+	}
+
+	if err := c.Chat(line); err != nil {
+		fmt.Println("Send Error:", err)
+		return false
+	}
+	return true
+}
+
+// react sends /react's emoji, given as itself or its number in the list, and
+// the optional move number
+func react(c *client.Client, args []string) bool {
+	if len(args) == 0 || len(args) > 2 {
+		fmt.Println("Usage: /react <emoji> [move], emoji one of", strings.Join(protocol.Emojis, " "))
+		return true
+	}
+	emoji := args[0]
+	if n, err := strconv.Atoi(emoji); err == nil && n >= 1 && n <= len(protocol.Emojis) {
+		emoji = protocol.Emojis[n-1]
+	}
+	move := 0
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			fmt.Println("The move is a number, 1 for the game's first move.")
+			return true
+		}
+		move = n
+	}
+	if err := c.React(emoji, move); err != nil {
+		fmt.Println("Send Error:", err)
+		return false
+	}
+	return true
+}
+
+// render prints one event, and the board whenever it changed
+func render(ev client.Event, state *client.State) {
+	switch e := ev.(type) {
+	case client.Assigned:
+		fmt.Printf("YOU ARE PLAYING AS %s (%s)\n", e.UserName, e.Symbol)
+	case client.Spectating:
+		fmt.Printf("YOU ARE SPECTATING AS %s: %s\n", e.UserName, e.Text)
+	case client.Renamed:
+		fmt.Println("YOU ARE NOW", e.Name)
+	case client.ChatReceived:
+		if e.Channel != "" {
+			fmt.Printf("[%s] %s: %s\n", e.Channel, e.Sender, e.Text)
+		} else {
+			fmt.Printf("%s: %s\n", e.Sender, e.Text)
+		}
+	case client.Reacted:
+		if e.Move == 0 {
+			fmt.Printf("%s reacted %s\n", e.Sender, e.Emoji)
+			break
+		}
+		var counts []string
+		for _, emoji := range protocol.Emojis {
+			if n := e.Counts[emoji]; n > 0 {
+				counts = append(counts, fmt.Sprintf("%s %d", emoji, n))
+			}
+		}
+		fmt.Printf("%s reacted %s to move %d (%s)\n", e.Sender, e.Emoji, e.Move, strings.Join(counts, ", "))
+	case client.SystemNotice:
+		fmt.Println("GAMEMASTER:", e.Text)
+	case client.Snapshot:
+		if e.Rules.Variant != "" {
+			fmt.Println("RULES:", e.Rules.Description)
+		}
+		fmt.Print("\n", state.String(), "\n")
+	case client.Moved:
+		fmt.Print("\n", state.String(), "\n")
+	case client.TurnChanged:
+		if state.MyTurn() {
+			fmt.Printf("Your move (1-%d):\n", len(state.Board))
+		} else {
+			fmt.Printf("It's %s's turn.\n", e.Symbol)
+		}
+	case client.GameOver:
+		fmt.Println("GAME OVER:", e.Text)
+	case client.Rejected:
+		fmt.Println("SERVER:", e.Message)
+	}
+}
+
+// pickMove is the bot, see ai.Heuristic. It plays anything legal with the
+// rule variants, which the heuristic doesn't know, or if the board doesn't add up.
+func pickMove(state *client.State) int {
+	rules := game.Rules{Size: state.Rules.Size, Line: state.Rules.Line, Depth: state.Rules.Depth}
+	g, err := game.FromPosition(rules, state.Board, state.Symbol)
+	if err != nil || state.Rules.Variant != game.Standard {
+		moves := state.LegalMoves()
+		return moves[rand.Intn(len(moves))]
+	}
+	return ai.Heuristic(g)
+}
@@ -0,0 +1,99 @@
+// tttengine - a small example engine speaking TTTP (see the arena package).
+// It is meant as a starting point for bots and as a sparring partner in the arena.
+//
+//	-strategy random     any empty cell
+//	-strategy greedy     win if possible, otherwise block, otherwise random
+//	-strategy heuristic  ai.Heuristic: also forks and open lines, plays Qubic well
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+
+	"goChatSocket/ai"
+	"goChatSocket/arena"
+	"goChatSocket/game"
+)
+
+func main() {
+	strategy := flag.String("strategy", "greedy", "random, greedy or heuristic")
+	flag.Parse()
+
+	rules := game.Classic
+	var g *game.Game
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "tttp":
+			fmt.Println("id name tttengine-" + *strategy)
+			fmt.Println("tttpok")
+		case "isready":
+			fmt.Println("readyok")
+		case "rules":
+			// rules size <n> line <k> [depth <d>]
+			rules = game.Rules{}
+			for i := 1; i+1 < len(fields); i += 2 {
+				value, _ := strconv.Atoi(fields[i+1])
+				switch fields[i] {
+				case "size":
+					rules.Size = value
+				case "line":
+					rules.Line = value
+				case "depth":
+					rules.Depth = value
+				}
+			}
+		case "position":
+			// position <cells> <turn>
+			if len(fields) != 3 {
+				continue
+			}
+			board, err := arena.DecodeBoard(fields[1])
+			if err != nil {
+				fmt.Println("info", err)
+				continue
+			}
+			g = game.New(rules)
+			g.Board, g.Turn = board, fields[2]
+		case "go":
+			if g == nil {
+				continue
+			}
+			fmt.Println("bestmove", pickMove(g, *strategy))
+		case "quit":
+			return
+		}
+	}
+}
+
+func pickMove(g *game.Game, strategy string) int {
+	if strategy == "heuristic" {
+		return ai.Heuristic(g)
+	}
+	moves := g.LegalMoves()
+	if strategy == "greedy" {
+		// a move that wins for us, then one that would win for them
+		for _, symbol := range []string{g.Turn, game.Other(g.Turn)} {
+			for _, pos := range moves {
+				next := g.Clone()
+				next.Turn = symbol
+				next.Play(pos, symbol)
+				if next.Winner() == symbol {
+					return pos
+				}
+			}
+		}
+	}
+	return moves[rand.Intn(len(moves))]
+}
@@ -0,0 +1,159 @@
+// tttload - load generator for the Tic-Tac-Toe websocket server.
+//
+// Opens N websocket connections, pairs them into games, plays random legal
+// moves at a set rate and chats in the background. When the run ends it prints
+// move-broadcast latency percentiles, dropped messages and errors, and saves
+// the same summary as JSON so runs can be compared.
+//
+//	go run ./cmd/tttload --players 1000 --duration 1m --out run1.json
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"goChatSocket/client"
+)
+
+// config is the set of flags for one run, also saved in the report
+type config struct {
+	Addr        string
+	RoomPrefix  string
+	Players     int
+	MoveRate    float64
+	ChatRate    float64
+	Duration    time.Duration
+	Timeout     time.Duration
+	DialWorkers int
+	Seed        int64
+}
+
+// MarshalJSON writes durations as "30s" rather than nanoseconds
+func (c config) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"addr":        c.Addr,
+		"roomPrefix":  c.RoomPrefix,
+		"players":     c.Players,
+		"moveRate":    c.MoveRate,
+		"chatRate":    c.ChatRate,
+		"duration":    c.Duration.String(),
+		"timeout":     c.Timeout.String(),
+		"dialWorkers": c.DialWorkers,
+		"seed":        c.Seed,
+	})
+}
+
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.Addr, "addr", "ws://localhost:8080/ws", "websocket address of the game server")
+	flag.StringVar(&cfg.RoomPrefix, "room-prefix", "load", "each pair of players gets room <prefix>-<n>, empty puts everyone in the main room")
+	flag.IntVar(&cfg.Players, "players", 100, "number of simulated players (paired into games)")
+	flag.Float64Var(&cfg.MoveRate, "move-rate", 2, "moves per second per game")
+	flag.Float64Var(&cfg.ChatRate, "chat-rate", 0.2, "chat messages per second per player, 0 disables chat")
+	flag.DurationVar(&cfg.Duration, "duration", 30*time.Second, "length of the whole run, including connecting")
+	flag.DurationVar(&cfg.Timeout, "timeout", 2*time.Second, "a broadcast not seen within this long counts as dropped")
+	flag.IntVar(&cfg.DialWorkers, "dial-workers", 50, "connections opened in parallel")
+	flag.Int64Var(&cfg.Seed, "seed", time.Now().UnixNano(), "random seed for moves and chat timing")
+	out := flag.String("out", "loadtest.json", "file the JSON summary is written to")
+	flag.Parse()
+
+	if cfg.Players < 2 || cfg.MoveRate <= 0 {
+		fmt.Println("need at least 2 players and a positive move rate")
+		os.Exit(2)
+	}
+
+	startedAt := time.Now()
+	st := newStats()
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Duration)
+	defer cancel()
+
+	// sweep stale messages while the run is going so the pending maps stay small
+	go func() {
+		ticker := time.NewTicker(cfg.Timeout)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				st.sweep(cfg.Timeout)
+			}
+		}
+	}()
+
+	fmt.Printf("connecting %d players to %s for %s\n", cfg.Players, cfg.Addr, cfg.Duration)
+	var wg sync.WaitGroup
+	connect(ctx, cfg, st, &wg)
+	wg.Wait()
+
+	// everything still pending after the run is dropped
+	st.sweep(0)
+
+	report := st.report(cfg, startedAt)
+	report.printTable(os.Stdout)
+	if err := report.save(*out); err != nil {
+		fmt.Println("Error saving report:", err)
+		os.Exit(1)
+	}
+	fmt.Println("summary saved to", *out)
+}
+
+// connect dials every player using a pool of workers and starts each one
+// playing as soon as it is connected. failed dials are counted and skipped.
+func connect(ctx context.Context, cfg config, st *stats, players *sync.WaitGroup) {
+	ids := make(chan int)
+
+	var dialers sync.WaitGroup
+	for w := 0; w < cfg.DialWorkers; w++ {
+		dialers.Add(1)
+		go func() {
+			defer dialers.Done()
+			for id := range ids {
+				if ctx.Err() != nil {
+					continue
+				}
+				room := ""
+				if cfg.RoomPrefix != "" {
+					room = fmt.Sprintf("%s-%d", cfg.RoomPrefix, id/2)
+				}
+				url, err := client.RoomURL(cfg.Addr, room, "")
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(2)
+				}
+				c, err := client.Dial(url)
+				if err != nil {
+					st.count(&st.dialErrors)
+					continue
+				}
+				st.count(&st.connected)
+
+				p := &player{
+					id:    id,
+					room:  room,
+					c:     c,
+					stats: st,
+					rng:   rand.New(rand.NewSource(cfg.Seed + int64(id))),
+				}
+				players.Add(1)
+				go func() {
+					defer players.Done()
+					p.run(ctx, cfg)
+				}()
+			}
+		}()
+	}
+
+	for id := 0; id < cfg.Players; id++ {
+		ids <- id
+	}
+	close(ids)
+	dialers.Wait()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"goChatSocket/client"
+)
+
+// player is one simulated user, paired with another player to form a game
+type player struct {
+	id    int
+	room  string // players 2n and 2n+1 share a room, unless everyone is in the main room
+	c     *client.Client
+	state client.State
+	stats *stats
+	rng   *rand.Rand
+}
+
+// run plays until ctx is done or the connection drops
+func (p *player) run(ctx context.Context, cfg config) {
+	defer p.c.Close()
+
+	var moveTimer <-chan time.Time
+	var chatTimer <-chan time.Time
+	if cfg.ChatRate > 0 {
+		chatTimer = time.After(p.jitter(cfg.ChatRate))
+	}
+	chats := 0
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case ev, ok := <-p.c.Events():
+			if !ok {
+				// the server hung up on us before the run finished
+				if ctx.Err() == nil {
+					p.stats.count(&p.stats.disconnects)
+				}
+				return
+			}
+			p.observe(ev)
+
+			if p.state.MyTurn() && moveTimer == nil {
+				moveTimer = time.After(time.Duration(float64(time.Second) / cfg.MoveRate))
+			}
+
+		case <-moveTimer:
+			moveTimer = nil
+			moves := p.state.LegalMoves()
+			if !p.state.MyTurn() || len(moves) == 0 {
+				continue
+			}
+			position := moves[p.rng.Intn(len(moves))]
+			// both players of the game should see the move broadcast
+			p.stats.moveSent(p.room, position, 2)
+			if err := p.c.Move(position); err != nil {
+				p.stats.count(&p.stats.sendErrors)
+				return
+			}
+
+		case <-chatTimer:
+			chats++
+			text := fmt.Sprintf("load-%d-%d", p.id, chats)
+			p.stats.chatSent(text)
+			if err := p.c.Chat(text); err != nil {
+				p.stats.count(&p.stats.sendErrors)
+				return
+			}
+			chatTimer = time.After(p.jitter(cfg.ChatRate))
+		}
+	}
+}
+
+// observe applies an event to the local board and records what we measure
+func (p *player) observe(ev client.Event) {
+	p.state.Apply(ev)
+
+	switch e := ev.(type) {
+	case client.Spectating:
+		p.stats.count(&p.stats.spectators)
+	case client.Moved:
+		// spectators see the move too, they aren't waited for
+		if p.state.Symbol != "" {
+			p.stats.moveReceived(p.room, e.Position)
+		}
+	case client.ChatReceived:
+		if e.Sender == p.c.UserName() {
+			p.stats.chatReceived(e.Text)
+		}
+	case client.GameOver:
+		// only count each game once, from the X side
+		if p.state.Symbol == "X" {
+			p.stats.count(&p.stats.gamesFinished)
+		}
+	case client.Rejected:
+		p.stats.count(&p.stats.rejected)
+	}
+}
+
+// jitter spreads events around an average rate per second so players don't act in lockstep
+func (p *player) jitter(rate float64) time.Duration {
+	return time.Duration(p.rng.ExpFloat64() / rate * float64(time.Second))
+}
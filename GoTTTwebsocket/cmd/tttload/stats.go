@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// stats collects everything the simulated players observe.
+// all methods are safe to call from many player goroutines.
+type stats struct {
+	mu sync.Mutex
+
+	// moves and chats waiting to be seen by every expected recipient
+	pendingMoves map[moveKey]*pending
+	pendingChats map[string]*pending
+
+	latencies []time.Duration
+
+	connected     int
+	spectators    int
+	movesSent     int
+	chatsSent     int
+	gamesFinished int
+	droppedMoves  int
+	droppedChats  int
+	dialErrors    int
+	sendErrors    int
+	rejected      int
+	disconnects   int
+}
+
+// moveKey identifies a move by the room it was played in and where, a
+// square is only played once per game. With --room-prefix "" every player
+// shares the main room and only the two seated ones take part.
+type moveKey struct {
+	room     string
+	position int
+}
+
+// pending is a message we sent and are waiting to see broadcast back
+type pending struct {
+	sentAt    time.Time
+	remaining int // recipients that have not received it yet
+}
+
+func newStats() *stats {
+	return &stats{
+		pendingMoves: make(map[moveKey]*pending),
+		pendingChats: make(map[string]*pending),
+	}
+}
+
+func (s *stats) moveSent(room string, position, recipients int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.movesSent++
+	key := moveKey{room, position}
+	// the same square in an earlier game of the room that never came back
+	if p, ok := s.pendingMoves[key]; ok {
+		s.droppedMoves += p.remaining
+	}
+	s.pendingMoves[key] = &pending{sentAt: time.Now(), remaining: recipients}
+}
+
+// moveReceived records the broadcast latency for one recipient of a move
+func (s *stats) moveReceived(room string, position int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := moveKey{room, position}
+	p, ok := s.pendingMoves[key]
+	if !ok {
+		return
+	}
+	s.latencies = append(s.latencies, time.Since(p.sentAt))
+	p.remaining--
+	if p.remaining <= 0 {
+		delete(s.pendingMoves, key)
+	}
+}
+
+func (s *stats) chatSent(text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chatsSent++
+	// the sender gets its own chat back from the server, that is the echo we wait for
+	s.pendingChats[text] = &pending{sentAt: time.Now(), remaining: 1}
+}
+
+func (s *stats) chatReceived(text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pendingChats, text)
+}
+
+// sweep counts anything older than timeout as dropped
+func (s *stats) sweep(timeout time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, p := range s.pendingMoves {
+		if time.Since(p.sentAt) > timeout {
+			s.droppedMoves += p.remaining
+			delete(s.pendingMoves, key)
+		}
+	}
+	for key, p := range s.pendingChats {
+		if time.Since(p.sentAt) > timeout {
+			s.droppedChats += p.remaining
+			delete(s.pendingChats, key)
+		}
+	}
+}
+
+// count bumps one of the plain counters under the lock
+func (s *stats) count(counter *int) {
+	s.mu.Lock()
+	*counter++
+	s.mu.Unlock()
+}
+
+// Report is the summary of one run, saved as JSON so runs can be compared.
+type Report struct {
+	StartedAt time.Time `json:"startedAt"`
+	Config    config    `json:"config"`
+
+	Connected     int `json:"connected"`
+	Spectators    int `json:"spectators"`
+	MovesSent     int `json:"movesSent"`
+	ChatsSent     int `json:"chatsSent"`
+	GamesFinished int `json:"gamesFinished"`
+
+	MoveLatency Latency `json:"moveLatency"`
+
+	DroppedMoves int `json:"droppedMoves"`
+	DroppedChats int `json:"droppedChats"`
+	DialErrors   int `json:"dialErrors"`
+	SendErrors   int `json:"sendErrors"`
+	Rejected     int `json:"rejected"`
+	Disconnects  int `json:"disconnects"`
+}
+
+// Latency percentiles in milliseconds
+type Latency struct {
+	Samples int     `json:"samples"`
+	P50     float64 `json:"p50Ms"`
+	P95     float64 `json:"p95Ms"`
+	P99     float64 `json:"p99Ms"`
+	Max     float64 `json:"maxMs"`
+}
+
+func (s *stats) report(cfg config, startedAt time.Time) Report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sorted := append([]time.Duration(nil), s.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	return Report{
+		StartedAt:     startedAt,
+		Config:        cfg,
+		Connected:     s.connected,
+		Spectators:    s.spectators,
+		MovesSent:     s.movesSent,
+		ChatsSent:     s.chatsSent,
+		GamesFinished: s.gamesFinished,
+		MoveLatency: Latency{
+			Samples: len(sorted),
+			P50:     percentile(sorted, 50),
+			P95:     percentile(sorted, 95),
+			P99:     percentile(sorted, 99),
+			Max:     percentile(sorted, 100),
+		},
+		DroppedMoves: s.droppedMoves,
+		DroppedChats: s.droppedChats,
+		DialErrors:   s.dialErrors,
+		SendErrors:   s.sendErrors,
+		Rejected:     s.rejected,
+		Disconnects:  s.disconnects,
+	}
+}
+
+// percentile uses the nearest-rank method on an already sorted slice
+func percentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p/100*float64(len(sorted))+0.5) - 1
+	rank = max(0, min(rank, len(sorted)-1))
+	return float64(sorted[rank].Microseconds()) / 1000
+}
+
+// printTable writes the human readable summary
+func (r Report) printTable(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "players\t%d\n", r.Config.Players)
+	fmt.Fprintf(tw, "connected\t%d (%d spectating)\n", r.Connected, r.Spectators)
+	fmt.Fprintf(tw, "duration\t%s\n", r.Config.Duration)
+	fmt.Fprintf(tw, "moves sent\t%d\n", r.MovesSent)
+	fmt.Fprintf(tw, "chats sent\t%d\n", r.ChatsSent)
+	fmt.Fprintf(tw, "games finished\t%d\n", r.GamesFinished)
+	fmt.Fprintf(tw, "move latency p50\t%.2fms\n", r.MoveLatency.P50)
+	fmt.Fprintf(tw, "move latency p95\t%.2fms\n", r.MoveLatency.P95)
+	fmt.Fprintf(tw, "move latency p99\t%.2fms\n", r.MoveLatency.P99)
+	fmt.Fprintf(tw, "move latency max\t%.2fms (%d samples)\n", r.MoveLatency.Max, r.MoveLatency.Samples)
+	fmt.Fprintf(tw, "dropped moves\t%d\n", r.DroppedMoves)
+	fmt.Fprintf(tw, "dropped chats\t%d\n", r.DroppedChats)
+	fmt.Fprintf(tw, "dial errors\t%d\n", r.DialErrors)
+	fmt.Fprintf(tw, "send errors\t%d\n", r.SendErrors)
+	fmt.Fprintf(tw, "rejected by server\t%d\n", r.Rejected)
+	fmt.Fprintf(tw, "disconnects\t%d\n", r.Disconnects)
+	tw.Flush()
+}
+
+// save writes the report as indented JSON
+func (r Report) save(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
@@ -0,0 +1,92 @@
+// tttnotation - convert games between TTN (see the notation package) and JSON.
+// Every game is replayed through the engine first, so it also checks files.
+//
+//	go run ./cmd/tttnotation < games.ttn > games.json   // TTN to JSON
+//	go run ./cmd/tttnotation < games.json > games.ttn   // and back
+//	curl 'localhost:8080/games?format=ttn' | go run ./cmd/tttnotation
+//
+// The input format is detected, JSON is one game object or an array of them
+// like GET /games returns. -to forces the output format.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"goChatSocket/notation"
+)
+
+func main() {
+	to := flag.String("to", "", "json or ttn, the other format than the input's by default")
+	flag.Parse()
+
+	input, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Println("Read Error:", err)
+		os.Exit(1)
+	}
+
+	records, isJSON, err := parse(input)
+	if err != nil {
+		fmt.Println("Parse Error:", err)
+		os.Exit(1)
+	}
+	for i, rec := range records {
+		if _, err := rec.Replay(); err != nil {
+			fmt.Printf("Invalid game %d: %v\n", i+1, err)
+			os.Exit(1)
+		}
+	}
+
+	if *to == "" {
+		*to = "json"
+		if isJSON {
+			*to = "ttn"
+		}
+	}
+	switch *to {
+	case "ttn":
+		for i, rec := range records {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Print(notation.Format(rec))
+		}
+	case "json":
+		var out any = records
+		if len(records) == 1 {
+			out = records[0]
+		}
+		data, _ := json.MarshalIndent(out, "", "  ")
+		fmt.Println(string(data))
+	default:
+		fmt.Println("-to must be json or ttn")
+		os.Exit(2)
+	}
+}
+
+// parse reads TTN or JSON, whichever input is. A TTN tag starts with [ too,
+// but is followed by the tag name rather than { or ].
+func parse(input []byte) ([]*notation.Record, bool, error) {
+	trimmed := bytes.TrimSpace(input)
+	var afterBracket []byte
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		afterBracket = bytes.TrimSpace(trimmed[1:])
+	}
+	switch {
+	case len(afterBracket) > 0 && (afterBracket[0] == '{' || afterBracket[0] == ']'):
+		var records []*notation.Record
+		err := json.Unmarshal(trimmed, &records)
+		return records, true, err
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		var rec notation.Record
+		err := json.Unmarshal(trimmed, &rec)
+		return []*notation.Record{&rec}, true, err
+	}
+	records, err := notation.ParseAll(string(input))
+	return records, false, err
+}
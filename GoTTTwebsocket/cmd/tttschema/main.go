@@ -0,0 +1,35 @@
+// tttschema - print the JSON Schema for the websocket protocol.
+//
+//	go run ./cmd/tttschema                          // print to stdout
+//	go run ./cmd/tttschema -o protocol/schema.json  // what go generate ./protocol runs
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"goChatSocket/protocol"
+)
+
+func main() {
+	out := flag.String("o", "", "write the schema to this file instead of stdout")
+	flag.Parse()
+
+	data, err := json.MarshalIndent(protocol.Schema(), "", "  ")
+	if err != nil {
+		fmt.Println("Schema Error:", err)
+		os.Exit(1)
+	}
+	data = append(data, '\n')
+
+	if *out == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(*out, data, 0o644); err != nil {
+		fmt.Println("Write Error:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// fuzzRooms are the room options FuzzDispatch plays with, as a connection's query
+var fuzzRooms = []url.Values{
+	nil,
+	{"mode": {"qubic"}},
+	{"variant": {"misere"}},
+	{"variant": {"wild"}},
+	{"variant": {"orderChaos"}},
+	{"spectatorDelay": {"30s"}},
+}
+
+// dispatchCorpus are scripts for FuzzDispatch, one message per line. The
+// first byte picks the sender (mod 3): 0 is X, 1 is O and 2 the spectator.
+// With its top bit set the clock also moves on to the next timer afterwards.
+var dispatchCorpus = [][]string{
+	{ // X wins the top row and the rematch starts
+		`0{"v":1,"type":"move","payload":{"position":0}}`,
+		`1{"v":1,"type":"move","payload":{"position":3}}`,
+		`0{"v":1,"type":"move","payload":{"position":1}}`,
+		`1{"v":1,"type":"move","payload":{"position":4}}`,
+		`0{"v":1,"type":"move","payload":{"position":2}}`,
+		`0{"v":1,"type":"move","payload":{"position":4}}`,
+	},
+	{ // moves the room must refuse
+		`1{"v":1,"type":"move","payload":{"position":4}}`,
+		`2{"v":1,"type":"move","payload":{"position":4}}`,
+		`0{"v":1,"type":"move","payload":{"position":-1}}`,
+		`0{"v":1,"type":"move","payload":{"position":9}}`,
+		`0{"v":1,"type":"move","payload":{"position":64}}`,
+		`0{"v":1,"type":"move","payload":{"position":9223372036854775807}}`,
+		`0{"v":1,"type":"move","payload":{"position":99999999999999999999}}`,
+		`0{"v":1,"type":"move","payload":{"position":4,"symbol":"O"}}`,
+		`0{"v":1,"type":"move","payload":{"position":4,"symbol":"Z"}}`,
+		`0{"v":1,"type":"move","payload":{"position":4,"symbol":"0"}}`,
+		`0{"v":1,"type":"move","payload":{"cell":{"layer":0,"row":3,"col":3}}}`,
+		`0{"v":1,"type":"move","payload":{"position":1,"cell":{"layer":0,"row":0,"col":0}}}`,
+		`0{"v":1,"type":"move","payload":{"position":4}}`,
+		`1{"v":1,"type":"move","payload":{"position":4}}`,
+	},
+	{ // messages that aren't moves, or aren't anything
+		`0{"v":1,"type":"bogus","payload":{}}`,
+		`0{"v":2,"type":"move","payload":{"position":0}}`,
+		`0{"v":1,"type":"hello","payload":{"versions":[1]}}`,
+		`0{"v":1,"type":"gameOver","payload":{"winner":"X"}}`,
+		`0{"v":1,"type":"move"}`,
+		`0{"v":1,"type":"move","payload":{"position":4}}{"v":1}`,
+		`2{"v":1,"type":"resync","payload":{"fromSeq":18446744073709551615}}`,
+		`2{"v":1,"type":"resync","payload":{"fromSeq":0}}`,
+		`2{"v":1,"type":"chat","payload":{"text":"go left"}}`,
+		`1{"v":1,"type":"setName","payload":{"name":"spectator-1"}}`,
+		`1{"v":1,"type":"analyze","payload":{"board":["X","","","","","","","",""],"turn":"O"}}`,
+		`0not json`,
+	},
+}
+
+// FuzzDispatch sends whatever the fuzzer comes up with into a room with two
+// players and a spectator, through the simulation (see sim_test.go) so its
+// invariants hold after every message: the board stays one the rules can
+// reach, only the player to move moves and every client sees every event.
+func FuzzDispatch(f *testing.F) {
+	for mode := range fuzzRooms {
+		for _, script := range dispatchCorpus {
+			f.Add(uint8(mode), []byte(strings.Join(script, "\n")))
+		}
+	}
+	f.Fuzz(func(t *testing.T, mode uint8, script []byte) {
+		sim := newSimulation(t, 1)
+		for _, name := range []string{"x", "o", "spectator"} {
+			sim.add(name, "fuzz", fuzzRooms[int(mode)%len(fuzzRooms)], nil)
+		}
+		for _, sc := range sim.clients {
+			sim.do(sc.join())
+		}
+		for _, line := range bytes.Split(script, []byte("\n")) {
+			if len(line) == 0 {
+				continue
+			}
+			sim.step++
+			sim.do(&simAction{who: sim.clients[int(line[0])%len(sim.clients)], kind: "send", data: line[1:]})
+			if sim.clock.pending() && line[0]&0x80 != 0 {
+				sim.do(&simAction{kind: "tick"})
+			}
+		}
+	})
+}
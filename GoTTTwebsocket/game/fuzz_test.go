@@ -0,0 +1,172 @@
+package game
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fuzzRules are the boards and variants the fuzzers play on
+var fuzzRules = []Rules{
+	Classic,
+	Qubic,
+	{Size: 4, Line: 3},
+	{Size: 3, Line: 3, Variant: Misere},
+	{Size: 3, Line: 3, Variant: Wild},
+	OrderAndChaosRules,
+}
+
+// fuzzSymbols are what a fuzzed move gives as its player and symbol
+var fuzzSymbols = []string{X, O, "", "x"}
+
+// FuzzPlace plays moves read from the fuzzer's bytes, three per move:
+// the position (signed, times 2^40 when the player byte's top bit is set),
+// the player and the symbol placed. Check must agree with Place, refused moves
+// must leave the game as it was, accepted ones must leave a board the rules can reach.
+func FuzzPlace(f *testing.F) {
+	f.Add(uint8(0), []byte{0, 0, 0, 3, 1, 1, 1, 0, 0, 4, 1, 1, 2, 0, 0, 5, 1, 1}) // X wins the top row, then O tries to move
+	f.Add(uint8(0), []byte{0xff, 0, 0, 9, 0, 0, 1, 0x80, 0, 0xff, 0x80, 0})       // negative, past the end, huge both ways
+	f.Add(uint8(0), []byte{4, 1, 1, 4, 0, 1, 4, 0, 2, 4, 0, 3, 4, 2, 2})          // out of turn, wrong symbols, nobody
+	f.Add(uint8(0), []byte{4, 0, 0, 4, 1, 1})                                     // occupied
+	f.Add(uint8(1), []byte{0, 0, 0, 63, 1, 1, 21, 0, 0, 64, 1, 1})                // Qubic corners, one past the cube
+	f.Add(uint8(3), []byte{0, 0, 0, 3, 1, 1, 1, 0, 0, 4, 1, 1, 2, 0, 0})          // misère, X loses
+	f.Add(uint8(4), []byte{0, 0, 1, 1, 1, 1, 2, 0, 1, 4, 1, 0})                   // wild, O's line made by X
+	f.Add(uint8(5), []byte{0, 0, 1, 1, 1, 0, 2, 0, 2})                            // Order and Chaos
+	f.Fuzz(func(t *testing.T, variant uint8, moves []byte) {
+		g := New(fuzzRules[int(variant)%len(fuzzRules)])
+		for i := 0; i+2 < len(moves); i += 3 {
+			position := int(int8(moves[i]))
+			if moves[i+1]&0x80 != 0 {
+				position <<= 40
+			}
+			player, symbol := fuzzSymbols[moves[i+1]&0x7f%4], fuzzSymbols[moves[i+2]%4]
+
+			before := g.Clone()
+			checked := g.Check(position, player, symbol)
+			if !reflect.DeepEqual(g, before) {
+				t.Fatalf("checking move %d %s %s changed the game", position, player, symbol)
+			}
+			err := g.Place(position, player, symbol)
+			if err != checked {
+				t.Fatalf("move %d %s %s: checked %v, placed %v", position, player, symbol, checked, err)
+			}
+			if err != nil {
+				if !reflect.DeepEqual(g, before) {
+					t.Fatalf("refused move %d %s %s (%v) changed the game", position, player, symbol, err)
+				}
+				continue
+			}
+			checkMove(t, before, g, position, player, symbol)
+		}
+	})
+}
+
+// FuzzFromPosition sets up games from fuzzed boards, one cell per character:
+// X, O, '.' for empty and anything else as itself, which must be refused.
+// A position that is accepted must be consistent and playable.
+func FuzzFromPosition(f *testing.F) {
+	f.Add(uint8(0), "X...O....", X)
+	f.Add(uint8(0), "XX.OO....", O)
+	f.Add(uint8(0), "XXXOO....", O)
+	f.Add(uint8(0), "XXXOO....", X)
+	f.Add(uint8(0), "XXXOOO...", X)
+	f.Add(uint8(0), "XOXXOOOXX", O)
+	f.Add(uint8(0), "Z........", X)
+	f.Add(uint8(0), ".........", "")
+	f.Add(uint8(4), "OOO......", O)
+	f.Add(uint8(5), strings.Repeat("X", 36), X)
+	f.Fuzz(func(t *testing.T, variant uint8, board, turn string) {
+		rules := fuzzRules[int(variant)%len(fuzzRules)]
+		cells := make([]string, 0, len(board))
+		for _, r := range board {
+			cell := string(r)
+			if r == '.' {
+				cell = ""
+			}
+			cells = append(cells, cell)
+		}
+		g, err := FromPosition(rules, cells, turn)
+		if err != nil {
+			return
+		}
+		checkBoard(t, g)
+		if g.Over() != (len(g.LegalMoves()) == 0) {
+			t.Fatalf("%q: over %v with legal moves %v", board, g.Over(), g.LegalMoves())
+		}
+		for _, pos := range g.LegalMoves() {
+			next := g.Clone()
+			if err := next.Play(pos, g.Turn); err != nil {
+				t.Fatalf("%q: legal move %d refused: %v", board, pos, err)
+			}
+			checkMove(t, g, next, pos, g.Turn, g.Turn)
+		}
+	})
+}
+
+// checkMove checks the game after player put symbol at position
+func checkMove(t *testing.T, before, g *Game, position int, player, symbol string) {
+	t.Helper()
+	switch {
+	case before.Over():
+		t.Fatalf("move %d accepted after the game ended", position)
+	case player != before.Turn:
+		t.Fatalf("%s moved on %s's turn", player, before.Turn)
+	case symbol != player && !g.Rules.PickSymbol():
+		t.Fatalf("%s placed %q", player, symbol)
+	case before.Board[position] != "":
+		t.Fatalf("move onto %d, which held %s", position, before.Board[position])
+	}
+	for pos := range g.Board {
+		if pos != position && g.Board[pos] != before.Board[pos] {
+			t.Fatalf("move to %d changed cell %d", position, pos)
+		}
+	}
+	if g.Board[position] != symbol {
+		t.Fatalf("cell %d holds %q after placing %q", position, g.Board[position], symbol)
+	}
+	if len(g.Moves) != len(before.Moves)+1 || len(g.Symbols) != len(g.Moves) {
+		t.Fatalf("%d moves and %d symbols after %d moves", len(g.Moves), len(g.Symbols), len(before.Moves))
+	}
+	checkBoard(t, g)
+
+	// the position can be set up again, with the same result
+	again, err := FromPosition(g.Rules, g.Board, Other(player))
+	if err != nil {
+		t.Fatalf("board %q after %s's move is unreachable: %v", g.Board, player, err)
+	}
+	if again.Over() != g.Over() || again.Winner() != g.Winner() {
+		t.Fatalf("board %q: over %v winner %q, set up again over %v winner %q", g.Board, g.Over(), g.Winner(), again.Over(), again.Winner())
+	}
+	if g.Over() && g.Turn != player || !g.Over() && g.Turn != Other(player) {
+		t.Fatalf("turn %s after %s's move, over %v", g.Turn, player, g.Over())
+	}
+}
+
+// checkBoard checks that a board only holds symbols, in counts X moving first
+// allows, and that a game still going has no line and an empty cell
+func checkBoard(t *testing.T, g *Game) {
+	t.Helper()
+	if len(g.Board) != g.Rules.Cells() {
+		t.Fatalf("board of %d cells, the rules have %d", len(g.Board), g.Rules.Cells())
+	}
+	counts := map[string]int{}
+	for pos, cell := range g.Board {
+		if cell != "" && cell != X && cell != O {
+			t.Fatalf("cell %d holds %q", pos, cell)
+		}
+		counts[cell]++
+	}
+	if !g.Rules.PickSymbol() && counts[X] != counts[O] && counts[X] != counts[O]+1 {
+		t.Fatalf("board %q has %d X and %d O", g.Board, counts[X], counts[O])
+	}
+	if g.Over() {
+		return
+	}
+	if len(g.WinningLines(X)) > 0 || len(g.WinningLines(O)) > 0 {
+		t.Fatalf("board %q has a line and the game goes on", g.Board)
+	}
+	if !slices.Contains(g.Board, "") {
+		t.Fatalf("board %q is full and the game goes on", g.Board)
+	}
+}
@@ -0,0 +1,389 @@
+// Package game is the Tic-Tac-Toe engine: board, turn order, move validation
+// and win/draw detection. The websocket server and the bot arena both play
+// through it so the rules only live in one place.
+package game
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// player symbols
+const (
+	X = "X"
+	O = "O"
+)
+
+// Errors returned by Play when a move is rejected.
+var (
+	ErrOutOfBounds = errors.New("position is out of bounds")
+	ErrNotYourTurn = errors.New("not your turn")
+	ErrOccupied    = errors.New("cell already occupied")
+	ErrGameOver    = errors.New("game is over")
+	ErrSymbol      = errors.New("you can't place that symbol")
+)
+
+// Rule variants, see Rules.Variant.
+const (
+	Standard      = ""           // the player making a line of their symbol wins
+	Misere        = "misere"     // the player making a line of their symbol loses
+	Wild          = "wild"       // each move places X or O, whoever completes a line of either wins
+	OrderAndChaos = "orderChaos" // X (Order) wins with a line of either symbol, O (Chaos) by filling the board without one
+)
+
+// Rules describe the board and what counts as a win.
+// Size: cells per side of the square board.
+// Line: how many symbols in a row are needed to win.
+// Depth: layers of a cube board, 0 for a flat one. Cells are numbered layer
+// by layer, row by row: layer*Size*Size + row*Size + col.
+// Variant: who a line is good for and which symbols a move may place, Standard
+// or one of the other variant constants.
+type Rules struct {
+	Size    int    `json:"size"`
+	Line    int    `json:"line"`
+	Depth   int    `json:"depth,omitempty"`
+	Variant string `json:"variant,omitempty"`
+}
+
+// Classic is the 3x3, three in a row game.
+var Classic = Rules{Size: 3, Line: 3}
+
+// Qubic is the 4x4x4 cube, four in a row in any direction.
+var Qubic = Rules{Size: 4, Line: 4, Depth: 4}
+
+// OrderAndChaosRules is Order and Chaos on its usual 6x6 board, five in a row.
+var OrderAndChaosRules = Rules{Size: 6, Line: 5, Variant: OrderAndChaos}
+
+// Layers is the number of layers, 1 for a flat board.
+func (r Rules) Layers() int {
+	return max(r.Depth, 1)
+}
+
+// Cells is the number of cells on the board.
+func (r Rules) Cells() int {
+	return r.Size * r.Size * r.Layers()
+}
+
+// The biggest boards there are: squares are named a-z across (see notation),
+// and every line through the cells is worked out when a game starts.
+const (
+	MaxSize  = 26
+	MaxCells = 1024
+)
+
+// Validate checks that the rules describe a playable board.
+func (r Rules) Validate() error {
+	if r.Size < 1 || r.Line < 1 || r.Line > r.Size || r.Depth < 0 {
+		return fmt.Errorf("invalid rules: %s board with %d in a row", r.board(), r.Line)
+	}
+	if r.Size > MaxSize || r.Depth > MaxCells || r.Cells() > MaxCells {
+		return fmt.Errorf("invalid rules: %s board is too big, boards go up to %d a side and %d cells", r.board(), MaxSize, MaxCells)
+	}
+	switch r.Variant {
+	case Standard, Misere, Wild, OrderAndChaos:
+	default:
+		return fmt.Errorf("invalid rules: unknown variant %q", r.Variant)
+	}
+	return nil
+}
+
+// PickSymbol reports whether players choose X or O for each move, rather than
+// always placing their own symbol.
+func (r Rules) PickSymbol() bool {
+	return r.Variant == Wild || r.Variant == OrderAndChaos
+}
+
+// Description explains how the rules are won, for players new to a variant.
+func (r Rules) Description() string {
+	switch r.Variant {
+	case Misere:
+		return fmt.Sprintf("Misère: whoever makes %d in a row of their symbol loses.", r.Line)
+	case Wild:
+		return fmt.Sprintf("Wild: place X or O on your turn, whoever completes %d in a row of either wins.", r.Line)
+	case OrderAndChaos:
+		return fmt.Sprintf("Order and Chaos: both place X or O. X plays Order and wins with %d in a row of either symbol, O plays Chaos and wins by filling the board without one.", r.Line)
+	}
+	return fmt.Sprintf("%d in a row of your symbol wins.", r.Line)
+}
+
+// board describes the board's shape, e.g. 3x3 or 4x4x4
+func (r Rules) board() string {
+	if r.Depth > 0 {
+		return fmt.Sprintf("%dx%dx%d", r.Size, r.Size, r.Depth)
+	}
+	return fmt.Sprintf("%dx%d", r.Size, r.Size)
+}
+
+// Cell numbers the cell at layer, row and col, or returns ErrOutOfBounds.
+func (r Rules) Cell(layer, row, col int) (int, error) {
+	if layer < 0 || layer >= r.Layers() || row < 0 || row >= r.Size || col < 0 || col >= r.Size {
+		return 0, ErrOutOfBounds
+	}
+	return (layer*r.Size+row)*r.Size + col, nil
+}
+
+// Coords is the reverse of Cell.
+func (r Rules) Coords(pos int) (layer, row, col int) {
+	return pos / (r.Size * r.Size), pos / r.Size % r.Size, pos % r.Size
+}
+
+// Lines generates every winning line for the rules from the board geometry:
+// each run of Line cells in one of the 13 directions through a cube (4 on a
+// flat board). A direction and its opposite give the same lines, so only the
+// half whose first non-zero step is positive is used.
+func (r Rules) Lines() [][]int {
+	var directions [][3]int // (layer, row, col) steps
+	for dl := -1; dl <= 1; dl++ {
+		for dr := -1; dr <= 1; dr++ {
+			for dc := -1; dc <= 1; dc++ {
+				d := [3]int{dl, dr, dc}
+				if first := firstNonZero(d); first <= 0 || (dl != 0 && r.Layers() == 1) {
+					continue
+				}
+				directions = append(directions, d)
+			}
+		}
+	}
+
+	var lines [][]int
+	for _, d := range directions {
+		for pos := 0; pos < r.Cells(); pos++ {
+			layer, row, col := r.Coords(pos)
+			// the line must end on the board
+			if _, err := r.Cell(layer+d[0]*(r.Line-1), row+d[1]*(r.Line-1), col+d[2]*(r.Line-1)); err != nil {
+				continue
+			}
+			line := make([]int, r.Line)
+			for i := range line {
+				line[i], _ = r.Cell(layer+d[0]*i, row+d[1]*i, col+d[2]*i)
+			}
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// firstNonZero returns the first non-zero step of a direction, 0 for none
+func firstNonZero(d [3]int) int {
+	for _, step := range d {
+		if step != 0 {
+			return step
+		}
+	}
+	return 0
+}
+
+// Game is the state of one game.
+// Board: one entry per cell, empty (""), "X" or "O".
+// Turn: the player who moves next, X or O. After the game it is whoever moved last.
+// Moves: positions played so far, in order.
+// Symbols: the symbol each move placed, the mover's own unless the rules let them pick.
+type Game struct {
+	Rules   Rules
+	Board   []string
+	Turn    string
+	Moves   []int
+	Symbols []string
+
+	lines  [][]int
+	winner string
+	over   bool
+}
+
+// New starts an empty game, X moves first.
+func New(rules Rules) *Game {
+	return &Game{
+		Rules: rules,
+		Board: make([]string, rules.Cells()),
+		Turn:  X,
+		lines: rules.Lines(),
+	}
+}
+
+// FromPosition sets up a game from a board in the middle of play, e.g. one
+// sent for analysis. turn must be the side whose move it is given the counts
+// of X and O (or of filled cells when players pick their symbol), and the
+// position must be reachable. Moves stays empty, the order the cells were
+// filled in is unknown.
+func FromPosition(rules Rules, board []string, turn string) (*Game, error) {
+	if err := rules.Validate(); err != nil {
+		return nil, err
+	}
+	if len(board) != rules.Cells() {
+		return nil, fmt.Errorf("board has %d cells, want %d", len(board), rules.Cells())
+	}
+	counts := map[string]int{}
+	for pos, cell := range board {
+		if cell != "" && cell != X && cell != O {
+			return nil, fmt.Errorf("cell %d holds %q, want \"\", X or O", pos, cell)
+		}
+		counts[cell]++
+	}
+	if turn != X && turn != O {
+		return nil, fmt.Errorf("turn must be X or O")
+	}
+	// X moves first, so X has as many symbols as O or one more
+	expected := X
+	switch {
+	case rules.PickSymbol():
+		if (counts[X]+counts[O])%2 == 1 {
+			expected = O
+		}
+	case counts[X] == counts[O]+1:
+		expected = O
+	case counts[X] != counts[O]:
+		return nil, fmt.Errorf("board has %d X and %d O, X moves first", counts[X], counts[O])
+	}
+	if turn != expected {
+		return nil, fmt.Errorf("it is %s's turn on this board", expected)
+	}
+
+	g := New(rules)
+	g.Board, g.Turn = append([]string(nil), board...), turn
+	xLine, oLine := len(g.WinningLines(X)) > 0, len(g.WinningLines(O)) > 0
+	last := Other(turn)
+	switch {
+	case xLine && oLine:
+		return nil, errors.New("both symbols have a line")
+	case xLine || oLine:
+		// the line was made by the last move, so the game ended on that player's turn
+		symbol := X
+		if oLine {
+			symbol = O
+		}
+		if !rules.PickSymbol() && symbol != last {
+			return nil, fmt.Errorf("%s has a line but it is their turn", symbol)
+		}
+		g.winner, g.over = g.outcome(last, symbol)
+		g.Turn = last
+	case counts[""] == 0:
+		g.winner, g.over = g.outcome(last, "")
+		g.Turn = last
+	}
+	return g, nil
+}
+
+// Play places the player's own symbol at position, or returns why the move
+// is illegal. It is Place(position, symbol, symbol).
+func (g *Game) Play(position int, symbol string) error {
+	return g.Place(position, symbol, symbol)
+}
+
+// Place is player's move putting symbol at position. symbol must be the
+// player's own unless the rules let them pick (see Rules.PickSymbol).
+func (g *Game) Place(position int, player, symbol string) error {
+	if err := g.Check(position, player, symbol); err != nil {
+		return err
+	}
+
+	g.Board[position] = symbol
+	g.Moves = append(g.Moves, position)
+	g.Symbols = append(g.Symbols, symbol)
+
+	g.winner, g.over = g.outcome(player, symbol)
+	if !g.over {
+		g.Turn = Other(player)
+	}
+	return nil
+}
+
+// Check returns why Place(position, player, symbol) would be refused, nil
+// if it would be played. It doesn't change the game.
+func (g *Game) Check(position int, player, symbol string) error {
+	if g.over {
+		return ErrGameOver
+	}
+	if position < 0 || position >= len(g.Board) {
+		return ErrOutOfBounds
+	}
+	if player != g.Turn {
+		return ErrNotYourTurn
+	}
+	if symbol != player && !(g.Rules.PickSymbol() && (symbol == X || symbol == O)) {
+		return ErrSymbol
+	}
+	if g.Board[position] != "" {
+		return ErrOccupied
+	}
+	return nil
+}
+
+// outcome decides the game after player placed symbol: the winner ("" for a
+// draw) and whether it is over. Only the symbol just placed can have made a line.
+func (g *Game) outcome(player, symbol string) (string, bool) {
+	line := symbol != "" && len(g.WinningLines(symbol)) > 0
+	full := !slices.Contains(g.Board, "")
+	switch {
+	case g.Rules.Variant == OrderAndChaos && line:
+		return X, true
+	case g.Rules.Variant == OrderAndChaos && full:
+		return O, true
+	case g.Rules.Variant == Misere && line:
+		return Other(player), true
+	case line:
+		return player, true
+	}
+	return "", full // a full board is a stalemate
+}
+
+// WinningLines returns every line completely filled with symbol.
+func (g *Game) WinningLines(symbol string) [][]int {
+	var winning [][]int
+	for _, line := range g.lines {
+		if g.lineOwnedBy(line, symbol) {
+			winning = append(winning, line)
+		}
+	}
+	return winning
+}
+
+func (g *Game) lineOwnedBy(line []int, symbol string) bool {
+	for _, pos := range line {
+		if g.Board[pos] != symbol {
+			return false
+		}
+	}
+	return true
+}
+
+// Over reports whether the game has finished, by a win or a draw.
+func (g *Game) Over() bool {
+	return g.over
+}
+
+// Winner returns the winning symbol, empty while playing or on a draw.
+func (g *Game) Winner() string {
+	return g.winner
+}
+
+// LegalMoves lists the empty cells, or nothing once the game is over.
+func (g *Game) LegalMoves() []int {
+	if g.over {
+		return nil
+	}
+	var moves []int
+	for pos, cell := range g.Board {
+		if cell == "" {
+			moves = append(moves, pos)
+		}
+	}
+	return moves
+}
+
+// Clone returns an independent copy, handy for searching ahead.
+func (g *Game) Clone() *Game {
+	c := *g
+	c.Board = append([]string(nil), g.Board...)
+	c.Moves = append([]int(nil), g.Moves...)
+	c.Symbols = append([]string(nil), g.Symbols...)
+	return &c
+}
+
+// Other returns the opponent's symbol.
+func Other(symbol string) string {
+	if symbol == X {
+		return O
+	}
+	return X
+}
@@ -0,0 +1,106 @@
+package game
+
+import "testing"
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		rules Rules
+		lines int
+	}{
+		{Classic, 8},
+		{Rules{Size: 4, Line: 3}, 24},
+		{Rules{Size: 5, Line: 4}, 28},
+		{Qubic, 76},
+		{Rules{Size: 3, Line: 3, Depth: 3}, 49},
+	}
+	for _, tt := range tests {
+		lines := tt.rules.Lines()
+		if len(lines) != tt.lines {
+			t.Errorf("%+v: %d lines, want %d", tt.rules, len(lines), tt.lines)
+		}
+		seen := map[[2]int]bool{}
+		for _, line := range lines {
+			ends := [2]int{line[0], line[len(line)-1]}
+			if seen[ends] {
+				t.Errorf("%+v: line %v twice", tt.rules, line)
+			}
+			seen[ends] = true
+		}
+	}
+}
+
+func TestQubicDiagonal(t *testing.T) {
+	g := New(Qubic)
+	// the space diagonal from the first corner to the last, O plays along the first row
+	for i := 0; i < 4; i++ {
+		x, _ := Qubic.Cell(i, i, i)
+		if err := g.Play(x, X); err != nil {
+			t.Fatal(err)
+		}
+		if i < 3 {
+			o, _ := Qubic.Cell(3, 0, i)
+			if err := g.Play(o, O); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if g.Winner() != X {
+		t.Fatalf("winner %q", g.Winner())
+	}
+	if layer, row, col := Qubic.Coords(63); layer != 3 || row != 3 || col != 3 {
+		t.Fatalf("cell 63 is at %d %d %d", layer, row, col)
+	}
+}
+
+func TestVariants(t *testing.T) {
+	type move struct {
+		pos            int
+		player, symbol string
+	}
+	tests := map[string]struct {
+		rules  Rules
+		moves  []move
+		winner string
+		over   bool
+	}{
+		"misere line loses": {
+			Rules{Size: 3, Line: 3, Variant: Misere},
+			[]move{{0, X, X}, {3, O, O}, {1, X, X}, {4, O, O}, {8, X, X}, {5, O, O}},
+			X, true,
+		},
+		"wild line of the other symbol wins": {
+			Rules{Size: 3, Line: 3, Variant: Wild},
+			[]move{{0, X, O}, {4, O, X}, {1, X, O}, {8, O, X}, {2, X, O}},
+			X, true,
+		},
+		"order wins with chaos' symbol": {
+			Rules{Size: 3, Line: 3, Variant: OrderAndChaos},
+			[]move{{0, X, O}, {4, O, O}, {8, X, O}},
+			X, true,
+		},
+		"chaos wins on a full board": {
+			Rules{Size: 3, Line: 3, Variant: OrderAndChaos},
+			[]move{{0, X, X}, {1, O, O}, {2, X, X}, {3, O, X}, {4, X, O}, {5, O, O}, {6, X, O}, {7, O, X}, {8, X, X}},
+			O, true,
+		},
+	}
+	for name, tt := range tests {
+		g := New(tt.rules)
+		for _, m := range tt.moves {
+			if err := g.Place(m.pos, m.player, m.symbol); err != nil {
+				t.Fatalf("%s: move %+v: %v", name, m, err)
+			}
+		}
+		if g.Over() != tt.over || g.Winner() != tt.winner {
+			t.Errorf("%s: over %v winner %q, want %v %q", name, g.Over(), g.Winner(), tt.over, tt.winner)
+		}
+		replayed, err := FromPosition(tt.rules, g.Board, Other(g.Turn))
+		if err != nil || replayed.Winner() != tt.winner {
+			t.Errorf("%s: from position winner %v, %v", name, replayed, err)
+		}
+	}
+
+	if err := New(Classic).Place(0, X, O); err != ErrSymbol {
+		t.Fatalf("placing the other symbol in the standard game: %v", err)
+	}
+}
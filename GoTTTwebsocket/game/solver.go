@@ -0,0 +1,284 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Outcomes of a position for the side to move, with perfect play from both sides.
+const (
+	Win  = "win"
+	Draw = "draw"
+	Loss = "loss"
+)
+
+// ErrTooBig is returned by the solver when a position has too many continuations
+// to search, e.g. an empty board much bigger than 3x3.
+var ErrTooBig = errors.New("position is too big to solve")
+
+// ErrVariant is returned by the solver for rules other than Standard.
+var ErrVariant = errors.New("the solver only knows the standard rules")
+
+// maxSolvedPositions caps the positions one analysis may look at
+const maxSolvedPositions = 1 << 20
+
+// MoveValue is what one move is worth to the side playing it.
+// Outcome: Win, Draw or Loss with perfect play afterwards.
+// Plies: for a win or loss, how many moves until the game ends counting this one
+// (distance to mate), the winner hurries and the loser holds on. 0 for a draw.
+type MoveValue struct {
+	Position int    `json:"position"`
+	Outcome  string `json:"outcome"`
+	Plies    int    `json:"plies,omitempty"`
+}
+
+// Analysis is the solved value of a position.
+// Outcome, Plies: the value of the position for the side to move, the same as Best's.
+// Best: the strongest move, the quickest win or the longest loss, lowest position on ties.
+// Moves: every legal move in board order.
+type Analysis struct {
+	Turn    string      `json:"turn"`
+	Outcome string      `json:"outcome"`
+	Plies   int         `json:"plies,omitempty"`
+	Best    int         `json:"best"`
+	Moves   []MoveValue `json:"moves"`
+}
+
+// Analyze solves the game from its current position.
+func Analyze(g *Game) (*Analysis, error) {
+	if g.Over() {
+		return nil, ErrGameOver
+	}
+	if g.Rules.Variant != Standard {
+		return nil, ErrVariant
+	}
+	s := newSolver(g)
+	analysis := &Analysis{Turn: g.Turn}
+	bestScore := 0
+	for _, pos := range g.LegalMoves() {
+		score, err := s.play(pos, g.Turn)
+		if err != nil {
+			return nil, err
+		}
+		value := s.value(pos, score)
+		analysis.Moves = append(analysis.Moves, value)
+		if len(analysis.Moves) == 1 || score > bestScore {
+			bestScore = score
+			analysis.Best, analysis.Outcome, analysis.Plies = pos, value.Outcome, value.Plies
+		}
+	}
+	return analysis, nil
+}
+
+// solver searches every continuation, remembering positions it already solved.
+// Scores are from the point of view of whoever just moved: a win that ends the
+// game p moves from now is worth top-p, a loss -(top-p), a draw 0, so quicker
+// wins and slower losses score higher.
+type solver struct {
+	board   []string
+	through [][][]int // cell -> the lines crossing it
+	empty   int
+	top     int
+	memo    map[string]int
+	wins    map[string]bool // canWin results by board, attacker and moves
+}
+
+func newSolver(g *Game) *solver {
+	s := &solver{
+		board:   append([]string(nil), g.Board...),
+		through: make([][][]int, len(g.Board)),
+		top:     len(g.Board) + 1,
+		memo:    make(map[string]int),
+		wins:    make(map[string]bool),
+	}
+	for _, line := range g.lines {
+		for _, pos := range line {
+			s.through[pos] = append(s.through[pos], line)
+		}
+	}
+	for _, cell := range g.Board {
+		if cell == "" {
+			s.empty++
+		}
+	}
+	return s
+}
+
+// value turns the score of the move at pos into a MoveValue
+func (s *solver) value(pos, score int) MoveValue {
+	switch {
+	case score > 0:
+		return MoveValue{Position: pos, Outcome: Win, Plies: s.top - score}
+	case score < 0:
+		return MoveValue{Position: pos, Outcome: Loss, Plies: s.top + score}
+	}
+	return MoveValue{Position: pos, Outcome: Draw}
+}
+
+// play scores symbol moving at pos, then takes the move back
+func (s *solver) play(pos int, symbol string) (int, error) {
+	s.board[pos] = symbol
+	s.empty--
+	defer func() {
+		s.board[pos] = ""
+		s.empty++
+	}()
+
+	if s.completesLine(pos, symbol) {
+		return s.top - 1, nil
+	}
+	if s.empty == 0 {
+		return 0, nil
+	}
+
+	key := strings.Join(s.board, ",")
+	if score, ok := s.memo[key]; ok {
+		return score, nil
+	}
+	if len(s.memo) >= maxSolvedPositions {
+		return 0, ErrTooBig
+	}
+
+	// the opponent picks their best reply, one move further from the end for us
+	reply := Other(symbol)
+	best := 0
+	first := true
+	for next, cell := range s.board {
+		if cell != "" {
+			continue
+		}
+		theirs, err := s.play(next, reply)
+		if err != nil {
+			return 0, err
+		}
+		if first || theirs > best {
+			best, first = theirs, false
+		}
+	}
+	score := 0
+	switch {
+	case best > 0:
+		score = -(best - 1)
+	case best < 0:
+		score = -best - 1
+	}
+	s.memo[key] = score
+	return score, nil
+}
+
+// completesLine reports whether symbol at pos finished a line
+func (s *solver) completesLine(pos int, symbol string) bool {
+	for _, line := range s.through[pos] {
+		full := true
+		for _, cell := range line {
+			if s.board[cell] != symbol {
+				full = false
+				break
+			}
+		}
+		if full {
+			return true
+		}
+	}
+	return false
+}
+
+// MovesToWin returns the fewest of its own moves the side to move needs to
+// force a win, counting the winning move, or 0 if it can't within max.
+// It is much cheaper than Analyze for small max, e.g. for puzzles.
+func MovesToWin(g *Game, max int) (int, error) {
+	if g.Over() {
+		return 0, ErrGameOver
+	}
+	if g.Rules.Variant != Standard {
+		return 0, ErrVariant
+	}
+	s := newSolver(g)
+	for n := 1; n <= max; n++ {
+		won, err := s.canWin(g.Turn, n)
+		if err != nil || won {
+			return n, err
+		}
+	}
+	return 0, nil
+}
+
+// Refutes reports whether every reply to the position loses within n of the
+// opponent's moves, i.e. the side that just moved still forces a win in n.
+// On a finished game it reports whether the side that moved won.
+func Refutes(g *Game, n int) (bool, error) {
+	if g.Over() {
+		return g.Winner() != "", nil
+	}
+	if g.Rules.Variant != Standard {
+		return false, ErrVariant
+	}
+	return newSolver(g).allRepliesLose(g.Turn, n)
+}
+
+// canWin reports whether attacker, to move, wins within n of its own moves
+func (s *solver) canWin(attacker string, n int) (bool, error) {
+	if n <= 0 || s.empty == 0 {
+		return false, nil
+	}
+	key := fmt.Sprintf("%s|%s|%d", strings.Join(s.board, ","), attacker, n)
+	if won, ok := s.wins[key]; ok {
+		return won, nil
+	}
+	if len(s.wins) >= maxSolvedPositions {
+		return false, ErrTooBig
+	}
+
+	won := false
+	for pos, cell := range s.board {
+		if cell != "" {
+			continue
+		}
+		s.board[pos] = attacker
+		s.empty--
+		var err error
+		switch {
+		case s.completesLine(pos, attacker):
+			won = true
+		case n > 1 && s.empty > 0:
+			won, err = s.allRepliesLose(Other(attacker), n-1)
+		}
+		s.board[pos] = ""
+		s.empty++
+		if err != nil {
+			return false, err
+		}
+		if won {
+			break
+		}
+	}
+	s.wins[key] = won
+	return won, nil
+}
+
+// allRepliesLose reports whether every move of defender still lets the other
+// side win within n of its moves
+func (s *solver) allRepliesLose(defender string, n int) (bool, error) {
+	if s.empty == 0 {
+		return false, nil
+	}
+	for pos, cell := range s.board {
+		if cell != "" {
+			continue
+		}
+		s.board[pos] = defender
+		s.empty--
+		var lost bool
+		var err error
+		if !s.completesLine(pos, defender) && s.empty > 0 {
+			lost, err = s.canWin(Other(defender), n)
+		}
+		s.board[pos] = ""
+		s.empty++
+		if err != nil || !lost {
+			return false, err
+		}
+	}
+	return true, nil
+}
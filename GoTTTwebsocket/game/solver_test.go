@@ -0,0 +1,52 @@
+package game
+
+import "testing"
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		name    string
+		board   []string
+		turn    string
+		outcome string
+		plies   int
+		best    int
+	}{
+		{"empty board is a draw", []string{"", "", "", "", "", "", "", "", ""}, X, Draw, 0, 0},
+		{"win at once", []string{X, X, "", O, O, "", "", "", ""}, X, Win, 1, 2},
+		{"block then lose to a fork", []string{X, X, "", O, "", "", "", "", ""}, O, Loss, 4, 2},
+		{"edge holds the corners", []string{X, "", "", "", O, "", "", "", X}, O, Draw, 0, 1},
+		{"lost either way", []string{X, "", X, "", O, "", X, "", O}, O, Loss, 2, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := FromPosition(Classic, tt.board, tt.turn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a, err := Analyze(g)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a.Outcome != tt.outcome || a.Plies != tt.plies || a.Best != tt.best {
+				t.Fatalf("got %s in %d with %d, want %s in %d with %d", a.Outcome, a.Plies, a.Best, tt.outcome, tt.plies, tt.best)
+			}
+			if len(a.Moves) != len(g.LegalMoves()) {
+				t.Fatalf("%d move values for %d legal moves", len(a.Moves), len(g.LegalMoves()))
+			}
+		})
+	}
+}
+
+func TestFromPositionRejects(t *testing.T) {
+	boards := map[string][]string{
+		"too many X":  {X, X, "", "", "", "", "", "", ""},
+		"two winners": {X, X, X, O, O, O, "", "", ""},
+		"wrong cells": {"Z", "", "", "", "", "", "", "", ""},
+		"short board": {"", ""},
+	}
+	for name, board := range boards {
+		if _, err := FromPosition(Classic, board, O); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
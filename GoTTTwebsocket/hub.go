@@ -0,0 +1,114 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// the room clients join when they don't ask for one
+const defaultRoom = "main"
+
+// Hub keeps track of every room on the server.
+// archive keeps the games they finish (see archive.go), profiles the
+// players' saved stats (see profiles.go) and ratings the signed in players'
+// Elo ratings (see ratings.go). escrow holds the stakes in rooms played for
+// them, nil when there is no ledger (see wagers.go). clock runs the rooms'
+// timers (see clock.go).
+type Hub struct {
+	mu       sync.Mutex
+	rooms    map[string]*Room
+	archive  *gameArchive
+	profiles *profileBook
+	ratings  *ratingBook
+	escrow   *escrow
+	clock    clock
+}
+
+func newHub() *Hub {
+	return &Hub{rooms: make(map[string]*Room), archive: newGameArchive(), profiles: newProfileBook(), ratings: newRatingBook(), clock: realClock{}}
+}
+
+// join puts a client in the room with id, creating an ordinary room with opts if it doesn't exist yet.
+// the lookup and join happen under the hub lock so the room can't be removed in between.
+func (h *Hub) join(id string, c *Client, opts roomOptions) *Room {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r, ok := h.rooms[id]
+	if !ok {
+		r = newRoom(h, id, opts)
+		h.rooms[id] = r
+	}
+	r.join(c)
+	return r
+}
+
+// createRoom sets up a room with specific options. If people are already
+// waiting in a room with that id it is reconfigured and they are reseated.
+func (h *Hub) createRoom(id string, opts roomOptions) *Room {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r, ok := h.rooms[id]
+	if !ok {
+		r = newRoom(h, id, opts)
+		h.rooms[id] = r
+		return r
+	}
+	r.configure(opts)
+	return r
+}
+
+// restore sets up a room taken over from another node (see cluster.go), from
+// the state its owner last published. state is nil for a room that had none yet.
+func (h *Hub) restore(id string, opts roomOptions, state *roomState) *Room {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r, ok := h.rooms[id]
+	if !ok {
+		r = newRoom(h, id, opts)
+		if state != nil {
+			r.restore(*state)
+		}
+		h.rooms[id] = r
+	}
+	return r
+}
+
+// get returns the room with id, or nil
+func (h *Hub) get(id string) *Room {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.rooms[id]
+}
+
+// removeIfEmpty drops a room once the last client has left, unless it is being kept
+func (h *Hub) removeIfEmpty(r *Room) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.rooms[r.ID] == r && r.empty() {
+		delete(h.rooms, r.ID)
+	}
+}
+
+// list returns the open rooms in name order
+func (h *Hub) list() []*Room {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	rooms := make([]*Room, 0, len(h.rooms))
+	for _, r := range h.rooms {
+		rooms = append(rooms, r)
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].ID < rooms[j].ID })
+	return rooms
+}
+
+// roomIDs lists the open rooms in name order
+func (h *Hub) roomIDs() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ids := make([]string, 0, len(h.rooms))
+	for id := range h.rooms {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// how long the ledger has to make one transfer
+const ledgerTimeout = 5 * time.Second
+
+// Errors the ledger answers a transfer with.
+var (
+	errInsufficientFunds = errors.New("insufficient funds")
+	errUnknownAccount    = errors.New("unknown account")
+)
+
+// transfer moves amount into an account's balance, out of it when negative.
+// Key: names the transfer, the ledger applies each key once however often it is sent.
+type transfer struct {
+	Key     string `json:"key"`
+	Account int    `json:"account"`
+	Amount  int64  `json:"amount"`
+}
+
+// ledger keeps the accounts' balances (UsersGo's Account.Balance)
+type ledger interface {
+	apply(ctx context.Context, t transfer) error
+}
+
+// httpLedger makes transfers through a UsersGo compatible endpoint: POST url
+// with the transfer as JSON answers 200 once it is applied (or was applied
+// before under the same key), 402 when the balance is too low and 404 for an
+// unknown account.
+type httpLedger struct {
+	url  string
+	http *http.Client
+}
+
+func newHTTPLedger(url string) *httpLedger {
+	return &httpLedger{url: url, http: &http.Client{Timeout: ledgerTimeout}}
+}
+
+func (l *httpLedger) apply(ctx context.Context, t transfer) (err error) {
+	ctx, span := startStorageSpan(ctx, "ledger.transfer", attribute.String("ttt.transfer", t.Key),
+		attribute.Int("ttt.account", t.Account), attribute.Int64("ttt.amount", t.Amount))
+	defer endSpan(span, &err)
+	body, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", t.Key)
+	injectHeaders(ctx, req)
+	resp, err := l.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("ledger: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusPaymentRequired:
+		return errInsufficientFunds
+	case http.StatusNotFound:
+		return errUnknownAccount
+	}
+	return fmt.Errorf("ledger: %s", resp.Status)
+}
@@ -0,0 +1,377 @@
+// Package notation reads and writes games in TTN, a text notation for
+// Tic-Tac-Toe and its bigger boards modelled on chess PGN: tag pairs for the
+// headers, then the numbered move list ending with the result.
+//
+//	[Event "main"]
+//	[Date "2026.10.19"]
+//	[X "User-X"]
+//	[O "User-O"]
+//	[Rules "3x3/3"]
+//	[Result "1-0"]
+//
+//	1. b2 a1 2. a3 c1 3. b1 c3 4. b3 1-0
+//
+// Squares are a column letter and a row number counted from the top left,
+// so a1 is cell 0 and b2 the centre of a 3x3 board. Rules is the board size
+// and how many in a row win. On a 3D board, e.g. Rules "4x4x4/4" for Qubic,
+// a square starts with its layer counted from 1 at the top: 1a1 is cell 0.
+// A rule variant follows the board, e.g. "3x3/3 wild", and where players pick
+// their symbol each move says which one it placed: b2=O.
+// Several games can follow each other in one text,
+// each one ends with its result.
+package notation
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"goChatSocket/game"
+)
+
+// Results, the same as PGN.
+const (
+	XWins      = "1-0"
+	OWins      = "0-1"
+	Draw       = "1/2-1/2"
+	Unfinished = "*"
+)
+
+// ResultFor returns the result for a winning symbol, a draw when winner is empty.
+func ResultFor(winner string) string {
+	switch winner {
+	case game.X:
+		return XWins
+	case game.O:
+		return OWins
+	}
+	return Draw
+}
+
+// Record is one game.
+// Event: what the game was played for, e.g. the room or tournament match.
+// Date: when it was played, YYYY.MM.DD.
+// X, O: the players' names.
+// Termination: why a game ended early, e.g. forfeit. Empty when the board decided it.
+// Moves: cells in the order they were played.
+// Symbols: the symbol each move placed, "" for the mover's own. Only used when
+// the rules let players pick (see game.Rules.PickSymbol), nil if they never did.
+// Tags: any other headers, kept as they are.
+type Record struct {
+	Event       string            `json:"event,omitempty"`
+	Date        string            `json:"date,omitempty"`
+	X           string            `json:"x"`
+	O           string            `json:"o"`
+	Rules       game.Rules        `json:"rules"`
+	Result      string            `json:"result"`
+	Termination string            `json:"termination,omitempty"`
+	Moves       []int             `json:"moves"`
+	Symbols     []string          `json:"symbols,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+// Replay plays the moves through the engine and checks the result agrees with
+// the board. A result the board didn't decide (a forfeit, an admin ending the
+// game) is accepted as long as the game was still going.
+func (rec *Record) Replay() (*game.Game, error) {
+	if err := rec.Rules.Validate(); err != nil {
+		return nil, err
+	}
+	g := game.New(rec.Rules)
+	for i, pos := range rec.Moves {
+		if err := g.Place(pos, g.Turn, rec.symbol(i, g.Turn)); err != nil {
+			return nil, fmt.Errorf("move %d (%s): %w", i+1, Square(rec.Rules, pos), err)
+		}
+	}
+
+	if !isResult(rec.Result) {
+		return nil, fmt.Errorf("unknown result %q", rec.Result)
+	}
+	if g.Over() && rec.Result != ResultFor(g.Winner()) {
+		return nil, fmt.Errorf("result %s, but the board says %s", rec.Result, ResultFor(g.Winner()))
+	}
+	return g, nil
+}
+
+// symbol is what move i placed, played by player
+func (rec *Record) symbol(i int, player string) string {
+	if i < len(rec.Symbols) && rec.Symbols[i] != "" {
+		return rec.Symbols[i]
+	}
+	return player
+}
+
+// Square names a cell, e.g. b2, or 2b2 on a 3D board.
+func Square(rules game.Rules, pos int) string {
+	if rules.Size < 1 || pos < 0 || pos >= rules.Cells() {
+		return strconv.Itoa(pos)
+	}
+	layer, row, col := rules.Coords(pos)
+	square := fmt.Sprintf("%c%d", 'a'+col, row+1)
+	if rules.Depth > 0 {
+		square = strconv.Itoa(layer+1) + square
+	}
+	return square
+}
+
+var squarePattern = regexp.MustCompile(`^([1-9][0-9]*)?([a-z])([1-9][0-9]*)$`)
+
+// ParseSquare turns a square name back into a cell.
+func ParseSquare(rules game.Rules, name string) (int, error) {
+	m := squarePattern.FindStringSubmatch(name)
+	if m == nil || (m[1] != "") != (rules.Depth > 0) {
+		return 0, fmt.Errorf("%q is not a square", name)
+	}
+	layer := 1
+	if m[1] != "" {
+		layer, _ = strconv.Atoi(m[1])
+	}
+	col := int(m[2][0] - 'a')
+	row, _ := strconv.Atoi(m[3])
+	pos, err := rules.Cell(layer-1, row-1, col)
+	if err != nil {
+		return 0, fmt.Errorf("%s is off the %s board", name, FormatRules(rules))
+	}
+	return pos, nil
+}
+
+// FormatRules writes rules as size x size / line, e.g. 3x3/3, with the
+// layers too on a 3D board, e.g. 4x4x4/4, and then the variant if there is
+// one, e.g. 3x3/3 misere.
+func FormatRules(rules game.Rules) string {
+	text := fmt.Sprintf("%dx%d/%d", rules.Size, rules.Size, rules.Line)
+	if rules.Depth > 0 {
+		text = fmt.Sprintf("%dx%dx%d/%d", rules.Size, rules.Size, rules.Depth, rules.Line)
+	}
+	if rules.Variant != game.Standard {
+		text += " " + rules.Variant
+	}
+	return text
+}
+
+var rulesPattern = regexp.MustCompile(`^([1-9][0-9]*)x([1-9][0-9]*)(?:x([1-9][0-9]*))?/([1-9][0-9]*)(?: ([A-Za-z]+))?$`)
+
+// ParseRules reads FormatRules' output.
+func ParseRules(text string) (game.Rules, error) {
+	var rules game.Rules
+	m := rulesPattern.FindStringSubmatch(text)
+	if m == nil || m[1] != m[2] {
+		return rules, fmt.Errorf("rules %q are not like 3x3/3", text)
+	}
+	rules.Size, _ = strconv.Atoi(m[1])
+	rules.Line, _ = strconv.Atoi(m[4])
+	if m[3] != "" {
+		rules.Depth, _ = strconv.Atoi(m[3])
+	}
+	rules.Variant = m[5]
+	return rules, rules.Validate()
+}
+
+// Format writes a record in TTN.
+func Format(rec *Record) string {
+	var b strings.Builder
+	tag := func(name, value string) {
+		fmt.Fprintf(&b, "[%s %q]\n", name, value)
+	}
+	if rec.Event != "" {
+		tag("Event", rec.Event)
+	}
+	if rec.Date != "" {
+		tag("Date", rec.Date)
+	}
+	tag("X", rec.X)
+	tag("O", rec.O)
+	tag("Rules", FormatRules(rec.Rules))
+	tag("Result", rec.Result)
+	if rec.Termination != "" {
+		tag("Termination", rec.Termination)
+	}
+	names := make([]string, 0, len(rec.Tags))
+	for name := range rec.Tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tag(name, rec.Tags[name])
+	}
+
+	b.WriteString("\n")
+	for i, pos := range rec.Moves {
+		if i%2 == 0 {
+			fmt.Fprintf(&b, "%d. ", i/2+1)
+		}
+		b.WriteString(Square(rec.Rules, pos))
+		if i < len(rec.Symbols) && rec.Symbols[i] != "" {
+			b.WriteString("=" + rec.Symbols[i])
+		}
+		b.WriteString(" ")
+	}
+	b.WriteString(rec.Result + "\n")
+	return b.String()
+}
+
+var (
+	tagPattern        = regexp.MustCompile(`^\[([A-Za-z0-9_]+)\s+"((?:[^"\\]|\\.)*)"\]$`)
+	moveNumberPattern = regexp.MustCompile(`^[0-9]+\.+`)
+	commentPattern    = regexp.MustCompile(`\{[^}]*\}`)
+)
+
+// Parse reads one game, see ParseAll for several.
+func Parse(text string) (*Record, error) {
+	records, err := ParseAll(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) != 1 {
+		return nil, fmt.Errorf("found %d games, want one", len(records))
+	}
+	return records[0], nil
+}
+
+// ParseAll reads every game in text. Comments in {braces}, and from ; to the
+// end of a move line, are skipped. The moves are not checked, see Record.Replay.
+func ParseAll(text string) ([]*Record, error) {
+	var records []*Record
+	var rec *Record
+	var movetext []string
+	finish := func() error {
+		if rec == nil {
+			return nil
+		}
+		err := rec.parseMoves(strings.Join(movetext, " "))
+		records = append(records, rec)
+		rec, movetext = nil, nil
+		return err
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if movetext != nil {
+				// tags after moves start the next game
+				if err := finish(); err != nil {
+					return nil, fmt.Errorf("game %d: %w", len(records), err)
+				}
+			}
+			if rec == nil {
+				rec = &Record{Rules: game.Classic}
+			}
+			if err := rec.setTag(line); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			continue
+		}
+		if i := strings.Index(line, ";"); i >= 0 {
+			line = line[:i]
+		}
+		if rec == nil {
+			rec = &Record{Rules: game.Classic}
+		}
+		movetext = append(movetext, line)
+
+		// the result closes the move list
+		if fields := strings.Fields(line); len(fields) > 0 && isResult(fields[len(fields)-1]) {
+			if err := finish(); err != nil {
+				return nil, fmt.Errorf("game %d: %w", len(records), err)
+			}
+		}
+	}
+	if err := finish(); err != nil {
+		return nil, fmt.Errorf("game %d: %w", len(records), err)
+	}
+	if len(records) == 0 {
+		return nil, errors.New("no games found")
+	}
+	return records, nil
+}
+
+func isResult(token string) bool {
+	switch token {
+	case XWins, OWins, Draw, Unfinished:
+		return true
+	}
+	return false
+}
+
+// setTag reads one [Name "value"] line into the record
+func (rec *Record) setTag(line string) error {
+	m := tagPattern.FindStringSubmatch(line)
+	if m == nil {
+		return fmt.Errorf("%s is not a tag", line)
+	}
+	value, err := strconv.Unquote(`"` + m[2] + `"`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", line, err)
+	}
+
+	switch m[1] {
+	case "Event":
+		rec.Event = value
+	case "Date":
+		rec.Date = value
+	case "X":
+		rec.X = value
+	case "O":
+		rec.O = value
+	case "Rules":
+		rec.Rules, err = ParseRules(value)
+	case "Result":
+		rec.Result = value
+	case "Termination":
+		rec.Termination = value
+	default:
+		if rec.Tags == nil {
+			rec.Tags = make(map[string]string)
+		}
+		rec.Tags[m[1]] = value
+	}
+	return err
+}
+
+// parseMoves reads the move list, it ends with the result if there is one.
+// Without a Result tag the result comes from there, or the game is unfinished.
+func (rec *Record) parseMoves(text string) error {
+	defer func() {
+		if rec.Result == "" {
+			rec.Result = Unfinished
+		}
+	}()
+	text = commentPattern.ReplaceAllString(text, " ")
+	for _, token := range strings.Fields(text) {
+		token = moveNumberPattern.ReplaceAllString(token, "")
+		switch token {
+		case "":
+			continue
+		case XWins, OWins, Draw, Unfinished:
+			if rec.Result == "" {
+				rec.Result = token
+			} else if token != rec.Result {
+				return fmt.Errorf("the moves end in %s but the Result tag is %s", token, rec.Result)
+			}
+			continue
+		}
+		square, symbol, picked := strings.Cut(token, "=")
+		pos, err := ParseSquare(rec.Rules, square)
+		if err != nil {
+			return err
+		}
+		if picked && symbol != game.X && symbol != game.O {
+			return fmt.Errorf("%s places %q, want X or O", token, symbol)
+		}
+		rec.Moves = append(rec.Moves, pos)
+		rec.Symbols = append(rec.Symbols, symbol)
+	}
+	if !slices.ContainsFunc(rec.Symbols, func(s string) bool { return s != "" }) {
+		rec.Symbols = nil
+	}
+	return nil
+}
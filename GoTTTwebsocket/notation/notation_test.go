@@ -0,0 +1,148 @@
+package notation
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"goChatSocket/game"
+)
+
+func TestRoundTrip(t *testing.T) {
+	rec := &Record{
+		Event:       "main",
+		Date:        "2026.10.19",
+		X:           `Ann "the wall"`,
+		O:           "Bob",
+		Rules:       game.Rules{Size: 4, Line: 3},
+		Result:      XWins,
+		Termination: "forfeit",
+		Moves:       []int{5, 0, 6},
+		Tags:        map[string]string{"Round": "2"},
+	}
+	text := Format(rec)
+	if !strings.Contains(text, "1. b2 a1 2. c2 1-0") {
+		t.Fatalf("moves written as:\n%s", text)
+	}
+	parsed, err := Parse(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, rec) {
+		t.Fatalf("parsed %+v, want %+v", parsed, rec)
+	}
+	if _, err := parsed.Replay(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQubicRoundTrip(t *testing.T) {
+	rec := &Record{X: "a", O: "b", Rules: game.Qubic, Result: Unfinished, Moves: []int{0, 21, 63}}
+	text := Format(rec)
+	if !strings.Contains(text, `[Rules "4x4x4/4"]`) || !strings.Contains(text, "1. 1a1 2b2 2. 4d4 *") {
+		t.Fatalf("written as:\n%s", text)
+	}
+	parsed, err := Parse(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, rec) {
+		t.Fatalf("parsed %+v, want %+v", parsed, rec)
+	}
+	if _, err := Parse("[Rules \"4x4x4/4\"]\n1. b2 *"); err == nil {
+		t.Fatal("a square without its layer parsed on a 3D board")
+	}
+}
+
+func TestVariantRoundTrip(t *testing.T) {
+	rec := &Record{
+		X: "a", O: "b",
+		Rules:   game.Rules{Size: 3, Line: 3, Variant: game.Wild},
+		Result:  XWins,
+		Moves:   []int{0, 4, 1, 8, 2},
+		Symbols: []string{"O", "X", "O", "X", "O"},
+	}
+	text := Format(rec)
+	if !strings.Contains(text, `[Rules "3x3/3 wild"]`) || !strings.Contains(text, "1. a1=O b2=X 2. b1=O c3=X 3. c1=O 1-0") {
+		t.Fatalf("written as:\n%s", text)
+	}
+	parsed, err := Parse(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, rec) {
+		t.Fatalf("parsed %+v, want %+v", parsed, rec)
+	}
+	if _, err := parsed.Replay(); err != nil {
+		t.Fatal(err)
+	}
+	if rec, _ := Parse("1. a1=O *"); rec != nil {
+		if _, err := rec.Replay(); err == nil {
+			t.Fatal("X placed an O in the standard game")
+		}
+	}
+}
+
+func TestParseAll(t *testing.T) {
+	records, err := ParseAll(`
+[X "a"]
+[O "b"]
+
+1. b2 {the centre} a1 2. a3 c1 3. b1 c3 4. b3 1-0
+
+1. a1 b1 ; no tags at all
+2. a2 b2 3. a3 *
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Result != XWins || records[1].Result != Unfinished || len(records[1].Moves) != 5 {
+		t.Fatalf("parsed %+v", records)
+	}
+}
+
+func TestReplayRejects(t *testing.T) {
+	games := map[string]string{
+		"occupied square":     "1. b2 b2 *",
+		"move after the win":  "1. a1 b1 2. a2 b2 3. a3 b3 1-0",
+		"result against line": "1. a1 b1 2. a2 b2 3. a3 0-1",
+		"off the board":       "1. d4 *",
+	}
+	for name, text := range games {
+		rec, err := Parse(text)
+		if err == nil {
+			_, err = rec.Replay()
+		}
+		if err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestParseRulesLimits(t *testing.T) {
+	tests := []struct {
+		text string
+		ok   bool
+	}{
+		{"26x26/5", true},
+		{"27x27/5", false},
+		{"8x8x16/4", true},
+		{"8x8x17/4", false},
+		{"60x60x60/4", false},
+		{"4x4x99999999999999999999/4", false},
+	}
+	for _, tt := range tests {
+		if _, err := ParseRules(tt.text); (err == nil) != tt.ok {
+			t.Errorf("%s: error %v", tt.text, err)
+		}
+	}
+
+	// the last column of the widest board still has a name
+	rules, _ := ParseRules("26x26/5")
+	if name := Square(rules, 25); name != "z1" {
+		t.Errorf("square 25 is %q", name)
+	}
+	if pos, err := ParseSquare(rules, "z26"); err != nil || pos != rules.Cells()-1 {
+		t.Errorf("z26 is %d, %v", pos, err)
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"goChatSocket/game"
+	"goChatSocket/protocol"
+)
+
+// names people pick: letters, digits, spaces and _ . - , starting with a letter or digit
+var validName = regexp.MustCompile(`^[\p{L}\p{N}][\p{L}\p{N} _.-]*$`)
+
+// names the server hands out itself, nobody may pick one
+var autoName = regexp.MustCompile(`(?i)^(player|spectator)-[0-9]+$`)
+
+const maxNameLength = 20
+
+// checkName tells why a display name can't be used, nil if it can
+func checkName(name string) error {
+	switch {
+	case utf8.RuneCountInString(name) > maxNameLength:
+		return fmt.Errorf("Names can be at most %d characters.", maxNameLength)
+	case !validName.MatchString(name) || strings.HasSuffix(name, " "):
+		return errors.New("Names use letters, digits, spaces and _ . - and start with a letter or digit.")
+	case autoName.MatchString(name) || strings.EqualFold(name, "GAMEMASTER"):
+		return fmt.Errorf("%s is reserved.", name)
+	}
+	return nil
+}
+
+// profile is a player's saved settings and record.
+// Token: the login token the owner proves who they are with, never shown to others.
+// ID: the public id, used where other people can see it (e.g. puzzle stats).
+type profile struct {
+	ID               string                `json:"id"`
+	Token            string                `json:"token"`
+	Name             string                `json:"name"`
+	Color            string                `json:"color,omitempty"`
+	PreferredVariant string                `json:"preferredVariant,omitempty"`
+	Stats            protocol.ProfileStats `json:"stats"`
+	Created          time.Time             `json:"created"`
+}
+
+// public is what the owner is shown of their profile
+func (p *profile) public() *protocol.Profile {
+	return &protocol.Profile{ID: p.ID, Name: p.Name, Color: p.Color, PreferredVariant: p.PreferredVariant, Stats: p.Stats}
+}
+
+// profileBook stores the profiles by token.
+// path: the JSON file the book is saved to, empty keeps it in memory.
+type profileBook struct {
+	mu       sync.Mutex
+	path     string
+	profiles map[string]*profile
+}
+
+func newProfileBook() *profileBook {
+	return &profileBook{profiles: make(map[string]*profile)}
+}
+
+// load reads the book saved at path, if there is one, and saves it there from now on
+func (b *profileBook) load(path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.path = path
+
+	var profiles []*profile
+	if err := readJSONFile(path, &profiles); err != nil {
+		return err
+	}
+	for _, p := range profiles {
+		b.profiles[p.Token] = p
+	}
+	return nil
+}
+
+// save writes the book to its file, call with the lock held
+func (b *profileBook) save() error {
+	if b.path == "" {
+		return nil
+	}
+	profiles := make([]*profile, 0, len(b.profiles))
+	for _, p := range b.profiles {
+		profiles = append(profiles, p)
+	}
+	return writeJSONFile(b.path, profiles)
+}
+
+// create makes a profile and returns it with its new token
+func (b *profileBook) create(settings profileSettings) (profile, error) {
+	p := profile{ID: "p" + randomHex(6), Token: randomHex(24), Created: time.Now()}
+	if err := settings.apply(&p); err != nil {
+		return profile{}, err
+	}
+	if p.Name == "" {
+		return profile{}, errors.New("name is required")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.profiles[p.Token] = &p
+	if err := b.save(); err != nil {
+		fmt.Println("Profile Error:", err)
+	}
+	return p, nil
+}
+
+// get returns a copy of the profile with token
+func (b *profileBook) get(token string) (profile, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p, ok := b.profiles[token]
+	if !ok {
+		return profile{}, false
+	}
+	return *p, true
+}
+
+// update changes the profile with token and returns it
+func (b *profileBook) update(token string, settings profileSettings) (profile, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p, ok := b.profiles[token]
+	if !ok {
+		return profile{}, errUnknownProfile
+	}
+	changed := *p
+	if err := settings.apply(&changed); err != nil {
+		return profile{}, err
+	}
+	*p = changed
+	if err := b.save(); err != nil {
+		fmt.Println("Profile Error:", err)
+	}
+	return changed, nil
+}
+
+// byID finds a profile by its public id, call with the lock held
+func (b *profileBook) byID(id string) *profile {
+	for _, p := range b.profiles {
+		if p.ID == id {
+			return p
+		}
+	}
+	return nil
+}
+
+// rename saves a new name for the profile with id
+func (b *profileBook) rename(id, name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if p := b.byID(id); p != nil {
+		p.Name = name
+		if err := b.save(); err != nil {
+			fmt.Println("Profile Error:", err)
+		}
+	}
+}
+
+// recordGame adds a finished game to the stats of the profile with id:
+// won, lost or (neither) drawn
+func (b *profileBook) recordGame(id string, won, lost bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p := b.byID(id)
+	if p == nil {
+		return
+	}
+	p.Stats.Played++
+	switch {
+	case won:
+		p.Stats.Wins++
+	case lost:
+		p.Stats.Losses++
+	default:
+		p.Stats.Draws++
+	}
+	if err := b.save(); err != nil {
+		fmt.Println("Profile Error:", err)
+	}
+}
+
+var errUnknownProfile = errors.New("unknown profile token")
+
+// profileSettings is the body of POST /profiles and PATCH /profile, fields
+// left out stay as they are
+type profileSettings struct {
+	Name             *string `json:"name"`
+	Color            *string `json:"color"`
+	PreferredVariant *string `json:"preferredVariant"`
+}
+
+var validColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// apply checks the settings and copies them into p
+func (s profileSettings) apply(p *profile) error {
+	if s.Name != nil {
+		if err := checkName(*s.Name); err != nil {
+			return err
+		}
+		p.Name = *s.Name
+	}
+	if s.Color != nil {
+		if *s.Color != "" && !validColor.MatchString(*s.Color) {
+			return errors.New("color must look like #1e90ff")
+		}
+		p.Color = *s.Color
+	}
+	if s.PreferredVariant != nil {
+		if err := (game.Rules{Size: 3, Line: 3, Variant: *s.PreferredVariant}).Validate(); err != nil {
+			return err
+		}
+		p.PreferredVariant = *s.PreferredVariant
+	}
+	return nil
+}
+
+// profileToken reads the login token from "Authorization: Bearer <token>"
+func profileToken(r *http.Request) (string, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", statusError{http.StatusUnauthorized, errors.New("send your profile token as Authorization: Bearer <token>")}
+	}
+	return token, nil
+}
+
+// createdProfile is the answer to POST /profiles, the only time the token is sent
+type createdProfile struct {
+	Token string `json:"token"`
+	*protocol.Profile
+}
+
+// handleCreateProfile makes a profile, the body is a profileSettings with at least a name
+func (s *Server) handleCreateProfile(w http.ResponseWriter, r *http.Request) error {
+	var settings profileSettings
+	if err := readJSON(r, &settings); err != nil {
+		return err
+	}
+	p, err := s.profiles.create(settings)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusCreated, createdProfile{Token: p.Token, Profile: p.public()})
+}
+
+// handleProfile shows the caller their profile
+func (s *Server) handleProfile(w http.ResponseWriter, r *http.Request) error {
+	token, err := profileToken(r)
+	if err != nil {
+		return err
+	}
+	p, ok := s.profiles.get(token)
+	if !ok {
+		return statusError{http.StatusUnauthorized, errUnknownProfile}
+	}
+	return WriteJSON(w, http.StatusOK, p.public())
+}
+
+// handleUpdateProfile changes the caller's profile, see profileSettings
+func (s *Server) handleUpdateProfile(w http.ResponseWriter, r *http.Request) error {
+	token, err := profileToken(r)
+	if err != nil {
+		return err
+	}
+	var settings profileSettings
+	if err := readJSON(r, &settings); err != nil {
+		return err
+	}
+	p, err := s.profiles.update(token, settings)
+	if errors.Is(err, errUnknownProfile) {
+		return statusError{http.StatusUnauthorized, err}
+	}
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, p.public())
+}
+
+// room side
+
+// chooseName is the name c gets in the room: the one they asked for in hello
+// if nobody here has it, otherwise auto. Call with the lock held.
+func (r *Room) chooseName(c *Client, auto string) string {
+	if c.wantName == "" {
+		return auto
+	}
+	if r.nameTaken(c.wantName, c) {
+		c.reply(&protocol.Error{Code: protocol.CodeNameTaken, Message: fmt.Sprintf("%s is taken in this room, you are %s.", c.wantName, auto)})
+		return auto
+	}
+	return c.wantName
+}
+
+// nameTaken reports whether someone other than c has name in the room, or has
+// a seat kept or reserved under it. Names differing only in case count as the same.
+// Call with the lock held.
+func (r *Room) nameTaken(name string, c *Client) bool {
+	for other := range r.clients {
+		if other != c && strings.EqualFold(other.name, name) {
+			return true
+		}
+	}
+	for _, seat := range r.returning {
+		if seat.Conn != c.id && strings.EqualFold(seat.Name, name) {
+			return true
+		}
+	}
+	for _, seat := range r.opts.seats {
+		if (c.token == "" || seat.token != c.token) && strings.EqualFold(seat.name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// rename handles setName: the new name is checked against the naming rules
+// and everyone in the room, and saved to the client's profile
+func (r *Room) rename(c *Client, name string) error {
+	if err := checkName(name); err != nil {
+		return &protocol.Error{Code: protocol.CodeInvalidName, Message: err.Error()}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c.symbol != "" && r.opts.seats[c.symbol].name != "" {
+		return &protocol.Error{Code: protocol.CodeInvalidName, Message: "Tournament players keep the name they registered with."}
+	}
+	if r.nameTaken(name, c) {
+		return &protocol.Error{Code: protocol.CodeNameTaken, Message: fmt.Sprintf("%s is taken in this room.", name)}
+	}
+
+	old := c.name
+	c.name = name
+	c.reply(&protocol.SetName{Name: name})
+	if old != name {
+		r.broadcastSystem(fmt.Sprintf("%s is now known as %s.", old, name))
+	}
+	if c.profile != "" {
+		r.hub.profiles.rename(c.profile, name)
+	}
+	return nil
+}
+
+// seatedPlayers lists who sits in each seat, call with the lock held
+func (r *Room) seatedPlayers() map[string]gamePlayer {
+	players := make(map[string]gamePlayer)
+	for symbol, p := range r.players {
+		players[symbol] = gamePlayer{Profile: p.profile, Account: p.account}
+	}
+	return players
+}
+
+// recordStats adds the game that just ended to its players' profiles, call with the lock held
+func (r *Room) recordStats(winner string) {
+	for symbol, p := range r.gamePlayers {
+		if p.Profile != "" {
+			_, span := startStorageSpan(r.traceCtx(), "profiles.recordGame", attrRoom.String(r.ID), attrProfile.String(p.Profile))
+			r.hub.profiles.recordGame(p.Profile, winner == symbol, winner != "" && winner != symbol)
+			span.End()
+		}
+	}
+}
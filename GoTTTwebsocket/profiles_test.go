@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"goChatSocket/client"
+	"goChatSocket/protocol"
+)
+
+// dialAs is dial with a name or profile
+func dialAs(t *testing.T, url string, id client.Identity) *client.Client {
+	t.Helper()
+	c, err := client.DialAs(url, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestNames(t *testing.T) {
+	addr := startServer(t, nil)
+	url := roomURL(t, addr, "names")
+
+	x := dialAs(t, url, client.Identity{Name: "Ada"})
+	if assigned := waitFor[client.Assigned](t, x, nil); assigned.UserName != "Ada" {
+		t.Fatalf("assigned %+v", assigned)
+	}
+
+	// names are unique in a room whatever the case, a taken one gets an automatic name
+	o := dialAs(t, url, client.Identity{Name: "ada"})
+	waitFor(t, o, func(e client.Rejected) bool { return e.Code == protocol.CodeNameTaken })
+	if assigned := waitFor[client.Assigned](t, o, nil); assigned.UserName != "player-2" {
+		t.Fatalf("assigned %+v", assigned)
+	}
+
+	if err := o.SetName("Grace"); err != nil {
+		t.Fatal(err)
+	}
+	if renamed := waitFor[client.Renamed](t, o, nil); renamed.Name != "Grace" || o.UserName() != "Grace" {
+		t.Fatalf("renamed %+v, user name %q", renamed, o.UserName())
+	}
+	waitFor(t, x, func(e client.SystemNotice) bool { return e.Text == "player-2 is now known as Grace." })
+
+	for name, code := range map[string]string{
+		"GRACE":     protocol.CodeNameTaken,
+		"player-7":  protocol.CodeInvalidName,
+		" Ada":      protocol.CodeInvalidName,
+		"<b>hi</b>": protocol.CodeInvalidName,
+	} {
+		if err := x.SetName(name); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, x, func(e client.Rejected) bool { return e.Code == code })
+	}
+
+	// a bad name in hello is refused outright
+	_, err := client.DialAs(url, client.Identity{Name: "GAMEMASTER"})
+	var perr *protocol.Error
+	if !errors.As(err, &perr) || perr.Code != protocol.CodeInvalidName {
+		t.Fatalf("dial as GAMEMASTER: %v", err)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	srv := httptest.NewServer(newServer().routes())
+	t.Cleanup(srv.Close)
+	url := roomURL(t, "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", "profiles")
+
+	if status := adminCall(t, srv.URL, "", "POST", "/profiles", map[string]string{"name": "Lin", "color": "red"}, nil); status != http.StatusBadRequest {
+		t.Fatalf("bad colour: status %d", status)
+	}
+	var created createdProfile
+	settings := map[string]string{"name": "Lin", "color": "#1e90ff", "preferredVariant": "misere"}
+	if status := adminCall(t, srv.URL, "", "POST", "/profiles", settings, &created); status != http.StatusCreated || created.Token == "" {
+		t.Fatalf("create: status %d, %+v", status, created)
+	}
+
+	_, err := client.DialAs(url, client.Identity{Profile: "nope"})
+	var perr *protocol.Error
+	if !errors.As(err, &perr) || perr.Code != protocol.CodeUnknownProfile {
+		t.Fatalf("dial with unknown profile: %v", err)
+	}
+
+	// the profile's name and variant are used, the room is created misère
+	x := dialAs(t, url, client.Identity{Profile: created.Token})
+	if p := x.Profile(); p == nil || p.ID != created.ID || p.Color != "#1e90ff" {
+		t.Fatalf("welcome profile %+v", p)
+	}
+	if snapshot := waitFor[client.Snapshot](t, x, nil); snapshot.Rules.Variant != "misere" {
+		t.Fatalf("snapshot rules %+v", snapshot.Rules)
+	}
+	if assigned := waitFor[client.Assigned](t, x, nil); assigned.UserName != "Lin" {
+		t.Fatalf("assigned %+v", assigned)
+	}
+	o := dial(t, url)
+
+	// X completes the top row and loses, the guest's game isn't recorded anywhere
+	play(t, x, o, 0, 3, 1, 4, 2)
+	waitFor[client.GameOver](t, x, nil)
+	if err := x.SetName("Lin2"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor[client.Renamed](t, x, nil)
+
+	var saved protocol.Profile
+	if status := adminCall(t, srv.URL, created.Token, "GET", "/profile", nil, &saved); status != http.StatusOK {
+		t.Fatalf("get profile: status %d", status)
+	}
+	want := protocol.ProfileStats{Played: 1, Losses: 1}
+	if saved.Name != "Lin2" || saved.Stats != want {
+		t.Fatalf("saved profile %+v", saved)
+	}
+
+	if status := adminCall(t, srv.URL, created.Token, "PATCH", "/profile", map[string]string{"preferredVariant": "chess"}, nil); status != http.StatusBadRequest {
+		t.Fatalf("unknown variant: status %d", status)
+	}
+	if status := adminCall(t, srv.URL, "wrong", "GET", "/profile", nil, nil); status != http.StatusUnauthorized {
+		t.Fatalf("wrong token: status %d", status)
+	}
+}
+
+func TestProfileBookFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profiles.json")
+	book := newProfileBook()
+	if err := book.load(path); err != nil {
+		t.Fatal(err)
+	}
+	name := "Kim"
+	p, err := book.create(profileSettings{Name: &name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	book.recordGame(p.ID, true, false)
+
+	reloaded := newProfileBook()
+	if err := reloaded.load(path); err != nil {
+		t.Fatal(err)
+	}
+	got, ok := reloaded.get(p.Token)
+	if !ok || got.Name != "Kim" || got.Stats.Wins != 1 {
+		t.Fatalf("reloaded %+v, %v", got, ok)
+	}
+}
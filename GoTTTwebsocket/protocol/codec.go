@@ -0,0 +1,143 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec turns envelopes into frames and back. JSON is the default, a client
+// asks for another codec with the websocket subprotocol Subprotocol(codec).
+// Name: short name, e.g. "msgpack".
+// Binary: frames should go out as binary websocket messages.
+type Codec interface {
+	Name() string
+	Binary() bool
+	Encode(env Envelope) ([]byte, error)
+	Decode(data []byte, from Role) (Envelope, any, error)
+}
+
+var (
+	// JSON is the text codec every transport supports.
+	JSON Codec = jsonCodec{}
+
+	// MessagePack is the same envelope as a binary MessagePack map, with the
+	// same field names as the JSON.
+	MessagePack Codec = msgpackCodec{}
+)
+
+// Codecs lists every codec, the order is our preference when a client offers several.
+var Codecs = []Codec{MessagePack, JSON}
+
+// subprotocolPrefix namespaces our websocket subprotocols, e.g. "ttt.msgpack"
+const subprotocolPrefix = "ttt."
+
+// Subprotocol is the websocket subprotocol that selects codec.
+func Subprotocol(codec Codec) string {
+	return subprotocolPrefix + codec.Name()
+}
+
+// CodecFor finds the codec for a websocket subprotocol.
+func CodecFor(subprotocol string) (Codec, bool) {
+	for _, codec := range Codecs {
+		if Subprotocol(codec) == subprotocol {
+			return codec, true
+		}
+	}
+	return nil, false
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+func (jsonCodec) Binary() bool { return false }
+
+func (jsonCodec) Encode(env Envelope) ([]byte, error) {
+	return json.Marshal(env)
+}
+
+func (jsonCodec) Decode(data []byte, from Role) (Envelope, any, error) {
+	return Decode(data, from)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+func (msgpackCodec) Binary() bool { return true }
+
+// msgpackEnvelope is Envelope on the wire, the payload is a nested map rather
+// than embedded JSON
+type msgpackEnvelope struct {
+	V       int                `msgpack:"v"`
+	Type    string             `msgpack:"type"`
+	Seq     uint64             `msgpack:"seq,omitempty"`
+	TS      time.Time          `msgpack:"ts"`
+	Payload msgpack.RawMessage `msgpack:"payload,omitempty"`
+}
+
+func (msgpackCodec) Encode(env Envelope) ([]byte, error) {
+	// envelopes that didn't come from New (e.g. decoded JSON) only have the JSON payload
+	body := env.body
+	if body == nil && len(env.Payload) > 0 {
+		if spec, ok := registry[env.Type]; ok {
+			body = spec.new()
+			if err := json.Unmarshal(env.Payload, body); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	wire := msgpackEnvelope{V: env.V, Type: env.Type, Seq: env.Seq, TS: env.TS}
+	if body != nil {
+		payload, err := msgpackMarshal(body)
+		if err != nil {
+			return nil, err
+		}
+		wire.Payload = payload
+	}
+	return msgpackMarshal(&wire)
+}
+
+func (msgpackCodec) Decode(data []byte, from Role) (Envelope, any, error) {
+	var wire msgpackEnvelope
+	if err := msgpackUnmarshal(data, &wire); err != nil {
+		return Envelope{}, nil, &Error{Code: CodeMalformed, Message: err.Error()}
+	}
+	env := Envelope{V: wire.V, Type: wire.Type, Seq: wire.Seq, TS: wire.TS}
+	payload, err := typed(env, from, len(wire.Payload) == 0, func(v any) error {
+		return msgpackUnmarshal(wire.Payload, v)
+	})
+	env.body = payload
+	return env, payload, err
+}
+
+// msgpackMarshal uses the json tags of our payload structs so both codecs
+// share field names and omitempty rules
+func msgpackMarshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// msgpackUnmarshal is strict like the JSON decoder: unknown fields are an error.
+// The decoder panics on some malformed input (nil where a struct goes, like
+// the envelope's ts), that is an error too.
+func msgpackUnmarshal(data []byte, v any) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("msgpack: %v", r)
+		}
+	}()
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	dec.DisallowUnknownFields(true)
+	return dec.Decode(v)
+}
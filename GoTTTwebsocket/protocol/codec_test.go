@@ -0,0 +1,151 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// samples are typical messages, from a tiny move to a whole room snapshot
+func samples() []any {
+	return []any{
+		&Move{Position: intPtr(4), Symbol: "X"},
+		&Move{Position: intPtr(21), Cell: &Cell{Layer: 1, Row: 1, Col: 1}, Symbol: "O"},
+		&Chat{Sender: "player-1", Text: "good game, rematch?"},
+		&GameOver{Winner: "O", Text: "User-O Wins!"},
+		&Reaction{Sender: "player-2", Emoji: "🔥", Move: 3, Counts: map[string]int{"🔥": 2, "👏": 1}},
+		&Snapshot{
+			Board:   []string{"X", "", "O", "", "X", "", "", "O", ""},
+			Turn:    "X",
+			Players: map[string]string{"X": "player-1", "O": "player-2"},
+		},
+	}
+}
+
+// name is the message type of a sample, move3d for the move on a 3D board
+func name(payload any) string {
+	msgType, _ := typeOf(payload)
+	if m, ok := payload.(*Move); ok && m.Cell != nil {
+		return msgType + "3d"
+	}
+	return msgType
+}
+
+func intPtr(n int) *int { return &n }
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, codec := range Codecs {
+		for _, payload := range samples() {
+			t.Run(codec.Name()+"/"+name(payload), func(t *testing.T) {
+				env := MustNew(payload)
+				env.Seq = 42
+
+				data, err := codec.Encode(env)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, decoded, err := codec.Decode(data, FromServer)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.Type != env.Type || got.Seq != 42 || got.V != Version || !got.TS.Equal(env.TS) {
+					t.Fatalf("envelope %+v, want %+v", got, env)
+				}
+				if !reflect.DeepEqual(decoded, payload) {
+					t.Fatalf("payload %+v, want %+v", decoded, payload)
+				}
+			})
+		}
+	}
+}
+
+func TestMessagePackIsStrict(t *testing.T) {
+	encode := func(wire any) []byte {
+		data, err := msgpackMarshal(wire)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	payload := func(v any) []byte { return encode(v) }
+
+	tests := map[string]struct {
+		data []byte
+		code string
+	}{
+		"garbage":         {[]byte{0xc1}, CodeMalformed},
+		"unknown type":    {encode(msgpackEnvelope{V: 1, Type: "nope"}), CodeUnknownType},
+		"server only":     {encode(msgpackEnvelope{V: 1, Type: TypeSnapshot}), CodeUnknownType},
+		"missing payload": {encode(msgpackEnvelope{V: 1, Type: TypeMove}), CodeInvalidPayload},
+		"unknown field":   {encode(msgpackEnvelope{V: 1, Type: TypeChat, Payload: payload(map[string]any{"text": "hi", "extra": 1})}), CodeInvalidPayload},
+		"negative move":   {encode(msgpackEnvelope{V: 1, Type: TypeMove, Payload: payload(map[string]any{"position": -1})}), CodeInvalidPayload},
+		"empty move":      {encode(msgpackEnvelope{V: 1, Type: TypeMove, Payload: payload(map[string]any{})}), CodeInvalidPayload},
+		"unknown symbol":  {encode(msgpackEnvelope{V: 1, Type: TypeMove, Payload: payload(map[string]any{"position": 4, "symbol": "0"})}), CodeInvalidPayload},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := MessagePack.Decode(tt.data, FromClient)
+			var perr *Error
+			if !errors.As(err, &perr) || perr.Code != tt.code {
+				t.Fatalf("got %v, want code %s", err, tt.code)
+			}
+		})
+	}
+}
+
+// go test ./protocol -bench . -benchmem
+func BenchmarkEncode(b *testing.B) {
+	for _, codec := range Codecs {
+		for _, payload := range samples() {
+			env := MustNew(payload)
+			b.Run(fmt.Sprintf("%s/%s", codec.Name(), name(payload)), func(b *testing.B) {
+				var size int
+				for i := 0; i < b.N; i++ {
+					data, err := codec.Encode(env)
+					if err != nil {
+						b.Fatal(err)
+					}
+					size = len(data)
+				}
+				b.ReportMetric(float64(size), "bytes/msg")
+			})
+		}
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	for _, codec := range Codecs {
+		for _, payload := range samples() {
+			data, err := codec.Encode(MustNew(payload))
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.Run(fmt.Sprintf("%s/%s", codec.Name(), name(payload)), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if _, _, err := codec.Decode(data, FromServer); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// the schema tags list the emoji and phrases again, they must stay in step
+func TestReactionEnums(t *testing.T) {
+	defs := Schema()["$defs"].(map[string]any)
+	enum := func(def, prop string) []string {
+		return defs[def].(map[string]any)["properties"].(map[string]any)[prop].(map[string]any)["enum"].([]string)
+	}
+	if got := enum("Reaction", "emoji"); !reflect.DeepEqual(got, Emojis) {
+		t.Errorf("schema emoji %v, Emojis %v", got, Emojis)
+	}
+	var phrases []string
+	for _, p := range QuickChats {
+		phrases = append(phrases, p.ID)
+	}
+	if got := enum("QuickChat", "phrase"); !reflect.DeepEqual(got, phrases) {
+		t.Errorf("schema phrases %v, QuickChats %v", got, phrases)
+	}
+}
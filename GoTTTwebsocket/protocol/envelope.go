@@ -0,0 +1,153 @@
+// Package protocol holds the wire types shared by the Tic-Tac-Toe server and
+// its Go clients, so both sides agree on what is sent over /ws.
+//
+// Every message is an Envelope: a protocol version, the message type, a
+// sequence number, a timestamp and a payload whose shape depends on the type.
+// A connection starts with the client sending hello with the versions it
+// speaks, the server answers welcome with the version it picked (or error).
+//
+// Everything that happens in a room (moves, turns, chat, ...) is a room event
+// numbered by the room's Seq. A client that sees a gap in those numbers sends
+// resync and gets the missed events again, or a fresh snapshot.
+//
+// schema.json describes every message for client authors, regenerate it with
+// go generate after changing a payload.
+package protocol
+
+//go:generate go run ../cmd/tttschema -o schema.json
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Version is the newest protocol version this package speaks.
+const Version = 1
+
+// SupportedVersions lists every version this package can speak.
+var SupportedVersions = []int{1}
+
+// Envelope wraps every message.
+// V: protocol version negotiated at connect time.
+// Type: what kind of message this is, decides the payload struct.
+// Seq: from clients, their own count starting at 1. From the server, the room
+// event number, 0 for messages meant only for one connection (welcome, error, ...).
+// TS: when the message was created.
+// Payload: the type specific body, see payloads.go.
+type Envelope struct {
+	V       int             `json:"v"`
+	Type    string          `json:"type"`
+	Seq     uint64          `json:"seq,omitempty"`
+	TS      time.Time       `json:"ts"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+
+	// the payload struct Payload was made from, binary codecs encode it directly
+	body any
+}
+
+// New builds an envelope for payload, the type comes from the payload struct.
+// Seq is left for the sender to stamp.
+func New(payload any) (Envelope, error) {
+	msgType, ok := typeOf(payload)
+	if !ok {
+		return Envelope{}, fmt.Errorf("no message type for %T", payload)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Envelope{}, err
+	}
+	return Envelope{V: Version, Type: msgType, TS: time.Now().UTC(), Payload: data, body: payload}, nil
+}
+
+// MustNew is New for payloads that can't fail to encode, which is all of ours.
+func MustNew(payload any) Envelope {
+	env, err := New(payload)
+	if err != nil {
+		panic(err)
+	}
+	return env
+}
+
+// Body is the typed payload (e.g. *Move) of an envelope made by New or
+// decoded, nil for an envelope built by hand.
+func (e Envelope) Body() any {
+	return e.body
+}
+
+// Decode parses one JSON message sent by a peer with the given role and returns
+// the envelope together with its typed payload (a pointer, e.g. *Move).
+// Malformed JSON, unknown fields, unknown types, types the sender may not send
+// and payloads that fail validation are rejected with an *Error.
+// See Codec for other encodings.
+func Decode(data []byte, from Role) (Envelope, any, error) {
+	var env Envelope
+	if err := strictUnmarshal(data, &env); err != nil {
+		return env, nil, &Error{Code: CodeMalformed, Message: err.Error()}
+	}
+	payload, err := typed(env, from, len(env.Payload) == 0, func(v any) error {
+		return strictUnmarshal(env.Payload, v)
+	})
+	env.body = payload
+	return env, payload, err
+}
+
+// typed checks the envelope's type and decodes its payload with unmarshal,
+// shared by every codec so they reject the same messages.
+// empty: the message had no payload, which is fine for payloads without required fields.
+func typed(env Envelope, from Role, empty bool, unmarshal func(v any) error) (any, error) {
+	if env.Type == "" {
+		return nil, &Error{Code: CodeMalformed, Message: "missing type", RefSeq: env.Seq}
+	}
+
+	spec, ok := registry[env.Type]
+	if !ok || spec.from&from == 0 {
+		return nil, &Error{
+			Code:    CodeUnknownType,
+			Message: fmt.Sprintf("unknown message type %q", env.Type),
+			RefSeq:  env.Seq,
+			RefType: env.Type,
+		}
+	}
+
+	payload := spec.new()
+	var err error
+	if !empty {
+		err = unmarshal(payload)
+	}
+	if v, ok := payload.(interface{ Validate() error }); ok && err == nil {
+		err = v.Validate()
+	}
+	if err != nil {
+		return nil, &Error{Code: CodeInvalidPayload, Message: err.Error(), RefSeq: env.Seq, RefType: env.Type}
+	}
+	return payload, nil
+}
+
+// strictUnmarshal is json.Unmarshal that refuses unknown fields and trailing data
+func strictUnmarshal(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("unexpected data after message")
+	}
+	return nil
+}
+
+// Negotiate picks the newest version both sides speak, 0 if there is none.
+func Negotiate(offered []int) int {
+	best := 0
+	for _, v := range offered {
+		for _, ours := range SupportedVersions {
+			if v == ours && v > best {
+				best = v
+			}
+		}
+	}
+	return best
+}
@@ -0,0 +1,95 @@
+package protocol
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// edgeCases are messages a careless or hostile client might send
+var edgeCases = []string{
+	`{"v":1,"type":"move","payload":{"position":-1}}`,
+	`{"v":1,"type":"move","payload":{"position":9}}`,
+	`{"v":1,"type":"move","payload":{"position":2147483648}}`,
+	`{"v":1,"type":"move","payload":{"position":99999999999999999999}}`,
+	`{"v":1,"type":"move","payload":{"position":1.5}}`,
+	`{"v":1,"type":"move","payload":{"position":"4"}}`,
+	`{"v":1,"type":"move","payload":{"position":4,"symbol":"Z"}}`,
+	`{"v":1,"type":"move","payload":{"position":4,"symbol":"x"}}`,
+	`{"v":1,"type":"move","payload":{"position":4,"player":"0"}}`,
+	`{"v":1,"type":"move","payload":{"cell":{"layer":-1,"row":0,"col":0}}}`,
+	`{"v":1,"type":"move","payload":{}}`,
+	`{"v":1,"type":"move","payload":null}`,
+	`{"v":1,"type":"move"}`,
+	`{"v":1,"type":"move","payload":{"position":4},"extra":true}`,
+	`{"v":1,"type":"move","payload":{"position":4}}{"v":1}`,
+	`{"v":1,"type":"bogus","payload":{}}`,
+	`{"v":1,"type":"","payload":{}}`,
+	`{"v":1,"type":"gameOver","payload":{"winner":"X"}}`,
+	`{"v":0,"type":"hello","payload":{"versions":[]}}`,
+	`{"v":1,"type":"resync","payload":{"fromSeq":18446744073709551615}}`,
+	`{"v":1,"type":"chat","payload":{"text":"\ud800"}}`,
+	`{"v":-1,"type":"chat","seq":-1,"payload":{"text":""}}`,
+	`{"v":1,"type":"reaction","payload":{"emoji":"💩"}}`,
+	`{"v":1,"type":"reaction","payload":{"emoji":"👍","move":-1}}`,
+	`{"v":1,"type":"reaction","payload":{"emoji":"👍","move":1,"counts":{}}}`,
+	`{"v":1,"type":"reaction","payload":{"emoji":"👍","move":1,"counts":{"👍":0}}}`,
+	`{"v":1,"type":"quickChat","payload":{"phrase":"buy gold at example.com"}}`,
+	`[]`,
+	`null`,
+	``,
+	"\x80\x81\xff",
+}
+
+// FuzzDecode feeds both codecs arbitrary bytes. Whatever they accept must be a
+// valid message of a known type, that encodes and decodes again to the same payload.
+// Whatever they refuse is refused with an *Error the server can send back.
+func FuzzDecode(f *testing.F) {
+	for _, payload := range samples() {
+		for _, codec := range Codecs {
+			data, err := codec.Encode(MustNew(payload))
+			if err != nil {
+				f.Fatal(err)
+			}
+			f.Add(data)
+		}
+	}
+	for _, edge := range edgeCases {
+		f.Add([]byte(edge))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, codec := range Codecs {
+			for _, from := range []Role{FromClient, FromServer} {
+				env, payload, err := codec.Decode(data, from)
+				if err != nil {
+					var perr *Error
+					if !errors.As(err, &perr) {
+						t.Fatalf("%s: %T %v isn't a protocol error", codec.Name(), err, err)
+					}
+					continue
+				}
+				if spec, ok := registry[env.Type]; !ok || spec.from&from == 0 {
+					t.Fatalf("%s: accepted type %q", codec.Name(), env.Type)
+				}
+				if v, ok := payload.(interface{ Validate() error }); ok {
+					if err := v.Validate(); err != nil {
+						t.Fatalf("%s: accepted an invalid %s: %v", codec.Name(), env.Type, err)
+					}
+				}
+
+				again, err := codec.Encode(env)
+				if err != nil {
+					t.Fatalf("%s: encoding a decoded %s: %v", codec.Name(), env.Type, err)
+				}
+				_, decoded, err := codec.Decode(again, from)
+				if err != nil {
+					t.Fatalf("%s: decoding a re-encoded %s: %v", codec.Name(), env.Type, err)
+				}
+				if !reflect.DeepEqual(decoded, payload) {
+					t.Fatalf("%s: %+v came back as %+v", codec.Name(), payload, decoded)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,525 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+)
+
+// Role is who sends a message.
+type Role int
+
+const (
+	FromClient Role = 1 << iota
+	FromServer
+)
+
+// Message types.
+const (
+	TypeHello            = "hello"
+	TypeWelcome          = "welcome"
+	TypeError            = "error"
+	TypeChat             = "chat"
+	TypeMove             = "move"
+	TypeSystem           = "system"
+	TypeAssignPlayer     = "assignPlayer"
+	TypeLobbyFull        = "lobbyFull"
+	TypeUpdateTurn       = "updateTurn"
+	TypeSnapshot         = "snapshot"
+	TypeResync           = "resync"
+	TypeGameOver         = "gameOver"
+	TypeWatchTournament  = "watchTournament"
+	TypeTournamentUpdate = "tournamentUpdate"
+	TypeAnalyze          = "analyze"
+	TypeAnalysis         = "analysis"
+	TypePuzzleRequest    = "puzzleRequest"
+	TypePuzzle           = "puzzle"
+	TypePuzzleMove       = "puzzleMove"
+	TypePuzzleResult     = "puzzleResult"
+	TypeSetName          = "setName"
+	TypeReaction         = "reaction"
+	TypeQuickChat        = "quickChat"
+)
+
+// Error codes sent in Error payloads.
+const (
+	CodeMalformed          = "malformed"          // not a valid envelope
+	CodeUnknownType        = "unknownType"        // no such type, or not one a client may send
+	CodeInvalidPayload     = "invalidPayload"     // the payload doesn't match its schema
+	CodeUnsupportedVersion = "unsupportedVersion" // no common version, or v isn't the negotiated one
+	CodeHandshake          = "handshake"          // the first message wasn't hello
+	CodeIllegalMove        = "illegalMove"        // the move was rejected by the game rules
+	CodeNotFound           = "notFound"           // e.g. watching a tournament that doesn't exist
+	CodeInvalidRoom        = "invalidRoom"        // the ?room= name isn't allowed
+	CodeKicked             = "kicked"             // an admin closed the connection
+	CodeBanned             = "banned"             // connections from this address are refused
+	CodeAnalysisDisabled   = "analysisDisabled"   // no analysis while a rated game is on
+	CodeInvalidName        = "invalidName"        // the name breaks the naming rules
+	CodeNameTaken          = "nameTaken"          // someone in the room already has the name
+	CodeUnknownProfile     = "unknownProfile"     // hello named a profile token the server doesn't know
+	CodeUnauthorized       = "unauthorized"       // the account login token was refused
+	CodeRateLimited        = "rateLimited"        // too many reactions, quick chats or analyses, wait a moment
+)
+
+// Hello is the first message a client sends, listing the versions it speaks.
+// Name: the display name wanted, the server picks one when empty or taken.
+// Profile: the login token of a saved profile, its name is used when Name is empty.
+type Hello struct {
+	Versions []int  `json:"versions" schema:"minItems=1"`
+	Client   string `json:"client,omitempty"`
+	Name     string `json:"name,omitempty" schema:"maxLength=20"`
+	Profile  string `json:"profile,omitempty"`
+}
+
+func (h *Hello) Validate() error {
+	if len(h.Versions) == 0 {
+		return errors.New("versions is required")
+	}
+	return nil
+}
+
+// Welcome answers hello with the version the server picked and the room joined,
+// and the saved profile when hello logged in with one.
+// Account: who the connection signed in as, nil for guests.
+type Welcome struct {
+	Version int      `json:"version"`
+	Room    string   `json:"room"`
+	Profile *Profile `json:"profile,omitempty"`
+	Account *Account `json:"account,omitempty"`
+}
+
+// Account is a signed in player as the accounts service knows them.
+// Rating: their Elo rating from games between signed in players.
+type Account struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Rating int    `json:"rating"`
+}
+
+// Profile is a player's saved settings and record, carried over between sessions.
+// Color: avatar colour as #rrggbb.
+// PreferredVariant: the rule variant rooms they create use unless the url picks one.
+type Profile struct {
+	ID               string       `json:"id"`
+	Name             string       `json:"name"`
+	Color            string       `json:"color,omitempty"`
+	PreferredVariant string       `json:"preferredVariant,omitempty" schema:"enum=misere|wild|orderChaos"`
+	Stats            ProfileStats `json:"stats"`
+}
+
+// ProfileStats counts a profile's finished games.
+type ProfileStats struct {
+	Played int `json:"played"`
+	Wins   int `json:"wins"`
+	Losses int `json:"losses"`
+	Draws  int `json:"draws"`
+}
+
+// SetName changes the sender's display name in the room. The server answers
+// with the name now in use, or an invalidName or nameTaken error.
+type SetName struct {
+	Name string `json:"name" schema:"minLength=1,maxLength=20"`
+}
+
+func (s *SetName) Validate() error {
+	if s.Name == "" {
+		return errors.New("name is required")
+	}
+	return nil
+}
+
+// Error tells the client why a message was rejected.
+// RefSeq and RefType point at the offending message when known.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	RefSeq  uint64 `json:"refSeq,omitempty"`
+	RefType string `json:"refType,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Chat is a chat line. Clients only send Text, the server fills in Sender.
+// Channel is set by the server: ChannelSpectators for the spectator chat
+// players can't see during a game, empty for the room chat.
+type Chat struct {
+	Sender  string `json:"sender,omitempty"`
+	Text    string `json:"text" schema:"minLength=1,maxLength=500"`
+	Channel string `json:"channel,omitempty" schema:"enum=spectators"`
+}
+
+// ChannelSpectators marks chat from spectators, see Chat.
+const ChannelSpectators = "spectators"
+
+func (c *Chat) Validate() error {
+	if c.Text == "" {
+		return errors.New("text is required")
+	}
+	if len(c.Text) > 500 {
+		return errors.New("text is longer than 500 characters")
+	}
+	return nil
+}
+
+// Emojis are the reactions there are, in the order to offer them.
+var Emojis = []string{"👍", "👏", "🔥", "😮", "😂", "🤔"}
+
+// Reaction is an emoji from Emojis, on the room or on one move of the game.
+// Move numbers the game's moves from 1, 0 reacts to the room.
+// The server's broadcast adds the Sender and, for a move, Counts: how many
+// people reacted to it with each emoji so far.
+type Reaction struct {
+	Sender string         `json:"sender,omitempty"`
+	Emoji  string         `json:"emoji" schema:"enum=👍|👏|🔥|😮|😂|🤔"`
+	Move   int            `json:"move,omitempty" schema:"minimum=0"`
+	Counts map[string]int `json:"counts,omitempty"`
+}
+
+func (r *Reaction) Validate() error {
+	if !slices.Contains(Emojis, r.Emoji) {
+		return fmt.Errorf("emoji must be one of %v", Emojis)
+	}
+	if r.Move < 0 {
+		return errors.New("move can't be negative")
+	}
+	if r.Counts != nil && len(r.Counts) == 0 {
+		return errors.New("counts can't be empty")
+	}
+	for emoji, n := range r.Counts {
+		if !slices.Contains(Emojis, emoji) || n < 1 {
+			return fmt.Errorf("can't count %d of %q", n, emoji)
+		}
+	}
+	return nil
+}
+
+// Phrase is a canned quick chat line.
+type Phrase struct {
+	ID   string
+	Text string
+}
+
+// QuickChats are the phrases quick chat can send, in the order to offer them.
+var QuickChats = []Phrase{
+	{"glhf", "Good luck, have fun!"},
+	{"nice", "Nice move!"},
+	{"thinking", "Let me think..."},
+	{"oops", "Oops!"},
+	{"gg", "Good game!"},
+	{"rematch", "Rematch?"},
+	{"thanks", "Thanks!"},
+}
+
+// QuickChatText is the text of the quick chat phrase with id.
+func QuickChatText(id string) (string, bool) {
+	for _, p := range QuickChats {
+		if p.ID == id {
+			return p.Text, true
+		}
+	}
+	return "", false
+}
+
+// QuickChat sends one of the QuickChats by its ID, the server passes it on
+// as a chat line from the sender. Unlike free text it can't carry anything
+// but the phrase.
+type QuickChat struct {
+	Phrase string `json:"phrase" schema:"enum=glhf|nice|thinking|oops|gg|rematch|thanks"`
+}
+
+func (q *QuickChat) Validate() error {
+	if _, ok := QuickChatText(q.Phrase); !ok {
+		return fmt.Errorf("unknown phrase %q", q.Phrase)
+	}
+	return nil
+}
+
+// Move places a symbol. Clients send Position, or Cell on a 3D board, the
+// server's broadcast adds the Symbol placed and, on 3D boards, the Cell too.
+// When a move has both they must name the same square.
+// Position is a pointer because 0 is a real cell.
+// In variants where players pick X or O for each move (wild, Order and Chaos)
+// clients send the Symbol they place, their own when they leave it out, and
+// the broadcast adds the Player who moved.
+type Move struct {
+	Position *int   `json:"position,omitempty" schema:"minimum=0"`
+	Cell     *Cell  `json:"cell,omitempty"`
+	Symbol   string `json:"symbol,omitempty" schema:"enum=X|O"`
+	Player   string `json:"player,omitempty" schema:"enum=X|O"`
+}
+
+func (m *Move) Validate() error {
+	switch {
+	case m.Position == nil && m.Cell == nil:
+		return errors.New("position or cell is required")
+	case m.Position != nil && *m.Position < 0:
+		return errors.New("position can't be negative")
+	case m.Cell != nil && (m.Cell.Layer < 0 || m.Cell.Row < 0 || m.Cell.Col < 0):
+		return errors.New("cell can't be negative")
+	case m.Symbol != "" && m.Symbol != "X" && m.Symbol != "O":
+		return errors.New("symbol must be X or O")
+	case m.Player != "" && m.Player != "X" && m.Player != "O":
+		return errors.New("player must be X or O")
+	}
+	return nil
+}
+
+// At builds the Move a client sends for position.
+func At(position int) *Move {
+	return &Move{Position: &position}
+}
+
+// AtCell builds the Move a client sends for a cell of a 3D board.
+func AtCell(layer, row, col int) *Move {
+	return &Move{Cell: &Cell{Layer: layer, Row: row, Col: col}}
+}
+
+// Cell is a square of a 3D board, layer 0 is the top one. Position is
+// layer*size*size + row*size + col.
+type Cell struct {
+	Layer int `json:"layer" schema:"minimum=0"`
+	Row   int `json:"row" schema:"minimum=0"`
+	Col   int `json:"col" schema:"minimum=0"`
+}
+
+// Rules is the board a room plays on: Size x Size, Line in a row to win,
+// and Depth layers on a 3D board (0 on a flat one).
+// Variant: empty for the standard game, or misere, wild or orderChaos.
+// PickSymbol: players choose X or O for each move, see Move.
+// Description: how the game is won, to show players.
+type Rules struct {
+	Size        int    `json:"size"`
+	Line        int    `json:"line"`
+	Depth       int    `json:"depth,omitempty"`
+	Variant     string `json:"variant,omitempty" schema:"enum=misere|wild|orderChaos"`
+	PickSymbol  bool   `json:"pickSymbol,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// System is a GAMEMASTER announcement.
+type System struct {
+	Text string `json:"text"`
+}
+
+// AssignPlayer tells a client which seat it got.
+type AssignPlayer struct {
+	UserName string `json:"userName"`
+	Symbol   string `json:"symbol" schema:"enum=X|O"`
+}
+
+// LobbyFull tells a client both seats are taken and it is spectating.
+type LobbyFull struct {
+	UserName string `json:"userName"`
+	Text     string `json:"text"`
+}
+
+// UpdateTurn says whose move it is.
+type UpdateTurn struct {
+	Symbol string `json:"symbol" schema:"enum=X|O"`
+}
+
+// Snapshot is the whole room state, sent on joining and when a resync gap is
+// too big to replay. The envelope's Seq is the last room event it includes.
+// Board: one entry per cell, "", "X" or "O".
+// Rules: the board's shape.
+// Turn: whose move it is, empty while waiting for players.
+// Players: seated player names by symbol.
+// Stake: what each player puts up per game in a room played for stakes, the winner takes both.
+type Snapshot struct {
+	Board   []string          `json:"board"`
+	Rules   *Rules            `json:"rules,omitempty"`
+	Turn    string            `json:"turn,omitempty" schema:"enum=X|O"`
+	Players map[string]string `json:"players"`
+	Stake   int64             `json:"stake,omitempty"`
+}
+
+// Resync asks for every room event from FromSeq on, after the client noticed a gap.
+type Resync struct {
+	FromSeq uint64 `json:"fromSeq"`
+}
+
+// GameOver ends a game, Winner is empty on a draw.
+type GameOver struct {
+	Winner string `json:"winner,omitempty" schema:"enum=X|O"`
+	Text   string `json:"text"`
+}
+
+// WatchTournament subscribes the connection to a tournament's bracket.
+type WatchTournament struct {
+	ID string `json:"id" schema:"minLength=1"`
+}
+
+func (w *WatchTournament) Validate() error {
+	if w.ID == "" {
+		return errors.New("id is required")
+	}
+	return nil
+}
+
+// TournamentUpdate carries the bracket, the same JSON as GET /tournaments/{id}.
+type TournamentUpdate struct {
+	Tournament any `json:"tournament"`
+}
+
+// Analyze asks the solver about a position, the same body as POST /analyze.
+// Board: one entry per cell, "", "X" or "O", on a square board.
+// Turn: the side to move.
+// Line: how many in a row win, the board's side when left out.
+type Analyze struct {
+	Board []string `json:"board" schema:"minItems=1"`
+	Turn  string   `json:"turn" schema:"enum=X|O"`
+	Line  int      `json:"line,omitempty" schema:"minimum=1"`
+}
+
+func (a *Analyze) Validate() error {
+	if len(a.Board) == 0 {
+		return errors.New("board is required")
+	}
+	if a.Turn != "X" && a.Turn != "O" {
+		return errors.New("turn must be X or O")
+	}
+	if a.Line < 0 {
+		return errors.New("line can't be negative")
+	}
+	return nil
+}
+
+// Analysis answers analyze with the solved value of the position for the side
+// to move: win, draw or loss with perfect play, and for a win or loss the
+// plies until the game ends (distance to mate). Best is the strongest move,
+// Moves values every legal move.
+type Analysis struct {
+	Turn    string      `json:"turn" schema:"enum=X|O"`
+	Outcome string      `json:"outcome" schema:"enum=win|draw|loss"`
+	Plies   int         `json:"plies,omitempty"`
+	Best    int         `json:"best"`
+	Moves   []MoveValue `json:"moves"`
+}
+
+// MoveValue is one move in an Analysis, valued for the side playing it.
+type MoveValue struct {
+	Position int    `json:"position"`
+	Outcome  string `json:"outcome" schema:"enum=win|draw|loss"`
+	Plies    int    `json:"plies,omitempty"`
+}
+
+// PuzzleRequest asks for a "win in N" puzzle. ID asks for that one, otherwise
+// one is picked at random among those with N moves to win on a Size board
+// (0 for any), puzzles the user hasn't solved first.
+type PuzzleRequest struct {
+	ID   string `json:"id,omitempty"`
+	N    int    `json:"n,omitempty" schema:"minimum=0"`
+	Size int    `json:"size,omitempty" schema:"minimum=0"`
+}
+
+func (p *PuzzleRequest) Validate() error {
+	if p.N < 0 || p.Size < 0 {
+		return errors.New("n and size can't be negative")
+	}
+	return nil
+}
+
+// Puzzle is a position where Turn can force a win in N moves, counting the
+// winning one, on a Size x Size board with Line in a row to win.
+// Solved: how many puzzles the user has solved so far.
+type Puzzle struct {
+	ID     string   `json:"id"`
+	Board  []string `json:"board"`
+	Turn   string   `json:"turn" schema:"enum=X|O"`
+	Size   int      `json:"size"`
+	Line   int      `json:"line"`
+	N      int      `json:"n"`
+	Solved int      `json:"solved"`
+}
+
+// PuzzleMove plays Position in the puzzle being solved, ID is the puzzle's.
+type PuzzleMove struct {
+	ID       string `json:"id" schema:"minLength=1"`
+	Position *int   `json:"position" schema:"minimum=0"`
+}
+
+func (m *PuzzleMove) Validate() error {
+	if m.ID == "" {
+		return errors.New("id is required")
+	}
+	if m.Position == nil {
+		return errors.New("position is required")
+	}
+	if *m.Position < 0 {
+		return errors.New("position can't be negative")
+	}
+	return nil
+}
+
+// PuzzleResult answers a PuzzleMove.
+// Correct: the move keeps the forced win, otherwise the attempt is over.
+// Solved: the move won the puzzle.
+// Reply: the opponent's best defence, played on Board, when the puzzle goes on.
+// Left: moves still to find, counting the winning one.
+// SolvedCount: how many puzzles the user has solved so far.
+type PuzzleResult struct {
+	ID          string   `json:"id"`
+	Position    int      `json:"position"`
+	Correct     bool     `json:"correct"`
+	Solved      bool     `json:"solved"`
+	Reply       *int     `json:"reply,omitempty"`
+	Board       []string `json:"board"`
+	Left        int      `json:"left"`
+	SolvedCount int      `json:"solvedCount"`
+	Text        string   `json:"text"`
+}
+
+// spec describes one message type: its payload struct and who may send it
+type spec struct {
+	payload any
+	from    Role
+}
+
+// new returns a pointer to a fresh payload struct
+func (s spec) new() any {
+	return reflect.New(reflect.TypeOf(s.payload)).Interface()
+}
+
+// registry maps every message type to its payload, the schema is generated from it too
+var registry = map[string]spec{
+	TypeHello:            {Hello{}, FromClient},
+	TypeWelcome:          {Welcome{}, FromServer},
+	TypeError:            {Error{}, FromServer},
+	TypeChat:             {Chat{}, FromClient | FromServer},
+	TypeMove:             {Move{}, FromClient | FromServer},
+	TypeSystem:           {System{}, FromServer},
+	TypeAssignPlayer:     {AssignPlayer{}, FromServer},
+	TypeLobbyFull:        {LobbyFull{}, FromServer},
+	TypeUpdateTurn:       {UpdateTurn{}, FromServer},
+	TypeSnapshot:         {Snapshot{}, FromServer},
+	TypeResync:           {Resync{}, FromClient},
+	TypeGameOver:         {GameOver{}, FromServer},
+	TypeWatchTournament:  {WatchTournament{}, FromClient},
+	TypeTournamentUpdate: {TournamentUpdate{}, FromServer},
+	TypeAnalyze:          {Analyze{}, FromClient},
+	TypeAnalysis:         {Analysis{}, FromServer},
+	TypePuzzleRequest:    {PuzzleRequest{}, FromClient},
+	TypePuzzle:           {Puzzle{}, FromServer},
+	TypePuzzleMove:       {PuzzleMove{}, FromClient},
+	TypePuzzleResult:     {PuzzleResult{}, FromServer},
+	TypeSetName:          {SetName{}, FromClient | FromServer},
+	TypeReaction:         {Reaction{}, FromClient | FromServer},
+	TypeQuickChat:        {QuickChat{}, FromClient},
+}
+
+// typeOf finds the message type of a payload struct or pointer to one
+func typeOf(payload any) (string, bool) {
+	t := reflect.TypeOf(payload)
+	if t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	for name, s := range registry {
+		if reflect.TypeOf(s.payload) == t {
+			return name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,128 @@
+package protocol
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Schema describes every message as a JSON Schema (draft 2020-12), built from
+// the payload structs so it can't drift from the code. Fields without
+// omitempty are required, the schema struct tag adds constraints, e.g.
+// `schema:"minimum=0"` or `schema:"enum=X|O"`.
+func Schema() map[string]any {
+	types := make([]string, 0, len(registry))
+	for name := range registry {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+
+	defs := make(map[string]any)
+	var variants []any
+	for _, name := range types {
+		s := registry[name]
+		t := reflect.TypeOf(s.payload)
+		defs[t.Name()] = typeSchema(t)
+		variants = append(variants, map[string]any{
+			"description": t.Name() + ", sent by the " + s.from.String(),
+			"properties": map[string]any{
+				"type":    map[string]any{"const": name},
+				"payload": map[string]any{"$ref": "#/$defs/" + t.Name()},
+			},
+		})
+	}
+
+	return map[string]any{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"title":                "Tic-Tac-Toe websocket message",
+		"description":          "Every message on /ws is one of these envelopes. Start with hello, the server answers welcome or error.",
+		"type":                 "object",
+		"required":             []string{"v", "type"},
+		"additionalProperties": false,
+		"properties": map[string]any{
+			"v":       map[string]any{"type": "integer", "enum": SupportedVersions},
+			"type":    map[string]any{"type": "string", "enum": types},
+			"seq":     map[string]any{"type": "integer", "minimum": 0},
+			"ts":      map[string]any{"type": "string", "format": "date-time"},
+			"payload": map[string]any{"type": "object"},
+		},
+		"oneOf": variants,
+		"$defs": defs,
+	}
+}
+
+func (r Role) String() string {
+	switch r {
+	case FromClient:
+		return "client"
+	case FromServer:
+		return "server"
+	default:
+		return "client or server"
+	}
+}
+
+// typeSchema maps a Go type to its JSON Schema
+func typeSchema(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		return structSchema(t)
+	default:
+		// interfaces carry arbitrary JSON
+		return map[string]any{}
+	}
+}
+
+func structSchema(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if !f.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+
+		prop := typeSchema(f.Type)
+		if tag := f.Tag.Get("schema"); tag != "" {
+			for _, kv := range strings.Split(tag, ",") {
+				key, value, _ := strings.Cut(kv, "=")
+				if key == "enum" {
+					prop[key] = strings.Split(value, "|")
+				} else if n, err := strconv.Atoi(value); err == nil {
+					prop[key] = n
+				}
+			}
+		}
+		properties[name] = prop
+	}
+	return map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
@@ -0,0 +1,143 @@
+// Package puzzle makes and checks "win in N" puzzles: positions where the side
+// to move can force a win in N of its own moves whatever the defence. The
+// solver in the game package decides what is correct and plays the defence.
+package puzzle
+
+import (
+	"errors"
+	"math/rand"
+	"slices"
+
+	"goChatSocket/game"
+)
+
+// Errors from Generate and Attempt.Play.
+var (
+	ErrNotFound = errors.New("no puzzle found, try again or a bigger board")
+	ErrFinished = errors.New("the puzzle is over")
+)
+
+// how many random positions Generate looks at before giving up
+const generateTries = 2000
+
+// Puzzle is a position to solve.
+// ID: set by whoever stores it.
+// Turn: the side to move, who has the forced win.
+// N: the fewest moves Turn needs to win, counting the winning one.
+type Puzzle struct {
+	ID    string     `json:"id"`
+	Rules game.Rules `json:"rules"`
+	Board []string   `json:"board"`
+	Turn  string     `json:"turn"`
+	N     int        `json:"n"`
+}
+
+// Generate plays random games on an empty board until it reaches a position
+// where the side to move needs exactly n moves to force a win.
+func Generate(rng *rand.Rand, rules game.Rules, n int) (*Puzzle, error) {
+	if err := rules.Validate(); err != nil {
+		return nil, err
+	}
+	for try := 0; try < generateTries; try++ {
+		g := game.New(rules)
+		// stop somewhere in the middle game, leaving room for n moves each
+		stop := 1 + rng.Intn(max(1, rules.Cells()-2*n))
+		for i := 0; i < stop && !g.Over(); i++ {
+			moves := g.LegalMoves()
+			g.Play(moves[rng.Intn(len(moves))], g.Turn)
+		}
+		if g.Over() {
+			continue
+		}
+		needed, err := game.MovesToWin(g, n)
+		if err != nil {
+			continue // too big to search, try a quieter position
+		}
+		if needed == n {
+			return &Puzzle{Rules: rules, Board: slices.Clone(g.Board), Turn: g.Turn, N: n}, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Attempt is one go at solving a puzzle, see Play.
+type Attempt struct {
+	Puzzle *Puzzle
+	game   *game.Game
+	left   int
+	over   bool
+}
+
+// Start begins an attempt from the puzzle's position.
+func (p *Puzzle) Start() (*Attempt, error) {
+	g, err := game.FromPosition(p.Rules, p.Board, p.Turn)
+	if err != nil {
+		return nil, err
+	}
+	return &Attempt{Puzzle: p, game: g, left: p.N}, nil
+}
+
+// Step is how a move in an attempt went.
+// Correct: the move keeps the forced win within the moves left.
+// Solved: the move won, the puzzle is done.
+// Reply: the defence played in answer, -1 when there is none.
+// Left: the moves still to find, counting the winning one.
+type Step struct {
+	Correct bool `json:"correct"`
+	Solved  bool `json:"solved"`
+	Reply   int  `json:"reply"`
+	Left    int  `json:"left"`
+}
+
+// Board returns the position the attempt has reached.
+func (a *Attempt) Board() []string {
+	return slices.Clone(a.game.Board)
+}
+
+// Play checks a move. A correct move is answered with the defence that holds
+// out longest, a wrong one ends the attempt. Illegal moves return an error
+// and change nothing.
+func (a *Attempt) Play(pos int) (Step, error) {
+	if a.over {
+		return Step{}, ErrFinished
+	}
+	if err := a.game.Play(pos, a.game.Turn); err != nil {
+		return Step{}, err
+	}
+	if a.game.Winner() != "" {
+		a.over = true
+		return Step{Correct: true, Solved: true, Reply: -1}, nil
+	}
+
+	correct, err := game.Refutes(a.game, a.left-1)
+	if err != nil || !correct {
+		a.over = true
+		return Step{Reply: -1, Left: a.left}, err
+	}
+	a.left--
+	reply, err := bestDefense(a.game, a.left)
+	if err != nil {
+		a.over = true
+		return Step{}, err
+	}
+	a.game.Play(reply, a.game.Turn)
+	return Step{Correct: true, Reply: reply, Left: a.left}, nil
+}
+
+// bestDefense picks the reply after which the attacker needs the most moves,
+// the lowest cell on ties. Every reply loses within n.
+func bestDefense(g *game.Game, n int) (int, error) {
+	best, longest := -1, 0
+	for _, pos := range g.LegalMoves() {
+		next := g.Clone()
+		next.Play(pos, next.Turn)
+		needed, err := game.MovesToWin(next, n)
+		if err != nil {
+			return 0, err
+		}
+		if best == -1 || needed > longest {
+			best, longest = pos, needed
+		}
+	}
+	return best, nil
+}
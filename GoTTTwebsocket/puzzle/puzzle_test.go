@@ -0,0 +1,72 @@
+package puzzle
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+
+	"goChatSocket/game"
+)
+
+// correctMove finds a move that keeps the forced win, the way a solver would
+func correctMove(t *testing.T, p *Puzzle, board []string, left int) (good, bad int) {
+	t.Helper()
+	g, err := game.FromPosition(p.Rules, board, p.Turn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	good, bad = -1, -1
+	for _, pos := range g.LegalMoves() {
+		next := g.Clone()
+		next.Play(pos, next.Turn)
+		ok, err := game.Refutes(next, left-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok && good == -1 {
+			good = pos
+		} else if !ok && bad == -1 {
+			bad = pos
+		}
+	}
+	return good, bad
+}
+
+func TestSolve(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	for _, rules := range []game.Rules{{Size: 4, Line: 3}, {Size: 5, Line: 4}} {
+		for n := 1; n <= 3; n++ {
+			p, err := Generate(rng, rules, n)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// a wrong move ends the attempt
+			attempt, _ := p.Start()
+			if _, bad := correctMove(t, p, attempt.Board(), n); bad != -1 {
+				if step, _ := attempt.Play(bad); step.Correct {
+					t.Fatalf("%+v: wrong move %d accepted", p, bad)
+				}
+				if _, err := attempt.Play(0); !errors.Is(err, ErrFinished) {
+					t.Fatalf("play after the end: %v", err)
+				}
+			}
+
+			// the right moves win in exactly n, whatever the defence
+			attempt, _ = p.Start()
+			for moves := 1; ; moves++ {
+				good, _ := correctMove(t, p, attempt.Board(), n-moves+1)
+				step, err := attempt.Play(good)
+				if err != nil || !step.Correct {
+					t.Fatalf("%+v: move %d (%d) got %+v %v", p, moves, good, step, err)
+				}
+				if step.Solved {
+					if moves != n {
+						t.Fatalf("%+v solved in %d moves", p, moves)
+					}
+					break
+				}
+			}
+		}
+	}
+}
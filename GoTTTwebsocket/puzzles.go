@@ -0,0 +1,248 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+
+	"goChatSocket/game"
+	"goChatSocket/protocol"
+	"goChatSocket/puzzle"
+)
+
+// the puzzles kept in stock: board and moves to win
+var puzzleSets = []struct {
+	rules game.Rules
+	n     int
+}{
+	{game.Rules{Size: 4, Line: 3}, 2},
+	{game.Rules{Size: 4, Line: 3}, 3},
+	{game.Rules{Size: 5, Line: 4}, 2},
+	{game.Rules{Size: 5, Line: 4}, 3},
+	{game.Rules{Size: 6, Line: 4}, 2},
+	{game.Rules{Size: 6, Line: 4}, 3},
+}
+
+// how many puzzles of each set fill makes
+const puzzlesPerSet = 20
+
+// puzzleBook stores the puzzles and who solved which.
+// path: the JSON file the book is saved to, empty keeps it in memory.
+// solved: puzzle ids by user.
+type puzzleBook struct {
+	mu      sync.Mutex
+	path    string
+	puzzles []*puzzle.Puzzle
+	solved  map[string]map[string]bool
+}
+
+// puzzleFile is the book on disk
+type puzzleFile struct {
+	Puzzles []*puzzle.Puzzle    `json:"puzzles"`
+	Solved  map[string][]string `json:"solved"`
+}
+
+func newPuzzleBook() *puzzleBook {
+	return &puzzleBook{solved: make(map[string]map[string]bool)}
+}
+
+// load reads the book saved at path, if there is one, and saves it there from now on
+func (b *puzzleBook) load(path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.path = path
+
+	var file puzzleFile
+	if err := readJSONFile(path, &file); err != nil {
+		return err
+	}
+	for _, p := range file.Puzzles {
+		b.addLocked(p)
+	}
+	for user, ids := range file.Solved {
+		for _, id := range ids {
+			b.markLocked(user, id)
+		}
+	}
+	return nil
+}
+
+// save writes the book to its file, call with the lock held
+func (b *puzzleBook) save() error {
+	if b.path == "" {
+		return nil
+	}
+	file := puzzleFile{Puzzles: b.puzzles, Solved: make(map[string][]string)}
+	for user, ids := range b.solved {
+		for id := range ids {
+			file.Solved[user] = append(file.Solved[user], id)
+		}
+	}
+	return writeJSONFile(b.path, file)
+}
+
+// fill generates puzzles until every set has puzzlesPerSet, it takes a while
+// so the server runs it in the background
+func (b *puzzleBook) fill(rng *rand.Rand) {
+	for _, set := range puzzleSets {
+		for b.count(set.rules, set.n) < puzzlesPerSet {
+			p, err := puzzle.Generate(rng, set.rules, set.n)
+			if err != nil {
+				fmt.Println("Puzzle Error:", err)
+				break
+			}
+			b.add(p)
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.save(); err != nil {
+		fmt.Println("Puzzle Error:", err)
+	}
+}
+
+// count is how many puzzles the book has for rules and n
+func (b *puzzleBook) count(rules game.Rules, n int) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	count := 0
+	for _, p := range b.puzzles {
+		if p.Rules == rules && p.N == n {
+			count++
+		}
+	}
+	return count
+}
+
+// add stores a puzzle under an id made from its position, a position already in the book is skipped
+func (b *puzzleBook) add(p *puzzle.Puzzle) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.addLocked(p)
+}
+
+func (b *puzzleBook) addLocked(p *puzzle.Puzzle) {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%v|%s|%s", p.Rules, strings.Join(p.Board, ","), p.Turn)))
+	p.ID = fmt.Sprintf("%dx%d-%d-%x", p.Rules.Size, p.Rules.Size, p.N, sum[:4])
+	for _, existing := range b.puzzles {
+		if existing.ID == p.ID {
+			return
+		}
+	}
+	b.puzzles = append(b.puzzles, p)
+}
+
+// pick returns the puzzle asked for: by id, or at random among those matching
+// n and size (0 for any), the ones user hasn't solved yet first. nil if none match.
+func (b *puzzleBook) pick(user string, req *protocol.PuzzleRequest) *puzzle.Puzzle {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var fresh, seen []*puzzle.Puzzle
+	for _, p := range b.puzzles {
+		switch {
+		case req.ID != "" && p.ID != req.ID:
+		case req.N != 0 && p.N != req.N:
+		case req.Size != 0 && p.Rules.Size != req.Size:
+		case b.solved[user][p.ID]:
+			seen = append(seen, p)
+		default:
+			fresh = append(fresh, p)
+		}
+	}
+	if len(fresh) == 0 {
+		fresh = seen
+	}
+	if len(fresh) == 0 {
+		return nil
+	}
+	return fresh[rand.Intn(len(fresh))]
+}
+
+// markSolved records that user solved the puzzle and returns how many they have solved
+func (b *puzzleBook) markSolved(user, id string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.markLocked(user, id)
+	if err := b.save(); err != nil {
+		fmt.Println("Puzzle Error:", err)
+	}
+	return len(b.solved[user])
+}
+
+func (b *puzzleBook) markLocked(user, id string) {
+	if b.solved[user] == nil {
+		b.solved[user] = make(map[string]bool)
+	}
+	b.solved[user][id] = true
+}
+
+// solvedCount is how many puzzles user has solved
+func (b *puzzleBook) solvedCount(user string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.solved[user])
+}
+
+// sendPuzzle starts the puzzle c asked for
+func (s *Server) sendPuzzle(c *Client, req *protocol.PuzzleRequest) error {
+	p := s.puzzles.pick(c.user(), req)
+	if p == nil {
+		return &protocol.Error{Code: protocol.CodeNotFound, Message: "No puzzle like that yet, try again in a minute."}
+	}
+	attempt, err := p.Start()
+	if err != nil {
+		return &protocol.Error{Code: protocol.CodeNotFound, Message: err.Error()}
+	}
+	c.puzzle = attempt
+	c.reply(&protocol.Puzzle{
+		ID:     p.ID,
+		Board:  attempt.Board(),
+		Turn:   p.Turn,
+		Size:   p.Rules.Size,
+		Line:   p.Rules.Line,
+		N:      p.N,
+		Solved: s.puzzles.solvedCount(c.user()),
+	})
+	return nil
+}
+
+// playPuzzle checks c's move in their puzzle against the solver and answers
+// with the best defence
+func (s *Server) playPuzzle(c *Client, move *protocol.PuzzleMove) error {
+	attempt := c.puzzle
+	if attempt == nil || attempt.Puzzle.ID != move.ID {
+		return &protocol.Error{Code: protocol.CodeNotFound, Message: "Start the puzzle with puzzleRequest first."}
+	}
+	step, err := attempt.Play(*move.Position)
+	if err != nil {
+		return &protocol.Error{Code: protocol.CodeIllegalMove, Message: err.Error()}
+	}
+
+	result := &protocol.PuzzleResult{
+		ID:       move.ID,
+		Position: *move.Position,
+		Correct:  step.Correct,
+		Solved:   step.Solved,
+		Board:    attempt.Board(),
+		Left:     step.Left,
+	}
+	switch {
+	case step.Solved:
+		c.puzzle = nil
+		result.SolvedCount = s.puzzles.markSolved(c.user(), move.ID)
+		result.Text = "Solved!"
+	case step.Correct:
+		result.Reply = &step.Reply
+		result.SolvedCount = s.puzzles.solvedCount(c.user())
+		result.Text = fmt.Sprintf("Good move. %d to go.", step.Left)
+	default:
+		c.puzzle = nil
+		result.SolvedCount = s.puzzles.solvedCount(c.user())
+		result.Text = "That move lets the win slip, ask for the puzzle again to retry."
+	}
+	c.reply(result)
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math/rand"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"goChatSocket/client"
+	"goChatSocket/game"
+	"goChatSocket/puzzle"
+)
+
+func TestPuzzle(t *testing.T) {
+	server := newServer()
+	path := filepath.Join(t.TempDir(), "puzzles.json")
+	if err := server.puzzles.load(path); err != nil {
+		t.Fatal(err)
+	}
+	rules := game.Rules{Size: 4, Line: 3}
+	p, err := puzzle.Generate(rand.New(rand.NewSource(1)), rules, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.puzzles.add(p)
+	srv := httptest.NewServer(server.routes())
+	defer srv.Close()
+	c := dial(t, roomURL(t, "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", "training"))
+
+	c.RequestPuzzle(2, 4)
+	got := waitFor[client.PuzzleReceived](t, c, nil)
+	if got.ID != p.ID || got.N != 2 || got.Solved != 0 {
+		t.Fatalf("puzzle %+v", got)
+	}
+
+	// play whatever keeps the win until the server says it is solved
+	board, left := got.Board, got.N
+	for {
+		g, err := game.FromPosition(rules, board, got.Turn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		move := -1
+		for _, pos := range g.LegalMoves() {
+			next := g.Clone()
+			next.Play(pos, next.Turn)
+			if ok, _ := game.Refutes(next, left-1); ok {
+				move = pos
+				break
+			}
+		}
+		c.PuzzleMove(got.ID, move)
+		result := waitFor[client.PuzzleChecked](t, c, nil)
+		if !result.Correct {
+			t.Fatalf("move %d got %+v", move, result)
+		}
+		if result.Solved {
+			if result.SolvedCount != 1 {
+				t.Fatalf("solved count %d", result.SolvedCount)
+			}
+			break
+		}
+		if result.Reply < 0 || result.Board[result.Reply] == "" {
+			t.Fatalf("no defence in %+v", result)
+		}
+		board, left = result.Board, result.Left
+	}
+
+	// the book and the solved count survive a restart
+	book := newPuzzleBook()
+	if err := book.load(path); err != nil {
+		t.Fatal(err)
+	}
+	solved := 0
+	for _, ids := range book.solved {
+		solved += len(ids)
+	}
+	if book.count(rules, 2) != 1 || solved != 1 {
+		t.Fatalf("reloaded book has %d puzzles and %d solved", book.count(rules, 2), solved)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"goChatSocket/client"
+	"goChatSocket/protocol"
+)
+
+func TestQubicRoom(t *testing.T) {
+	srv := httptest.NewServer(newServer().routes())
+	defer srv.Close()
+	url := roomURL(t, "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", "cube") + "&mode=qubic"
+
+	x := dial(t, url)
+	snapshot := waitFor[client.Snapshot](t, x, nil)
+	if rules := snapshot.Rules; rules.Size != 4 || rules.Line != 4 || rules.Depth != 4 || len(snapshot.Board) != 64 {
+		t.Fatalf("snapshot rules %+v with %d cells", snapshot.Rules, len(snapshot.Board))
+	}
+	o := dial(t, url)
+
+	// a cell off the board is refused
+	waitFor(t, x, func(e client.TurnChanged) bool { return e.Symbol == "X" })
+	if err := x.MoveCell(4, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, x, func(e client.Rejected) bool { return e.Code == protocol.CodeIllegalMove })
+
+	// X takes the space diagonal by cell, O answers by position along the back row
+	for i := 0; i < 4; i++ {
+		if i > 0 {
+			waitFor(t, x, func(e client.TurnChanged) bool { return e.Symbol == "X" })
+		}
+		if err := x.MoveCell(i, i, i); err != nil {
+			t.Fatal(err)
+		}
+		moved := waitFor(t, o, func(e client.Moved) bool { return e.Symbol == "X" })
+		if moved.Cell == nil || *moved.Cell != (protocol.Cell{Layer: i, Row: i, Col: i}) || moved.Position != 21*i {
+			t.Fatalf("move %d broadcast as %+v", i, moved)
+		}
+		if i == 3 {
+			break
+		}
+		waitFor(t, o, func(e client.TurnChanged) bool { return e.Symbol == "O" })
+		if err := o.Move(48 + i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, o, func(e client.GameOver) bool { return e.Winner == "X" })
+
+	status, text := get(t, srv.URL+"/games/g1?format=ttn")
+	if status != http.StatusOK || !strings.Contains(text, `[Rules "4x4x4/4"]`) || !strings.Contains(text, "4. 4d4 1-0") {
+		t.Fatalf("status %d export:\n%s", status, text)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"goChatSocket/game"
+)
+
+// Elo settings: everyone starts at initialRating, ratingK is the most a game can move a rating
+const (
+	initialRating = 1200
+	ratingK       = 32
+)
+
+// rating is one account's place on the ladder.
+// Name: what they last played as.
+type rating struct {
+	Account int    `json:"account"`
+	Name    string `json:"name"`
+	Rating  int    `json:"rating"`
+	Games   int    `json:"games"`
+}
+
+// ratingBook keeps the Elo ratings of signed in players, games between two of them count.
+// path: the JSON file the book is saved to, empty keeps it in memory.
+type ratingBook struct {
+	mu      sync.Mutex
+	path    string
+	ratings map[int]*rating
+}
+
+func newRatingBook() *ratingBook {
+	return &ratingBook{ratings: make(map[int]*rating)}
+}
+
+// load reads the book saved at path, if there is one, and saves it there from now on
+func (b *ratingBook) load(path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.path = path
+
+	var ratings []*rating
+	if err := readJSONFile(path, &ratings); err != nil {
+		return err
+	}
+	for _, r := range ratings {
+		b.ratings[r.Account] = r
+	}
+	return nil
+}
+
+// save writes the book to its file, call with the lock held
+func (b *ratingBook) save() error {
+	if b.path == "" {
+		return nil
+	}
+	return writeJSONFile(b.path, b.ladder())
+}
+
+// get returns an account's rating, initialRating before their first rated game
+func (b *ratingBook) get(id int) rating {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if r, ok := b.ratings[id]; ok {
+		return *r
+	}
+	return rating{Account: id, Rating: initialRating}
+}
+
+// entry returns the account's rating to update, call with the lock held
+func (b *ratingBook) entry(acc *account, name string) *rating {
+	r, ok := b.ratings[acc.ID]
+	if !ok {
+		r = &rating{Account: acc.ID, Rating: initialRating}
+		b.ratings[acc.ID] = r
+	}
+	r.Name = name
+	return r
+}
+
+// record rates a game between x and o, winner is "" for a draw.
+// It returns how much each rating changed.
+func (b *ratingBook) record(x, o *account, xName, oName, winner string) (int, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	rx, ro := b.entry(x, xName), b.entry(o, oName)
+
+	score := 0.5
+	switch winner {
+	case game.X:
+		score = 1
+	case game.O:
+		score = 0
+	}
+	expected := 1 / (1 + math.Pow(10, float64(ro.Rating-rx.Rating)/400))
+	change := int(math.Round(ratingK * (score - expected)))
+	rx.Rating += change
+	ro.Rating -= change
+	rx.Games++
+	ro.Games++
+
+	if err := b.save(); err != nil {
+		fmt.Println("Rating Error:", err)
+	}
+	return change, -change
+}
+
+// ladder lists every rating, best first, call with the lock held
+func (b *ratingBook) ladder() []rating {
+	ratings := make([]rating, 0, len(b.ratings))
+	for _, r := range b.ratings {
+		ratings = append(ratings, *r)
+	}
+	sort.Slice(ratings, func(i, j int) bool {
+		if ratings[i].Rating != ratings[j].Rating {
+			return ratings[i].Rating > ratings[j].Rating
+		}
+		return ratings[i].Account < ratings[j].Account
+	})
+	return ratings
+}
+
+// handleRatings lists the rating ladder, best first
+func (s *Server) handleRatings(w http.ResponseWriter, r *http.Request) error {
+	s.hub.ratings.mu.Lock()
+	ladder := s.hub.ratings.ladder()
+	s.hub.ratings.mu.Unlock()
+	return WriteJSON(w, http.StatusOK, ladder)
+}
+
+// handleRating shows one account's rating
+func (s *Server) handleRating(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconv.Atoi(r.PathValue("account"))
+	if err != nil {
+		return notFound("account %s not found", r.PathValue("account"))
+	}
+	return WriteJSON(w, http.StatusOK, s.hub.ratings.get(id))
+}
+
+// room side
+
+// ratesAccounts reports whether the game in progress is between two different
+// signed in players and so will be rated, call with the lock held
+func (r *Room) ratesAccounts() bool {
+	x, o := r.gamePlayers[game.X], r.gamePlayers[game.O]
+	return x.Account != nil && o.Account != nil && x.Account.ID != o.Account.ID
+}
+
+// rateGame updates the ratings after a game between two signed in players and
+// tells the room, call with the lock held
+func (r *Room) rateGame(winner string) {
+	if !r.ratesAccounts() {
+		return
+	}
+	x, o := r.gamePlayers[game.X], r.gamePlayers[game.O]
+	xName, oName := r.playerName(game.X), r.playerName(game.O)
+	if r.record != nil {
+		xName, oName = r.record.X, r.record.O
+	}
+	_, span := startStorageSpan(r.traceCtx(), "ratings.record", attrRoom.String(r.ID))
+	dx, do := r.hub.ratings.record(x.Account, o.Account, xName, oName, winner)
+	span.End()
+	rx, ro := r.hub.ratings.get(x.Account.ID), r.hub.ratings.get(o.Account.ID)
+	r.broadcastSystem(fmt.Sprintf("Ratings: %s %d (%+d), %s %d (%+d).", xName, rx.Rating, dx, oName, ro.Rating, do))
+}
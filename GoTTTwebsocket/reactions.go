@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"goChatSocket/protocol"
+)
+
+// Reactions and quick chats are limited per connection to a burst of
+// reactionBurst, then one every reactionRefill. Both share the one budget.
+const (
+	reactionBurst  = 5
+	reactionRefill = 2 * time.Second
+)
+
+// rateLimit is a token bucket, used is how many tokens are spent as of last
+type rateLimit struct {
+	used float64
+	last time.Time
+}
+
+// allow spends a token if there is one
+func (l *rateLimit) allow(now time.Time) bool {
+	if !l.last.IsZero() {
+		l.used -= float64(now.Sub(l.last)) / float64(reactionRefill)
+		if l.used < 0 {
+			l.used = 0
+		}
+	}
+	l.last = now
+	if l.used+1 > reactionBurst {
+		return false
+	}
+	l.used++
+	return true
+}
+
+// spend takes one of c's reactions or quick chats, call with the lock held
+func (r *Room) spend(c *Client) error {
+	if !c.limit.allow(r.hub.clock.now()) {
+		return &protocol.Error{Code: protocol.CodeRateLimited, Message: "too many reactions, wait a moment"}
+	}
+	return nil
+}
+
+// movePlayed opens the move just played to reactions, call with the lock
+// held. The last game's reactions are kept until the next game's first move,
+// so the final move can still be reacted to after the game is over.
+func (r *Room) movePlayed() {
+	if len(r.game.Moves) == 1 {
+		r.reactions = nil
+	}
+	r.reactMoves = len(r.game.Moves)
+}
+
+// react broadcasts a reaction to the room, or to one of the moves played.
+// Reactions to a move are counted once per connection and emoji, and the
+// broadcast carries the move's counts. Reacting again the same way is ignored.
+// During a game spectators' reactions only go to spectators, like their chat,
+// and the counts players see leave them out until the game is over.
+func (r *Room) react(ctx context.Context, c *Client, reaction *protocol.Reaction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	defer r.within(ctx)()
+
+	move, emoji := reaction.Move, reaction.Emoji
+	if move > r.reactMoves {
+		return &protocol.Error{Code: protocol.CodeInvalidPayload, Message: fmt.Sprintf("there is no move %d to react to", move)}
+	}
+	if _, done := r.reactions[move][emoji][c]; move > 0 && done {
+		return nil
+	}
+	if err := r.spend(c); err != nil {
+		return err
+	}
+
+	held := r.reactingLive() && c.symbol == ""
+	out := &protocol.Reaction{Sender: c.name, Emoji: emoji, Move: move}
+	if move > 0 {
+		if r.reactions == nil {
+			r.reactions = make(map[int]map[string]map[*Client]bool)
+		}
+		if r.reactions[move] == nil {
+			r.reactions[move] = make(map[string]map[*Client]bool)
+		}
+		if r.reactions[move][emoji] == nil {
+			r.reactions[move][emoji] = make(map[*Client]bool)
+		}
+		r.reactions[move][emoji][c] = held
+		out.Counts = r.reactionCounts(move, held)
+	}
+	if !held {
+		r.broadcast(out)
+		return nil
+	}
+	enc := newEncoder(protocol.MustNew(out))
+	for c := range r.clients {
+		if c.symbol == "" {
+			c.push(enc)
+		}
+	}
+	return nil
+}
+
+// reactingLive reports whether reactions are to the game being played, not
+// to the last one while a rematch hasn't had its first move. Call with the lock held.
+func (r *Room) reactingLive() bool {
+	return r.started && len(r.game.Moves) > 0
+}
+
+// reactionCounts counts the reactions to move by emoji, spectators' reactions
+// during the game only when forSpectators. Call with the lock held.
+func (r *Room) reactionCounts(move int, forSpectators bool) map[string]int {
+	counts := make(map[string]int)
+	for emoji, who := range r.reactions[move] {
+		for _, held := range who {
+			if !held || forSpectators || !r.reactingLive() {
+				counts[emoji]++
+			}
+		}
+	}
+	return counts
+}
+
+// quickChat sends one of the canned phrases as a chat line from c, routed
+// like any other chat
+func (r *Room) quickChat(ctx context.Context, c *Client, phrase string) error {
+	text, ok := protocol.QuickChatText(phrase)
+	if !ok {
+		return &protocol.Error{Code: protocol.CodeInvalidPayload, Message: fmt.Sprintf("unknown phrase %q", phrase)}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	defer r.within(ctx)()
+	if err := r.spend(c); err != nil {
+		return err
+	}
+	r.say(c, text)
+	return nil
+}
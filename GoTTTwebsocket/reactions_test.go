@@ -0,0 +1,111 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"goChatSocket/client"
+	"goChatSocket/protocol"
+)
+
+func TestReactions(t *testing.T) {
+	url := roomURL(t, startServer(t, nil), "cheering")
+	x, o := dial(t, url), dial(t, url)
+	spectator := dial(t, url)
+	waitFor[client.Spectating](t, spectator, nil)
+	play(t, x, o, 4)
+	waitFor(t, spectator, func(e client.Moved) bool { return e.Position == 4 })
+
+	// reactions to a move are counted once per connection and emoji
+	react := func(c *client.Client, emoji string, move int) {
+		t.Helper()
+		if err := c.React(emoji, move); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// each reaction is waited for, connections aren't ordered among themselves
+	seen := func(by, to *client.Client, emoji string, move int, counts map[string]int) {
+		t.Helper()
+		react(by, emoji, move)
+		if e := waitFor[client.Reacted](t, to, nil); e.Emoji != emoji || e.Move != move || e.Sender != by.UserName() || !reflect.DeepEqual(e.Counts, counts) {
+			t.Fatalf("%s on move %d arrived as %+v, want counts %v", emoji, move, e, counts)
+		}
+	}
+	// during the game spectators react among themselves, players don't see it
+	// nor count it, so the next reaction x sees is o's
+	seen(spectator, spectator, "🔥", 1, map[string]int{"🔥": 1})
+	seen(o, x, "🔥", 1, map[string]int{"🔥": 1})
+	// o's second 🔥 isn't broadcast, the next reaction x sees is the 👏
+	react(o, "🔥", 1)
+	seen(o, x, "👏", 1, map[string]int{"🔥": 1, "👏": 1})
+	seen(o, x, "👍", 0, nil)
+
+	// moves not played yet can't be reacted to
+	react(x, "👍", 2)
+	if e := waitFor[client.Rejected](t, x, nil); e.Code != protocol.CodeInvalidPayload || e.RefType != protocol.TypeReaction {
+		t.Fatalf("reacting to move 2 of 1: %+v", e)
+	}
+
+	// quick chat goes where chat from the sender goes
+	if err := x.QuickChat("glhf"); err != nil {
+		t.Fatal(err)
+	}
+	if e := waitFor[client.ChatReceived](t, o, nil); e.Text != "Good luck, have fun!" || e.Channel != "" {
+		t.Fatalf("player quick chat arrived as %+v", e)
+	}
+	if err := spectator.QuickChat("nice"); err != nil {
+		t.Fatal(err)
+	}
+	if e := waitFor(t, spectator, func(e client.ChatReceived) bool { return e.Sender != x.UserName() }); e.Text != "Nice move!" || e.Channel != protocol.ChannelSpectators {
+		t.Fatalf("spectator quick chat arrived as %+v", e)
+	}
+
+	// reactions and quick chats share one budget, the spectator has spent two
+	for range reactionBurst - 1 {
+		react(spectator, "😂", 0)
+	}
+	if e := waitFor[client.Rejected](t, spectator, nil); e.Code != protocol.CodeRateLimited {
+		t.Fatalf("burst refused with %+v", e)
+	}
+
+	// after the game the last move can still be reacted to, until the next game starts
+	if err := o.Move(0); err != nil {
+		t.Fatal(err)
+	}
+	play(t, x, o, 1, 3, 7)
+	waitFor[client.GameOver](t, x, nil)
+	react(o, "😮", 5)
+	if e := waitFor(t, x, func(e client.Reacted) bool { return e.Emoji == "😮" }); e.Move != 5 || e.Counts["😮"] != 1 {
+		t.Fatalf("reaction after the game: %+v", e)
+	}
+	// and spectators' reactions reach everyone again, this one still has a budget
+	fresh := dial(t, url)
+	waitFor[client.Spectating](t, fresh, nil)
+	react(fresh, "😮", 5)
+	if e := waitFor(t, x, func(e client.Reacted) bool { return e.Emoji == "😮" }); e.Sender != fresh.UserName() || e.Counts["😮"] != 2 {
+		t.Fatalf("spectator's reaction after the game: %+v", e)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	var l rateLimit
+	start := time.Now()
+	for i := range reactionBurst {
+		if !l.allow(start) {
+			t.Fatalf("refused %d of a burst of %d", i+1, reactionBurst)
+		}
+	}
+	if l.allow(start) {
+		t.Fatal("allowed more than the burst")
+	}
+	if l.allow(start.Add(reactionRefill / 2)) {
+		t.Fatal("allowed one before it refilled")
+	}
+	if !l.allow(start.Add(reactionRefill)) {
+		t.Fatal("refused one after it refilled")
+	}
+	if !l.allow(start.Add(20 * reactionRefill)) {
+		t.Fatal("refused after a long wait")
+	}
+}
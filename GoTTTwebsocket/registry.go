@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// clientRegistry knows every connection on the server by id, whatever room
+// it is in. The hub is the same for rooms.
+// prefix: put in front of every id, in a cluster it is the node name so ids are unique.
+type clientRegistry struct {
+	mu      sync.Mutex
+	prefix  string
+	nextID  int
+	clients map[string]*Client
+}
+
+func newClientRegistry() *clientRegistry {
+	return &clientRegistry{clients: make(map[string]*Client)}
+}
+
+// add gives a client its id, unless it has one from another node, and registers it
+func (reg *clientRegistry) add(c *Client) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if c.id == "" {
+		reg.nextID++
+		c.id = fmt.Sprintf("%sc%d", reg.prefix, reg.nextID)
+	}
+	reg.clients[c.id] = c
+}
+
+func (reg *clientRegistry) remove(c *Client) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	delete(reg.clients, c.id)
+}
+
+// get returns the client with id, or nil
+func (reg *clientRegistry) get(id string) *Client {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	return reg.clients[id]
+}
+
+// fromIP lists the clients connected from an address, oldest first
+func (reg *clientRegistry) fromIP(ip string) []*Client {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	var list []*Client
+	for _, c := range reg.clients {
+		if c.ip == ip {
+			list = append(list, c)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].connected.Before(list[j].connected) })
+	return list
+}
@@ -0,0 +1,595 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"goChatSocket/game"
+	"goChatSocket/notation"
+	"goChatSocket/protocol"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// how many past events a room keeps for clients that resync
+const eventLogSize = 256
+
+// errNotPlaying rejects moves from spectators and moves before the game starts
+var errNotPlaying = errors.New("game has not started or you are not playing")
+
+// seat is a reserved place at the board, claimed by connecting with ?token=
+type seat struct {
+	token string
+	name  string
+}
+
+// roomOptions change how a room behaves.
+// autoRematch: start a new game with the same players after gameOver.
+// keep: don't remove the room when it empties (tournament rooms waiting for players).
+// seats: reserved seats by symbol, only matching tokens may sit down.
+// onGameOver: called with the winning symbol ("" for a draw) after each game, without the room lock held.
+// spectatorDelay: how long spectators wait for each event, 0 shows them the game live (see spectators.go).
+// replicate: called with the room state after every event, with the room lock held (see cluster.go).
+// rated: games count for something (tournament matches), no analysis while one is played.
+// rules: the board played on, the zero value is the classic 3x3 one.
+// stake: what each player puts in escrow per game, the winner takes both (see wagers.go). Only signed in players sit down.
+type roomOptions struct {
+	autoRematch    bool
+	keep           bool
+	seats          map[string]seat
+	onGameOver     func(winner string)
+	spectatorDelay time.Duration
+	replicate      func(state roomState)
+	rated          bool
+	rules          game.Rules
+	stake          int64
+}
+
+// gameRules are the rules the room's games are played with
+func (o roomOptions) gameRules() game.Rules {
+	if o.rules == (game.Rules{}) {
+		return game.Classic
+	}
+	return o.rules
+}
+
+// Room is one board with its players and spectators.
+type Room struct {
+	ID  string
+	hub *Hub
+
+	mu      sync.Mutex
+	opts    roomOptions
+	clients map[*Client]bool
+	players map[string]*Client // seated players by symbol
+
+	// the game in progress, holds the board and whose turn it is (see the game package)
+	game *game.Game
+
+	// track if game is started, (needs two players)
+	started bool
+
+	// player and spectator counts - used in naming
+	playerCount    int
+	spectatorCount int
+
+	// seq numbers every broadcast, log keeps the last eventLogSize of them for resync
+	seq uint64
+	log []protocol.Envelope
+
+	// what spectators have seen so far when they watch with a delay
+	feed spectatorFeed
+
+	// spectator chat from the game in progress, shown to the players at gameOver
+	heldChat []*protocol.Chat
+
+	// seats kept for the players of a room taken over from another node, by symbol
+	returning map[string]seatState
+
+	// headers of the game in progress for the archive, the moves are in game
+	record *notation.Record
+
+	// who plays the game in progress by symbol, for their stats and ratings
+	gamePlayers map[string]gamePlayer
+
+	// the stakes on the game in progress, and whether we are waiting for the ledger to hold them
+	wager   *wager
+	holding bool
+
+	// who reacted to which move with which emoji, true for spectators reacting
+	// during the game, and how many moves there are to react to (see reactions.go)
+	reactions  map[int]map[string]map[*Client]bool
+	reactMoves int
+
+	// the trace of the operation holding the lock, its broadcasts and
+	// storage calls are traced as its children (see within)
+	ctx context.Context
+}
+
+// gamePlayer is who sits in a seat beyond their name.
+// Profile: their saved profile's id, empty if they have none.
+// Account: the account they signed in with, nil for guests.
+type gamePlayer struct {
+	Profile string   `json:"profile,omitempty"`
+	Account *account `json:"account,omitempty"`
+}
+
+func newRoom(hub *Hub, id string, opts roomOptions) *Room {
+	return &Room{
+		ID:      id,
+		hub:     hub,
+		opts:    opts,
+		clients: make(map[*Client]bool),
+		players: make(map[string]*Client),
+		game:    game.New(opts.gameRules()),
+	}
+}
+
+// join registers a client, seating them if there is a free (or reserved) seat
+func (r *Room) join(c *Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	defer r.within(c.ctx)()
+
+	c.room = r
+	r.clients[c] = true
+
+	// Send the room state first so every event after it continues from its seq,
+	// spectators get it as they see it (see spectators.go)
+	symbol := r.freeSeat(c)
+	c.symbol = symbol
+	r.sendSnapshot(c)
+
+	// if both seats are taken, spectator role assigned
+	if symbol != "" {
+		r.seat(c, symbol)
+	} else {
+		r.spectatorCount++
+		c.name = r.chooseName(c, fmt.Sprintf("spectator-%d", r.spectatorCount))
+
+		// Notify spectator of status
+		text := "The game lobby is full. You are now spectating."
+		if r.opts.stake > 0 && c.account == nil {
+			text = "This room plays for stakes, sign in to play. You are now spectating."
+		}
+		c.reply(&protocol.LobbyFull{UserName: c.name, Text: text})
+
+		// Broadcast spectator join message
+		r.broadcastSystem(fmt.Sprintf("%s has joined as a spectator.", c.name))
+	}
+
+	r.maybeStart()
+}
+
+// freeSeat picks the seat for a new client: their reserved seat if the room
+// has reservations, otherwise X then O. Empty means spectate.
+// Seats kept for returning players only go to them.
+func (r *Room) freeSeat(c *Client) string {
+	if r.opts.stake > 0 && c.account == nil {
+		return ""
+	}
+	for _, symbol := range []string{game.X, game.O} {
+		if r.players[symbol] != nil {
+			continue
+		}
+		if returning, ok := r.returning[symbol]; ok {
+			if returning.Conn == c.id {
+				return symbol
+			}
+			continue
+		}
+		if r.opts.seats == nil || (c.token != "" && r.opts.seats[symbol].token == c.token) {
+			return symbol
+		}
+	}
+	return ""
+}
+
+// seat puts a client in the seat for symbol and tells everyone
+func (r *Room) seat(c *Client, symbol string) {
+	c.symbol = symbol
+	r.players[symbol] = c
+	if returning, ok := r.returning[symbol]; ok {
+		c.name = returning.Name
+		delete(r.returning, symbol)
+	} else if reserved := r.opts.seats[symbol].name; reserved != "" {
+		c.name = reserved
+	} else if c.name == "" {
+		r.playerCount++
+		c.name = r.chooseName(c, fmt.Sprintf("player-%d", r.playerCount))
+	}
+
+	// Notify player of assignment
+	c.reply(&protocol.AssignPlayer{UserName: c.name, Symbol: symbol})
+
+	// Broadcast player join message
+	r.broadcastSystem(fmt.Sprintf("%s has joined the game.", c.name))
+}
+
+// maybeStart starts the game when both seats are filled
+func (r *Room) maybeStart() {
+	if r.started || r.players[game.X] == nil || r.players[game.O] == nil {
+		return
+	}
+	if r.opts.stake > 0 && r.wager == nil {
+		r.holdStakes()
+		return
+	}
+	r.started = true
+	r.game = game.New(r.opts.gameRules())
+	r.record = r.newRecord()
+	r.gamePlayers = r.seatedPlayers()
+	r.broadcastSystem("Game has started! It's X's turn.")
+	if r.game.Rules.Variant != game.Standard {
+		r.broadcastSystem(r.game.Rules.Description())
+	}
+	r.broadcast(&protocol.UpdateTurn{Symbol: r.game.Turn})
+}
+
+// leave removes a client, a player leaving midgame forfeits
+func (r *Room) leave(c *Client) {
+	r.mu.Lock()
+	done := r.within(c.ctx)
+
+	delete(r.clients, c)
+	var onGameOver func(string)
+	var winner string
+	if c.symbol != "" && r.players[c.symbol] == c {
+		delete(r.players, c.symbol)
+		r.broadcastSystem(fmt.Sprintf("%s has left the game.", c.name))
+
+		if r.started {
+			winner, onGameOver = r.forfeit(c.symbol, c.name)
+		}
+	}
+	done()
+	r.mu.Unlock()
+
+	if onGameOver != nil {
+		onGameOver(winner)
+	}
+	r.hub.removeIfEmpty(r)
+}
+
+// forfeit ends the game in progress against the player of symbol, who is gone.
+// It returns the winner and the game over callback for the caller to run once unlocked.
+func (r *Room) forfeit(symbol, name string) (string, func(string)) {
+	winner := game.Other(symbol)
+	r.broadcast(&protocol.GameOver{
+		Winner: winner,
+		Text:   fmt.Sprintf("%s left the game. User-%s Wins!", name, winner),
+	})
+	return winner, r.finishGame(winner, "abandoned")
+}
+
+// chat relays a chat line to the room, the sender is always the connection's own name.
+// While a game is running spectators only chat among themselves.
+func (r *Room) chat(ctx context.Context, c *Client, text string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	defer r.within(ctx)()
+	r.say(c, text)
+}
+
+// say sends a chat line from c where chat goes, call with the lock held
+func (r *Room) say(c *Client, text string) {
+	if r.started && c.symbol == "" {
+		r.spectatorChat(c, text)
+		return
+	}
+	r.broadcast(&protocol.Chat{Sender: c.name, Text: text})
+}
+
+// args
+// ctx: The trace of the message the move came in, validating and playing it are its children.
+// c *Client: The client of the player making the move, their seat decides the symbol.
+// position int: The board position where the player wants to place their symbol (accept 0 value).
+// The returned error says why the move was rejected, the caller sends it back to the client.
+func (r *Room) handleMove(ctx context.Context, c *Client, move *protocol.Move) error {
+	winner, onGameOver, err := r.playMove(ctx, c, move)
+	if onGameOver != nil {
+		onGameOver(winner)
+	}
+	return err
+}
+
+// playMove validates and plays a move under the lock. When it ends the game
+// it returns the winner and the game over callback for the caller to run once unlocked.
+func (r *Room) playMove(ctx context.Context, c *Client, move *protocol.Move) (string, func(string), error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// players place their own symbol unless the variant lets them pick
+	symbol, placed := c.symbol, move.Symbol
+	if placed == "" {
+		placed = symbol
+	}
+	position, err := r.validateMove(ctx, c, move, placed)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// the state update and the events it sends are traced together
+	ctx, span := startSpan(ctx, "move.apply", append(clientAttrs(c, r.ID), attrPos.Int(position))...)
+	defer span.End()
+	defer r.within(ctx)()
+	if err := r.game.Place(position, symbol, placed); err != nil {
+		return "", nil, err
+	}
+	r.movePlayed()
+
+	// Broadcast the move to all clients, with the cell too on a 3D board
+	broadcast := &protocol.Move{Position: &position, Symbol: placed}
+	if r.game.Rules.PickSymbol() {
+		broadcast.Player = symbol
+	}
+	if r.game.Rules.Depth > 0 {
+		layer, row, col := r.game.Rules.Coords(position)
+		broadcast.Cell = &protocol.Cell{Layer: layer, Row: row, Col: col}
+	}
+	r.broadcast(broadcast)
+
+	if !r.game.Over() {
+		// Notify players of the turn change
+		r.broadcast(&protocol.UpdateTurn{Symbol: r.game.Turn})
+		return "", nil, nil
+	}
+
+	winner := r.game.Winner()
+	if winner != "" {
+		// Announce the winner
+		r.broadcast(&protocol.GameOver{Winner: winner, Text: fmt.Sprintf("User-%s Wins!", winner)})
+	} else {
+		r.broadcast(&protocol.GameOver{Text: "It's a draw!"})
+	}
+	return winner, r.finishGame(winner, ""), nil
+}
+
+// validateMove checks a move without playing it and returns the cell it is
+// for, call with the lock held. The engine rejects out of bounds, out of turn
+// and occupied cells.
+func (r *Room) validateMove(ctx context.Context, c *Client, move *protocol.Move, placed string) (position int, err error) {
+	_, span := startSpan(ctx, "move.validate", append(clientAttrs(c, r.ID), attrSymbol.String(c.symbol))...)
+	defer endSpan(span, &err)
+	if !r.started || c.symbol == "" {
+		return 0, errNotPlaying
+	}
+	position, err = r.position(move)
+	if err != nil {
+		return 0, err
+	}
+	span.SetAttributes(attrPos.Int(position))
+	return position, r.game.Check(position, c.symbol, placed)
+}
+
+// position is the cell a move names, by position or by its 3D coordinates,
+// call with the lock held
+func (r *Room) position(move *protocol.Move) (int, error) {
+	if move.Cell == nil {
+		return *move.Position, nil
+	}
+	pos, err := r.game.Rules.Cell(move.Cell.Layer, move.Cell.Row, move.Cell.Col)
+	if err != nil {
+		return 0, err
+	}
+	if move.Position != nil && *move.Position != pos {
+		return 0, fmt.Errorf("position %d isn't cell %d,%d,%d", *move.Position, move.Cell.Layer, move.Cell.Row, move.Cell.Col)
+	}
+	return pos, nil
+}
+
+// snapshotRules describes the room's board for snapshots
+func (r *Room) snapshotRules() *protocol.Rules {
+	rules := r.game.Rules
+	return &protocol.Rules{
+		Size:        rules.Size,
+		Line:        rules.Line,
+		Depth:       rules.Depth,
+		Variant:     rules.Variant,
+		PickSymbol:  rules.PickSymbol(),
+		Description: rules.Description(),
+	}
+}
+
+// resync replays the events a client missed from fromSeq on, or sends a
+// snapshot when they are no longer in the log
+func (r *Room) resync(c *Client, fromSeq uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// delayed spectators can only catch up to what has been released to them
+	latest := r.seq
+	if r.delayed(c) {
+		latest = r.feed.sent
+	}
+
+	// nothing missed
+	if fromSeq == latest+1 {
+		return
+	}
+
+	// seq of the oldest event still in the log
+	first := r.seq - uint64(len(r.log)) + 1
+	if fromSeq < first || fromSeq > latest {
+		r.sendSnapshot(c)
+		return
+	}
+	for _, msg := range r.log[fromSeq-first : latest-first+1] {
+		c.queue(msg)
+	}
+}
+
+// sendSnapshot sends one client the whole room state, numbered with the latest
+// event they have been sent
+func (r *Room) sendSnapshot(c *Client) {
+	if r.delayed(c) {
+		snapshot, seq := r.spectatorSnapshot()
+		msg := protocol.MustNew(snapshot)
+		msg.Seq = seq
+		c.queue(msg)
+		return
+	}
+
+	snapshot := &protocol.Snapshot{Board: r.game.Board, Rules: r.snapshotRules(), Players: r.playerNames(), Stake: r.opts.stake}
+	if r.started {
+		snapshot.Turn = r.game.Turn
+	}
+	msg := protocol.MustNew(snapshot)
+	msg.Seq = r.seq
+	c.queue(msg)
+}
+
+// playerNames lists the seated players' names by symbol
+func (r *Room) playerNames() map[string]string {
+	players := make(map[string]string)
+	for symbol, p := range r.players {
+		players[symbol] = p.name
+	}
+	return players
+}
+
+// finishGame archives the game, resets the board and, for ordinary rooms, starts a rematch.
+// termination says why the game ended early, empty when the board decided it.
+// It returns the room's game over callback for the caller to run once unlocked.
+func (r *Room) finishGame(winner, termination string) func(string) {
+	r.archiveGame(winner, termination)
+	r.recordStats(winner)
+	r.rateGame(winner)
+	r.settleWager(winner, termination)
+	r.gamePlayers = nil
+	r.started = false
+	r.game = game.New(r.opts.gameRules())
+	r.mergeChat()
+	if r.opts.autoRematch {
+		r.maybeStart()
+	}
+	return r.opts.onGameOver
+}
+
+// reserve replaces the reserved seats and reseats connected clients to match,
+// starting a game as soon as both players are present
+func (r *Room) reserve(seats map[string]seat) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.opts.seats = seats
+	r.reseat()
+}
+
+// configure replaces all the room options and reseats connected clients
+func (r *Room) configure(opts roomOptions) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.settleWager("", "reconfigured")
+	// spectators are sent what they still wait for of the game being dropped,
+	// the feed starts again from the new, maybe differently sized, board
+	r.flush()
+	r.started = false
+	r.game = game.New(opts.gameRules())
+	r.feed = spectatorFeed{sent: r.seq, board: append([]string(nil), r.game.Board...)}
+	r.opts = opts
+	r.mergeChat()
+	r.reseat()
+}
+
+// setSpectatorDelay changes how far behind spectators are, call with the lock held
+func (r *Room) setSpectatorDelay(delay time.Duration) {
+	if delay == 0 {
+		r.flush()
+	} else if r.opts.spectatorDelay == 0 {
+		// spectators were live until now, the feed starts from here
+		r.feed = spectatorFeed{sent: r.seq, board: append([]string(nil), r.game.Board...)}
+		if r.started {
+			r.feed.turn = r.game.Turn
+		}
+	}
+	r.opts.spectatorDelay = delay
+}
+
+// reseat empties the seats and fills them again from the connected clients
+func (r *Room) reseat() {
+	r.players = make(map[string]*Client)
+	for c := range r.clients {
+		c.symbol = ""
+	}
+	for c := range r.clients {
+		if symbol := r.freeSeat(c); symbol != "" {
+			r.seat(c, symbol)
+		}
+	}
+	r.maybeStart()
+}
+
+// release lets the room be removed once everyone has left
+func (r *Room) release() {
+	r.mu.Lock()
+	r.opts.keep = false
+	r.mu.Unlock()
+	r.hub.removeIfEmpty(r)
+}
+
+// empty reports whether the room can be removed
+func (r *Room) empty() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.clients) == 0 && !r.opts.keep
+}
+
+// broadcast numbers a payload as the room's next event, logs it and queues it
+// for everyone in the room, call with the lock held.
+// The envelope is built once and shared by every recipient, delayed
+// spectators get it when the room releases it to them.
+func (r *Room) broadcast(payload any) {
+	msg := protocol.MustNew(payload)
+	r.seq++
+	msg.Seq = r.seq
+	if len(r.log) == eventLogSize {
+		r.log = r.log[1:]
+	}
+	r.log = append(r.log, msg)
+
+	fmt.Printf("Broadcasting %s #%d to %s: %s\n", msg.Type, msg.Seq, r.ID, msg.Payload)
+	ctx, span := startSpan(r.traceCtx(), "broadcast", attrRoom.String(r.ID), attrType.String(msg.Type), attrSeq.Int64(int64(msg.Seq)))
+	defer span.End()
+	enc := newEncoder(msg)
+	if span.IsRecording() {
+		// every recipient's frame is sent in a span of its own under this one
+		enc.ctx, enc.room = ctx, r.ID
+	}
+	recipients := 0
+	for c := range r.clients {
+		if !r.delayed(c) {
+			c.push(enc)
+			recipients++
+		}
+	}
+	span.SetAttributes(attribute.Int("ttt.recipients", recipients))
+	if r.opts.spectatorDelay > 0 {
+		r.hold(msg, enc)
+	}
+	if r.opts.replicate != nil {
+		r.opts.replicate(r.state())
+	}
+}
+
+// within makes ctx the trace of the locked operation until the returned func
+// is called, call with the lock held: defer r.within(ctx)()
+func (r *Room) within(ctx context.Context) func() {
+	prev := r.ctx
+	r.ctx = ctx
+	return func() { r.ctx = prev }
+}
+
+// traceCtx is the trace of the operation holding the lock, call with the lock held
+func (r *Room) traceCtx() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+func (r *Room) broadcastSystem(text string) {
+	r.broadcast(&protocol.System{Text: text})
+}
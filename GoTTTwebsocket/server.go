@@ -0,0 +1,447 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+
+	"goChatSocket/game"
+	"goChatSocket/protocol"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/websocket"
+)
+
+// room ids come from the ?room= query, keep them short and url safe
+var validRoomID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Server ties the rooms and tournaments to the HTTP and websocket endpoints.
+// hub knows every room, clients every connection, puzzles the training puzzles.
+// cluster is set when rooms are shared with other instances, see joinCluster.
+// accounts checks login tokens, nil when everyone plays as a guest (see accounts.go).
+// web serves the page and its assets (see web.go).
+type Server struct {
+	hub         *Hub
+	clients     *clientRegistry
+	tournaments *tournamentManager
+	sse         *sseSessions
+	admin       *adminAPI
+	cluster     *cluster
+	puzzles     *puzzleBook
+	profiles    *profileBook
+	accounts    *accountService
+	web         *frontEnd
+	analyses    chan struct{} // one slot per analysis running
+}
+
+func newServer() *Server {
+	hub := newHub()
+	clients := newClientRegistry()
+	return &Server{
+		hub:         hub,
+		clients:     clients,
+		tournaments: newTournamentManager(hub),
+		sse:         newSSESessions(),
+		admin:       newAdminAPI(hub, clients),
+		puzzles:     newPuzzleBook(),
+		profiles:    hub.profiles,
+		web:         newFrontEnd(),
+		analyses:    make(chan struct{}, maxAnalyses),
+	}
+}
+
+// enableAdmin turns on the admin API for requests carrying token, audit also
+// gets every admin action as a line of JSON (nil keeps them in memory only)
+func (s *Server) enableAdmin(token string, audit io.Writer) {
+	s.admin.token = token
+	s.admin.audit.out = audit
+}
+
+// routes registers every endpoint
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+
+	// the page and its allow-listed assets, nothing else on disk is served (see web.go)
+	mux.HandleFunc("GET /{$}", makeHTTPHandleFunc(s.web.handleIndex))
+	mux.HandleFunc("GET /index.html", makeHTTPHandleFunc(s.web.handleIndex))
+	mux.HandleFunc("GET /assets/{name}", makeHTTPHandleFunc(s.web.handleAsset))
+	if s.web.dev {
+		mux.HandleFunc("GET /dev/reload", s.web.handleReload)
+	}
+
+	// sets up a WebSocket handler at the /ws path.
+	// ?room=<id> picks the room (default "main"), ?token=<seat token> claims a reserved seat,
+	// ?spectatorDelay=<duration> delays what spectators see in a room this connection creates
+	// an account login token signs in, as Authorization: Bearer <token> or ?access_token=
+	// the subprotocol picks the codec: ttt.json (the default) or ttt.msgpack
+	mux.Handle("/ws", websocket.Server{Handshake: wsHandshake, Handler: s.handleConnections})
+
+	// the same connection over server-sent events + POST, for proxies that block websockets (see sse.go)
+	mux.HandleFunc("GET /sse", s.handleSSE)
+	mux.HandleFunc("POST /sse/{session}", makeHTTPHandleFunc(s.handlePost))
+
+	// the solver, body and answer are the analyze and analysis payloads
+	mux.HandleFunc("POST /analyze", makeHTTPHandleFunc(s.handleAnalyze))
+
+	// finished games and the one being played in a room, as JSON or ?format=ttn (see notation)
+	mux.HandleFunc("GET /games", makeHTTPHandleFunc(s.handleGames))
+	mux.HandleFunc("POST /games", makeHTTPHandleFunc(s.handleImport))
+	mux.HandleFunc("GET /games/{id}", makeHTTPHandleFunc(s.handleGame))
+	mux.HandleFunc("GET /rooms/{id}/game", makeHTTPHandleFunc(s.handleRoomGame))
+
+	// saved profiles, the token from POST /profiles logs in as Authorization: Bearer <token> (see profiles.go)
+	mux.HandleFunc("POST /profiles", makeHTTPHandleFunc(s.handleCreateProfile))
+	mux.HandleFunc("GET /profile", makeHTTPHandleFunc(s.handleProfile))
+	mux.HandleFunc("PATCH /profile", makeHTTPHandleFunc(s.handleUpdateProfile))
+
+	// Elo ratings of signed in players (see ratings.go)
+	mux.HandleFunc("GET /ratings", makeHTTPHandleFunc(s.handleRatings))
+	mux.HandleFunc("GET /ratings/{account}", makeHTTPHandleFunc(s.handleRating))
+
+	// tournaments
+	mux.HandleFunc("GET /tournaments", makeHTTPHandleFunc(s.tournaments.handleList))
+	mux.HandleFunc("POST /tournaments", makeHTTPHandleFunc(s.tournaments.handleCreate))
+	mux.HandleFunc("GET /tournaments/{id}", makeHTTPHandleFunc(s.tournaments.handleGet))
+	mux.HandleFunc("POST /tournaments/{id}/players", makeHTTPHandleFunc(s.tournaments.handleRegister))
+	mux.HandleFunc("POST /tournaments/{id}/start", makeHTTPHandleFunc(s.tournaments.handleStart))
+
+	// admin console, every request needs the admin token (see admin.go)
+	a := s.admin
+	mux.HandleFunc("GET /admin/rooms", makeHTTPHandleFunc(a.authorized(a.handleRooms)))
+	mux.HandleFunc("GET /admin/rooms/{id}", makeHTTPHandleFunc(a.authorized(a.handleRoom)))
+	mux.HandleFunc("POST /admin/rooms/{id}/end", makeHTTPHandleFunc(a.action("endRoom", a.handleEndRoom)))
+	mux.HandleFunc("POST /admin/rooms/{id}/reset", makeHTTPHandleFunc(a.action("resetRoom", a.handleResetRoom)))
+	mux.HandleFunc("PATCH /admin/rooms/{id}/settings", makeHTTPHandleFunc(a.action("roomSettings", a.handleRoomSettings)))
+	mux.HandleFunc("GET /admin/connections", makeHTTPHandleFunc(a.authorized(a.handleConnections)))
+	mux.HandleFunc("POST /admin/connections/{id}/kick", makeHTTPHandleFunc(a.action("kick", a.handleKick)))
+	mux.HandleFunc("POST /admin/connections/{id}/ban", makeHTTPHandleFunc(a.action("banConnection", a.handleBanConnection)))
+	mux.HandleFunc("GET /admin/bans", makeHTTPHandleFunc(a.authorized(a.handleBans)))
+	mux.HandleFunc("POST /admin/bans", makeHTTPHandleFunc(a.action("ban", a.handleBan)))
+	mux.HandleFunc("DELETE /admin/bans/{ip}", makeHTTPHandleFunc(a.action("unban", a.handleUnban)))
+	mux.HandleFunc("POST /admin/announcements", makeHTTPHandleFunc(a.action("announce", a.handleAnnounce)))
+	mux.HandleFunc("GET /admin/audit", makeHTTPHandleFunc(a.authorized(a.handleAudit)))
+	mux.HandleFunc("GET /admin/wagers", makeHTTPHandleFunc(a.authorized(a.handleWagers)))
+	mux.HandleFunc("POST /admin/wagers/{id}/settle", makeHTTPHandleFunc(a.action("settleWager", a.handleSettleWager)))
+
+	return mux
+}
+
+func (s *Server) handleConnections(ws *websocket.Conn) {
+	// defers the execution until the surrounding function returns.
+	defer ws.Close()
+	conn := wsTransport{ws}
+	s.serve(conn, conn.codec(), ws.Request())
+}
+
+// serve runs one connection from hello to goodbye, whatever its transport.
+// codec is how its messages are encoded, r is the request that opened it
+// with ?room= and ?token= in its query.
+func (s *Server) serve(conn transport, codec protocol.Codec, r *http.Request) {
+	// the handshake is traced until the connection is in its room, signing in included
+	ctx, span := startSpan(r.Context(), "handshake",
+		attribute.String("ttt.transport", conn.name()), attribute.String("ttt.codec", codec.Name()))
+	r = r.WithContext(ctx)
+	refuse := func(perr *protocol.Error) {
+		sendError(conn, codec, perr)
+		traceRefusal(span, perr)
+		span.End()
+	}
+
+	if ban, ok := s.admin.bans.banned(hostOf(conn.remoteAddr())); ok {
+		refuse(&protocol.Error{Code: protocol.CodeBanned, Message: ban.message()})
+		return
+	}
+	acc, err := s.signIn(r)
+	if err != nil {
+		refuse(&protocol.Error{Code: protocol.CodeUnauthorized, Message: err.Error()})
+		return
+	}
+	query := r.URL.Query()
+
+	roomID := query.Get("room")
+	if roomID == "" {
+		roomID = defaultRoom
+	}
+	if !validRoomID.MatchString(roomID) {
+		refuse(&protocol.Error{Code: protocol.CodeInvalidRoom, Message: "Invalid room name."})
+		return
+	}
+	span.SetAttributes(attrRoom.String(roomID))
+	opts, err := roomQueryOptions(query)
+	if err == nil && opts.stake > 0 && (s.hub.escrow == nil || s.accounts == nil) {
+		err = errors.New("This server doesn't take stakes.")
+	}
+	if err != nil {
+		refuse(&protocol.Error{Code: protocol.CodeInvalidRoom, Message: err.Error()})
+		return
+	}
+
+	// The first message must be hello, agree on a protocol version before anything else
+	hello, version, err := handshake(conn, codec)
+	if err != nil {
+		fmt.Println("Handshake failed:", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		return
+	}
+
+	// hello may log in with a profile and ask for a name
+	var saved *profile
+	if hello.Profile != "" {
+		p, ok := s.profiles.get(hello.Profile)
+		if !ok {
+			refuse(&protocol.Error{Code: protocol.CodeUnknownProfile, Message: "Unknown profile, create one with POST /profiles."})
+			return
+		}
+		saved = &p
+	}
+	wantName := hello.Name
+	if wantName == "" && saved != nil {
+		wantName = saved.Name
+	}
+	if wantName == "" && acc != nil && checkName(acc.name()) == nil {
+		wantName = acc.name()
+	}
+	if wantName != "" {
+		if err := checkName(wantName); err != nil {
+			refuse(&protocol.Error{Code: protocol.CodeInvalidName, Message: err.Error()})
+			return
+		}
+	}
+	// a room this connection creates uses the profile's variant unless the url picks one
+	if saved != nil && saved.PreferredVariant != "" && query.Get("variant") == "" {
+		preferred := url.Values{}
+		for key, values := range query {
+			preferred[key] = values
+		}
+		preferred.Set("variant", saved.PreferredVariant)
+		if preferredOpts, err := roomQueryOptions(preferred); err == nil {
+			opts = preferredOpts
+		}
+	}
+
+	// Register user, the room decides if they play or spectate
+	c := newClient(conn, codec, query.Get("token"), version)
+	c.wantName, c.account, c.ctx = wantName, acc, ctx
+	welcome := &protocol.Welcome{Version: version, Room: roomID}
+	if saved != nil {
+		c.profile = saved.ID
+		welcome.Profile = saved.public()
+	}
+	if acc != nil {
+		welcome.Account = &protocol.Account{ID: acc.ID, Name: acc.name(), Rating: s.hub.ratings.get(acc.ID).Rating}
+	}
+	s.clients.add(c)
+	span.SetAttributes(attrConn.String(c.id))
+	c.reply(welcome)
+
+	// in a cluster the room may run on another node, room is nil while it does
+	var room *Room
+	if s.cluster != nil {
+		room = s.cluster.enter(c, roomID, opts)
+	} else {
+		room = s.hub.join(roomID, c, opts)
+	}
+	span.End()
+
+	// Listen for messages, each traced from when it is read until it is handled
+	for {
+		data, err := conn.receive(0)
+		if err != nil {
+			fmt.Println("Connection closed:", err)
+			break
+		}
+		ctx, msgSpan := otel.Tracer(tracerName).Start(context.Background(), "message",
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithLinks(trace.LinkFromContext(c.ctx)),
+			trace.WithAttributes(append(clientAttrs(c, roomID), attrBytes.Int(len(data)))...))
+		if s.cluster != nil {
+			if room = s.cluster.forward(ctx, c, roomID, data); room == nil {
+				msgSpan.End()
+				continue // sent to the node running the room
+			}
+		}
+		s.dispatch(ctx, c, room, data)
+		msgSpan.End()
+	}
+
+	s.tournaments.unwatch(c)
+	if s.cluster != nil {
+		s.cluster.leave(c, roomID)
+	} else {
+		room.leave(c)
+	}
+	s.clients.remove(c)
+	c.close()
+
+	// wait for the last messages to be written, the SSE response ends when we return
+	<-c.done
+}
+
+// roomQueryOptions reads the options for a room created by this connection,
+// ?spectatorDelay=30s holds events back from spectators, ?mode=qubic plays
+// on a 4x4x4 board (see game.Qubic), ?variant=misere, wild or orderChaos
+// changes the rules (see game.Rules.Variant) and ?stake=50 plays every game
+// for that much, up to maxStake, from each player's balance. A room that
+// already exists keeps its own options.
+func roomQueryOptions(query url.Values) (roomOptions, error) {
+	opts := roomOptions{autoRematch: true}
+	if delay := query.Get("spectatorDelay"); delay != "" {
+		d, err := time.ParseDuration(delay)
+		if err != nil || d < 0 || d > maxSpectatorDelay {
+			return opts, fmt.Errorf("Invalid spectator delay, use a duration up to %s like 30s.", maxSpectatorDelay)
+		}
+		opts.spectatorDelay = d
+	}
+	opts.rules = game.Classic
+	switch query.Get("mode") {
+	case "", "classic":
+	case "qubic":
+		opts.rules = game.Qubic
+	default:
+		return opts, fmt.Errorf("Unknown mode %q, use classic or qubic.", query.Get("mode"))
+	}
+	switch variant := query.Get("variant"); variant {
+	case game.Standard, game.Misere, game.Wild:
+		opts.rules.Variant = variant
+	case game.OrderAndChaos:
+		if opts.rules != game.Classic {
+			return opts, errors.New("Order and Chaos has its own board, leave out the mode.")
+		}
+		opts.rules = game.OrderAndChaosRules
+	default:
+		return opts, fmt.Errorf("Unknown variant %q, use misere, wild or orderChaos.", variant)
+	}
+	if stake := query.Get("stake"); stake != "" {
+		n, err := strconv.ParseInt(stake, 10, 64)
+		if err != nil || n < 1 || n > maxStake {
+			return opts, fmt.Errorf("Invalid stake, use a whole amount up to %d like 50.", maxStake)
+		}
+		opts.stake = n
+	}
+	return opts, nil
+}
+
+// dispatch handles one message from a client, ctx is the message's trace.
+// Anything we can't understand is answered with an error, the connection stays open.
+func (s *Server) dispatch(ctx context.Context, c *Client, room *Room, data []byte) {
+	span := trace.SpanFromContext(ctx)
+	env, payload, err := c.codec.Decode(data, protocol.FromClient)
+	if err == nil {
+		span.SetName("message " + env.Type)
+		span.SetAttributes(attrType.String(env.Type), attrSeq.Int64(int64(env.Seq)))
+	}
+	if err == nil && env.V != c.version {
+		err = &protocol.Error{
+			Code:    protocol.CodeUnsupportedVersion,
+			Message: fmt.Sprintf("this connection speaks version %d", c.version),
+			RefSeq:  env.Seq,
+			RefType: env.Type,
+		}
+	}
+	if err != nil {
+		if perr, ok := err.(*protocol.Error); ok {
+			traceRefusal(span, perr)
+		}
+		c.reply(err)
+		return
+	}
+
+	// Handle chat, move and tournament messages
+	switch p := payload.(type) {
+	case *protocol.Chat:
+		room.chat(ctx, c, p.Text)
+	case *protocol.Reaction:
+		err = room.react(ctx, c, p)
+	case *protocol.QuickChat:
+		err = room.quickChat(ctx, c, p.Phrase)
+	case *protocol.Move:
+		err = room.handleMove(ctx, c, p)
+		if err != nil {
+			err = &protocol.Error{Code: protocol.CodeIllegalMove, Message: err.Error()}
+		}
+	case *protocol.Resync:
+		room.resync(c, p.FromSeq)
+	case *protocol.SetName:
+		err = room.rename(c, p.Name)
+	case *protocol.WatchTournament:
+		err = s.tournaments.watch(c, p.ID)
+		if err != nil {
+			err = &protocol.Error{Code: protocol.CodeNotFound, Message: err.Error()}
+		}
+	case *protocol.Analyze:
+		err = s.analyzeFor(c, room, p)
+	case *protocol.PuzzleRequest:
+		err = s.sendPuzzle(c, p)
+	case *protocol.PuzzleMove:
+		err = s.playPuzzle(c, p)
+	case *protocol.Hello:
+		err = &protocol.Error{Code: protocol.CodeHandshake, Message: "already said hello"}
+	}
+	if perr, ok := err.(*protocol.Error); ok {
+		perr.RefSeq, perr.RefType = env.Seq, env.Type
+		traceRefusal(span, perr)
+		c.reply(perr)
+	}
+}
+
+// traceRefusal marks a span with the error the client was answered with
+func traceRefusal(span trace.Span, perr *protocol.Error) {
+	span.SetAttributes(attrCode.String(perr.Code))
+	span.SetStatus(codes.Error, perr.Message)
+}
+
+// how long a new connection has to say hello
+const handshakeTimeout = 10 * time.Second
+
+// handshake reads the client's hello and returns it with the version both sides speak.
+// On failure the client is sent an error before the connection is closed.
+func handshake(conn transport, codec protocol.Codec) (*protocol.Hello, int, error) {
+	data, err := conn.receive(handshakeTimeout)
+	if err != nil {
+		return nil, 0, err
+	}
+	env, payload, err := codec.Decode(data, protocol.FromClient)
+	if err != nil {
+		sendError(conn, codec, err.(*protocol.Error))
+		return nil, 0, err
+	}
+	hello, ok := payload.(*protocol.Hello)
+	if !ok {
+		perr := &protocol.Error{Code: protocol.CodeHandshake, Message: "the first message must be hello", RefSeq: env.Seq, RefType: env.Type}
+		sendError(conn, codec, perr)
+		return nil, 0, perr
+	}
+
+	version := protocol.Negotiate(hello.Versions)
+	if version == 0 {
+		perr := &protocol.Error{
+			Code:    protocol.CodeUnsupportedVersion,
+			Message: fmt.Sprintf("no common protocol version, the server speaks %v", protocol.SupportedVersions),
+			RefSeq:  env.Seq,
+			RefType: env.Type,
+		}
+		sendError(conn, codec, perr)
+		return nil, 0, perr
+	}
+	return hello, version, nil
+}
+
+// sendError writes an error straight to a connection that never got a Client
+func sendError(conn transport, codec protocol.Codec, perr *protocol.Error) {
+	data, err := codec.Encode(protocol.MustNew(perr))
+	if err != nil {
+		return
+	}
+	conn.send(frame{data: data, binary: codec.Binary()})
+}
@@ -0,0 +1,595 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"goChatSocket/game"
+	"goChatSocket/protocol"
+)
+
+// -simseed runs TestSimulation with one seed only and logs its trace, to
+// replay a failure step by step
+var simSeed = flag.Int64("simseed", 0, "run TestSimulation with this seed only and log its trace")
+
+// simClock is virtual time for the simulation. Timers never fire on their
+// own, the scheduler advances the clock to the next one and runs it on its
+// own goroutine, between two steps.
+type simClock struct {
+	mu     sync.Mutex
+	t      time.Time
+	timers []*simTimer
+	nextID int
+}
+
+type simTimer struct {
+	clock *simClock
+	id    int // timers due at the same time fire in the order they were set
+	due   time.Time
+	f     func()
+}
+
+func newSimClock() *simClock {
+	return &simClock{t: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *simClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *simClock) afterFunc(d time.Duration, f func()) timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextID++
+	t := &simTimer{clock: c, id: c.nextID, due: c.t.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (t *simTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := slices.Index(c.timers, t)
+	if i < 0 {
+		return false
+	}
+	c.timers = slices.Delete(c.timers, i, i+1)
+	return true
+}
+
+// pending reports whether a timer is waiting to fire
+func (c *simClock) pending() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers) > 0
+}
+
+// advance moves the clock on to the next timer and runs it, it returns how
+// much time passed
+func (c *simClock) advance() time.Duration {
+	c.mu.Lock()
+	next := c.timers[0]
+	for _, t := range c.timers[1:] {
+		if t.due.Before(next.due) || (t.due.Equal(next.due) && t.id < next.id) {
+			next = t
+		}
+	}
+	c.timers = slices.DeleteFunc(c.timers, func(t *simTimer) bool { return t == next })
+	elapsed := max(next.due.Sub(c.t), 0)
+	c.t = c.t.Add(elapsed)
+	c.mu.Unlock()
+
+	next.f()
+	return elapsed
+}
+
+// simTransport stands in for a socket. Nothing goes through it: the
+// scheduler hands messages to dispatch and takes the frames off the
+// client's queue itself.
+type simTransport struct {
+	addr string
+}
+
+func (t simTransport) receive(time.Duration) ([]byte, error) { return nil, errSessionClosed }
+func (t simTransport) send(frame) error                      { return nil }
+func (t simTransport) close() error                          { return nil }
+func (t simTransport) remoteAddr() string                    { return t.addr }
+func (t simTransport) name() string                          { return "sim" }
+
+// simView is what a virtual client believes about its room, built only
+// from the frames it was sent, the way the Go client's State is.
+// seq: the last room event applied. synced: the snapshot has arrived.
+type simView struct {
+	seq    uint64
+	synced bool
+	symbol string
+	board  []string
+	turn   string
+	over   bool
+}
+
+func (v *simView) myTurn() bool {
+	return v.symbol != "" && !v.over && v.turn == v.symbol
+}
+
+// simClient is one scripted user of the simulation.
+// c and r are their connection and room, nil while they are away.
+type simClient struct {
+	name   string
+	room   string
+	opts   roomOptions
+	script simScript
+	c      *Client
+	r      *Room
+	view   simView
+}
+
+// simScript picks what a client does next from its own view, nil to do nothing this step
+type simScript func(sc *simClient, rng *rand.Rand) *simAction
+
+// simAction is one thing done in a step.
+// kind: join, leave, send (msg is the payload) or tick, which advances the clock.
+// data: for send, the bytes to send as they are instead of msg, e.g. from a fuzzer.
+type simAction struct {
+	who  *simClient
+	kind string
+	msg  any
+	data []byte
+}
+
+// move is the move sent, if the action sends one the server can read
+func (a *simAction) move() *protocol.Move {
+	if a.data == nil {
+		move, _ := a.msg.(*protocol.Move)
+		return move
+	}
+	env, payload, err := a.who.c.codec.Decode(a.data, protocol.FromClient)
+	if err != nil || env.V != a.who.c.version {
+		return nil
+	}
+	move, _ := payload.(*protocol.Move)
+	return move
+}
+
+func (sc *simClient) join() *simAction  { return &simAction{who: sc, kind: "join"} }
+func (sc *simClient) leave() *simAction { return &simAction{who: sc, kind: "leave"} }
+func (sc *simClient) send(msg any) *simAction {
+	return &simAction{who: sc, kind: "send", msg: msg}
+}
+
+// player sits down and plays random moves, mostly legal ones. Now and then it
+// tries any cell, in turn or not, which the room has to refuse when it is wrong.
+func player(sc *simClient, rng *rand.Rand) *simAction {
+	if sc.c == nil {
+		return sc.join()
+	}
+	v := &sc.view
+	switch n := rng.Intn(10); {
+	case v.myTurn() && n < 8:
+		var empty []int
+		for i, cell := range v.board {
+			if cell == "" {
+				empty = append(empty, i)
+			}
+		}
+		if len(empty) > 0 {
+			position := empty[rng.Intn(len(empty))]
+			return sc.send(&protocol.Move{Position: &position})
+		}
+	case n == 9 && len(v.board) > 0:
+		position := rng.Intn(len(v.board))
+		return sc.send(&protocol.Move{Position: &position})
+	}
+	if rng.Intn(20) == 0 {
+		return sc.send(&protocol.Chat{Text: "gg " + sc.name})
+	}
+	return nil
+}
+
+// quitter plays like player but walks out now and then, mid game or not, and comes back later
+func quitter(sc *simClient, rng *rand.Rand) *simAction {
+	if sc.c == nil {
+		if rng.Intn(4) == 0 {
+			return sc.join()
+		}
+		return nil
+	}
+	if rng.Intn(15) == 0 {
+		return sc.leave()
+	}
+	return player(sc, rng)
+}
+
+// watcher chats and asks for resyncs, of nothing or from long ago. It plays
+// like player when it got a seat.
+func watcher(sc *simClient, rng *rand.Rand) *simAction {
+	if sc.c == nil {
+		return sc.join()
+	}
+	if sc.view.symbol != "" {
+		return player(sc, rng)
+	}
+	switch rng.Intn(12) {
+	case 0:
+		return sc.send(&protocol.Chat{Text: "nice one, " + sc.name + " here"})
+	case 1:
+		return sc.send(&protocol.Resync{FromSeq: sc.view.seq + 1})
+	case 2:
+		return sc.send(&protocol.Resync{FromSeq: uint64(rng.Int63n(int64(sc.view.seq) + 1))})
+	}
+	return nil
+}
+
+// simulation drives a server's hub with scripted virtual clients on a virtual
+// clock. Each step the seeded scheduler picks one action among those the
+// clients want to take (or advancing the clock), runs it, delivers every
+// queued frame and checks the invariants. Everything a client is sent goes
+// into the trace, the same seed always gives the same trace.
+type simulation struct {
+	t       *testing.T
+	seed    int64
+	rng     *rand.Rand
+	server  *Server
+	clock   *simClock
+	clients []*simClient
+	trace   []string
+	step    int
+}
+
+func newSimulation(t *testing.T, seed int64) *simulation {
+	server := newServer()
+	clock := newSimClock()
+	server.hub.clock = clock
+	return &simulation{t: t, seed: seed, rng: rand.New(rand.NewSource(seed)), server: server, clock: clock}
+}
+
+// add puts a client in the simulation, they create room with the options
+// of a connection asking for query if they are first there
+func (s *simulation) add(name, room string, query url.Values, script simScript) {
+	opts, err := roomQueryOptions(query)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	s.clients = append(s.clients, &simClient{name: name, room: room, opts: opts, script: script})
+}
+
+// populate fills three rooms: a classic one with a player who walks out now
+// and then, one holding events back from spectators and a Qubic one
+func (s *simulation) populate() {
+	s.add("alice", "main", nil, player)
+	s.add("bob", "main", nil, quitter)
+	s.add("carol", "main", nil, watcher)
+	s.add("dave", "main", nil, watcher)
+	delayed := url.Values{"spectatorDelay": {"30s"}}
+	s.add("erin", "slow", delayed, player)
+	s.add("frank", "slow", delayed, player)
+	s.add("grace", "slow", delayed, watcher)
+	cube := url.Values{"mode": {"qubic"}}
+	s.add("heidi", "cube", cube, player)
+	s.add("ivan", "cube", cube, quitter)
+}
+
+func (s *simulation) logf(format string, args ...any) {
+	s.trace = append(s.trace, fmt.Sprintf("%d ", s.step)+fmt.Sprintf(format, args...))
+}
+
+// fail stops the test with the end of the trace and how to replay it
+func (s *simulation) fail(format string, args ...any) {
+	s.t.Helper()
+	tail := s.trace[max(len(s.trace)-40, 0):]
+	s.t.Fatalf("seed %d, step %d: %s\n%s\nreplay with: go test -run 'TestSimulation$' -simseed=%d -v",
+		s.seed, s.step, fmt.Sprintf(format, args...), strings.Join(tail, "\n"), s.seed)
+}
+
+// run plays steps steps
+func (s *simulation) run(steps int) {
+	for s.step = 1; s.step <= steps; s.step++ {
+		var actions []*simAction
+		for _, sc := range s.clients {
+			if a := sc.script(sc, s.rng); a != nil {
+				actions = append(actions, a)
+			}
+		}
+		if s.clock.pending() {
+			actions = append(actions, &simAction{kind: "tick"})
+		}
+		if len(actions) > 0 {
+			s.do(actions[s.rng.Intn(len(actions))])
+		}
+	}
+}
+
+// do runs one action, delivers what it caused and checks the invariants
+func (s *simulation) do(a *simAction) {
+	sc := a.who
+	var before roomBefore
+	switch a.kind {
+	case "tick":
+		s.logf("clock +%s", s.clock.advance())
+	case "join":
+		s.connect(sc)
+	case "leave":
+		s.logf("%s leaves", sc.name)
+		before = s.roomBefore(a)
+		sc.r.leave(sc.c)
+		s.server.clients.remove(sc.c)
+		sc.c.close()
+		s.deliver(sc)
+		sc.c, sc.r = nil, nil
+	case "send":
+		data := a.data
+		if data == nil {
+			msg := protocol.MustNew(a.msg)
+			msg.V = sc.c.version
+			var err error
+			if data, err = sc.c.codec.Encode(msg); err != nil {
+				s.fail("encoding %s: %v", msg.Type, err)
+			}
+			s.logf("%s sends %s %s", sc.name, msg.Type, msg.Payload)
+		} else {
+			s.logf("%s sends %q", sc.name, data)
+		}
+		before = s.roomBefore(a)
+		s.server.dispatch(context.Background(), sc.c, sc.r, data)
+	}
+	for _, other := range s.clients {
+		if other.c != nil {
+			s.deliver(other)
+		}
+	}
+	if before.room != nil {
+		s.checkMoves(a, before)
+	}
+	s.checkRooms()
+}
+
+// connect opens a virtual connection for sc and joins their room, as serve
+// does once the handshake is over
+func (s *simulation) connect(sc *simClient) {
+	c := &Client{
+		ip:        "sim",
+		connected: s.clock.now(),
+		conn:      simTransport{addr: sc.name},
+		version:   protocol.Version,
+		codec:     protocol.JSON,
+		send:      make(chan frame, sendBuffer),
+		done:      make(chan struct{}),
+	}
+	s.server.clients.add(c)
+	s.logf("%s joins %s as %s", sc.name, sc.room, c.id)
+	sc.c, sc.view = c, simView{}
+	sc.r = s.server.hub.join(sc.room, c, sc.opts)
+}
+
+// deliver takes every frame queued for sc and applies it to their view.
+// A room event must follow the last one they saw, anything else was lost
+// (or came before the snapshot). Older events are replays asked for with
+// resync and are dropped, as the Go client does.
+func (s *simulation) deliver(sc *simClient) {
+	for {
+		var f frame
+		var ok bool
+		select {
+		case f, ok = <-sc.c.send:
+		default:
+		}
+		if !ok {
+			return
+		}
+		env, payload, err := sc.c.codec.Decode(f.data, protocol.FromServer)
+		if err != nil {
+			s.fail("%s got a frame that doesn't decode: %v", sc.name, err)
+		}
+		s.logf("%s <- #%d %s %s", sc.name, env.Seq, env.Type, env.Payload)
+
+		v := &sc.view
+		if snapshot, ok := payload.(*protocol.Snapshot); ok {
+			v.seq, v.synced = env.Seq, true
+			v.board, v.turn, v.over = append([]string(nil), snapshot.Board...), snapshot.Turn, false
+			continue
+		}
+		if env.Seq == 0 {
+			switch p := payload.(type) {
+			case *protocol.AssignPlayer:
+				v.symbol = p.Symbol
+			case *protocol.LobbyFull:
+				v.symbol = ""
+			}
+			continue
+		}
+		switch {
+		case !v.synced:
+			s.fail("%s got event #%d before the snapshot", sc.name, env.Seq)
+		case env.Seq <= v.seq:
+			continue
+		case env.Seq > v.seq+1:
+			s.fail("%s lost events #%d to #%d", sc.name, v.seq+1, env.Seq-1)
+		}
+		v.seq = env.Seq
+		switch p := payload.(type) {
+		case *protocol.Move:
+			v.board[*p.Position] = p.Symbol
+		case *protocol.UpdateTurn:
+			// a turn after a finished game means the rematch started
+			if v.over {
+				v.board, v.over = make([]string, len(v.board)), false
+			}
+			v.turn = p.Symbol
+		case *protocol.GameOver:
+			v.over, v.turn = true, ""
+		}
+	}
+}
+
+// roomBefore is the acting client's room before their action.
+// turn: "" when no game is on. legal: the action is a move the rules allow.
+type roomBefore struct {
+	room  *Room
+	seq   uint64
+	turn  string
+	legal bool
+}
+
+func (s *simulation) roomBefore(a *simAction) roomBefore {
+	r := a.who.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	before := roomBefore{room: r, seq: r.seq}
+	if r.started {
+		before.turn = r.game.Turn
+	}
+	if move := a.move(); move != nil && r.started && a.who.c.symbol == r.game.Turn {
+		position, err := r.position(move)
+		placed := move.Symbol
+		if placed == "" {
+			placed = a.who.c.symbol
+		}
+		before.legal = err == nil && position < len(r.game.Board) && r.game.Board[position] == "" &&
+			(placed == a.who.c.symbol || r.game.Rules.PickSymbol() && (placed == game.X || placed == game.O))
+	}
+	return before
+}
+
+// checkMoves holds the room to one mover per turn: only the player whose turn
+// it was can have moved, at most once, and a legal move is never dropped
+func (s *simulation) checkMoves(a *simAction, before roomBefore) {
+	r := before.room
+	r.mu.Lock()
+	var moves []*protocol.Move
+	for _, env := range r.log {
+		if move, ok := env.Body().(*protocol.Move); ok && env.Seq > before.seq {
+			moves = append(moves, move)
+		}
+	}
+	r.mu.Unlock()
+
+	isMove := a.move() != nil
+	switch {
+	case len(moves) > 1:
+		s.fail("%d moves in one step", len(moves))
+	case len(moves) == 1 && !isMove:
+		s.fail("a move was played when %s sent %T", a.who.name, a.msg)
+	case len(moves) == 1 && a.who.view.symbol != before.turn:
+		s.fail("%s (%q) moved when it was %q's turn", a.who.name, a.who.view.symbol, before.turn)
+	case len(moves) == 0 && before.legal:
+		s.fail("%s's legal move was refused", a.who.name)
+	}
+}
+
+// checkRooms holds every room to its invariants: a board a game can reach,
+// somebody in the seat to move, every client up to date with the events it
+// should have had and all of them seeing the same board as the server
+func (s *simulation) checkRooms() {
+	for _, r := range s.server.hub.list() {
+		r.mu.Lock()
+		err := s.checkRoom(r)
+		r.mu.Unlock()
+		if err != nil {
+			s.fail("room %s: %v", r.ID, err)
+		}
+	}
+}
+
+// checkRoom checks one room, call with its lock held
+func (s *simulation) checkRoom(r *Room) error {
+	// a game in progress is on a board its moves can reach, between games the board is clear
+	turn := ""
+	if r.started {
+		turn = r.game.Turn
+		if _, err := game.FromPosition(r.game.Rules, r.game.Board, turn); err != nil {
+			return fmt.Errorf("illegal board %q: %v", r.game.Board, err)
+		}
+		if r.game.Over() {
+			return errors.New("the game is over but still being played")
+		}
+		if r.players[turn] == nil {
+			return fmt.Errorf("nobody sits in %s's seat to move", turn)
+		}
+	} else if slices.ContainsFunc(r.game.Board, func(cell string) bool { return cell != "" }) {
+		return fmt.Errorf("board %q with no game on", r.game.Board)
+	}
+
+	movers := 0
+	for _, sc := range s.clients {
+		if sc.r != r || sc.c == nil {
+			continue
+		}
+		v := &sc.view
+		seq, board, wantTurn := r.seq, r.game.Board, turn
+		if r.delayed(sc.c) {
+			seq, board, wantTurn = r.feed.sent, r.feed.board, r.feed.turn
+			if board == nil {
+				board = make([]string, len(r.game.Board))
+			}
+		}
+		if v.seq != seq {
+			return fmt.Errorf("%s has seen up to #%d, the room is at #%d", sc.name, v.seq, seq)
+		}
+		if v.symbol != sc.c.symbol {
+			return fmt.Errorf("%s thinks they are %q, the room seats them as %q", sc.name, v.symbol, sc.c.symbol)
+		}
+		if v.turn != wantTurn {
+			return fmt.Errorf("%s thinks it is %q's turn, it is %q's", sc.name, v.turn, wantTurn)
+		}
+		// after gameOver the board stays up until the rematch starts
+		if !v.over && !slices.Equal(v.board, board) {
+			return fmt.Errorf("%s sees board %q, the room has %q", sc.name, v.board, board)
+		}
+		if v.myTurn() {
+			movers++
+		}
+	}
+	if movers > 1 {
+		return fmt.Errorf("%d clients think it is their move", movers)
+	}
+	return nil
+}
+
+func TestSimulation(t *testing.T) {
+	seeds, steps := 20, 400
+	if testing.Short() {
+		seeds = 3
+	}
+	if *simSeed != 0 {
+		sim := newSimulation(t, *simSeed)
+		sim.populate()
+		sim.run(steps)
+		t.Log("trace:\n" + strings.Join(sim.trace, "\n"))
+		return
+	}
+	for seed := int64(1); seed <= int64(seeds); seed++ {
+		sim := newSimulation(t, seed)
+		sim.populate()
+		sim.run(steps)
+	}
+}
+
+func TestSimulationDeterministic(t *testing.T) {
+	var traces [2][]string
+	for i := range traces {
+		sim := newSimulation(t, 42)
+		sim.populate()
+		sim.run(300)
+		traces[i] = sim.trace
+	}
+	for i := range min(len(traces[0]), len(traces[1])) {
+		if traces[0][i] != traces[1][i] {
+			t.Fatalf("the traces part at line %d:\n%s\n%s", i, traces[0][i], traces[1][i])
+		}
+	}
+	if len(traces[0]) != len(traces[1]) {
+		t.Fatalf("traces of %d and %d lines", len(traces[0]), len(traces[1]))
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"goChatSocket/protocol"
+)
+
+// the longest spectator delay a room can ask for
+const maxSpectatorDelay = 10 * time.Minute
+
+// spectatorFeed holds room events back from spectators for the room's
+// spectatorDelay, so nobody watching can coach the players in a live game.
+// Spectators get the same numbered events as everyone else, just later.
+type spectatorFeed struct {
+	// events not yet sent to spectators, oldest first
+	pending []heldEvent
+	timer   timer
+
+	// sent: seq of the last event spectators were sent.
+	// board, turn: the game as spectators see it, for their snapshots.
+	sent  uint64
+	board []string
+	turn  string
+}
+
+// heldEvent is a broadcast waiting to be shown to spectators at due
+type heldEvent struct {
+	msg protocol.Envelope
+	enc *encoder
+	due time.Time
+}
+
+// delayed reports whether c only gets the spectator feed, call with the lock held
+func (r *Room) delayed(c *Client) bool {
+	return r.opts.spectatorDelay > 0 && c.symbol == ""
+}
+
+// hold queues an event for the spectators, call with the lock held
+func (r *Room) hold(msg protocol.Envelope, enc *encoder) {
+	r.feed.pending = append(r.feed.pending, heldEvent{msg, enc, r.hub.clock.now().Add(r.opts.spectatorDelay)})
+	if r.feed.timer == nil {
+		r.feed.timer = r.hub.clock.afterFunc(r.opts.spectatorDelay, r.releaseHeld)
+	}
+}
+
+// releaseHeld sends spectators every held event that is due and waits for the next one
+func (r *Room) releaseHeld() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.hub.clock.now()
+	for len(r.feed.pending) > 0 && !r.feed.pending[0].due.After(now) {
+		held := r.feed.pending[0]
+		r.feed.pending = r.feed.pending[1:]
+		r.feed.apply(held.msg, len(r.game.Board))
+		for c := range r.clients {
+			if r.delayed(c) {
+				c.push(held.enc)
+			}
+		}
+	}
+
+	r.feed.timer = nil
+	if len(r.feed.pending) > 0 {
+		r.feed.timer = r.hub.clock.afterFunc(r.feed.pending[0].due.Sub(now), r.releaseHeld)
+	}
+}
+
+// apply moves the spectators' view of the game on by one event
+func (f *spectatorFeed) apply(msg protocol.Envelope, cells int) {
+	f.sent = msg.Seq
+	if f.board == nil {
+		f.board = make([]string, cells)
+	}
+	switch p := msg.Body().(type) {
+	case *protocol.Move:
+		f.board[*p.Position] = p.Symbol
+	case *protocol.UpdateTurn:
+		f.turn = p.Symbol
+	case *protocol.GameOver:
+		// the room clears the board for the next game straight away
+		f.board = make([]string, cells)
+		f.turn = ""
+	}
+}
+
+// flush sends spectators everything still held, used when the delay is turned off
+func (r *Room) flush() {
+	if r.feed.timer != nil {
+		r.feed.timer.Stop()
+		r.feed.timer = nil
+	}
+	for _, held := range r.feed.pending {
+		r.feed.apply(held.msg, len(r.game.Board))
+		for c := range r.clients {
+			if c.symbol == "" {
+				c.push(held.enc)
+			}
+		}
+	}
+	r.feed.pending = nil
+}
+
+// spectatorSnapshot is the room state as spectators currently see it
+func (r *Room) spectatorSnapshot() (*protocol.Snapshot, uint64) {
+	board := r.feed.board
+	if board == nil {
+		board = make([]string, len(r.game.Board))
+	}
+	snapshot := &protocol.Snapshot{
+		Board:   append([]string(nil), board...),
+		Rules:   r.snapshotRules(),
+		Turn:    r.feed.turn,
+		Players: r.playerNames(),
+		Stake:   r.opts.stake,
+	}
+	return snapshot, r.feed.sent
+}
+
+// spectatorChat relays a spectator's chat line to the other spectators only
+// and keeps it to show the players once the game is over, call with the lock held
+func (r *Room) spectatorChat(c *Client, text string) {
+	line := &protocol.Chat{Sender: c.name, Text: text, Channel: protocol.ChannelSpectators}
+	r.heldChat = append(r.heldChat, line)
+
+	fmt.Printf("Spectator chat in %s from %s: %s\n", r.ID, c.name, text)
+	enc := newEncoder(protocol.MustNew(line))
+	for c := range r.clients {
+		if c.symbol == "" {
+			c.push(enc)
+		}
+	}
+}
+
+// mergeChat shows the players what the spectators said during the game, call with the lock held
+func (r *Room) mergeChat() {
+	for _, line := range r.heldChat {
+		enc := newEncoder(protocol.MustNew(line))
+		for _, p := range r.players {
+			p.push(enc)
+		}
+	}
+	r.heldChat = nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"goChatSocket/client"
+	"goChatSocket/protocol"
+)
+
+func dial(t *testing.T, url string) *client.Client {
+	t.Helper()
+	c, err := client.Dial(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestSpectatorDelay(t *testing.T) {
+	const delay = 300 * time.Millisecond
+	url := roomURL(t, startServer(t, nil), "delayed") + "&spectatorDelay=" + delay.String()
+
+	x, o := dial(t, url), dial(t, url)
+	spectator := dial(t, url)
+	waitFor[client.Spectating](t, spectator, nil)
+	waitFor(t, x, func(e client.TurnChanged) bool { return e.Symbol == "X" })
+
+	moved := time.Now()
+	if err := x.Move(4); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, o, func(e client.Moved) bool { return e.Position == 4 })
+	if time.Since(moved) >= delay {
+		t.Fatal("players should see the move straight away")
+	}
+	waitFor(t, spectator, func(e client.Moved) bool { return e.Position == 4 })
+	if time.Since(moved) < delay {
+		t.Fatalf("spectator saw the move after %s, want at least %s", time.Since(moved), delay)
+	}
+
+	// someone arriving now is shown the board as spectators see it, before the move
+	waitFor(t, o, func(e client.TurnChanged) bool { return e.Symbol == "O" })
+	if err := o.Move(0); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, x, func(e client.Moved) bool { return e.Position == 0 })
+	late := dial(t, url)
+	snapshot := waitFor[client.Snapshot](t, late, nil)
+	if snapshot.Board[4] != "X" || snapshot.Board[0] != "" {
+		t.Fatalf("late spectator got board %v", snapshot.Board)
+	}
+	waitFor(t, late, func(e client.Moved) bool { return e.Position == 0 })
+}
+
+func TestSpectatorChat(t *testing.T) {
+	url := roomURL(t, startServer(t, nil), "coaching")
+
+	x, o := dial(t, url), dial(t, url)
+	waitFor(t, o, func(e client.TurnChanged) bool { return e.Symbol == "X" })
+	spectator := dial(t, url)
+	waitFor[client.Spectating](t, spectator, nil)
+
+	// during the game spectators only talk among themselves
+	if err := spectator.Chat("block the top row!"); err != nil {
+		t.Fatal(err)
+	}
+	line := waitFor[client.ChatReceived](t, spectator, nil)
+	if line.Channel != protocol.ChannelSpectators {
+		t.Fatalf("spectator chat on channel %q", line.Channel)
+	}
+
+	// X takes the top row while O plays the middle row
+	for i, pos := range []int{0, 3, 1, 4, 2} {
+		mover, symbol := x, "X"
+		if i%2 == 1 {
+			mover, symbol = o, "O"
+		}
+		waitFor(t, mover, func(e client.TurnChanged) bool { return e.Symbol == symbol })
+		if err := mover.Move(pos); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// O must not hear the spectators until the game is over, then they do
+	for over := false; !over; {
+		select {
+		case ev := <-o.Events():
+			switch e := ev.(type) {
+			case client.ChatReceived:
+				t.Fatalf("player got spectator chat during the game: %+v", e)
+			case client.GameOver:
+				over = true
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for gameOver")
+		}
+	}
+	heard := waitFor[client.ChatReceived](t, o, nil)
+	if heard.Text != "block the top row!" || heard.Channel != protocol.ChannelSpectators {
+		t.Fatalf("player heard %+v after the game", heard)
+	}
+}
+
+func TestReconfigureDelayedRoom(t *testing.T) {
+	const delay = 200 * time.Millisecond
+	server := newServer()
+	srv := httptest.NewServer(server.routes())
+	t.Cleanup(srv.Close)
+	url := roomURL(t, "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", "reused") + "&mode=qubic&spectatorDelay=" + delay.String()
+
+	x, o := dial(t, url), dial(t, url)
+	spectator := dial(t, url)
+	waitFor[client.Spectating](t, spectator, nil)
+	play(t, x, o, 40)
+	waitFor(t, o, func(e client.Moved) bool { return e.Position == 40 })
+
+	// the room is taken over for a 3x3 game while spectators still wait for cell 40,
+	// they get it now and the feed starts again on the new board
+	room := server.hub.createRoom("reused", roomOptions{spectatorDelay: delay})
+	waitFor(t, spectator, func(e client.Moved) bool { return e.Position == 40 })
+
+	// the room seats whichever two it comes to first, the third watches the new game
+	room.mu.Lock()
+	seated := map[string]string{}
+	for symbol, c := range room.players {
+		seated[c.name] = symbol
+	}
+	room.mu.Unlock()
+	var mover, watcher *client.Client
+	for _, c := range []*client.Client{x, o, spectator} {
+		switch seated[c.UserName()] {
+		case "X":
+			mover = c
+		case "":
+			watcher = c
+		}
+	}
+	if mover == nil || watcher == nil {
+		t.Fatalf("seated %v", seated)
+	}
+	waitFor(t, mover, func(e client.TurnChanged) bool { return e.Symbol == "X" })
+	if err := mover.Move(4); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, watcher, func(e client.Moved) bool { return e.Position == 4 })
+	late := dial(t, url)
+	if snapshot := waitFor[client.Snapshot](t, late, nil); len(snapshot.Board) != 9 || snapshot.Board[4] != "X" {
+		t.Fatalf("late spectator got board %v", snapshot.Board)
+	}
+}
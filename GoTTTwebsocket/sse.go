@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"goChatSocket/protocol"
+)
+
+// Server-sent events fallback for networks that block websocket upgrades.
+//
+//	GET  /sse?room=<id>&token=<seat token>   event stream, the first event is "session" with the session id
+//	POST /sse/{session}                      one client message (an envelope) per request
+//
+// After the session event the client POSTs hello and everything else goes
+// exactly like on /ws.
+
+// how often an idle stream gets a comment so proxies keep it open
+const sseHeartbeat = 25 * time.Second
+
+// largest message accepted by POST /sse/{session}
+const maxPostSize = 64 << 10
+
+// sseSessions maps session ids to their open streams
+type sseSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*sseTransport
+}
+
+func newSSESessions() *sseSessions {
+	return &sseSessions{sessions: make(map[string]*sseTransport)}
+}
+
+func (s *sseSessions) add(t *sseTransport) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[t.id] = t
+}
+
+func (s *sseSessions) remove(t *sseTransport) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, t.id)
+}
+
+func (s *sseSessions) get(id string) *sseTransport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessions[id]
+}
+
+// handleSSE opens an event stream and serves it like a websocket connection
+func (s *Server) handleSSE(w http.ResponseWriter, r *http.Request) {
+	conn, err := newSSETransport(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // ask nginx style proxies not to buffer
+	w.WriteHeader(http.StatusOK)
+
+	s.sse.add(conn)
+	defer s.sse.remove(conn)
+	defer conn.detach()
+
+	// the session ends when the browser goes away or the server hangs up
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(sseHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				conn.close()
+				return
+			case <-ticker.C:
+				if err := conn.heartbeat(); err != nil {
+					conn.close()
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	if err := conn.event("session", conn.id); err != nil {
+		return
+	}
+	s.serve(conn, protocol.JSON, r)
+}
+
+// handlePost delivers one client message to an open SSE session
+func (s *Server) handlePost(w http.ResponseWriter, r *http.Request) error {
+	conn := s.sse.get(r.PathValue("session"))
+	if conn == nil {
+		return notFound("session %s not found", r.PathValue("session"))
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPostSize))
+	if err != nil {
+		return fmt.Errorf("invalid body: %w", err)
+	}
+	if !conn.deliver(data) {
+		return statusError{http.StatusServiceUnavailable, fmt.Errorf("session %s is not accepting messages", conn.id)}
+	}
+	w.WriteHeader(http.StatusAccepted)
+	return nil
+}
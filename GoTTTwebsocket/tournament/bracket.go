@@ -0,0 +1,120 @@
+package tournament
+
+// buildWinnersBracket lays out a knockout bracket for every player and
+// returns its rounds. Byes go to the top seeds when the field is not a power of two.
+func (t *Tournament) buildWinnersBracket(bracket string) [][]*Match {
+	size := 1
+	for size < len(t.Players) {
+		size *= 2
+	}
+
+	order := seedOrder(size)
+	var rounds [][]*Match
+
+	// first round, seed positions straight from the seed order
+	var first []*Match
+	for i := 0; i < size; i += 2 {
+		first = append(first, t.addMatch(&Match{
+			Round:   1,
+			Bracket: bracket,
+			Slots:   [2]Slot{t.seedSlot(order[i]), t.seedSlot(order[i+1])},
+		}))
+	}
+	rounds = append(rounds, first)
+
+	// every later round takes the winners of two matches from the round before
+	for prev := first; len(prev) > 1; prev = rounds[len(rounds)-1] {
+		var next []*Match
+		for i := 0; i < len(prev); i += 2 {
+			next = append(next, t.addMatch(&Match{
+				Round:   len(rounds) + 1,
+				Bracket: bracket,
+				Slots:   [2]Slot{{From: prev[i].ID}, {From: prev[i+1].ID}},
+			}))
+		}
+		rounds = append(rounds, next)
+	}
+	return rounds
+}
+
+// seedSlot is the slot for a seed number, or a bye past the last player
+func (t *Tournament) seedSlot(seed int) Slot {
+	if seed > len(t.Players) {
+		return Slot{Bye: true}
+	}
+	return Slot{Player: t.Players[seed-1].ID}
+}
+
+// seedOrder gives the standard bracket order so the top seeds meet as late
+// as possible: 1 v 8, 4 v 5, 2 v 7, 3 v 6 for eight players.
+func seedOrder(size int) []int {
+	order := []int{1}
+	for len(order) < size {
+		n := len(order) * 2
+		var next []int
+		for _, seed := range order {
+			next = append(next, seed, n+1-seed)
+		}
+		order = next
+	}
+	return order
+}
+
+// buildDoubleElimination adds the winners bracket, a losers bracket fed by
+// everyone knocked out of it, and the grand final between the two champions.
+func (t *Tournament) buildDoubleElimination() {
+	winners := t.buildWinnersBracket(BracketWinners)
+	wbFinal := winners[len(winners)-1][0]
+
+	// lbChampion is the slot the grand final takes from the losers side
+	lbChampion := Slot{From: wbFinal.ID, Loser: true}
+
+	if len(winners) > 1 {
+		round := 1
+		lbMatch := func(a, b Slot) *Match {
+			return t.addMatch(&Match{Round: round, Bracket: BracketLosers, Slots: [2]Slot{a, b}})
+		}
+
+		// losers bracket round 1: the first round losers play each other
+		var current []*Match
+		for i := 0; i < len(winners[0]); i += 2 {
+			current = append(current, lbMatch(
+				Slot{From: winners[0][i].ID, Loser: true},
+				Slot{From: winners[0][i+1].ID, Loser: true},
+			))
+		}
+
+		for wr := 1; wr < len(winners); wr++ {
+			// drop-in round: survivors meet the losers of the next winners round,
+			// crossed over on alternate rounds to avoid early rematches
+			round++
+			dropping := winners[wr]
+			var next []*Match
+			for i, m := range current {
+				j := i
+				if wr%2 == 1 {
+					j = len(dropping) - 1 - i
+				}
+				next = append(next, lbMatch(Slot{From: m.ID}, Slot{From: dropping[j].ID, Loser: true}))
+			}
+			current = next
+
+			// then the survivors play each other, unless only one is left
+			if len(current) > 1 {
+				round++
+				next = nil
+				for i := 0; i < len(current); i += 2 {
+					next = append(next, lbMatch(Slot{From: current[i].ID}, Slot{From: current[i+1].ID}))
+				}
+				current = next
+			}
+		}
+		lbChampion = Slot{From: current[0].ID}
+	}
+
+	t.addMatch(&Match{
+		Round:   1,
+		Bracket: BracketFinal,
+		Slots:   [2]Slot{{From: wbFinal.ID}, lbChampion},
+	})
+}
@@ -0,0 +1,117 @@
+package tournament
+
+// buildRoundRobin schedules every round up front with the circle method.
+// With an odd number of players one of them sits out (a bye) each round.
+func (t *Tournament) buildRoundRobin() {
+	ids := make([]string, 0, len(t.Players)+1)
+	for _, p := range t.Players {
+		ids = append(ids, p.ID)
+	}
+	if len(ids)%2 == 1 {
+		ids = append(ids, "") // the bye
+	}
+
+	n := len(ids)
+	for round := 1; round < n; round++ {
+		for i := 0; i < n/2; i++ {
+			a, b := ids[i], ids[n-1-i]
+			// alternate colours so nobody is always X
+			if (round+i)%2 == 0 {
+				a, b = b, a
+			}
+			t.addMatch(&Match{Round: round, Slots: [2]Slot{idSlot(a), idSlot(b)}})
+		}
+		// rotate everyone but the first player one place
+		ids = append([]string{ids[0], ids[n-1]}, ids[1:n-1]...)
+	}
+}
+
+func idSlot(id string) Slot {
+	if id == "" {
+		return Slot{Bye: true}
+	}
+	return Slot{Player: id}
+}
+
+// pairSwissRound starts the next Swiss round. Players are paired top down by
+// standing, avoiding rematches where possible; with an odd field the lowest
+// ranked player who hasn't had a bye gets one.
+func (t *Tournament) pairSwissRound() {
+	t.Round++
+
+	var ranked []string
+	for _, s := range t.Standings() {
+		ranked = append(ranked, s.Player.ID)
+	}
+
+	played := make(map[[2]string]bool)
+	byes := make(map[string]bool)
+	for _, m := range t.Matches {
+		if m.Bye {
+			byes[m.Winner] = true
+			continue
+		}
+		played[[2]string{m.X(), m.O()}] = true
+		played[[2]string{m.O(), m.X()}] = true
+	}
+
+	if len(ranked)%2 == 1 {
+		bye := len(ranked) - 1
+		for i := len(ranked) - 1; i >= 0; i-- {
+			if !byes[ranked[i]] {
+				bye = i
+				break
+			}
+		}
+		t.addMatch(&Match{Round: t.Round, Slots: [2]Slot{{Player: ranked[bye]}, {Bye: true}}})
+		ranked = append(ranked[:bye:bye], ranked[bye+1:]...)
+	}
+
+	pairs, ok := pairUp(ranked, played)
+	if !ok {
+		// everyone has met already, allow rematches
+		pairs, _ = pairUp(ranked, nil)
+	}
+
+	xGames := t.xGames()
+	for _, pair := range pairs {
+		a, b := pair[0], pair[1]
+		// whoever has played X less often gets X, the higher ranked on a tie
+		if xGames[b] < xGames[a] {
+			a, b = b, a
+		}
+		t.addMatch(&Match{Round: t.Round, Slots: [2]Slot{{Player: a}, {Player: b}}})
+	}
+}
+
+// pairUp pairs the first player with the highest ranked opponent they haven't
+// played and recurses, backtracking when the rest can't be paired.
+func pairUp(ranked []string, played map[[2]string]bool) ([][2]string, bool) {
+	if len(ranked) == 0 {
+		return nil, true
+	}
+	first := ranked[0]
+	for i := 1; i < len(ranked); i++ {
+		if played[[2]string{first, ranked[i]}] {
+			continue
+		}
+		rest := make([]string, 0, len(ranked)-2)
+		rest = append(rest, ranked[1:i]...)
+		rest = append(rest, ranked[i+1:]...)
+		if pairs, ok := pairUp(rest, played); ok {
+			return append([][2]string{{first, ranked[i]}}, pairs...), true
+		}
+	}
+	return nil, false
+}
+
+// xGames counts how many games each player has played as X
+func (t *Tournament) xGames() map[string]int {
+	counts := make(map[string]int)
+	for _, m := range t.Matches {
+		if !m.Bye {
+			counts[m.X()]++
+		}
+	}
+	return counts
+}
@@ -0,0 +1,132 @@
+package tournament
+
+import "sort"
+
+// Standing is one line of the table.
+// Points: 1 per win, 0.5 per draw, and 1 for a Swiss bye.
+// Buchholz: sum of the opponents' points, first tiebreak.
+// SonnebornBerger: points of beaten opponents plus half of drawn ones, second tiebreak.
+type Standing struct {
+	Rank            int     `json:"rank"`
+	Player          *Player `json:"player"`
+	Points          float64 `json:"points"`
+	Wins            int     `json:"wins"`
+	Draws           int     `json:"draws"`
+	Losses          int     `json:"losses"`
+	Buchholz        float64 `json:"buchholz"`
+	SonnebornBerger float64 `json:"sonnebornBerger"`
+	Eliminated      bool    `json:"eliminated,omitempty"`
+}
+
+// Standings ranks the players. Swiss and round robin sort by points then the
+// tiebreaks; elimination formats sort by who is still alive, then wins.
+// The seed settles anything still tied.
+func (t *Tournament) Standings() []Standing {
+	table := make(map[string]*Standing, len(t.Players))
+	var opponents = make(map[string][]string)
+	for _, p := range t.Players {
+		table[p.ID] = &Standing{Player: p}
+	}
+
+	for _, m := range t.Matches {
+		if m.Status != MatchDone {
+			continue
+		}
+		if m.Bye {
+			if t.Format == Swiss && m.Winner != "" {
+				table[m.Winner].Points++
+			}
+			continue
+		}
+		x, o := m.X(), m.O()
+		opponents[x] = append(opponents[x], o)
+		opponents[o] = append(opponents[o], x)
+		if m.Draw {
+			table[x].Draws++
+			table[o].Draws++
+			table[x].Points += 0.5
+			table[o].Points += 0.5
+			continue
+		}
+		table[m.Winner].Wins++
+		table[m.Winner].Points++
+		table[m.Loser].Losses++
+	}
+
+	// tiebreaks need everyone's points first
+	for _, m := range t.Matches {
+		if m.Status != MatchDone || m.Bye {
+			continue
+		}
+		x, o := table[m.X()], table[m.O()]
+		switch {
+		case m.Draw:
+			x.SonnebornBerger += o.Points / 2
+			o.SonnebornBerger += x.Points / 2
+		case m.Winner == m.X():
+			x.SonnebornBerger += o.Points
+		default:
+			o.SonnebornBerger += x.Points
+		}
+	}
+	for id, opps := range opponents {
+		for _, opp := range opps {
+			table[id].Buchholz += table[opp].Points
+		}
+	}
+
+	if t.elimination() {
+		// out after one loss, or two in double elimination (the grand final reset aside)
+		allowed := 1
+		if t.Format == DoubleElimination {
+			allowed = 2
+		}
+		for _, s := range table {
+			s.Eliminated = s.Losses >= allowed
+		}
+		if t.State == StateFinished {
+			for _, s := range table {
+				s.Eliminated = s.Player.ID != t.champion()
+			}
+		}
+	}
+
+	standings := make([]Standing, 0, len(table))
+	for _, p := range t.Players {
+		standings = append(standings, *table[p.ID])
+	}
+	sort.SliceStable(standings, func(i, j int) bool {
+		a, b := standings[i], standings[j]
+		if t.elimination() {
+			if a.Eliminated != b.Eliminated {
+				return !a.Eliminated
+			}
+			if a.Wins != b.Wins {
+				return a.Wins > b.Wins
+			}
+			return a.Player.Seed < b.Player.Seed
+		}
+		if a.Points != b.Points {
+			return a.Points > b.Points
+		}
+		if a.Buchholz != b.Buchholz {
+			return a.Buchholz > b.Buchholz
+		}
+		if a.SonnebornBerger != b.SonnebornBerger {
+			return a.SonnebornBerger > b.SonnebornBerger
+		}
+		return a.Player.Seed < b.Player.Seed
+	})
+	for i := range standings {
+		standings[i].Rank = i + 1
+	}
+	return standings
+}
+
+// champion is the winner of the last match of a finished elimination event
+func (t *Tournament) champion() string {
+	if len(t.Matches) == 0 {
+		return ""
+	}
+	return t.Matches[len(t.Matches)-1].Winner
+}
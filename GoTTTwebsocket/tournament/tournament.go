@@ -0,0 +1,393 @@
+// Package tournament runs brackets and pairings for events made of many games:
+// single elimination, double elimination, Swiss and round robin.
+//
+// It is pure bookkeeping. The caller registers players, starts the event,
+// plays every match that becomes ready (the server opens a room per match)
+// and reports results back, which makes new matches ready until the event
+// is finished.
+package tournament
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Format of a tournament.
+type Format string
+
+const (
+	SingleElimination Format = "single-elimination"
+	DoubleElimination Format = "double-elimination"
+	Swiss             Format = "swiss"
+	RoundRobin        Format = "round-robin"
+)
+
+// Tournament states.
+const (
+	StateRegistering = "registering"
+	StateRunning     = "running"
+	StateFinished    = "finished"
+)
+
+// Match states.
+const (
+	MatchPending = "pending" // waiting on an earlier match or round
+	MatchReady   = "ready"   // both players known, game can be played
+	MatchDone    = "done"
+)
+
+// Double elimination brackets.
+const (
+	BracketWinners = "winners"
+	BracketLosers  = "losers"
+	BracketFinal   = "final"
+)
+
+// Errors returned by the tournament methods.
+var (
+	ErrUnknownFormat  = errors.New("unknown tournament format")
+	ErrNotRegistering = errors.New("registration is closed")
+	ErrNotRunning     = errors.New("tournament is not running")
+	ErrTooFewPlayers  = errors.New("need at least two players")
+	ErrNameTaken      = errors.New("name already registered")
+	ErrUnknownMatch   = errors.New("unknown match")
+	ErrMatchNotReady  = errors.New("match is not ready to be played")
+	ErrUnknownPlayer  = errors.New("player is not in this match")
+)
+
+// Options tune a tournament.
+// Rounds: number of Swiss rounds, 0 picks enough rounds to find a winner.
+// MaxReplays: drawn elimination games are replayed with colours swapped this
+// many times, after that the higher seed advances.
+type Options struct {
+	Rounds     int `json:"rounds,omitempty"`
+	MaxReplays int `json:"maxReplays,omitempty"`
+}
+
+// Player is a registered entrant. Seed 1 is the strongest.
+type Player struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Seed int    `json:"seed"`
+}
+
+// Slot is one side of a match. It either names a player, is a bye,
+// or waits for the winner (or loser) of another match.
+type Slot struct {
+	Player string `json:"player,omitempty"`
+	Bye    bool   `json:"bye,omitempty"`
+	From   string `json:"from,omitempty"`
+	Loser  bool   `json:"loser,omitempty"`
+}
+
+func (s Slot) resolved() bool {
+	return s.Player != "" || s.Bye
+}
+
+// Match is a pairing of two slots. Slot 0 plays X, slot 1 plays O.
+// Winner and Loser are empty on a draw, Bye is set when no game was played.
+type Match struct {
+	ID      string  `json:"id"`
+	Round   int     `json:"round"`
+	Bracket string  `json:"bracket,omitempty"`
+	Slots   [2]Slot `json:"slots"`
+	Status  string  `json:"status"`
+	Winner  string  `json:"winner,omitempty"`
+	Loser   string  `json:"loser,omitempty"`
+	Draw    bool    `json:"draw,omitempty"`
+	Bye     bool    `json:"bye,omitempty"`
+	Replays int     `json:"replays,omitempty"`
+}
+
+// X is the player id in slot 0.
+func (m *Match) X() string { return m.Slots[0].Player }
+
+// O is the player id in slot 1.
+func (m *Match) O() string { return m.Slots[1].Player }
+
+// Tournament is the full state of one event.
+type Tournament struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Format  Format    `json:"format"`
+	Options Options   `json:"options"`
+	State   string    `json:"state"`
+	Round   int       `json:"round"` // current round for Swiss and round robin
+	Players []*Player `json:"players"`
+	Matches []*Match  `json:"matches"`
+
+	players map[string]*Player
+	matches map[string]*Match
+}
+
+// New creates a tournament open for registration.
+func New(id, name string, format Format, opts Options) (*Tournament, error) {
+	switch format {
+	case SingleElimination, DoubleElimination, Swiss, RoundRobin:
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+	if opts.Rounds < 0 || opts.MaxReplays < 0 {
+		return nil, errors.New("rounds and maxReplays can't be negative")
+	}
+	return &Tournament{
+		ID:      id,
+		Name:    name,
+		Format:  format,
+		Options: opts,
+		State:   StateRegistering,
+		players: make(map[string]*Player),
+		matches: make(map[string]*Match),
+	}, nil
+}
+
+// Register adds a player. seed is optional (0), seeded players are placed
+// first in seed order, the rest follow in registration order.
+func (t *Tournament) Register(name string, seed int) (*Player, error) {
+	if t.State != StateRegistering {
+		return nil, ErrNotRegistering
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, errors.New("name is required")
+	}
+	for _, p := range t.Players {
+		if strings.EqualFold(p.Name, name) {
+			return nil, fmt.Errorf("%w: %q", ErrNameTaken, name)
+		}
+	}
+
+	p := &Player{ID: fmt.Sprintf("p%d", len(t.Players)+1), Name: name, Seed: seed}
+	t.Players = append(t.Players, p)
+	t.players[p.ID] = p
+	return p, nil
+}
+
+// Player looks up a registered player.
+func (t *Tournament) Player(id string) *Player {
+	return t.players[id]
+}
+
+// Match looks up a match.
+func (t *Tournament) Match(id string) *Match {
+	return t.matches[id]
+}
+
+// Start closes registration, seeds the players and builds the first matches.
+// It returns the matches that are ready to be played.
+func (t *Tournament) Start() ([]*Match, error) {
+	if t.State != StateRegistering {
+		return nil, ErrNotRegistering
+	}
+	if len(t.Players) < 2 {
+		return nil, ErrTooFewPlayers
+	}
+	t.seed()
+	t.State = StateRunning
+
+	switch t.Format {
+	case SingleElimination:
+		t.buildWinnersBracket("")
+	case DoubleElimination:
+		t.buildDoubleElimination()
+	case RoundRobin:
+		t.buildRoundRobin()
+		t.Round = 1
+	case Swiss:
+		if t.Options.Rounds == 0 {
+			t.Options.Rounds = log2ceil(len(t.Players))
+		}
+		t.pairSwissRound()
+	}
+	return t.settle(), nil
+}
+
+// Report records the result of a ready match. winner is a player id, or empty
+// for a draw. It returns every match that became ready because of it; a drawn
+// elimination match that must be replayed is returned again with its colours swapped.
+func (t *Tournament) Report(matchID, winner string) ([]*Match, error) {
+	if t.State != StateRunning {
+		return nil, ErrNotRunning
+	}
+	m := t.matches[matchID]
+	if m == nil {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownMatch, matchID)
+	}
+	if m.Status != MatchReady {
+		return nil, ErrMatchNotReady
+	}
+	if winner != "" && winner != m.X() && winner != m.O() {
+		return nil, ErrUnknownPlayer
+	}
+
+	if winner == "" && t.elimination() {
+		if m.Replays < t.Options.MaxReplays {
+			m.Replays++
+			m.Slots[0], m.Slots[1] = m.Slots[1], m.Slots[0]
+			return []*Match{m}, nil
+		}
+		// out of replays, the higher seed goes through
+		winner = m.X()
+		if t.players[m.O()].Seed < t.players[m.X()].Seed {
+			winner = m.O()
+		}
+	}
+
+	m.Status = MatchDone
+	if winner == "" {
+		m.Draw = true
+	} else {
+		m.Winner = winner
+		m.Loser = m.X()
+		if winner == m.X() {
+			m.Loser = m.O()
+		}
+	}
+
+	// in double elimination the losers bracket champion has to beat the
+	// winners bracket champion twice, add the bracket reset match. Replays
+	// swap the colours, so the slots say who came from which bracket.
+	if m.Bracket == BracketFinal && m.Round == 1 && winner != "" && !t.fromWinnersBracket(m.slotOf(winner)) {
+		t.addMatch(&Match{
+			Round:   2,
+			Bracket: BracketFinal,
+			Slots:   [2]Slot{{Player: winner}, {Player: m.Loser}},
+		})
+	}
+
+	return t.settle(), nil
+}
+
+// slotOf is the slot a player of the match is in
+func (m *Match) slotOf(player string) Slot {
+	if m.O() == player {
+		return m.Slots[1]
+	}
+	return m.Slots[0]
+}
+
+// fromWinnersBracket says the slot takes the winner of a winners bracket match
+func (t *Tournament) fromWinnersBracket(s Slot) bool {
+	return s.From != "" && !s.Loser && t.matches[s.From].Bracket == BracketWinners
+}
+
+func (t *Tournament) elimination() bool {
+	return t.Format == SingleElimination || t.Format == DoubleElimination
+}
+
+// seed orders players: explicit seeds first, then registration order
+func (t *Tournament) seed() {
+	sort.SliceStable(t.Players, func(i, j int) bool {
+		a, b := t.Players[i].Seed, t.Players[j].Seed
+		if a == 0 || b == 0 {
+			return a != 0 && b == 0
+		}
+		return a < b
+	})
+	for i, p := range t.Players {
+		p.Seed = i + 1
+	}
+}
+
+func (t *Tournament) addMatch(m *Match) *Match {
+	m.ID = fmt.Sprintf("m%d", len(t.Matches)+1)
+	m.Status = MatchPending
+	t.Matches = append(t.Matches, m)
+	t.matches[m.ID] = m
+	return m
+}
+
+// settle fills slots from finished matches, plays out byes, opens the next
+// round when one is complete and returns the matches that just became ready.
+func (t *Tournament) settle() []*Match {
+	var ready []*Match
+	for changed := true; changed; {
+		changed = false
+
+		for _, m := range t.Matches {
+			if m.Status == MatchDone {
+				continue
+			}
+			for i := range m.Slots {
+				s := &m.Slots[i]
+				if s.resolved() || s.From == "" || t.matches[s.From].Status != MatchDone {
+					continue
+				}
+				from := t.matches[s.From]
+				s.Player = from.Winner
+				if s.Loser {
+					s.Player = from.Loser
+				}
+				s.Bye = s.Player == ""
+			}
+			if !m.Slots[0].resolved() || !m.Slots[1].resolved() {
+				continue
+			}
+
+			if m.Slots[0].Bye || m.Slots[1].Bye {
+				// nobody to play, the other side (if any) goes through
+				m.Status, m.Bye = MatchDone, true
+				m.Winner = m.X() + m.O()
+				changed = true
+				continue
+			}
+			if m.Status == MatchPending && (t.elimination() || m.Round <= t.Round) {
+				m.Status = MatchReady
+				ready = append(ready, m)
+			}
+		}
+
+		if !changed && t.roundComplete() {
+			switch {
+			case t.Format == Swiss && t.Round < t.Options.Rounds:
+				t.pairSwissRound()
+				changed = true
+			case t.Format == RoundRobin && t.Round < t.lastRound():
+				t.Round++
+				changed = true
+			}
+		}
+	}
+
+	if t.allDone() {
+		t.State = StateFinished
+	}
+	return ready
+}
+
+func (t *Tournament) roundComplete() bool {
+	for _, m := range t.Matches {
+		if m.Round <= t.Round && m.Status != MatchDone {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *Tournament) lastRound() int {
+	last := 0
+	for _, m := range t.Matches {
+		last = max(last, m.Round)
+	}
+	return last
+}
+
+func (t *Tournament) allDone() bool {
+	for _, m := range t.Matches {
+		if m.Status != MatchDone {
+			return false
+		}
+	}
+	return true
+}
+
+// log2ceil is the number of rounds a knockout of n players needs
+func log2ceil(n int) int {
+	rounds := 0
+	for size := 1; size < n; size *= 2 {
+		rounds++
+	}
+	return max(rounds, 1)
+}
@@ -0,0 +1,295 @@
+package tournament
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// newTournament registers players p1..pn in seed order
+func newTournament(t *testing.T, format Format, players int, opts Options) *Tournament {
+	t.Helper()
+	tr, err := New("t1", "test", format, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= players; i++ {
+		if _, err := tr.Register(fmt.Sprintf("player %d", i), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tr
+}
+
+// run starts the tournament and reports every match that becomes ready with
+// decide's winner ("" for a draw) until none is left
+func run(t *testing.T, tr *Tournament, decide func(*Match) string) {
+	t.Helper()
+	ready, err := tr.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for len(ready) > 0 {
+		m := ready[0]
+		next, err := tr.Report(m.ID, decide(m))
+		if err != nil {
+			t.Fatalf("reporting %s: %v", m.ID, err)
+		}
+		ready = append(ready[1:], next...)
+	}
+}
+
+// higherSeed wins every match
+func higherSeed(tr *Tournament) func(*Match) string {
+	return func(m *Match) string {
+		if tr.Player(m.O()).Seed < tr.Player(m.X()).Seed {
+			return m.O()
+		}
+		return m.X()
+	}
+}
+
+// ranking is the player ids in standings order
+func ranking(tr *Tournament) []string {
+	var ids []string
+	for _, s := range tr.Standings() {
+		ids = append(ids, s.Player.ID)
+	}
+	return ids
+}
+
+func TestFormats(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  Format
+		players int
+		decide  func(*Tournament) func(*Match) string
+		matches int
+		byes    int
+		first   string
+	}{
+		// 5 players fill a bracket of 8, the top three seeds get byes
+		{"single elimination with byes", SingleElimination, 5, higherSeed, 7, 3, "p1"},
+		{"single elimination upsets", SingleElimination, 8, func(tr *Tournament) func(*Match) string {
+			return func(m *Match) string {
+				if tr.Player(m.O()).Seed > tr.Player(m.X()).Seed {
+					return m.O()
+				}
+				return m.X()
+			}
+		}, 7, 0, "p8"},
+		// winners bracket 3, losers bracket 2, grand final 1
+		{"double elimination", DoubleElimination, 4, higherSeed, 6, 0, "p1"},
+		// p1 loses the winners final to p2, comes back through the losers
+		// bracket and beats p2 twice: the grand final and its reset
+		{"double elimination reset", DoubleElimination, 4, func(tr *Tournament) func(*Match) string {
+			return func(m *Match) string {
+				if m.Bracket == BracketWinners && m.Round == 2 {
+					return "p2"
+				}
+				return higherSeed(tr)(m)
+			}
+		}, 7, 0, "p1"},
+		// p1's bye in the winners bracket leaves a bye in the losers bracket too
+		{"double elimination with byes", DoubleElimination, 3, higherSeed, 6, 2, "p1"},
+		// 3 rounds of one game and one bye
+		{"round robin odd", RoundRobin, 3, higherSeed, 6, 3, "p1"},
+		{"round robin even", RoundRobin, 4, higherSeed, 6, 0, "p1"},
+		// log2(5) rounds to 3, each with a bye
+		{"swiss odd", Swiss, 5, higherSeed, 9, 3, "p1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newTournament(t, tt.format, tt.players, Options{})
+			run(t, tr, tt.decide(tr))
+			byes := 0
+			for _, m := range tr.Matches {
+				if m.Bye {
+					byes++
+				}
+			}
+			if tr.State != StateFinished || len(tr.Matches) != tt.matches || byes != tt.byes {
+				t.Fatalf("%s with %d matches, %d byes, want finished with %d and %d", tr.State, len(tr.Matches), byes, tt.matches, tt.byes)
+			}
+			if got := ranking(tr); got[0] != tt.first {
+				t.Fatalf("standings %v, want %s first", got, tt.first)
+			}
+		})
+	}
+}
+
+func TestGrandFinalReplay(t *testing.T) {
+	tests := []struct {
+		name    string
+		replay  string // who wins the replayed grand final
+		matches int
+	}{
+		// p1 won the winners bracket, colours swap on the replay but there is no reset
+		{"winners bracket champion", "p1", 2},
+		{"losers bracket champion", "p2", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newTournament(t, DoubleElimination, 2, Options{MaxReplays: 1})
+			replayed := false
+			run(t, tr, func(m *Match) string {
+				switch {
+				case m.Bracket != BracketFinal || m.Round == 2:
+					return higherSeed(tr)(m)
+				case !replayed:
+					replayed = true
+					return ""
+				}
+				if m.X() != "p2" {
+					t.Fatalf("replay has X %s, want the colours swapped", m.X())
+				}
+				return tt.replay
+			})
+			if len(tr.Matches) != tt.matches || tr.State != StateFinished || ranking(tr)[0] != "p1" {
+				t.Fatalf("%d matches, %s, standings %v", len(tr.Matches), tr.State, ranking(tr))
+			}
+		})
+	}
+}
+
+func TestReplaysExhausted(t *testing.T) {
+	tr := newTournament(t, SingleElimination, 2, Options{MaxReplays: 2})
+	run(t, tr, func(*Match) string { return "" })
+	m := tr.Matches[0]
+	if m.Replays != 2 || m.Winner != "p1" || m.Draw {
+		t.Fatalf("drawn out final: %+v, want the higher seed through after 2 replays", m)
+	}
+}
+
+func TestRoundRobinPairsEveryone(t *testing.T) {
+	for _, n := range []int{2, 4, 5, 6} {
+		tr := newTournament(t, RoundRobin, n, Options{})
+		met := map[[2]string]int{}
+		rounds := map[string]map[int]bool{}
+		run(t, tr, func(m *Match) string {
+			a, b := min(m.X(), m.O()), max(m.X(), m.O())
+			met[[2]string{a, b}]++
+			for _, id := range []string{a, b} {
+				if rounds[id] == nil {
+					rounds[id] = map[int]bool{}
+				}
+				if rounds[id][m.Round] {
+					t.Fatalf("%d players: %s plays twice in round %d", n, id, m.Round)
+				}
+				rounds[id][m.Round] = true
+			}
+			return higherSeed(tr)(m)
+		})
+		if len(met) != n*(n-1)/2 {
+			t.Fatalf("%d players: %d pairings, want %d", n, len(met), n*(n-1)/2)
+		}
+		for pair, games := range met {
+			if games != 1 {
+				t.Fatalf("%d players: %v met %d times", n, pair, games)
+			}
+		}
+	}
+}
+
+func TestSwissPairing(t *testing.T) {
+	tr := newTournament(t, Swiss, 7, Options{Rounds: 4})
+	met := map[[2]string]bool{}
+	run(t, tr, func(m *Match) string {
+		pair := [2]string{min(m.X(), m.O()), max(m.X(), m.O())}
+		if met[pair] {
+			t.Fatalf("round %d rematches %v", m.Round, pair)
+		}
+		met[pair] = true
+		if m.Round%2 == 0 {
+			return ""
+		}
+		return higherSeed(tr)(m)
+	})
+	byes := map[string]bool{}
+	for _, m := range tr.Matches {
+		if m.Bye {
+			if byes[m.Winner] {
+				t.Fatalf("%s got a second bye", m.Winner)
+			}
+			byes[m.Winner] = true
+		}
+	}
+	if tr.Round != 4 || len(byes) != 4 {
+		t.Fatalf("%d rounds, %d byes, want 4 of each", tr.Round, len(byes))
+	}
+}
+
+func TestTiebreaks(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  Format
+		results map[[2]string]string // winner by pair, missing is a draw
+		ranking []string
+		points  []float64
+		tie     func(Standing) float64
+		values  []float64
+	}{
+		// p1 and p2 end on a point each, p2 lost to the stronger field
+		{"buchholz", Swiss, map[[2]string]string{
+			{"p1", "p2"}: "p2", {"p2", "p3"}: "p3", {"p1", "p4"}: "p1",
+		}, []string{"p3", "p2", "p1", "p4"}, []float64{1.5, 1, 1, 0.5},
+			func(s Standing) float64 { return s.Buchholz }, []float64{1.5, 2.5, 1.5, 2.5}},
+		// p3 and p1 end on 1.5 with the same buchholz, p3 beat the winner
+		{"sonneborn-berger", RoundRobin, map[[2]string]string{
+			{"p2", "p3"}: "p3", {"p1", "p2"}: "p2", {"p2", "p4"}: "p2",
+			{"p3", "p4"}: "p4", {"p1", "p4"}: "p1",
+		}, []string{"p2", "p3", "p1", "p4"}, []float64{2, 1.5, 1.5, 1},
+			func(s Standing) float64 { return s.SonnebornBerger }, []float64{2.5, 2.75, 1.75, 1.5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newTournament(t, tt.format, 4, Options{Rounds: 2})
+			run(t, tr, func(m *Match) string {
+				return tt.results[[2]string{min(m.X(), m.O()), max(m.X(), m.O())}]
+			})
+			var points, values []float64
+			for _, s := range tr.Standings() {
+				points = append(points, s.Points)
+				values = append(values, tt.tie(s))
+			}
+			if got := ranking(tr); !reflect.DeepEqual(got, tt.ranking) || !reflect.DeepEqual(points, tt.points) || !reflect.DeepEqual(values, tt.values) {
+				t.Fatalf("standings %v points %v %s %v, want %v %v %v", got, points, tt.name, values, tt.ranking, tt.points, tt.values)
+			}
+		})
+	}
+}
+
+func TestRegistration(t *testing.T) {
+	if _, err := New("t", "bad", "knockout", Options{}); !errors.Is(err, ErrUnknownFormat) {
+		t.Fatalf("unknown format: %v", err)
+	}
+	tr := newTournament(t, SingleElimination, 1, Options{})
+	if _, err := tr.Register("PLAYER 1", 0); !errors.Is(err, ErrNameTaken) {
+		t.Fatalf("same name in another case: %v", err)
+	}
+	if _, err := tr.Start(); !errors.Is(err, ErrTooFewPlayers) {
+		t.Fatalf("starting with one player: %v", err)
+	}
+
+	// explicit seeds come first, the rest keep registration order
+	if _, err := tr.Register("late", 1); err != nil {
+		t.Fatal(err)
+	}
+	ready, err := tr.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Player("p2").Seed != 1 || len(ready) != 1 || ready[0].X() != "p2" {
+		t.Fatalf("seeded player %+v, first match %+v", tr.Player("p2"), ready[0])
+	}
+	if _, err := tr.Register("too late", 0); !errors.Is(err, ErrNotRegistering) {
+		t.Fatalf("registering after the start: %v", err)
+	}
+	if _, err := tr.Report(ready[0].ID, "p9"); !errors.Is(err, ErrUnknownPlayer) {
+		t.Fatalf("reporting a stranger as winner: %v", err)
+	}
+	if _, err := tr.Report("m9", ""); !errors.Is(err, ErrUnknownMatch) {
+		t.Fatalf("reporting an unknown match: %v", err)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"goChatSocket/game"
+	"goChatSocket/protocol"
+	"goChatSocket/tournament"
+)
+
+// tournamentManager runs tournaments on top of the hub: it opens a room for
+// every match that becomes ready, reports results back when the room's game
+// ends and pushes the bracket to everyone watching.
+type tournamentManager struct {
+	hub *Hub
+
+	mu          sync.Mutex
+	nextID      int
+	tournaments map[string]*tournament.Tournament
+	order       []string                     // tournament ids in creation order
+	tokens      map[string]map[string]string // tournament id -> player id -> seat token
+	watchers    map[string]map[*Client]bool  // tournament id -> clients watching it
+	delays      map[string]time.Duration     // tournament id -> spectator delay in its match rooms
+}
+
+func newTournamentManager(hub *Hub) *tournamentManager {
+	return &tournamentManager{
+		hub:         hub,
+		tournaments: make(map[string]*tournament.Tournament),
+		tokens:      make(map[string]map[string]string),
+		watchers:    make(map[string]map[*Client]bool),
+		delays:      make(map[string]time.Duration),
+	}
+}
+
+// tournamentView is the bracket as served over REST and pushed over the websocket
+type tournamentView struct {
+	*tournament.Tournament
+	Rooms     map[string]string     `json:"rooms"` // match id -> room id
+	Standings []tournament.Standing `json:"standings"`
+}
+
+// view builds the public bracket, call with the lock held
+func (m *tournamentManager) view(t *tournament.Tournament) tournamentView {
+	rooms := make(map[string]string)
+	for _, match := range t.Matches {
+		if match.Status != tournament.MatchPending && !match.Bye {
+			rooms[match.ID] = matchRoomID(t, match)
+		}
+	}
+	return tournamentView{Tournament: t, Rooms: rooms, Standings: t.Standings()}
+}
+
+func matchRoomID(t *tournament.Tournament, match *tournament.Match) string {
+	return t.ID + "-" + match.ID
+}
+
+// lookup finds a tournament, call with the lock held
+func (m *tournamentManager) lookup(id string) (*tournament.Tournament, error) {
+	t, ok := m.tournaments[id]
+	if !ok {
+		return nil, notFound("tournament %s not found", id)
+	}
+	return t, nil
+}
+
+// REST handlers
+
+// SpectatorDelay is in seconds
+type createTournamentRequest struct {
+	Name           string            `json:"name"`
+	Format         tournament.Format `json:"format"`
+	Rounds         int               `json:"rounds"`
+	MaxReplays     int               `json:"maxReplays"`
+	SpectatorDelay int               `json:"spectatorDelay"`
+}
+
+type registerRequest struct {
+	Name string `json:"name"`
+	Seed int    `json:"seed"`
+}
+
+// registerResponse carries the seat token, only the registering player gets to see it
+type registerResponse struct {
+	Player *tournament.Player `json:"player"`
+	Token  string             `json:"token"`
+}
+
+func (m *tournamentManager) handleList(w http.ResponseWriter, r *http.Request) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	type summary struct {
+		ID      string            `json:"id"`
+		Name    string            `json:"name"`
+		Format  tournament.Format `json:"format"`
+		State   string            `json:"state"`
+		Players int               `json:"players"`
+	}
+	list := make([]summary, 0, len(m.order))
+	for _, id := range m.order {
+		t := m.tournaments[id]
+		list = append(list, summary{t.ID, t.Name, t.Format, t.State, len(t.Players)})
+	}
+	return WriteJSON(w, http.StatusOK, list)
+}
+
+func (m *tournamentManager) handleCreate(w http.ResponseWriter, r *http.Request) error {
+	req := new(createTournamentRequest)
+	if err := readJSON(r, req); err != nil {
+		return err
+	}
+	delay := time.Duration(req.SpectatorDelay) * time.Second
+	if delay < 0 || delay > maxSpectatorDelay {
+		return fmt.Errorf("spectatorDelay must be between 0 and %d seconds", int(maxSpectatorDelay.Seconds()))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	id := fmt.Sprintf("t%d", m.nextID)
+	t, err := tournament.New(id, req.Name, req.Format, tournament.Options{
+		Rounds:     req.Rounds,
+		MaxReplays: req.MaxReplays,
+	})
+	if err != nil {
+		return err
+	}
+	m.tournaments[id] = t
+	m.order = append(m.order, id)
+	m.tokens[id] = make(map[string]string)
+	m.delays[id] = delay
+	return WriteJSON(w, http.StatusCreated, m.view(t))
+}
+
+func (m *tournamentManager) handleGet(w http.ResponseWriter, r *http.Request) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, err := m.lookup(r.PathValue("id"))
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, m.view(t))
+}
+
+func (m *tournamentManager) handleRegister(w http.ResponseWriter, r *http.Request) error {
+	req := new(registerRequest)
+	if err := readJSON(r, req); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, err := m.lookup(r.PathValue("id"))
+	if err != nil {
+		return err
+	}
+	player, err := t.Register(req.Name, req.Seed)
+	if err != nil {
+		return err
+	}
+	token := randomHex(16)
+	m.tokens[t.ID][player.ID] = token
+
+	m.push(t)
+	return WriteJSON(w, http.StatusCreated, registerResponse{Player: player, Token: token})
+}
+
+func (m *tournamentManager) handleStart(w http.ResponseWriter, r *http.Request) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, err := m.lookup(r.PathValue("id"))
+	if err != nil {
+		return err
+	}
+	ready, err := t.Start()
+	if err != nil {
+		return err
+	}
+	for _, match := range ready {
+		m.openMatch(t, match)
+	}
+
+	m.push(t)
+	return WriteJSON(w, http.StatusOK, m.view(t))
+}
+
+// rooms
+
+// seats reserves X and O in a match room for the two players, call with the lock held
+func (m *tournamentManager) seats(t *tournament.Tournament, match *tournament.Match) map[string]seat {
+	seats := make(map[string]seat)
+	for symbol, id := range map[string]string{game.X: match.X(), game.O: match.O()} {
+		seats[symbol] = seat{token: m.tokens[t.ID][id], name: t.Player(id).Name}
+	}
+	return seats
+}
+
+// openMatch creates the room a ready match is played in, call with the lock held
+func (m *tournamentManager) openMatch(t *tournament.Tournament, match *tournament.Match) {
+	tournamentID, matchID := t.ID, match.ID
+	m.hub.createRoom(matchRoomID(t, match), roomOptions{
+		keep:           true,
+		rated:          true,
+		seats:          m.seats(t, match),
+		spectatorDelay: m.delays[t.ID],
+		onGameOver: func(winner string) {
+			m.gameOver(tournamentID, matchID, winner)
+		},
+	})
+}
+
+// gameOver reports a finished game in a match room and opens whatever became ready
+func (m *tournamentManager) gameOver(tournamentID, matchID, winner string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := m.tournaments[tournamentID]
+	match := t.Match(matchID)
+
+	winnerID := ""
+	switch winner {
+	case game.X:
+		winnerID = match.X()
+	case game.O:
+		winnerID = match.O()
+	}
+
+	ready, err := t.Report(matchID, winnerID)
+	if err != nil {
+		fmt.Println("Tournament Error:", err)
+		return
+	}
+
+	room := m.hub.get(matchRoomID(t, match))
+	for _, next := range ready {
+		if next == match && room != nil {
+			// a drawn knockout game, play it again with colours swapped
+			room.reserve(m.seats(t, match))
+			continue
+		}
+		m.openMatch(t, next)
+	}
+	if match.Status == tournament.MatchDone && room != nil {
+		room.release()
+	}
+
+	m.push(t)
+}
+
+// websocket push
+
+// watch subscribes a client to bracket updates and sends the current bracket
+func (m *tournamentManager) watch(c *Client, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, err := m.lookup(id)
+	if err != nil {
+		return err
+	}
+	if m.watchers[id] == nil {
+		m.watchers[id] = make(map[*Client]bool)
+	}
+	m.watchers[id][c] = true
+	c.queue(m.update(t))
+	return nil
+}
+
+// unwatch drops a disconnected client from every tournament
+func (m *tournamentManager) unwatch(c *Client) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, watchers := range m.watchers {
+		delete(watchers, c)
+	}
+}
+
+// push sends the bracket to every watcher, call with the lock held
+func (m *tournamentManager) push(t *tournament.Tournament) {
+	enc := newEncoder(m.update(t))
+	for c := range m.watchers[t.ID] {
+		c.push(enc)
+	}
+}
+
+func (m *tournamentManager) update(t *tournament.Tournament) protocol.Envelope {
+	return protocol.MustNew(&protocol.TournamentUpdate{Tournament: m.view(t)})
+}
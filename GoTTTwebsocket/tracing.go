@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Attributes on the server's spans. Every span about a room or a connection
+// carries ttt.room and ttt.conn so one game or one player can be picked out.
+const (
+	attrRoom    = attribute.Key("ttt.room")
+	attrConn    = attribute.Key("ttt.conn")
+	attrType    = attribute.Key("ttt.message.type")
+	attrSeq     = attribute.Key("ttt.seq")
+	attrBytes   = attribute.Key("ttt.bytes")
+	attrCode    = attribute.Key("ttt.error.code")
+	attrSymbol  = attribute.Key("ttt.symbol")
+	attrPos     = attribute.Key("ttt.position")
+	attrNode    = attribute.Key("ttt.node")
+	attrTopic   = attribute.Key("ttt.topic")
+	attrProfile = attribute.Key("ttt.profile")
+)
+
+// tracerName names the server's tracer
+const tracerName = "goChatSocket"
+
+// startSpan starts a span of the server's tracer. Until setupTracing installs
+// an exporter the global provider is a no-op, so spans cost next to nothing.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// startStorageSpan traces a call to storage or another service as part of
+// ctx's trace. Without one it doesn't start a trace of its own, background
+// work like renewing leases would drown out the games.
+func startStorageSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	return startSpan(ctx, name, attrs...)
+}
+
+// endSpan ends a span, marking it failed when *err is set. Defer it with a
+// named error result: defer endSpan(span, &err)
+func endSpan(span trace.Span, err *error) {
+	if *err != nil {
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+	span.End()
+}
+
+// clientAttrs are the attributes naming a connection and its room
+func clientAttrs(c *Client, room string) []attribute.KeyValue {
+	return []attribute.KeyValue{attrRoom.String(room), attrConn.String(c.id)}
+}
+
+// postgresAttr marks the spans of calls to the cluster's database
+var postgresAttr = attribute.String("db.system", "postgresql")
+
+// traceExporters are the exporters TTT_TRACE can name, the text after a
+// colon is handed to them: stdout, file:traces.jsonl or otlp:http://collector:4318.
+// Register another here to plug it in.
+var traceExporters = map[string]func(arg string) (sdktrace.SpanExporter, error){
+	"stdout": func(string) (sdktrace.SpanExporter, error) {
+		return stdouttrace.New(stdouttrace.WithPrettyPrint())
+	},
+	"file": func(path string) (sdktrace.SpanExporter, error) {
+		if path == "" {
+			return nil, errors.New("file needs a path, like file:traces.jsonl")
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return fileExporter{exp, f}, nil
+	},
+	"otlp": func(endpoint string) (sdktrace.SpanExporter, error) {
+		var opts []otlptracehttp.Option
+		if endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(endpoint))
+		}
+		return otlptracehttp.New(context.Background(), opts...)
+	},
+}
+
+// fileExporter writes spans as one JSON object per line and closes the file when shut down
+type fileExporter struct {
+	*stdouttrace.Exporter
+	f *os.File
+}
+
+func (e fileExporter) Shutdown(ctx context.Context) error {
+	err := e.Exporter.Shutdown(ctx)
+	if cerr := e.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// setupTracing installs the exporter spec names (see traceExporters) for
+// every span, node is the name the spans are tagged with. The returned func
+// sends what is still buffered and stops the exporter.
+func setupTracing(spec, node string) (func(context.Context) error, error) {
+	name, arg, _ := strings.Cut(spec, ":")
+	newExporter, ok := traceExporters[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown trace exporter %q", name)
+	}
+	exp, err := newExporter(arg)
+	if err != nil {
+		return nil, fmt.Errorf("trace exporter %s: %w", name, err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", "ttt"),
+		attrNode.String(node),
+	))
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return tp.Shutdown, nil
+}
+
+// injectTrace returns ctx's span as W3C trace context headers for a message
+// to another node, nil when there is no span to continue
+func injectTrace(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+// extractTrace is the context a message from another node continues, see injectTrace
+func extractTrace(carrier map[string]string) context.Context {
+	return otel.GetTextMapPropagator().Extract(context.Background(), propagation.MapCarrier(carrier))
+}
+
+// injectHeaders passes ctx's span on to a service we call over HTTP
+func injectHeaders(ctx context.Context, req *http.Request) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+}
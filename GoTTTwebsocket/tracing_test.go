@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"goChatSocket/client"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans sends every span to a recorder until the test ends
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagator)
+	})
+	return recorder
+}
+
+// spanAttr is a span's attribute as a string, "" if it has none
+func spanAttr(s sdktrace.ReadOnlySpan, key attribute.Key) string {
+	for _, kv := range s.Attributes() {
+		if kv.Key == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+// waitSpans polls the recorder until match has found what it wants in the ended spans
+func waitSpans(t *testing.T, recorder *tracetest.SpanRecorder, match func([]sdktrace.ReadOnlySpan) bool) []sdktrace.ReadOnlySpan {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		spans := recorder.Ended()
+		if match(spans) {
+			return spans
+		}
+		if time.Now().After(deadline) {
+			for _, s := range spans {
+				t.Logf("%s %v", s.Name(), s.Attributes())
+			}
+			t.Fatal("timed out waiting for spans")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// named returns the spans called name, with the attribute key set to value when key isn't empty
+func named(spans []sdktrace.ReadOnlySpan, name string, key attribute.Key, value string) []sdktrace.ReadOnlySpan {
+	var found []sdktrace.ReadOnlySpan
+	for _, s := range spans {
+		if s.Name() == name && (key == "" || spanAttr(s, key) == value) {
+			found = append(found, s)
+		}
+	}
+	return found
+}
+
+func TestTracing(t *testing.T) {
+	recorder := recordSpans(t)
+	url := roomURL(t, startServer(t, nil), "traced")
+	x := dial(t, url)
+	waitFor(t, x, func(e client.Assigned) bool { return e.Symbol == "X" })
+	o := dial(t, url)
+	waitFor(t, o, func(e client.Assigned) bool { return e.Symbol == "O" })
+
+	play(t, x, o, 4)
+	waitFor(t, o, func(e client.Moved) bool { return e.Position == 4 })
+	// O takes the cell X has
+	if err := o.Move(4); err != nil {
+		t.Fatal(err)
+	}
+	waitFor[client.Rejected](t, o, nil)
+
+	// the move is traced from the message to both players' frames
+	var moveBroadcast sdktrace.ReadOnlySpan
+	spans := waitSpans(t, recorder, func(spans []sdktrace.ReadOnlySpan) bool {
+		for _, b := range named(spans, "broadcast", attrType, "move") {
+			if len(named(spans, "send", attrSeq, spanAttr(b, attrSeq))) == 2 && len(named(spans, "message move", "", "")) == 2 {
+				moveBroadcast = b
+				return true
+			}
+		}
+		return false
+	})
+
+	handshakes := named(spans, "handshake", attrRoom, "traced")
+	if len(handshakes) != 2 {
+		t.Fatalf("%d handshakes in room traced, want 2", len(handshakes))
+	}
+	conns := map[string]bool{}
+	for _, h := range handshakes {
+		conns[spanAttr(h, attrConn)] = true
+	}
+
+	messages := named(spans, "message move", "", "")
+	played, refused := messages[0], messages[1]
+	if played.Status().Code == codes.Error {
+		played, refused = refused, played
+	}
+	if refused.Status().Code != codes.Error || spanAttr(refused, attrCode) == "" {
+		t.Fatalf("refused move traced with status %v code %q", refused.Status(), spanAttr(refused, attrCode))
+	}
+
+	// message > move.validate, move.apply > broadcast > send, all with the room and the connection
+	byID := map[trace.SpanID]sdktrace.ReadOnlySpan{}
+	for _, s := range spans {
+		byID[s.SpanContext().SpanID()] = s
+	}
+	parentName := func(s sdktrace.ReadOnlySpan) string {
+		return byID[s.Parent().SpanID()].Name()
+	}
+	for _, s := range append(named(spans, "send", attrSeq, spanAttr(moveBroadcast, attrSeq)), moveBroadcast) {
+		if s.SpanContext().TraceID() != played.SpanContext().TraceID() {
+			t.Fatalf("%s isn't in the move's trace", s.Name())
+		}
+	}
+	if parentName(moveBroadcast) != "move.apply" {
+		t.Fatalf("move broadcast is a child of %q", parentName(moveBroadcast))
+	}
+	for _, name := range []string{"move.validate", "move.apply"} {
+		steps := named(spans, name, attrRoom, "traced")
+		if len(steps) == 0 || parentName(steps[0]) != "message move" {
+			t.Fatalf("%d %s spans, want one under the message", len(steps), name)
+		}
+	}
+	if len(played.Links()) != 1 || !conns[spanAttr(played, attrConn)] {
+		t.Fatalf("move message links %d spans, conn %q", len(played.Links()), spanAttr(played, attrConn))
+	}
+	for _, s := range spans {
+		switch s.Name() {
+		case "message move", "move.validate", "move.apply", "send":
+			if spanAttr(s, attrRoom) == "" || spanAttr(s, attrConn) == "" {
+				t.Fatalf("%s has room %q conn %q", s.Name(), spanAttr(s, attrRoom), spanAttr(s, attrConn))
+			}
+		case "broadcast":
+			if spanAttr(s, attrRoom) == "" {
+				t.Fatal("broadcast without a room")
+			}
+		}
+	}
+}
+
+func TestTracingAcrossNodes(t *testing.T) {
+	recorder := recordSpans(t)
+	broker := newMemoryBroker()
+	_, a := startNode(t, broker, "a", time.Second)
+	_, b := startNode(t, broker, "b", time.Second)
+
+	// X opens the room on node a, O moves through node b
+	x := dial(t, roomURL(t, a, "relayed"))
+	waitFor(t, x, func(e client.Assigned) bool { return e.Symbol == "X" })
+	o := dial(t, roomURL(t, b, "relayed"))
+	waitFor(t, o, func(e client.Assigned) bool { return e.Symbol == "O" })
+	play(t, x, o, 0, 4)
+	waitFor(t, x, func(e client.Moved) bool { return e.Position == 4 })
+
+	// node b's message, node a's handling of it and both players' frames are one trace
+	waitSpans(t, recorder, func(spans []sdktrace.ReadOnlySpan) bool {
+		for _, s := range named(spans, "move.apply", attrRoom, "relayed") {
+			traceID := s.SpanContext().TraceID()
+			messages, sends := 0, 0
+			for _, other := range spans {
+				if other.SpanContext().TraceID() != traceID {
+					continue
+				}
+				switch other.Name() {
+				case "message", "message move": // node b forwards it without decoding
+					messages++
+				case "send":
+					sends++
+				}
+			}
+			// O's frame is sent twice: node a to node b, node b to O
+			if messages == 2 && sends >= 3 {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func TestFileExporter(t *testing.T) {
+	provider, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagator)
+	})
+	if _, err := setupTracing("carrier-pigeon", "n1"); err == nil {
+		t.Fatal("unknown exporter accepted")
+	}
+	if _, err := setupTracing("file", "n1"); err == nil {
+		t.Fatal("file exporter without a path accepted")
+	}
+
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	shutdown, err := setupTracing("file:"+path, "n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := startSpan(context.Background(), "broadcast", attrRoom.String("lobby"))
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// one span per line, as the stdout exporter writes them
+	type fileSpan struct {
+		Name       string
+		Attributes []struct {
+			Key   string
+			Value struct{ Value any }
+		}
+	}
+	var spans []fileSpan
+	lines := bufio.NewScanner(f)
+	for lines.Scan() {
+		var s fileSpan
+		if err := json.Unmarshal(lines.Bytes(), &s); err != nil {
+			t.Fatalf("%v in %s", err, lines.Text())
+		}
+		spans = append(spans, s)
+	}
+	if len(spans) != 1 || spans[0].Name != "broadcast" || len(spans[0].Attributes) != 1 ||
+		spans[0].Attributes[0].Key != "ttt.room" || spans[0].Attributes[0].Value.Value != "lobby" {
+		t.Fatalf("file holds %+v", spans)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"goChatSocket/protocol"
+
+	"golang.org/x/net/websocket"
+)
+
+// transport is how messages get to and from one connected client.
+// The websocket and the SSE + POST fallback both implement it, so rooms and
+// the hub never need to know which one a client is using.
+// receive: the next raw message from the client, waiting at most timeout (0 waits forever).
+// send: write one message to the client, only called from the client's writeLoop.
+// close: hang up, a blocked receive returns an error.
+// name: "websocket" or "sse", for the admin API.
+type transport interface {
+	receive(timeout time.Duration) ([]byte, error)
+	send(f frame) error
+	close() error
+	remoteAddr() string
+	name() string
+}
+
+// wsTransport is a plain websocket connection
+type wsTransport struct {
+	ws *websocket.Conn
+}
+
+// wsHandshake picks the codec from the subprotocols the client offered, in
+// its order of preference. Without one we speak JSON and don't echo any.
+func wsHandshake(config *websocket.Config, r *http.Request) error {
+	// the same origin check websocket.Handler does
+	var err error
+	config.Origin, err = websocket.Origin(config, r)
+	if err == nil && config.Origin == nil {
+		return errors.New("null origin")
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, p := range config.Protocol {
+		if _, ok := protocol.CodecFor(p); ok {
+			config.Protocol = []string{p}
+			return nil
+		}
+	}
+	config.Protocol = nil
+	return nil
+}
+
+// codec is the one agreed in wsHandshake
+func (t wsTransport) codec() protocol.Codec {
+	if p := t.ws.Config().Protocol; len(p) == 1 {
+		if codec, ok := protocol.CodecFor(p[0]); ok {
+			return codec
+		}
+	}
+	return protocol.JSON
+}
+
+func (t wsTransport) receive(timeout time.Duration) ([]byte, error) {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	t.ws.SetReadDeadline(deadline)
+
+	var data []byte
+	err := websocket.Message.Receive(t.ws, &data)
+	return data, err
+}
+
+func (t wsTransport) send(f frame) error {
+	if f.binary {
+		return websocket.Message.Send(t.ws, f.data)
+	}
+	return websocket.Message.Send(t.ws, string(f.data))
+}
+
+func (t wsTransport) close() error {
+	return t.ws.Close()
+}
+
+func (t wsTransport) remoteAddr() string {
+	return t.ws.Request().RemoteAddr
+}
+
+func (t wsTransport) name() string {
+	return "websocket"
+}
+
+// errSessionClosed is returned by a closed SSE session
+var errSessionClosed = errors.New("session closed")
+
+// sseTransport streams server messages as server-sent events on a GET request
+// that stays open, client messages arrive as POSTs and are handed over on incoming.
+type sseTransport struct {
+	id       string
+	addr     string
+	incoming chan []byte
+	done     chan struct{} // closed when the session ends
+
+	// guards the response writer, the writeLoop and the heartbeat both write.
+	// w is nil once the GET handler has returned
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+	once    sync.Once
+}
+
+// how many POSTed messages can wait before the server answers 503
+const sseIncomingBuffer = 64
+
+func newSSETransport(w http.ResponseWriter, r *http.Request) (*sseTransport, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errors.New("streaming is not supported")
+	}
+	return &sseTransport{
+		id:       randomHex(16),
+		addr:     r.RemoteAddr,
+		incoming: make(chan []byte, sseIncomingBuffer),
+		done:     make(chan struct{}),
+		w:        w,
+		flusher:  flusher,
+	}, nil
+}
+
+func (t *sseTransport) receive(timeout time.Duration) ([]byte, error) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case data := <-t.incoming:
+		return data, nil
+	case <-t.done:
+		return nil, errSessionClosed
+	case <-expired:
+		return nil, errors.New("timed out waiting for a message")
+	}
+}
+
+// send writes one event, room events carry their seq as the event id.
+// The stream is text so SSE clients always get JSON.
+func (t *sseTransport) send(f frame) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.w == nil {
+		return errSessionClosed
+	}
+	if f.seq != 0 {
+		fmt.Fprintf(t.w, "id: %d\n", f.seq)
+	}
+	if _, err := fmt.Fprintf(t.w, "data: %s\n\n", f.data); err != nil {
+		return err
+	}
+	t.flusher.Flush()
+	return nil
+}
+
+// event writes a named event outside the message stream, e.g. the session id
+func (t *sseTransport) event(name, data string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.w == nil {
+		return errSessionClosed
+	}
+	if _, err := fmt.Fprintf(t.w, "event: %s\ndata: %s\n\n", name, data); err != nil {
+		return err
+	}
+	t.flusher.Flush()
+	return nil
+}
+
+// heartbeat writes an SSE comment so proxies don't cut an idle stream
+func (t *sseTransport) heartbeat() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.w == nil {
+		return errSessionClosed
+	}
+	if _, err := fmt.Fprint(t.w, ": ping\n\n"); err != nil {
+		return err
+	}
+	t.flusher.Flush()
+	return nil
+}
+
+// deliver hands a POSTed message to the session, false if it is backed up
+func (t *sseTransport) deliver(data []byte) bool {
+	select {
+	case t.incoming <- data:
+		return true
+	case <-t.done:
+		return false
+	default:
+		return false
+	}
+}
+
+// detach stops all writes, the response writer can't be used once the handler returns
+func (t *sseTransport) detach() {
+	t.close()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.w, t.flusher = nil, nil
+}
+
+func (t *sseTransport) close() error {
+	t.once.Do(func() { close(t.done) })
+	return nil
+}
+
+func (t *sseTransport) remoteAddr() string {
+	return t.addr
+}
+
+func (t *sseTransport) name() string {
+	return "sse"
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"goChatSocket/client"
+	"goChatSocket/protocol"
+)
+
+// startServer runs the full server on a random port and returns its websocket address
+func startServer(t *testing.T, wrap func(http.Handler) http.Handler) string {
+	t.Helper()
+	handler := newServer().routes()
+	if wrap != nil {
+		handler = wrap(handler)
+	}
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+}
+
+func roomURL(t *testing.T, addr, room string) string {
+	t.Helper()
+	url, err := client.RoomURL(addr, room, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return url
+}
+
+// waitFor reads events until match returns true, failing the test after a few seconds
+func waitFor[E client.Event](t *testing.T, c *client.Client, match func(E) bool) E {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev, ok := <-c.Events():
+			if !ok {
+				t.Fatalf("connection closed while waiting: %v", c.Err())
+			}
+			if e, ok := ev.(E); ok && (match == nil || match(e)) {
+				return e
+			}
+		case <-timeout:
+			var zero E
+			t.Fatalf("timed out waiting for %T", zero)
+		}
+	}
+}
+
+func TestSSEGame(t *testing.T) {
+	addr := startServer(t, nil)
+	url := roomURL(t, addr, "sse")
+
+	x, err := client.DialSSE(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+	o, err := client.DialSSE(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+
+	if x.Transport() != client.TransportSSE || x.Version() != 1 || x.Room() != "sse" {
+		t.Fatalf("got transport %s version %d room %s", x.Transport(), x.Version(), x.Room())
+	}
+	waitFor(t, x, func(e client.Assigned) bool { return e.Symbol == "X" })
+	waitFor(t, o, func(e client.Assigned) bool { return e.Symbol == "O" })
+
+	// X takes the top row while O plays the middle row
+	for i, pos := range []int{0, 3, 1, 4, 2} {
+		mover, symbol := x, "X"
+		if i%2 == 1 {
+			mover, symbol = o, "O"
+		}
+		waitFor(t, mover, func(e client.TurnChanged) bool { return e.Symbol == symbol })
+		if err := mover.Move(pos); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, c := range []*client.Client{x, o} {
+		over := waitFor[client.GameOver](t, c, nil)
+		if over.Winner != "X" {
+			t.Fatalf("winner %q, want X", over.Winner)
+		}
+	}
+}
+
+func TestMixedTransports(t *testing.T) {
+	addr := startServer(t, nil)
+	url := roomURL(t, addr, "mixed")
+
+	ws, err := client.Dial(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	sse, err := client.DialSSE(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sse.Close()
+
+	if ws.Transport() != client.TransportWebsocket {
+		t.Fatalf("Dial used %s, want websocket", ws.Transport())
+	}
+
+	// both sit in the same room, chat goes both ways
+	waitFor[client.Assigned](t, sse, nil)
+	if err := ws.Chat("hello from ws"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, sse, func(e client.ChatReceived) bool { return e.Text == "hello from ws" })
+	if err := sse.Chat("hello from sse"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, ws, func(e client.ChatReceived) bool { return e.Text == "hello from sse" })
+}
+
+func TestFallbackWhenWebsocketBlocked(t *testing.T) {
+	// act like a proxy that refuses websocket upgrades
+	addr := startServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Upgrade") != "" {
+				http.Error(w, "upgrades not allowed", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	c, err := client.Dial(roomURL(t, addr, "blocked"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.Transport() != client.TransportSSE {
+		t.Fatalf("Dial used %s, want the sse fallback", c.Transport())
+	}
+	waitFor[client.Assigned](t, c, nil)
+}
+
+func TestSSERejectsBadMessages(t *testing.T) {
+	addr := startServer(t, nil)
+	c, err := client.DialSSE(roomURL(t, addr, "bad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// a move before the game has started comes back as an error on the stream
+	waitFor[client.Assigned](t, c, nil)
+	if err := c.Move(9); err != nil {
+		t.Fatal(err)
+	}
+	rejected := waitFor[client.Rejected](t, c, nil)
+	if rejected.Code != "illegalMove" || rejected.RefSeq != c.LastSeq() {
+		t.Fatalf("got %+v", rejected)
+	}
+
+	// posting to a session that doesn't exist is a 404
+	httpAddr := "http" + strings.TrimPrefix(strings.TrimSuffix(addr, "/ws"), "ws")
+	resp, err := http.Post(httpAddr+"/sse/nope", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("got %s, want 404", resp.Status)
+	}
+}
+
+func TestMixedCodecs(t *testing.T) {
+	addr := startServer(t, nil)
+	url := roomURL(t, addr, "codecs")
+
+	binary, err := client.Dial(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binary.Close()
+	text, err := client.DialCodec(url, protocol.JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer text.Close()
+
+	if binary.Codec() != "msgpack" || text.Codec() != "json" {
+		t.Fatalf("got codecs %s and %s", binary.Codec(), text.Codec())
+	}
+
+	// one broadcast reaches both, each in its own codec
+	waitFor(t, binary, func(e client.TurnChanged) bool { return e.Symbol == "X" })
+	if err := binary.Move(4); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*client.Client{binary, text} {
+		moved := waitFor[client.Moved](t, c, nil)
+		if moved.Position != 4 || moved.Symbol != "X" {
+			t.Fatalf("got %+v", moved)
+		}
+	}
+}
+
+// discard is a transport that throws away everything written to it
+type discard struct{}
+
+func (discard) receive(time.Duration) ([]byte, error) { select {} }
+func (discard) send(frame) error                      { return nil }
+func (discard) close() error                          { return nil }
+func (discard) remoteAddr() string                    { return "discard" }
+func (discard) name() string                          { return "discard" }
+
+// go test -run ^$ -bench Broadcast -benchmem
+func BenchmarkBroadcast(b *testing.B) {
+	for _, mix := range []struct {
+		name   string
+		codecs []protocol.Codec
+	}{
+		{"json", []protocol.Codec{protocol.JSON}},
+		{"msgpack", []protocol.Codec{protocol.MessagePack}},
+		{"mixed", []protocol.Codec{protocol.JSON, protocol.MessagePack}},
+	} {
+		b.Run(mix.name, func(b *testing.B) {
+			// every broadcast is logged, keep that out of the results
+			stdout := os.Stdout
+			os.Stdout, _ = os.Open(os.DevNull)
+			defer func() { os.Stdout = stdout }()
+
+			room := newRoom(newHub(), "bench", roomOptions{})
+			for i := 0; i < 100; i++ {
+				c := newClient(discard{}, mix.codecs[i%len(mix.codecs)], "", protocol.Version)
+				defer c.close()
+				room.clients[c] = true
+			}
+
+			position := 4
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				room.mu.Lock()
+				room.broadcast(&protocol.Move{Position: &position, Symbol: "X"})
+				room.mu.Unlock()
+			}
+		})
+	}
+}
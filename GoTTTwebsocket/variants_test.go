@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+
+	"goChatSocket/client"
+	"goChatSocket/protocol"
+)
+
+func TestMisereRoom(t *testing.T) {
+	url := roomURL(t, startServer(t, nil), "misere") + "&variant=misere"
+	x := dial(t, url)
+	snapshot := waitFor[client.Snapshot](t, x, nil)
+	if snapshot.Rules.Variant != "misere" || snapshot.Rules.PickSymbol || snapshot.Rules.Description == "" {
+		t.Fatalf("snapshot rules %+v", snapshot.Rules)
+	}
+	o := dial(t, url)
+
+	// X completes the top row and loses
+	play(t, x, o, 0, 3, 1, 4, 2)
+	if over := waitFor[client.GameOver](t, o, nil); over.Winner != "O" {
+		t.Fatalf("game over %+v", over)
+	}
+}
+
+func TestPickSymbolRooms(t *testing.T) {
+	addr := startServer(t, nil)
+
+	// in the standard game X can't place an O
+	x := dial(t, roomURL(t, addr, "standard"))
+	o := dial(t, roomURL(t, addr, "standard"))
+	waitFor(t, x, func(e client.TurnChanged) bool { return e.Symbol == "X" })
+	if err := x.Place(0, "O"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, x, func(e client.Rejected) bool { return e.Code == protocol.CodeIllegalMove })
+	o.Close()
+
+	// in wild X can, and wins by completing a line of O
+	url := roomURL(t, addr, "wild") + "&variant=wild"
+	x = dial(t, url)
+	if snapshot := waitFor[client.Snapshot](t, x, nil); !snapshot.Rules.PickSymbol {
+		t.Fatalf("snapshot rules %+v", snapshot.Rules)
+	}
+	o = dial(t, url)
+	for i, pos := range []int{0, 4, 1, 8, 2} {
+		mover, player := x, "X"
+		if i%2 == 1 {
+			mover, player = o, "O"
+		}
+		waitFor(t, mover, func(e client.TurnChanged) bool { return e.Symbol == player })
+		if err := mover.Place(pos, []string{"O", "X"}[i%2]); err != nil {
+			t.Fatal(err)
+		}
+		moved := waitFor[client.Moved](t, mover, nil)
+		if moved.Player != player || moved.Symbol != []string{"O", "X"}[i%2] {
+			t.Fatalf("move %d broadcast as %+v", i, moved)
+		}
+	}
+	if over := waitFor[client.GameOver](t, o, nil); over.Winner != "X" {
+		t.Fatalf("game over %+v", over)
+	}
+
+	// Order and Chaos brings its own board
+	chaos := dial(t, roomURL(t, addr, "chaos")+"&variant=orderChaos")
+	if snapshot := waitFor[client.Snapshot](t, chaos, nil); snapshot.Rules.Size != 6 || snapshot.Rules.Line != 5 || len(snapshot.Board) != 36 {
+		t.Fatalf("snapshot rules %+v", snapshot.Rules)
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"goChatSocket/game"
+)
+
+// Wager states.
+const (
+	wagerHolding   = "holding"   // taking the stakes from the players
+	wagerHeld      = "held"      // both stakes are in escrow, the game is on
+	wagerSettling  = "settling"  // the outcome is decided, paying out (retried by an admin after a ledger error)
+	wagerSettled   = "settled"   // paid out
+	wagerCancelled = "cancelled" // a stake couldn't be held, the other one went back
+)
+
+// wager is the stake two signed in players put on one game.
+// X, O: the players' account ids. ID, Room, Stake, X and O never change.
+// Held: the symbols whose stake is in escrow.
+// Winner: who gets the pot once the game is decided, "" to refund the stakes.
+// Error: why the last ledger call failed.
+type wager struct {
+	ID      string    `json:"id"`
+	Room    string    `json:"room"`
+	Stake   int64     `json:"stake"`
+	X       int       `json:"x"`
+	O       int       `json:"o"`
+	Status  string    `json:"status"`
+	Held    []string  `json:"held,omitempty"`
+	Winner  string    `json:"winner,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// account is the account id of the player of symbol
+func (w *wager) account(symbol string) int {
+	if symbol == game.X {
+		return w.X
+	}
+	return w.O
+}
+
+// payouts are the transfers settling the wager: the pot to the winner when
+// both stakes are held, otherwise every held stake back to its player
+func (w *wager) payouts() []transfer {
+	if w.Winner != "" && len(w.Held) == 2 {
+		return []transfer{{Key: w.ID + "/payout", Account: w.account(w.Winner), Amount: 2 * w.Stake}}
+	}
+	var refunds []transfer
+	for _, symbol := range w.Held {
+		refunds = append(refunds, transfer{Key: w.ID + "/refund/" + symbol, Account: w.account(symbol), Amount: w.Stake})
+	}
+	return refunds
+}
+
+// maxStake caps what a room can play for, a pot of twice that must fit an int64 with room to spare
+const maxStake = 1_000_000
+
+// how often a transfer is sent before giving up, resending is safe as the ledger applies each key once
+const ledgerAttempts = 3
+
+// escrow holds the stakes of wagered games and settles them through the
+// ledger. Every transfer is keyed by the wager and what it is for, so
+// repeating a hold or a settlement never moves money twice.
+// path: the JSON file the wagers are saved to, empty keeps them in memory.
+type escrow struct {
+	ledger ledger
+
+	mu     sync.Mutex
+	path   string
+	wagers map[string]*wager
+	order  []string // wager ids, oldest first
+}
+
+func newEscrow(l ledger) *escrow {
+	return &escrow{ledger: l, wagers: make(map[string]*wager)}
+}
+
+// apply sends a transfer, again when the answer was lost. Refusals (too low
+// a balance, no such account) are final. ctx carries the trace, its deadline
+// is the ledger's own.
+func (e *escrow) apply(ctx context.Context, t transfer) error {
+	var err error
+	for attempt := 0; attempt < ledgerAttempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), ledgerTimeout)
+		err = e.ledger.apply(ctx, t)
+		cancel()
+		if err == nil || errors.Is(err, errInsufficientFunds) || errors.Is(err, errUnknownAccount) {
+			return err
+		}
+	}
+	return err
+}
+
+// load reads the wagers saved at path, if there are any, and saves them there
+// from now on. Call resume once they are loaded.
+func (e *escrow) load(path string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.path = path
+
+	var wagers []*wager
+	if err := readJSONFile(path, &wagers); err != nil {
+		return err
+	}
+	for _, w := range wagers {
+		e.add(w)
+	}
+	return nil
+}
+
+// save writes the wagers to their file, call with the lock held. An error is
+// only logged, the ledger keeps the money right either way.
+func (e *escrow) save() {
+	if e.path == "" {
+		return
+	}
+	wagers := make([]*wager, 0, len(e.order))
+	for _, id := range e.order {
+		wagers = append(wagers, e.wagers[id])
+	}
+	if err := writeJSONFile(e.path, wagers); err != nil {
+		fmt.Println("Wager Error:", err)
+	}
+}
+
+// resume finishes the wagers the last run of the server left open, their
+// games are gone: the stakes of a game that was on go back, a settlement
+// that had started is paid out as decided
+func (e *escrow) resume(ctx context.Context) {
+	for _, w := range e.list() {
+		switch w.Status {
+		case wagerHolding:
+			e.finishHold(ctx, w.ID)
+			fallthrough
+		case wagerHeld, wagerSettling:
+			e.settleLogged(ctx, w.ID, "")
+		}
+	}
+}
+
+// finishHold sends again the hold the server may have stopped in the middle
+// of, its key makes sure the ledger takes the stake at most once, so it can
+// be refunded
+func (e *escrow) finishHold(ctx context.Context, id string) {
+	e.mu.Lock()
+	w := e.wagers[id]
+	// the stakes are held X first
+	next := game.X
+	switch len(w.Held) {
+	case 1:
+		next = game.O
+	case 2:
+		e.mu.Unlock()
+		return
+	}
+	e.mu.Unlock()
+
+	if err := e.apply(ctx, transfer{Key: w.ID + "/hold/" + next, Account: w.account(next), Amount: -w.Stake}); err != nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	w.Held = append(w.Held, next)
+	e.save()
+}
+
+// add registers a wager, call with the lock held
+func (e *escrow) add(w *wager) {
+	e.wagers[w.ID] = w
+	e.order = append(e.order, w.ID)
+}
+
+// hold takes the stake from both accounts and returns a copy of the held wager.
+// If either can't be taken the game is off and anything held goes back.
+func (e *escrow) hold(ctx context.Context, room string, x, o int, stake int64) (wager, error) {
+	w := &wager{ID: "w" + randomHex(6), Room: room, Stake: stake, X: x, O: o, Status: wagerHolding, Created: time.Now()}
+	e.mu.Lock()
+	e.add(w)
+	e.save()
+	e.mu.Unlock()
+
+	for _, symbol := range []string{game.X, game.O} {
+		err := e.apply(ctx, transfer{Key: w.ID + "/hold/" + symbol, Account: w.account(symbol), Amount: -stake})
+		e.mu.Lock()
+		if err != nil {
+			w.Error = err.Error()
+			e.save()
+			e.mu.Unlock()
+			if err := e.settle(ctx, w.ID, ""); err != nil {
+				fmt.Println("Wager Error:", err)
+			} else {
+				e.setStatus(w, wagerCancelled)
+			}
+			return wager{}, fmt.Errorf("couldn't hold %s's stake: %w", symbol, err)
+		}
+		w.Held = append(w.Held, symbol)
+		e.save()
+		e.mu.Unlock()
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	w.Status = wagerHeld
+	e.save()
+	return *w, nil
+}
+
+// adopt registers a wager held by another node, whose room this one took over
+func (e *escrow) adopt(w wager) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.wagers[w.ID]; !ok {
+		e.add(&w)
+		e.save()
+	}
+}
+
+func (e *escrow) setStatus(w *wager, status string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	w.Status = status
+	e.save()
+}
+
+// settle pays out a wager, the pot to winner or the stakes back when winner is "".
+// The first call decides the outcome, later ones (retries after a ledger
+// error) send the same transfers again and the ledger applies them only once.
+func (e *escrow) settle(ctx context.Context, id, winner string) error {
+	e.mu.Lock()
+	w, ok := e.wagers[id]
+	if !ok {
+		e.mu.Unlock()
+		return notFound("wager %s not found", id)
+	}
+	switch w.Status {
+	case wagerSettled, wagerCancelled:
+		e.mu.Unlock()
+		return nil
+	case wagerHolding, wagerHeld:
+		w.Status, w.Winner = wagerSettling, winner
+		e.save()
+	}
+	transfers := w.payouts()
+	e.mu.Unlock()
+
+	for _, t := range transfers {
+		if err := e.apply(ctx, t); err != nil {
+			e.mu.Lock()
+			w.Error = err.Error()
+			e.save()
+			e.mu.Unlock()
+			return fmt.Errorf("settling wager %s: %w", id, err)
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	w.Status, w.Error = wagerSettled, ""
+	e.save()
+	return nil
+}
+
+// settleLogged is settle for callers that can't report the error, an admin can retry it
+func (e *escrow) settleLogged(ctx context.Context, id, winner string) {
+	if err := e.settle(ctx, id, winner); err != nil {
+		fmt.Println("Wager Error:", err)
+	}
+}
+
+// list returns copies of every wager, oldest first
+func (e *escrow) list() []wager {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	wagers := make([]wager, 0, len(e.order))
+	for _, id := range e.order {
+		w := *e.wagers[id]
+		w.Held = append([]string(nil), w.Held...)
+		wagers = append(wagers, w)
+	}
+	return wagers
+}
+
+// admin
+
+func (a *adminAPI) handleWagers(w http.ResponseWriter, r *http.Request) error {
+	if a.hub.escrow == nil {
+		return notFound("wagers are off, start the server with TTT_LEDGER_URL set")
+	}
+	return WriteJSON(w, http.StatusOK, a.hub.escrow.list())
+}
+
+// handleSettleWager retries paying out a wager a ledger error left unsettled
+func (a *adminAPI) handleSettleWager(w http.ResponseWriter, r *http.Request, entry *auditEntry) error {
+	entry.Target = r.PathValue("id")
+	if a.hub.escrow == nil {
+		return notFound("wagers are off, start the server with TTT_LEDGER_URL set")
+	}
+	for _, wg := range a.hub.escrow.list() {
+		if wg.ID == entry.Target && wg.Status != wagerSettling {
+			return statusError{http.StatusConflict, fmt.Errorf("wager %s is %s, only settling wagers can be retried", wg.ID, wg.Status)}
+		}
+	}
+	return a.hub.escrow.settle(r.Context(), entry.Target, "")
+}
+
+// room side
+
+// holdStakes takes both players' stakes before their game can start, call
+// with the lock held. The game starts once the ledger has them.
+func (r *Room) holdStakes() {
+	if r.holding {
+		return
+	}
+	x, o := r.players[game.X], r.players[game.O]
+	if x.account == nil || o.account == nil {
+		return
+	}
+	r.holding = true
+	stake := r.opts.stake
+	r.broadcastSystem(fmt.Sprintf("Holding a stake of %d from each player.", stake))
+
+	ctx := r.traceCtx()
+	go func() {
+		w, err := r.hub.escrow.hold(ctx, r.ID, x.account.ID, o.account.ID, stake)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		defer r.within(ctx)()
+		r.holding = false
+		if err != nil {
+			r.broadcastSystem(fmt.Sprintf("The game can't start, %v.", err))
+			return
+		}
+		if r.players[game.X] != x || r.players[game.O] != o || r.started {
+			// someone left while we waited, the stakes go back
+			go r.hub.escrow.settleLogged(ctx, w.ID, "")
+			r.maybeStart()
+			return
+		}
+		r.wager = &w
+		r.broadcastSystem(fmt.Sprintf("Stakes held, the winner takes %d.", 2*stake))
+		r.maybeStart()
+	}()
+}
+
+// settleWager pays out the stakes on the game that just ended, call with the
+// lock held. A game someone abandoned is refunded like a draw.
+func (r *Room) settleWager(winner, termination string) {
+	w := r.wager
+	if w == nil {
+		return
+	}
+	r.wager = nil
+	if termination == "abandoned" {
+		winner = ""
+	}
+	if winner != "" {
+		r.broadcastSystem(fmt.Sprintf("%s wins the pot of %d.", r.playerName(winner), 2*w.Stake))
+	} else {
+		r.broadcastSystem("The stakes are refunded.")
+	}
+	go r.hub.escrow.settleLogged(r.traceCtx(), w.ID, winner)
+}
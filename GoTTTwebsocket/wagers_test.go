@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"goChatSocket/client"
+)
+
+// fakeLedger is a UsersGo ledger in memory for tests, it applies each
+// transfer key once. dropAnswers makes it apply the next transfers but
+// answer 503, as if the answer got lost on the way back.
+type fakeLedger struct {
+	mu          sync.Mutex
+	balances    map[int]int64
+	applied     map[string]bool
+	dropAnswers int
+}
+
+func newFakeLedger(balances map[int]int64) *fakeLedger {
+	return &fakeLedger{balances: balances, applied: make(map[string]bool)}
+}
+
+func (l *fakeLedger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var t transfer
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	balance, ok := l.balances[t.Account]
+	switch {
+	case l.applied[t.Key]:
+	case !ok:
+		WriteJSON(w, http.StatusNotFound, ApiError{Error: "account not found"})
+		return
+	case balance+t.Amount < 0:
+		WriteJSON(w, http.StatusPaymentRequired, ApiError{Error: "insufficient funds"})
+		return
+	default:
+		l.balances[t.Account] += t.Amount
+		l.applied[t.Key] = true
+	}
+	if l.dropAnswers > 0 {
+		l.dropAnswers--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]int64{"balance": l.balances[t.Account]})
+}
+
+func (l *fakeLedger) balance(account int) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.balances[account]
+}
+
+// startLedger serves a fake ledger and returns an escrow using it
+func startLedger(t *testing.T, l *fakeLedger) *escrow {
+	t.Helper()
+	srv := httptest.NewServer(l)
+	t.Cleanup(srv.Close)
+	return newEscrow(newHTTPLedger(srv.URL))
+}
+
+func TestEscrow(t *testing.T) {
+	l := newFakeLedger(map[int]int64{1: 100, 2: 100, 3: 10})
+	e := startLedger(t, l)
+
+	// a lost answer is sent again, the ledger only takes the stake once
+	l.dropAnswers = 1
+	w, err := e.hold(context.Background(), "room", 1, 2, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.balance(1) != 50 || l.balance(2) != 50 {
+		t.Fatalf("balances after holding %d and %d", l.balance(1), l.balance(2))
+	}
+
+	// settling twice pays once, the first outcome stands
+	if err := e.settle(context.Background(), w.ID, "X"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.settle(context.Background(), w.ID, "O"); err != nil {
+		t.Fatal(err)
+	}
+	if l.balance(1) != 150 || l.balance(2) != 50 {
+		t.Fatalf("balances after settling %d and %d", l.balance(1), l.balance(2))
+	}
+
+	// O can't cover the stake, X gets theirs back
+	if _, err := e.hold(context.Background(), "room", 1, 3, 50); !errors.Is(err, errInsufficientFunds) {
+		t.Fatalf("hold from a short balance: %v", err)
+	}
+	if l.balance(1) != 150 || l.balance(3) != 10 {
+		t.Fatalf("balances after a cancelled hold %d and %d", l.balance(1), l.balance(3))
+	}
+	wagers := e.list()
+	if len(wagers) != 2 || wagers[0].Status != wagerSettled || wagers[1].Status != wagerCancelled {
+		t.Fatalf("wagers %+v", wagers)
+	}
+}
+
+func TestWagersSurviveRestart(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name     string
+		applied  []string // the holds the ledger took before the restart
+		saved    wager
+		balances map[int]int64
+	}{
+		{"game on", []string{"X", "O"}, wager{Status: wagerHeld, Held: []string{"X", "O"}}, map[int]int64{1: 100, 2: 100}},
+		// the restart came between the ledger taking O's stake and the wager saying so
+		{"second hold unrecorded", []string{"X", "O"}, wager{Status: wagerHolding, Held: []string{"X"}}, map[int]int64{1: 100, 2: 100}},
+		{"second hold unsent", []string{"X"}, wager{Status: wagerHolding, Held: []string{"X"}}, map[int]int64{1: 100, 2: 100}},
+		{"first hold unrecorded", []string{"X"}, wager{Status: wagerHolding}, map[int]int64{1: 100, 2: 100}},
+		{"paying out", []string{"X", "O"}, wager{Status: wagerSettling, Held: []string{"X", "O"}, Winner: "X"}, map[int]int64{1: 130, 2: 70}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newFakeLedger(map[int]int64{1: 100, 2: 100})
+			before := startLedger(t, l)
+			w := tt.saved
+			w.ID, w.Room, w.Stake, w.X, w.O = "w1", "room", 30, 1, 2
+			for _, symbol := range tt.applied {
+				if err := before.apply(ctx, transfer{Key: w.ID + "/hold/" + symbol, Account: w.account(symbol), Amount: -w.Stake}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			path := filepath.Join(t.TempDir(), "wagers.json")
+			if err := writeJSONFile(path, []wager{w}); err != nil {
+				t.Fatal(err)
+			}
+
+			after := startLedger(t, l)
+			if err := after.load(path); err != nil {
+				t.Fatal(err)
+			}
+			after.resume(ctx)
+			waitBalances(t, l, tt.balances)
+			var saved []wager
+			if err := readJSONFile(path, &saved); err != nil || len(saved) != 1 || saved[0].Status != wagerSettled {
+				t.Fatalf("saved wagers %+v, %v", saved, err)
+			}
+		})
+	}
+
+	// wagers held from now on are saved as they go
+	l := newFakeLedger(map[int]int64{1: 100, 2: 100})
+	e := startLedger(t, l)
+	path := filepath.Join(t.TempDir(), "wagers.json")
+	if err := e.load(path); err != nil {
+		t.Fatal(err)
+	}
+	w, err := e.hold(ctx, "room", 1, 2, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved []wager
+	if err := readJSONFile(path, &saved); err != nil || len(saved) != 1 || saved[0].ID != w.ID || saved[0].Status != wagerHeld {
+		t.Fatalf("saved wagers %+v, %v", saved, err)
+	}
+}
+
+// waitBalances polls the ledger until the accounts hold want
+func waitBalances(t *testing.T, l *fakeLedger, want map[int]int64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ok := true
+		for account, balance := range want {
+			ok = ok && l.balance(account) == balance
+		}
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("balances %d and %d, want %v", l.balance(1), l.balance(2), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWageredRoom(t *testing.T) {
+	l := newFakeLedger(map[int]int64{1: 100, 2: 100})
+	server := newServer()
+	server.hub.escrow = startLedger(t, l)
+	server.accounts = newAccountService(stubAccounts(t, map[string]account{
+		"ada": {ID: 1, FirstName: "Ada"},
+		"bob": {ID: 2, FirstName: "Bob"},
+	}))
+	srv := httptest.NewServer(server.routes())
+	t.Cleanup(srv.Close)
+	url := roomURL(t, "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", "stakes") + "&stake=30"
+
+	x := dialAs(t, url, client.Identity{Token: "ada"})
+	if snapshot := waitFor[client.Snapshot](t, x, nil); snapshot.Stake != 30 {
+		t.Fatalf("snapshot stake %d", snapshot.Stake)
+	}
+	// guests only watch
+	guest := dial(t, url)
+	waitFor[client.Spectating](t, guest, nil)
+	o := dialAs(t, url, client.Identity{Token: "bob"})
+
+	// the winner takes the pot
+	play(t, x, o, 0, 3, 1, 4, 2)
+	waitFor(t, o, func(e client.SystemNotice) bool { return e.Text == "Ada wins the pot of 60." })
+
+	// the rematch holds new stakes from 130 and 70, leaving it refunds them
+	waitFor(t, o, func(e client.SystemNotice) bool { return e.Text == "Stakes held, the winner takes 60." })
+	waitBalances(t, l, map[int]int64{1: 100, 2: 40})
+	x.Close()
+	waitFor(t, o, func(e client.SystemNotice) bool { return e.Text == "The stakes are refunded." })
+	waitBalances(t, l, map[int]int64{1: 130, 2: 70})
+}
+
+func TestStakeLimits(t *testing.T) {
+	tests := []struct {
+		stake string
+		ok    bool
+	}{
+		{"50", true},
+		{"1000000", true},
+		{"1000001", false},
+		{"9223372036854775807", false},
+		{"0", false},
+		{"-5", false},
+		{"5.5", false},
+	}
+	for _, tt := range tests {
+		if _, err := roomQueryOptions(url.Values{"stake": {tt.stake}}); (err == nil) != tt.ok {
+			t.Errorf("stake %s: error %v", tt.stake, err)
+		}
+	}
+}
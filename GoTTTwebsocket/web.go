@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// The page and its assets are built into the binary, only the allow-listed
+// ones are served (see frontEnd).
+//
+//	GET /                     web/index.html, with the asset urls filled in
+//	GET /assets/{name}        an asset, app.3f9a1c2b7d.js for a build's version, app.js for the latest
+//	GET /dev/reload           dev mode only, an event stream that says when web/ changed
+//
+//go:embed web
+var embeddedWeb embed.FS
+
+// webAssets are the files in web/assets that are served, nothing else there is
+var webAssets = []string{"app.js", "style.css"}
+
+// how often dev mode looks at web/ for changes
+const devPollInterval = 300 * time.Millisecond
+
+// Cache-Control for assets under their hashed name, which never changes content
+const immutableCache = "public, max-age=31536000, immutable"
+
+// frontEnd serves the page and its assets. Asset urls carry a hash of their
+// content, so browsers keep them for a year and fetch a new build right away.
+// In dev mode the files are read from disk on every request and the page
+// reloads itself when one changes.
+type frontEnd struct {
+	files fs.FS // the web directory
+	dev   bool
+
+	bundle *webBundle // built once unless dev
+}
+
+// webBundle is the page and its assets as they are served
+type webBundle struct {
+	index  []byte
+	etag   string
+	assets map[string]webAsset // by served name, hashed and plain
+}
+
+// webAsset is one asset's content, hashed says it is served under its hashed name
+type webAsset struct {
+	name   string
+	data   []byte
+	etag   string
+	hashed bool
+}
+
+// newFrontEnd serves the embedded web directory, a broken one is a build error
+func newFrontEnd() *frontEnd {
+	files, err := fs.Sub(embeddedWeb, "web")
+	if err != nil {
+		panic(err)
+	}
+	bundle, err := buildBundle(files, false)
+	if err != nil {
+		panic(fmt.Sprintf("embedded web files: %v", err))
+	}
+	return &frontEnd{files: files, bundle: bundle}
+}
+
+// newDevFrontEnd serves dir from disk as it is edited, with live reload
+func newDevFrontEnd(dir string) (*frontEnd, error) {
+	files := os.DirFS(dir)
+	if _, err := buildBundle(files, true); err != nil {
+		return nil, err
+	}
+	return &frontEnd{files: files, dev: true}, nil
+}
+
+// buildBundle hashes the assets and fills their urls into the page
+func buildBundle(files fs.FS, dev bool) (*webBundle, error) {
+	b := &webBundle{assets: make(map[string]webAsset)}
+	urls := make(map[string]string)
+	for _, name := range webAssets {
+		data, err := fs.ReadFile(files, "assets/"+name)
+		if err != nil {
+			return nil, err
+		}
+		hash := contentHash(data)
+		ext := path.Ext(name)
+		hashed := strings.TrimSuffix(name, ext) + "." + hash + ext
+		b.assets[hashed] = webAsset{name: name, data: data, etag: `"` + hash + `"`, hashed: true}
+		b.assets[name] = webAsset{name: name, data: data, etag: `"` + hash + `"`}
+		urls[name] = "/assets/" + hashed
+	}
+
+	page, err := template.New("index.html").Funcs(template.FuncMap{
+		"asset": func(name string) (string, error) {
+			url, ok := urls[name]
+			if !ok {
+				return "", fmt.Errorf("%s isn't an allowed asset", name)
+			}
+			return url, nil
+		},
+	}).ParseFS(files, "index.html")
+	if err != nil {
+		return nil, err
+	}
+	var index bytes.Buffer
+	if err := page.Execute(&index, struct{ Dev bool }{dev}); err != nil {
+		return nil, err
+	}
+	b.index = index.Bytes()
+	b.etag = `"` + contentHash(b.index) + `"`
+	return b, nil
+}
+
+// contentHash is the short hash assets are versioned with
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:10]
+}
+
+// current is the bundle to serve, read again from disk in dev mode
+func (f *frontEnd) current() (*webBundle, error) {
+	if !f.dev {
+		return f.bundle, nil
+	}
+	return buildBundle(f.files, true)
+}
+
+func (f *frontEnd) handleIndex(w http.ResponseWriter, r *http.Request) error {
+	b, err := f.current()
+	if err != nil {
+		return statusError{http.StatusInternalServerError, err}
+	}
+	f.cacheHeaders(w, b.etag, false)
+	http.ServeContent(w, r, "index.html", time.Time{}, bytes.NewReader(b.index))
+	return nil
+}
+
+func (f *frontEnd) handleAsset(w http.ResponseWriter, r *http.Request) error {
+	b, err := f.current()
+	if err != nil {
+		return statusError{http.StatusInternalServerError, err}
+	}
+	a, ok := b.assets[r.PathValue("name")]
+	if !ok {
+		return notFound("no asset %s", r.PathValue("name"))
+	}
+	f.cacheHeaders(w, a.etag, a.hashed)
+	http.ServeContent(w, r, a.name, time.Time{}, bytes.NewReader(a.data))
+	return nil
+}
+
+// cacheHeaders lets browsers keep hashed assets for good and check anything
+// else with its etag before using it again. Dev mode caches nothing.
+func (f *frontEnd) cacheHeaders(w http.ResponseWriter, etag string, hashed bool) {
+	switch {
+	case f.dev:
+		w.Header().Set("Cache-Control", "no-store")
+		return
+	case hashed:
+		w.Header().Set("Cache-Control", immutableCache)
+	default:
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	w.Header().Set("ETag", etag)
+}
+
+// handleReload streams a reload event once any served file changes, the
+// page reconnects after reloading
+func (f *frontEnd) handleReload(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprint(w, ": watching\n\n")
+	flusher.Flush()
+
+	last := f.fingerprint()
+	ticker := time.NewTicker(devPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+		if now := f.fingerprint(); now != last {
+			fmt.Fprint(w, "event: reload\ndata: {}\n\n")
+			flusher.Flush()
+			return
+		}
+	}
+}
+
+// fingerprint changes whenever a served file does, from their sizes and modification times
+func (f *frontEnd) fingerprint() string {
+	var sb strings.Builder
+	for _, name := range append([]string{"index.html"}, webAssets...) {
+		if name != "index.html" {
+			name = "assets/" + name
+		}
+		info, err := fs.Stat(f.files, name)
+		if err != nil {
+			fmt.Fprintf(&sb, "%s missing;", name)
+			continue
+		}
+		fmt.Fprintf(&sb, "%s %d %d;", name, info.Size(), info.ModTime().UnixNano())
+	}
+	return sb.String()
+}
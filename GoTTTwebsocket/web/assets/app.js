@@ -0,0 +1,390 @@
+// Websocket constructor
+// the page query is passed on: ?room=<id> picks a room, ?token=<seat token> claims a tournament seat,
+// ?tournament=<id> shows that tournament's bracket, ?name=<name> asks for a display name
+const params = new URLSearchParams(location.search);
+// the server that served the page, wss:// behind https
+const httpBase = location.protocol + "//" + location.host;
+const ws = new WebSocket((location.protocol === "https:" ? "wss://" : "ws://") + location.host + "/ws" + location.search);
+let wsOpened = false;
+
+// fallback for proxies that block websockets: server events on /sse, our messages POSTed to /sse/<session>
+let postURL = null;
+
+// interface vars, cells holds every cell by board position
+const gameBoard = document.getElementById("tic-tac-toe");
+let cells = [];
+const messagesDiv = document.getElementById("messages");
+const playerInfo = document.getElementById("player-info");
+const rulesDiv = document.getElementById("rules");
+const symbolPicker = document.getElementById("symbol-picker");
+const bracketDiv = document.getElementById("bracket");
+
+// initial game values
+let pickSymbol = false;  // the room's variant lets each move place X or O
+let userName = "";
+let playerSymbol = "";
+let activePlayer = "X";
+let gameStarted = false;
+
+// moves played in the current or last game, reactions go to the latest.
+// A finished game's moves stay until the next game's first move.
+let movesPlayed = 0;
+let gameEnded = false;
+
+// the reactions and quick chat phrases the server accepts (protocol.Emojis, protocol.QuickChats)
+const emojis = ["👍", "👏", "🔥", "😮", "😂", "🤔"];
+const quickChats = {
+    glhf: "Good luck, have fun!",
+    nice: "Nice move!",
+    thinking: "Let me think...",
+    oops: "Oops!",
+    gg: "Good game!",
+    rematch: "Rematch?",
+    thanks: "Thanks!",
+};
+
+// protocol version we speak, and the sequence number of our last message
+const protocolVersion = 1;
+let seq = 0;
+
+// last room event we applied, and whether we asked the server to fill a gap
+let roomSeq = 0;
+let resyncing = false;
+
+// every message is an envelope, the type decides what goes in the payload (see protocol/schema.json)
+function send(type, payload) {
+    seq++;
+    const data = JSON.stringify({ v: protocolVersion, type: type, seq: seq, ts: new Date().toISOString(), payload: payload });
+    if (postURL) {
+        fetch(postURL, { method: "POST", headers: { "Content-Type": "application/json" }, body: data });
+    } else {
+        ws.send(data);
+    }
+}
+
+// say hello first to agree on the protocol version, with the name we want
+// and the login token of a saved profile (POST /profiles) if we kept one
+function hello() {
+    const payload = { versions: [protocolVersion], client: "index.html" };
+    if (params.get("name")) {
+        payload.name = params.get("name");
+    }
+    if (localStorage.getItem("profileToken")) {
+        payload.profile = localStorage.getItem("profileToken");
+    }
+    send("hello", payload);
+}
+
+// websocket connection opened
+ws.onopen = () => {
+    console.log("WebSocket connection established");
+    wsOpened = true;
+    hello();
+};
+
+// websocket connection error
+ws.onerror = (error) => {
+    console.log("WebSocket error:", error);
+};
+
+// websocket connection closed, if it never opened try server-sent events instead
+ws.onclose = () => {
+    console.log("WebSocket connection closed");
+    if (!wsOpened) {
+        connectSSE();
+    }
+};
+
+function connectSSE() {
+    const events = new EventSource(httpBase + "/sse" + location.search);
+
+    // the first event is our session id, messages are posted there
+    events.addEventListener("session", (event) => {
+        console.log("Event stream established");
+        postURL = httpBase + "/sse/" + event.data;
+        hello();
+    });
+    events.onmessage = handleMessage;
+
+    // don't let the browser reconnect, that would be a new player
+    events.onerror = () => {
+        console.log("Event stream closed");
+        events.close();
+    };
+}
+
+// handler for messages recieved from server
+ws.onmessage = handleMessage;
+function handleMessage(event) {
+    const envelope = JSON.parse(event.data);
+    const message = envelope.payload || {};
+    console.log("Message received:", envelope);
+
+    // room events are numbered, skip duplicates and ask for a resync on a gap
+    if (envelope.type === "snapshot") {
+        roomSeq = envelope.seq || 0;
+        resyncing = false;
+    } else if (envelope.seq) {
+        if (envelope.seq <= roomSeq) {
+            return;
+        }
+        if (envelope.seq > roomSeq + 1) {
+            if (!resyncing) {
+                resyncing = true;
+                send("resync", { fromSeq: roomSeq + 1 });
+            }
+            return;
+        }
+        roomSeq = envelope.seq;
+        resyncing = false;
+    }
+
+    switch (envelope.type) {
+
+        // the server accepted our hello
+        case "welcome":
+            if (params.get("tournament")) {
+                send("watchTournament", { id: params.get("tournament") });
+            }
+            break;
+
+        // the server rejected one of our messages
+        case "error":
+            messagesDiv.innerHTML += `<p class="system-msg">SERVER: ${message.message}</p>`;
+            break;
+
+        // more than two connections 
+        case "lobbyFull":
+            userName = message.userName;
+            playerInfo.innerHTML = `YOU ARE SPECTATING AS <b>${userName}</b>`;
+            alert(message.text);
+            break;
+
+        // player move message, triggered by what handleCellClick() sends to server
+        case "move":
+            // Ensure message.position is a valid number
+            if (typeof message.position === "number" && message.position >= 0) {
+                console.log("mesg", message)
+                const cell = cells[message.position];
+                console.log("Accessing Position:", message.position);
+
+                if (!cell) {
+                    console.error("Invalid tile position", message.position);
+                    return;
+                }
+                cell.textContent = message.symbol;  // Update the tile
+                if (gameEnded) {
+                    movesPlayed = 0;
+                    gameEnded = false;
+                }
+                movesPlayed++;
+            } else {
+                console.error("Unexpected message format", message);
+            }
+            break;
+
+        // switch whos turn it is based on who server deems is the active player
+        case "updateTurn":
+            activePlayer = message.symbol;
+            displaySystemMessage(`It's ${activePlayer}'s turn.`);
+            break;
+
+        // used for painting system chat messages
+        case "system":
+            messagesDiv.innerHTML += `<p class="system-msg">GAMEMASTER: ${message.text}</p>`;
+            break;
+
+        // used for painting user chat messages, spectator chat is marked
+        case "chat":
+            messagesDiv.innerHTML += `<p>${message.channel ? `[${message.channel}] ` : ""}${message.sender}: ${message.text}</p>`;
+            break;
+
+        // someone reacted, to a move with its counts so far
+        case "reaction":
+            if (message.move) {
+                const counts = emojis.filter((e) => message.counts[e]).map((e) => `${e} ${message.counts[e]}`).join(" ");
+                messagesDiv.innerHTML += `<p>${message.sender} reacted ${message.emoji} to move ${message.move} (${counts})</p>`;
+            } else {
+                messagesDiv.innerHTML += `<p>${message.sender} reacted ${message.emoji}</p>`;
+            }
+            break;
+
+        // player assignment
+        case "assignPlayer":
+            userName = message.userName;
+            playerSymbol = message.symbol;
+            playerInfo.innerHTML = `YOU ARE PLAYING AS <b>${userName} (${playerSymbol})</b>`;
+            break;
+
+        // the server accepted our new name
+        case "setName":
+            userName = message.name;
+            playerInfo.innerHTML = playerSymbol ? `YOU ARE PLAYING AS <b>${userName} (${playerSymbol})</b>` : `YOU ARE SPECTATING AS <b>${userName}</b>`;
+            break;
+
+        // alert when game is won, reset board
+        case "gameOver":
+            console.log("game over!", message)
+            alert(message.text);  // Show the winner
+            gameEnded = true;
+            resetBoard();  // Reset the game
+            break;
+
+        // whole room state when joining or after a resync, one board entry per cell,
+        // rules say how to lay them out
+        case "snapshot":
+            if (message.rules && message.board.length !== cells.length) {
+                createTicTacToeBoard(message.rules);
+            }
+            if (message.rules) {
+                rulesDiv.textContent = message.rules.variant ? message.rules.description : "";
+                pickSymbol = !!message.rules.pickSymbol;
+                symbolPicker.hidden = !pickSymbol;
+            }
+            message.board.forEach((symbol, i) => {
+                cells[i].textContent = symbol;
+            });
+            movesPlayed = message.board.filter((symbol) => symbol).length;
+            gameEnded = false;
+            if (message.turn) {
+                activePlayer = message.turn;
+            }
+            break;
+
+        // bracket of the tournament we are watching
+        case "tournamentUpdate":
+            renderBracket(message.tournament);
+            break;
+
+        default:
+            console.error("Unknown message type:", envelope);
+    }
+
+    // Auto-scroll chat
+    messagesDiv.scrollTop = messagesDiv.scrollHeight;
+}
+
+
+// sends message to server when submit button is clicked, /name <name> changes our name instead
+function sendMessage() {
+    const input = document.getElementById("message");
+    if (input.value.startsWith("/name ")) {
+        send("setName", { name: input.value.slice(6).trim() });
+    } else {
+        send("chat", { text: input.value });
+    }
+    input.value = "";
+}
+
+// reacts with an emoji to the latest move, or to the room before the first
+function sendReaction(emoji) {
+    send("reaction", movesPlayed ? { emoji: emoji, move: movesPlayed } : { emoji: emoji });
+}
+
+// sends the picked quick chat phrase and puts the menu back
+function sendQuickChat() {
+    const select = document.getElementById("quick-chat");
+    if (select.value) {
+        send("quickChat", { phrase: select.value });
+    }
+    select.value = "";
+}
+
+function createReactionControls() {
+    const buttons = document.getElementById("reaction-buttons");
+    emojis.forEach((emoji) => {
+        const button = document.createElement("button");
+        button.textContent = emoji;
+        button.addEventListener("click", () => sendReaction(emoji));
+        buttons.appendChild(button);
+    });
+    const select = document.getElementById("quick-chat");
+    Object.entries(quickChats).forEach(([id, text]) => {
+        select.add(new Option(text, id));
+    });
+}
+
+createReactionControls();
+
+// send tic tac toe tile position data to server to be processed by server game logic
+
+function handleCellClick(e) {
+    const cell = e.target;
+    const position = Number(cell.dataset.position);
+
+    // check if spectator
+    if (playerSymbol === "") {
+        alert("You are spectating and cannot play.");
+        return;
+    }
+
+    // Prevent clicking on an already-filled cell or playing out of turn
+    if (cell.textContent !== "" || activePlayer !== playerSymbol) {
+        alert("It's not your turn!");
+        return;
+    }
+
+    // Send move message to the server, with the picked symbol in variants that allow it
+    const move = { position: position };
+    if (pickSymbol) {
+        move.symbol = document.querySelector('input[name="place"]:checked').value;
+    }
+    send("move", move);
+
+    console.log(`Move sent: Player ${userName} to position ${position}`);
+}
+
+// creates game board, a 3D board is drawn layer by layer from the top
+function createTicTacToeBoard(rules) {
+    gameBoard.innerHTML = "";  // Clear previous game board
+    cells = [];
+    const small = rules.size > 3 || rules.depth > 0;
+    const cellSize = small ? "40px" : "100px";
+    for (let l = 0; l < (rules.depth || 1); l++) {
+        const layer = document.createElement("div");
+        layer.classList.add("layer");
+        layer.style.gridTemplateColumns = `repeat(${rules.size}, ${cellSize})`;
+        layer.style.gridTemplateRows = `repeat(${rules.size}, ${cellSize})`;
+        for (let i = 0; i < rules.size * rules.size; i++) {
+            const cell = document.createElement("div");
+            cell.classList.add("cell");
+            if (small) {
+                cell.classList.add("small");
+            }
+            cell.dataset.position = cells.length;
+            cell.addEventListener("click", handleCellClick);  // Attach event
+            layer.appendChild(cell);
+            cells.push(cell);
+        }
+        gameBoard.appendChild(layer);
+    }
+}
+
+createTicTacToeBoard({ size: 3, line: 3 });  // Call this during page load, the snapshot may change it
+
+// Reset Board with Style Reset
+function resetBoard() {
+    cells.forEach((cell) => {
+        cell.textContent = "";
+        cell.style.backgroundColor = "";  // Reset cell background
+    });
+}
+
+// lists each match of the tournament with its room link
+function renderBracket(t) {
+    const names = {};
+    t.players.forEach((p) => names[p.id] = p.name);
+    const side = (slot) => slot.bye ? "bye" : (names[slot.player] || "?");
+    let text = `${t.name} (${t.format}) - ${t.state}\n`;
+    t.matches.forEach((m) => {
+        const result = m.status !== "done" ? m.status : (m.draw ? "draw" : `${names[m.winner] || "bye"} wins`);
+        const room = t.rooms[m.id] ? `  ?room=${t.rooms[m.id]}` : "";
+        text += `${m.bracket || "round"} ${m.round}: ${side(m.slots[0])} vs ${side(m.slots[1])} - ${result}${room}\n`;
+    });
+    bracketDiv.textContent = text;
+}
+
+// displays system message in chat, adds system message chat styling
+function displaySystemMessage(msg) {
+    messagesDiv.innerHTML += `<p class="system-msg">GAMEMASTER: ${msg}</p>`;
+}
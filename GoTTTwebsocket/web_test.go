@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// assetURL finds the url the page loads an asset from
+var assetURL = regexp.MustCompile(`/assets/(app|style)\.[0-9a-f]{10}\.(js|css)`)
+
+func TestFrontEnd(t *testing.T) {
+	srv := httptest.NewServer(newServer().routes())
+	defer srv.Close()
+
+	// source, modules and anything not allow-listed stay private
+	for _, path := range []string{"/main.go", "/go.mod", "/web/index.html", "/README.md", "/assets/../main.go", "/assets/index.html", "/assets/app.0000000000.js"} {
+		if status, _ := get(t, srv.URL+path); status != http.StatusNotFound {
+			t.Errorf("GET %s: %d, want 404", path, status)
+		}
+	}
+
+	resp, err := http.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Cache-Control") != "no-cache" || resp.Header.Get("ETag") == "" {
+		t.Fatalf("GET /: %d, Cache-Control %q, ETag %q", resp.StatusCode, resp.Header.Get("Cache-Control"), resp.Header.Get("ETag"))
+	}
+	_, page := get(t, srv.URL+"/")
+	urls := assetURL.FindAllString(page, -1)
+	if len(urls) != 2 || strings.Contains(page, "EventSource") {
+		t.Fatalf("page loads %v, dev script %v", urls, strings.Contains(page, "EventSource"))
+	}
+
+	for _, url := range urls {
+		resp, err := http.Get(srv.URL + url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Cache-Control") != immutableCache {
+			t.Fatalf("GET %s: %d, Cache-Control %q", url, resp.StatusCode, resp.Header.Get("Cache-Control"))
+		}
+		if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/") {
+			t.Fatalf("GET %s: Content-Type %q", url, ct)
+		}
+
+		// the hash in the url is the etag, a browser that has it gets 304
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+url, nil)
+		req.Header.Set("If-None-Match", resp.Header.Get("ETag"))
+		again, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again.Body.Close()
+		if again.StatusCode != http.StatusNotModified || !strings.Contains(url, strings.Trim(resp.Header.Get("ETag"), `"`)) {
+			t.Fatalf("GET %s with its etag %s: %d", url, resp.Header.Get("ETag"), again.StatusCode)
+		}
+	}
+
+	// the plain name is the latest version, checked every time
+	resp, err = http.Get(srv.URL + "/assets/app.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Cache-Control") != "no-cache" {
+		t.Fatalf("GET /assets/app.js: %d, Cache-Control %q", resp.StatusCode, resp.Header.Get("Cache-Control"))
+	}
+	if status, _ := get(t, srv.URL+"/dev/reload"); status != http.StatusNotFound {
+		t.Fatalf("GET /dev/reload outside dev mode: %d", status)
+	}
+}
+
+func TestDevFrontEnd(t *testing.T) {
+	// a copy of web/ to edit
+	dir := t.TempDir()
+	for _, name := range append([]string{"index.html"}, webAssets...) {
+		if name != "index.html" {
+			name = filepath.Join("assets", name)
+		}
+		data, err := os.ReadFile(filepath.Join("web", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755)
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	web, err := newDevFrontEnd(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newServer()
+	s.web = web
+	srv := httptest.NewServer(s.routes())
+	defer srv.Close()
+
+	_, page := get(t, srv.URL+"/")
+	if !strings.Contains(page, `EventSource("/dev/reload")`) {
+		t.Fatal("dev page doesn't reload itself")
+	}
+	first := assetURL.FindAllString(page, -1)
+
+	resp, err := http.Get(srv.URL + "/dev/reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	events := bufio.NewReader(resp.Body)
+	if line, _ := events.ReadString('\n'); !strings.HasPrefix(line, ":") {
+		t.Fatalf("stream starts with %q", line)
+	}
+
+	// editing a file reloads the page, which then loads the new version
+	path := filepath.Join(dir, "assets", "app.js")
+	time.Sleep(10 * time.Millisecond) // a new modification time even on coarse clocks
+	if err := os.WriteFile(path, []byte("console.log('edited');\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := make(chan string, 1)
+	go func() {
+		for {
+			line, err := events.ReadString('\n')
+			if err != nil || strings.HasPrefix(line, "event: ") {
+				reloaded <- line
+				return
+			}
+		}
+	}()
+	select {
+	case line := <-reloaded:
+		if line != "event: reload\n" {
+			t.Fatalf("got %q, want a reload event", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reload after an edit")
+	}
+
+	_, page = get(t, srv.URL+"/")
+	if urls := assetURL.FindAllString(page, -1); len(urls) != 2 || urls[0] != first[0] || urls[1] == first[1] {
+		t.Fatalf("page loads %v after editing app.js, %v before", urls, first)
+	}
+	if status, js := get(t, srv.URL+"/assets/app.js"); status != http.StatusOK || js != "console.log('edited');\n" {
+		t.Fatalf("GET /assets/app.js: %d %q", status, js)
+	}
+}